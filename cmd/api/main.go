@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/findhouse/internal/api"
+	"github.com/findhouse/internal/auth"
 	"github.com/findhouse/internal/db"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,6 +24,13 @@ import (
 func main() {
 	dbPath := flag.String("db", "../../internal/db/findhouse.db", "Path to SQLite database")
 	port := flag.Int("port", 8080, "Port to listen on")
+	listen := flag.String("listen", "", "Address to listen on, e.g. 127.0.0.1:8080 (defaults to all interfaces on -port)")
+	allowedOrigins := flag.String("allowed-origins", "http://localhost:5173", "Comma-separated CORS allowed origins (\"*\" allows any)")
+	sessionTTL := flag.Duration("session-ttl", 30*24*time.Hour, "Duración de las sesiones")
+	setPassword := flag.String("set-password", "", "Crear o actualizar la contraseña de un usuario (lee FINDHOUSE_PASSWORD o stdin) y salir")
+	role := flag.String("role", "", "Rol del usuario de -set-password (viewer, member, admin)")
+	imagesDir := flag.String("images-dir", "", "Directorio del almacén de fotos del scraper (por defecto, images junto a la base de datos)")
+	attachmentsDir := flag.String("attachments-dir", "", "Directorio de los adjuntos de las notas (por defecto, attachments junto a la base de datos)")
 	flag.Parse()
 
 	// Inicializar DB
@@ -25,70 +40,219 @@ func main() {
 	}
 	defer database.Close()
 
+	if *setPassword != "" {
+		if err := setUserPassword(database, *setPassword, *role); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Crear router y handlers
 	r := chi.NewRouter()
 
-	// Basic CORS
+	// CORS: solo los orígenes de la UI. La autenticación va en encabezados, no en cookies.
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"}, // Permitir cualquier origen
+		AllowedOrigins:   splitList(*allowedOrigins),
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Requested-With", "X-Request-ID"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Requested-With", "X-Request-ID", "X-API-Key"},
 		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false, // Cambiar a false para evitar problemas con '*'
+		AllowCredentials: false,
 		MaxAge:           300,
 	}))
 
 	h := api.NewHandler(database)
+	h.SetSessionTTL(*sessionTTL)
+	if *attachmentsDir == "" {
+		*attachmentsDir = filepath.Join(filepath.Dir(*dbPath), "attachments")
+	}
+	h.SetAttachmentsDir(*attachmentsDir)
+	if *imagesDir == "" {
+		*imagesDir = filepath.Join(filepath.Dir(*dbPath), "images")
+	}
+	h.SetImagesDir(*imagesDir)
 
 	// Middleware
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	// Middleware CORS adicional para asegurar que las cabeceras se establezcan correctamente
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Request-ID")
+	// Rutas
+	r.Route("/api", func(r chi.Router) {
+		r.Post("/auth/login", h.Login)
 
-			// Manejar solicitudes preflight OPTIONS
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
+		// Las aplicaciones de calendario no envían encabezados: se autentica con ?key
+		r.Get("/visits/calendar.ics", h.GetVisitsCalendar)
 
-			next.ServeHTTP(w, r)
-		})
-	})
+		// Las fotos se piden desde etiquetas <img>, que no envían encabezados; se identifican por el hash del contenido
+		r.Get("/images/{hash}", h.GetImage)
+		r.Get("/images/{hash}/thumb", h.GetImageThumb)
 
-	// Rutas
-	r.Route("/api", func(r chi.Router) {
-		r.Get("/properties/unrated", h.GetUnratedProperties)
-		r.Get("/properties/liked", h.GetLikedProperties)
-		r.Get("/properties/favorites", h.GetFavoriteProperties)
-		r.Put("/properties/{id}/rate", h.RateProperty)
-		r.Put("/properties/{id}/favorite", h.TogglePropertyFavorite)
+		// El resto de la API requiere una sesión o una API key
+		r.Group(func(r chi.Router) {
+			r.Use(h.Authenticate)
+
+			r.Post("/auth/logout", h.Logout)
+			r.Get("/users/me", h.GetCurrentUser)
+
+			// Consultas: cualquier rol
+			r.Get("/properties/unrated", h.GetUnratedProperties)
+			r.Get("/properties/liked", h.GetLikedProperties)
+			r.Get("/properties/disliked", h.GetDislikedProperties)
+			r.Get("/properties/favorites", h.GetFavoriteProperties)
+			r.Get("/properties/compare", h.CompareProperties)
+			r.Get("/properties/{id}/history", h.GetPropertyHistory)
+			r.Get("/properties/{id}/images", h.GetPropertyImages)
+			r.Get("/properties/{id}/notes", h.GetPropertyNotes)
+			r.Get("/properties/notes/attachments/{attachmentId}", h.GetNoteAttachment)
+			r.Get("/properties/{id}/checklist", h.GetPropertyChecklist)
+			r.Get("/checklist-items", h.GetChecklistItems)
+			r.Get("/properties/{id}/rating/history", h.GetRatingHistory)
+			r.Get("/ratings/recent", h.GetRecentRatings)
+			r.Get("/rating-reasons", h.GetRatingReasons)
+			r.Get("/recommender", h.GetRecommender)
+			r.Get("/features", h.GetAvailableFeatures)
+			r.Get("/property-types", h.GetPropertyTypes)
+			r.Get("/lists/{listName}", h.GetListValues)
+			r.Get("/searches", h.GetSavedSearches)
+			r.Get("/searches/{id}", h.GetSavedSearch)
+			r.Get("/searches/{id}/run", h.RunSavedSearch)
+			r.Get("/searches/{id}/matches", h.GetSavedSearchMatches)
+			r.Get("/agencies", h.GetAgencies)
+			r.Get("/agencies/{id}", h.GetAgency)
+			r.Get("/agencies/{id}/stats", h.GetAgencyStats)
+			r.Get("/agencies/{id}/contacts", h.GetAgencyContacts)
+			r.Get("/analytics/segments", h.GetMarketSegments)
+			r.Get("/analytics/trend", h.GetMarketTrend)
+
+			// Contactos con inmobiliarias y visitas del usuario
+			r.Get("/properties/{id}/contacts", h.GetPropertyContacts)
+			r.Get("/properties/{id}/visits", h.GetPropertyVisits)
+			r.Get("/visits/upcoming", h.GetUpcomingVisits)
+
+			// Decisión conjunta del hogar del usuario
+			r.Get("/household", h.GetCurrentHousehold)
+			r.Get("/household/properties", h.GetHouseholdProperties)
+			r.Get("/household/matches", h.GetHouseholdMatches)
+
+			// Calificaciones, favoritos, notas, búsquedas y API keys propias
+			r.Group(func(r chi.Router) {
+				r.Use(api.RequireRole(db.RoleMember))
+
+				r.Put("/properties/{id}/rate", h.RateProperty)
+				r.Delete("/properties/{id}/rate", h.UnrateProperty)
+				r.Post("/recommender/train", h.TrainRecommender)
+				r.Put("/properties/{id}/favorite", h.TogglePropertyFavorite)
+				r.Post("/properties/{id}/notes", h.AddPropertyNote)
+				r.Put("/properties/notes/{noteId}", h.UpdatePropertyNote)
+				r.Delete("/properties/notes/{noteId}", h.DeletePropertyNote)
+				r.Post("/properties/notes/{noteId}/attachments", h.AddNoteAttachment)
+				r.Delete("/properties/notes/attachments/{attachmentId}", h.DeleteNoteAttachment)
+				r.Put("/properties/{id}/checklist", h.UpdatePropertyChecklist)
+				r.Post("/agencies/{id}/contacts", h.CreateAgencyContact)
+				r.Put("/agencies/contacts/{contactId}", h.UpdateAgencyContact)
+				r.Post("/properties/{id}/contacts", h.AddPropertyContact)
+				r.Post("/properties/{id}/visits", h.CreateVisit)
+				r.Put("/visits/{visitId}", h.UpdateVisit)
+				r.Delete("/visits/{visitId}", h.DeleteVisit)
+
+				r.Post("/searches", h.CreateSavedSearch)
+				r.Put("/searches/{id}", h.UpdateSavedSearch)
+				r.Delete("/searches/{id}", h.DeleteSavedSearch)
+
+				r.Get("/auth/keys", h.GetAPIKeys)
+				r.Post("/auth/keys", h.CreateAPIKey)
+				r.Delete("/auth/keys/{id}", h.RevokeAPIKey)
+			})
 
-		// Rutas para notas de propiedades
-		r.Get("/properties/{id}/notes", h.GetPropertyNotes)
-		r.Post("/properties/{id}/notes", h.AddPropertyNote)
-		r.Delete("/properties/notes/{noteId}", h.DeletePropertyNote)
+			// Administración de usuarios e inmobiliarias y scrapeos
+			r.Group(func(r chi.Router) {
+				r.Use(api.RequireRole(db.RoleAdmin))
 
-		// Ruta para obtener características disponibles
-		r.Get("/features", h.GetAvailableFeatures)
+				r.Get("/users", h.GetUsers)
+				r.Post("/users", h.CreateUser)
+				r.Put("/users/{id}", h.UpdateUser)
 
-		// Ruta para obtener tipos de propiedad
-		r.Get("/property-types", h.GetPropertyTypes)
+				r.Post("/rating-reasons", h.CreateRatingReason)
+				r.Put("/rating-reasons/{id}", h.UpdateRatingReason)
 
-		// Ruta para obtener valores de listas
-		r.Get("/lists/{listName}", h.GetListValues)
+				r.Get("/households", h.GetHouseholds)
+				r.Post("/households", h.CreateHousehold)
+				r.Delete("/households/{id}", h.DeleteHousehold)
+				r.Put("/households/{id}/members/{userId}", h.AddHouseholdMember)
+				r.Delete("/households/{id}/members/{userId}", h.RemoveHouseholdMember)
 
-		// Ruta para obtener inmobiliarias
-		r.Get("/agencies", h.GetAgencies)
+				r.Post("/agencies", h.CreateAgency)
+				r.Put("/agencies/{id}", h.UpdateAgency)
+				r.Delete("/agencies/{id}", h.DeleteAgency)
+				r.Put("/agencies/{id}/disabled", h.SetAgencyDisabled)
+				r.Post("/agencies/{id}/detect-system", h.DetectAgencySystem)
+				r.Post("/agencies/{id}/scrape", h.ScrapeAgency)
+				r.Post("/agencies/{id}/merge", h.MergeAgencies)
+			})
+		})
 	})
 
 	// Iniciar servidor
-	addr := fmt.Sprintf(":%d", *port)
+	addr := *listen
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", *port)
+	}
 	log.Printf("Server starting on %s", addr)
 	log.Fatal(http.ListenAndServe(addr, r))
 }
+
+// splitList separa una lista de valores separados por coma, sin vacíos
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// setUserPassword crea el usuario si no existe y le asigna contraseña y, opcionalmente, rol.
+// Sirve para crear el primer administrador, ya que la API requiere autenticación.
+func setUserPassword(database *db.DB, name, role string) error {
+	password := os.Getenv("FINDHOUSE_PASSWORD")
+	if password == "" {
+		fmt.Fprintf(os.Stderr, "Contraseña para %s: ", name)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("error leyendo contraseña: %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user, _, err := database.GetUserCredentials(name)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		user = &db.User{Name: name, Role: role}
+		if err := database.CreateUser(user); err != nil {
+			return err
+		}
+		log.Printf("Usuario %s creado con rol %s", user.Name, user.Role)
+	} else if role != "" {
+		if err := database.SetUserRole(user.ID, role); err != nil {
+			return err
+		}
+		log.Printf("Usuario %s: rol %s", user.Name, role)
+	}
+
+	if err := database.SetUserPassword(user.ID, hash); err != nil {
+		return err
+	}
+	if err := database.DeleteUserSessions(user.ID); err != nil {
+		return err
+	}
+
+	log.Printf("Contraseña de %s actualizada", user.Name)
+	return nil
+}
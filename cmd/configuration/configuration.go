@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type ExecutionMode string
@@ -14,6 +16,14 @@ const (
 	ModeNewInmobiliarias  ExecutionMode = "new-inmobiliarias"
 	ModeSearchProperties  ExecutionMode = "search-properties"
 	ModeUpdateProperties  ExecutionMode = "update-properties"
+	ModeRefreshProperties ExecutionMode = "refresh-properties"
+	ModeDedupeAgencies    ExecutionMode = "dedupe-agencies"
+	ModeEvaluateSearches  ExecutionMode = "evaluate-searches"
+	ModeNotify            ExecutionMode = "notify"
+	ModeTestNotifications ExecutionMode = "test-notifications"
+	ModeFetchImages       ExecutionMode = "fetch-images"
+	ModeReparse           ExecutionMode = "reparse"
+	ModeDaemon            ExecutionMode = "daemon"
 )
 
 type Flags struct {
@@ -22,6 +32,34 @@ type Flags struct {
 	TestMode     bool
 	Zone         string // Zona para búsqueda de inmobiliarias
 	Inmobiliaria string // Nombre de la inmobiliaria para filtrar
+
+	// Re-extracción de detalles desactualizados
+	RefreshAge   time.Duration // Antigüedad a partir de la cual se vuelven a extraer los detalles
+	RefreshLimit int           // Máximo de propiedades a refrescar por ejecución
+
+	// Re-procesamiento de fichas guardadas
+	ReparseLimit int // Máximo de fichas a volver a procesar por ejecución (0 sin límite)
+
+	// Notificaciones de búsquedas guardadas (vacío deshabilita las notificaciones)
+	NotifyConfig string // Ruta al archivo JSON con los canales de notificación
+
+	// Descarga de fotos de las propiedades
+	ImagesDir   string // Directorio del almacén de fotos (vacío: "images" junto a la base de datos)
+	ImagesLimit int    // Máximo de fotos a descargar por ejecución (0 sin límite)
+
+	// Programación de las tareas del daemon (expresiones cron; vacío deshabilita la tarea)
+	ScheduleSearch    string
+	ScheduleDetails   string
+	ScheduleRefresh   string
+	ScheduleSystems   string
+	ScheduleDiscovery string
+	ScheduleImages    string
+	Zones             string // Zonas separadas por coma para el descubrimiento de inmobiliarias
+
+	// Política de cortesía con los sitios
+	RequestsPerMinute  int  // Solicitudes por minuto por host
+	ConcurrencyPerHost int  // Solicitudes simultáneas por host
+	RespectRobots      bool // Respetar el Crawl-delay de robots.txt
 }
 
 func ParseFlags() (*Flags, error) {
@@ -31,13 +69,35 @@ func ParseFlags() (*Flags, error) {
 	flag.StringVar(&flags.DBPath, "db", "internal/db/findhouse.db", "Ruta a la base de datos SQLite")
 	flag.BoolVar(&flags.TestMode, "test", false, "Ejecutar en modo de prueba")
 	flag.StringVar(&flags.Zone, "zone", "", "Zona para búsqueda de inmobiliarias (ej: Lanús, Avellaneda, etc)")
-	flag.StringVar(&flags.Inmobiliaria, "inmobiliaria", "", "Nombre de la inmobiliaria para filtrar (solo para search-properties y update-properties)")
+	flag.StringVar(&flags.Inmobiliaria, "inmobiliaria", "", "Nombre de la inmobiliaria para filtrar (solo para search-properties, update-properties, refresh-properties y reparse)")
+	flag.DurationVar(&flags.RefreshAge, "refresh-age", 7*24*time.Hour, "Antigüedad de los detalles a partir de la cual se vuelven a extraer (refresh-properties)")
+	flag.IntVar(&flags.RefreshLimit, "refresh-limit", 200, "Máximo de propiedades a refrescar por ejecución (refresh-properties)")
+	flag.IntVar(&flags.ReparseLimit, "reparse-limit", 0, "Máximo de fichas guardadas a volver a procesar, 0 sin límite (reparse)")
+	flag.StringVar(&flags.NotifyConfig, "notify-config", "", "Archivo JSON con los canales de notificación de búsquedas guardadas")
+
+	flag.StringVar(&flags.ImagesDir, "images-dir", "", "Directorio del almacén de fotos (por defecto, images junto a la base de datos)")
+	flag.IntVar(&flags.ImagesLimit, "images-limit", 500, "Máximo de fotos a descargar por ejecución, 0 sin límite (fetch-images)")
+
+	flag.StringVar(&flags.ScheduleSearch, "schedule-search", "0 */6 * * *", "Cron del barrido de listados (daemon)")
+	flag.StringVar(&flags.ScheduleDetails, "schedule-details", "*/30 * * * *", "Cron de la extracción de detalles (daemon)")
+	flag.StringVar(&flags.ScheduleRefresh, "schedule-refresh", "0 5 * * *", "Cron de la re-extracción de detalles desactualizados (daemon)")
+	flag.StringVar(&flags.ScheduleSystems, "schedule-systems", "0 3 * * *", "Cron de la detección de sistemas (daemon)")
+	flag.StringVar(&flags.ScheduleDiscovery, "schedule-discovery", "0 4 * * 1", "Cron del descubrimiento de inmobiliarias por zona (daemon)")
+	flag.StringVar(&flags.ScheduleImages, "schedule-images", "30 */6 * * *", "Cron de la descarga de fotos (daemon)")
+	flag.StringVar(&flags.Zones, "zones", "", "Zonas separadas por coma para el descubrimiento de inmobiliarias (daemon)")
+
+	flag.IntVar(&flags.RequestsPerMinute, "rpm", 20, "Páginas por minuto por sitio: cada ficha y cada página del listado")
+	flag.IntVar(&flags.ConcurrencyPerHost, "per-host", 2, "Solicitudes simultáneas por sitio")
+	flag.BoolVar(&flags.RespectRobots, "robots", true, "Respetar el Crawl-delay de robots.txt")
 
 	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
 		return nil, fmt.Errorf("error parsing flags: %w", err)
 	}
 
 	flags.Mode = ExecutionMode(mode)
+	if flags.ImagesDir == "" {
+		flags.ImagesDir = filepath.Join(filepath.Dir(flags.DBPath), "images")
+	}
 
 	return flags, nil
 }
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/findhouse/cmd/configuration"
 	"github.com/findhouse/internal/analyzer"
 	"github.com/findhouse/internal/db"
+	"github.com/findhouse/internal/images"
+	"github.com/findhouse/internal/notify"
+	"github.com/findhouse/internal/scheduler"
+	"github.com/findhouse/internal/scraper"
+	"github.com/findhouse/internal/scraper/ratelimit"
 )
 
 func main() {
@@ -24,48 +33,111 @@ func main() {
 	}
 	defer database.Close()
 
-	if err := runProcess(database, flags); err != nil {
+	// Todos los scrapers comparten el limitador por sitio
+	rateLimit := ratelimit.DefaultConfig
+	rateLimit.RequestsPerMinute = flags.RequestsPerMinute
+	rateLimit.MaxConcurrentPerHost = flags.ConcurrencyPerHost
+	rateLimit.RespectRobots = flags.RespectRobots
+	scraper.ConfigureRateLimit(rateLimit)
+
+	// Cancelar el proceso en curso de forma ordenada ante SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := runProcess(ctx, database, flags); err != nil {
 		log.Fatal(err)
 	}
 
 	log.Printf("Proceso '%s' completado exitosamente\n", flags.Mode)
 }
 
-func runProcess(database *db.DB, flags *configuration.Flags) error {
+func runProcess(ctx context.Context, database *db.DB, flags *configuration.Flags) error {
 	switch flags.Mode {
 	case configuration.ModeFindInmobiliarias:
 		if flags.Zone == "" {
 			return fmt.Errorf("zona no especificada")
 		}
 
-		return findInmobiliarias(database, flags.Zone)
+		return findInmobiliarias(ctx, database, flags.Zone)
 
 	case configuration.ModeAnalyzeSystems:
-		return analyzeSystems(database)
+		return analyzeSystems(ctx, database)
 
 	case configuration.ModeNewInmobiliarias:
 		if flags.Zone == "" {
 			return fmt.Errorf("zona no especificada")
 		}
 
-		if err := findInmobiliarias(database, flags.Zone); err != nil {
+		if err := findInmobiliarias(ctx, database, flags.Zone); err != nil {
 			return fmt.Errorf("error en búsqueda de inmobiliarias: %w", err)
 		}
 
-		if err := analyzeSystems(database); err != nil {
+		if err := analyzeSystems(ctx, database); err != nil {
 			return fmt.Errorf("error en análisis de sistemas: %w", err)
 		}
 
 	case configuration.ModeSearchProperties:
 		// Pasamos el nombre de la inmobiliaria como filtro
-		if err := searchProperties(database, flags.TestMode, flags.Inmobiliaria); err != nil {
+		if err := searchProperties(ctx, database, flags.TestMode, flags.Inmobiliaria); err != nil {
 			return fmt.Errorf("error en búsqueda de propiedades: %w", err)
 		}
 
 	case configuration.ModeUpdateProperties:
-		if err := updateProperties(database, flags.TestMode, flags.Inmobiliaria); err != nil {
+		if err := updateProperties(ctx, database, flags.TestMode, flags.Inmobiliaria); err != nil {
 			return fmt.Errorf("error en actualización de propiedades: %w", err)
 		}
+
+	case configuration.ModeRefreshProperties:
+		if err := refreshProperties(ctx, database, flags); err != nil {
+			return fmt.Errorf("error en re-extracción de propiedades: %w", err)
+		}
+
+	case configuration.ModeDedupeAgencies:
+		if err := dedupeAgencies(database); err != nil {
+			return fmt.Errorf("error buscando inmobiliarias duplicadas: %w", err)
+		}
+
+	case configuration.ModeEvaluateSearches:
+		if err := evaluateSearches(database); err != nil {
+			return fmt.Errorf("error evaluando búsquedas guardadas: %w", err)
+		}
+
+	case configuration.ModeNotify:
+		if err := evaluateSearches(database); err != nil {
+			return fmt.Errorf("error evaluando búsquedas guardadas: %w", err)
+		}
+		dispatcher, err := newDispatcher(database, flags)
+		if err != nil {
+			return err
+		}
+		if err := dispatcher.Dispatch(ctx); err != nil {
+			return fmt.Errorf("error enviando notificaciones: %w", err)
+		}
+
+	case configuration.ModeTestNotifications:
+		dispatcher, err := newDispatcher(database, flags)
+		if err != nil {
+			return err
+		}
+		if err := dispatcher.SendTest(ctx); err != nil {
+			return fmt.Errorf("error enviando notificaciones de prueba: %w", err)
+		}
+
+	case configuration.ModeReparse:
+		if err := reparseSnapshots(ctx, database, flags); err != nil {
+			return fmt.Errorf("error volviendo a procesar fichas guardadas: %w", err)
+		}
+
+	case configuration.ModeFetchImages:
+		if err := fetchImages(ctx, database, flags); err != nil {
+			return fmt.Errorf("error descargando fotos: %w", err)
+		}
+
+	case configuration.ModeDaemon:
+		if err := runDaemon(ctx, database, flags); err != nil {
+			return fmt.Errorf("error en daemon: %w", err)
+		}
+
 	default:
 		return fmt.Errorf("modo no válido: %s", flags.Mode)
 	}
@@ -73,20 +145,158 @@ func runProcess(database *db.DB, flags *configuration.Flags) error {
 	return nil
 }
 
-func findInmobiliarias(database *db.DB, zone string) error {
+func findInmobiliarias(ctx context.Context, database *db.DB, zone string) error {
 	log.Println("Iniciando búsqueda de inmobiliarias...")
-	return analyzer.SearchAndSaveInmobiliarias(database, zone)
+	return analyzer.SearchAndSaveInmobiliarias(ctx, database, zone)
 }
 
-func analyzeSystems(database *db.DB) error {
+func analyzeSystems(ctx context.Context, database *db.DB) error {
 	log.Println("Iniciando análisis de sistemas...")
-	return analyzer.AnalyzeSystem(database)
+	return analyzer.AnalyzeSystem(ctx, database)
+}
+
+func searchProperties(ctx context.Context, database *db.DB, testMode bool, inmobiliaria string) error {
+	return analyzer.SearchProperties(ctx, database, testMode, inmobiliaria)
+}
+
+func updateProperties(ctx context.Context, database *db.DB, testMode bool, inmobiliaria string) error {
+	return analyzer.UpdateProperties(ctx, database, testMode, inmobiliaria)
+}
+
+func refreshProperties(ctx context.Context, database *db.DB, flags *configuration.Flags) error {
+	return analyzer.RefreshProperties(ctx, database, flags.TestMode, flags.Inmobiliaria, flags.RefreshAge, flags.RefreshLimit)
+}
+
+// reparseSnapshots vuelve a extraer los detalles de las fichas guardadas, sin visitar los sitios
+func reparseSnapshots(ctx context.Context, database *db.DB, flags *configuration.Flags) error {
+	log.Println("Volviendo a procesar fichas guardadas...")
+	return analyzer.ReparseSnapshots(ctx, database, flags.Inmobiliaria, flags.ReparseLimit)
+}
+
+func dedupeAgencies(database *db.DB) error {
+	log.Println("Buscando inmobiliarias duplicadas...")
+	return analyzer.DedupeAgencies(database)
+}
+
+func evaluateSearches(database *db.DB) error {
+	log.Println("Evaluando búsquedas guardadas...")
+	return analyzer.EvaluateSavedSearches(database)
+}
+
+// fetchImages descarga las fotos pendientes de las propiedades al almacén local
+func fetchImages(ctx context.Context, database *db.DB, flags *configuration.Flags) error {
+	store := images.NewStore(flags.ImagesDir)
+	log.Printf("Descargando fotos en %s...", flags.ImagesDir)
+	result, err := images.NewFetcher(database, store, scraper.RateLimiter()).Run(ctx, flags.ImagesLimit)
+	if result != nil {
+		log.Printf("Fotos: %d encoladas, %d descargadas, %d ya guardadas, %d casi idénticas, %d fallidas, %d postergadas",
+			result.Enqueued, result.Downloaded, result.Reused, result.Duplicates, result.Failed, result.Postponed)
+	}
+	return err
 }
 
-func searchProperties(database *db.DB, testMode bool, inmobiliaria string) error {
-	return analyzer.SearchProperties(database, testMode, inmobiliaria)
+// newDispatcher crea el dispatcher de notificaciones a partir de -notify-config
+func newDispatcher(database *db.DB, flags *configuration.Flags) (*notify.Dispatcher, error) {
+	if flags.NotifyConfig == "" {
+		return nil, fmt.Errorf("falta -notify-config")
+	}
+
+	cfg, err := notify.LoadConfig(flags.NotifyConfig)
+	if err != nil {
+		return nil, err
+	}
+	return notify.NewDispatcher(database, *cfg)
 }
 
-func updateProperties(database *db.DB, testMode bool, inmobiliaria string) error {
-	return analyzer.UpdateProperties(database, testMode, inmobiliaria)
+// runDaemon ejecuta las tareas recurrentes según su programación hasta recibir SIGINT/SIGTERM
+func runDaemon(ctx context.Context, database *db.DB, flags *configuration.Flags) error {
+	var zonas []string
+	for _, zona := range strings.Split(flags.Zones, ",") {
+		if zona = strings.TrimSpace(zona); zona != "" {
+			zonas = append(zonas, zona)
+		}
+	}
+
+	// Las notificaciones son opcionales en el daemon
+	var dispatcher *notify.Dispatcher
+	if flags.NotifyConfig != "" {
+		var err error
+		if dispatcher, err = newDispatcher(database, flags); err != nil {
+			return err
+		}
+	}
+
+	tareas := []struct {
+		nombre   string
+		schedule string
+		run      func(ctx context.Context) error
+	}{
+		{"search-properties", flags.ScheduleSearch, conBusquedas(database, dispatcher, func(ctx context.Context) error {
+			return searchProperties(ctx, database, flags.TestMode, flags.Inmobiliaria)
+		})},
+		{"update-properties", flags.ScheduleDetails, conBusquedas(database, dispatcher, func(ctx context.Context) error {
+			return updateProperties(ctx, database, flags.TestMode, flags.Inmobiliaria)
+		})},
+		{"refresh-properties", flags.ScheduleRefresh, conBusquedas(database, dispatcher, func(ctx context.Context) error {
+			return refreshProperties(ctx, database, flags)
+		})},
+		{"analyze-systems", flags.ScheduleSystems, func(ctx context.Context) error {
+			return analyzeSystems(ctx, database)
+		}},
+		{"fetch-images", flags.ScheduleImages, func(ctx context.Context) error {
+			return fetchImages(ctx, database, flags)
+		}},
+	}
+
+	if len(zonas) > 0 {
+		tareas = append(tareas, struct {
+			nombre   string
+			schedule string
+			run      func(ctx context.Context) error
+		}{"find-inmobiliarias", flags.ScheduleDiscovery, func(ctx context.Context) error {
+			for _, zona := range zonas {
+				if err := findInmobiliarias(ctx, database, zona); err != nil {
+					return fmt.Errorf("zona %s: %w", zona, err)
+				}
+			}
+			return nil
+		}})
+	}
+
+	var tasks []scheduler.Task
+	for _, tarea := range tareas {
+		if tarea.schedule == "" {
+			continue
+		}
+
+		schedule, err := scheduler.Parse(tarea.schedule)
+		if err != nil {
+			return fmt.Errorf("tarea %s: %w", tarea.nombre, err)
+		}
+		tasks = append(tasks, scheduler.Task{Name: tarea.nombre, Schedule: schedule, Run: tarea.run})
+	}
+
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d", hostname, os.Getpid())
+
+	log.Printf("Iniciando daemon (%s) con %d tareas", owner, len(tasks))
+	return scheduler.New(database, owner, tasks...).Run(ctx)
+}
+
+// conBusquedas evalúa las búsquedas guardadas después de cada scrapeo, aunque haya terminado con error,
+// porque las propiedades guardadas hasta el error también pueden coincidir.
+// Si hay notificaciones configuradas, envía las novedades.
+func conBusquedas(database *db.DB, dispatcher *notify.Dispatcher, run func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		err := run(ctx)
+		if errBusquedas := evaluateSearches(database); errBusquedas != nil {
+			log.Printf("Error evaluando búsquedas guardadas: %v", errBusquedas)
+		}
+		if dispatcher != nil {
+			if errNotify := dispatcher.Dispatch(ctx); errNotify != nil {
+				log.Printf("Error enviando notificaciones: %v", errNotify)
+			}
+		}
+		return err
+	}
 }
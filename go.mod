@@ -7,10 +7,11 @@ toolchain go1.23.6
 require (
 	github.com/chromedp/chromedp v0.12.1
 	github.com/mattn/go-sqlite3 v1.14.24
+	golang.org/x/crypto v0.33.0
 )
 
 require (
-	github.com/chromedp/cdproto v0.0.0-20250210231439-aea867ea8506 // indirect
+	github.com/chromedp/cdproto v0.0.0-20250210231439-aea867ea8506
 	github.com/chromedp/sysutil v1.1.0 // indirect
 	github.com/go-chi/chi/v5 v5.2.1
 	github.com/go-chi/cors v1.2.1
@@ -0,0 +1,330 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/findhouse/internal/db"
+)
+
+// Propiedades con precio por m² que necesita un segmento para comparar contra su mediana
+const MinSegmentSize = 5
+
+// Options acota las propiedades que entran en las estadísticas
+type Options struct {
+	// Pesos por dólar, para incluir los precios en pesos; sin cotización solo cuentan los precios en USD
+	USDRate        float64
+	Location       string
+	PropertyTypeID *int64
+	Operation      string
+}
+
+// Key identifica un segmento del mercado. Incluye la operación para no mezclar ventas con alquileres.
+type Key struct {
+	Location       string
+	PropertyTypeID int64 // 0 si la propiedad no tiene tipo
+	Operation      string
+}
+
+// Percentiles resume una distribución
+type Percentiles struct {
+	P10    float64 `json:"p10"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	P90    float64 `json:"p90"`
+}
+
+// SegmentStats son las estadísticas de un segmento: una ubicación, un tipo de propiedad y una operación
+type SegmentStats struct {
+	Location       string `json:"location"`
+	PropertyTypeID *int64 `json:"property_type_id,omitempty"`
+	Operation      string `json:"operation,omitempty"`
+
+	Count   int `json:"count"`
+	Active  int `json:"active"`
+	Removed int `json:"removed"`
+	// Propiedades con precio por m² en dólares
+	Priced int `json:"priced"`
+
+	USDPerM2           *Percentiles `json:"usd_per_m2,omitempty"`
+	MedianExpenses     *float64     `json:"median_expenses,omitempty"`
+	MedianDaysOnMarket *float64     `json:"median_days_on_market,omitempty"`
+
+	precios []float64
+}
+
+// TrendPoint es el inventario de un período
+type TrendPoint struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Propiedades disponibles al final del período
+	Active int `json:"active"`
+	// Propiedades vistas por primera vez y dadas de baja durante el período
+	New     int `json:"new"`
+	Removed int `json:"removed"`
+	// Mediana del precio por m² en dólares de las disponibles al final del período
+	MedianUSDPerM2 *float64 `json:"median_usd_per_m2,omitempty"`
+}
+
+// Comparison compara el precio por m² de una propiedad con la mediana de su segmento
+type Comparison struct {
+	Location       string  `json:"location"`
+	PropertyTypeID *int64  `json:"property_type_id,omitempty"`
+	Operation      string  `json:"operation,omitempty"`
+	USDPerM2       float64 `json:"usd_per_m2"`
+	SegmentMedian  float64 `json:"segment_median"`
+	SegmentCount   int     `json:"segment_count"`
+	// Diferencia con la mediana en porcentaje: negativo si está por debajo
+	DiffPct float64 `json:"diff_pct"`
+}
+
+// normalize unifica mayúsculas y espacios para agrupar
+func normalize(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+func keyOf(ubicacion string, tipo *int64, operacion string) Key {
+	k := Key{Location: normalize(ubicacion), Operation: normalize(operacion)}
+	if tipo != nil {
+		k.PropertyTypeID = *tipo
+	}
+	return k
+}
+
+// USDPerM2 calcula el precio por m² en dólares con la superficie cubierta o, si no se conoce, la total
+func USDPerM2(precio string, cubierta, total *float64, usdRate float64) (float64, bool) {
+	valor, moneda, ok := db.ParsePrecio(precio)
+	if !ok {
+		return 0, false
+	}
+	if moneda != "USD" {
+		if usdRate <= 0 {
+			return 0, false
+		}
+		valor /= usdRate
+	}
+
+	area := 0.0
+	if cubierta != nil && *cubierta > 0 {
+		area = *cubierta
+	} else if total != nil && *total > 0 {
+		area = *total
+	}
+	if area == 0 {
+		return 0, false
+	}
+	return valor / area, true
+}
+
+// matches indica si la propiedad entra en las estadísticas pedidas
+func (o Options) matches(l *db.MarketListing) bool {
+	if o.Location != "" && normalize(l.Ubicacion) != normalize(o.Location) {
+		return false
+	}
+	if o.PropertyTypeID != nil && (l.TipoPropiedad == nil || *l.TipoPropiedad != *o.PropertyTypeID) {
+		return false
+	}
+	if o.Operation != "" && normalize(l.Operacion) != normalize(o.Operation) {
+		return false
+	}
+	return true
+}
+
+// Segments calcula las estadísticas de cada segmento, de los que tienen más propiedades a los que menos
+func Segments(listings []db.MarketListing, opts Options, now time.Time) []SegmentStats {
+	segmentos := map[Key]*SegmentStats{}
+	expensas := map[Key][]float64{}
+	dias := map[Key][]float64{}
+
+	for i := range listings {
+		l := &listings[i]
+		if !opts.matches(l) {
+			continue
+		}
+
+		k := keyOf(l.Ubicacion, l.TipoPropiedad, l.Operacion)
+		s, ok := segmentos[k]
+		if !ok {
+			s = &SegmentStats{Location: strings.TrimSpace(l.Ubicacion), Operation: k.Operation}
+			if l.TipoPropiedad != nil {
+				tipo := *l.TipoPropiedad
+				s.PropertyTypeID = &tipo
+			}
+			segmentos[k] = s
+		}
+
+		s.Count++
+		fin := now
+		if l.RemovedAt != nil {
+			s.Removed++
+			fin = *l.RemovedAt
+		} else {
+			s.Active++
+		}
+		dias[k] = append(dias[k], math.Max(fin.Sub(l.CreatedAt).Hours()/24, 0))
+
+		if v, ok := USDPerM2(l.Precio, l.SuperficieCubierta, l.SuperficieTotal, opts.USDRate); ok {
+			s.precios = append(s.precios, v)
+		}
+		if l.Expensas != nil && *l.Expensas > 0 {
+			expensas[k] = append(expensas[k], *l.Expensas)
+		}
+	}
+
+	stats := make([]SegmentStats, 0, len(segmentos))
+	for k, s := range segmentos {
+		s.Priced = len(s.precios)
+		if len(s.precios) > 0 {
+			s.USDPerM2 = percentiles(s.precios)
+		}
+		s.MedianExpenses = median(expensas[k])
+		if d := median(dias[k]); d != nil {
+			redondeado := math.Round(*d*10) / 10
+			s.MedianDaysOnMarket = &redondeado
+		}
+		stats = append(stats, *s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Location < stats[j].Location
+	})
+	return stats
+}
+
+// Trend calcula el inventario de los últimos periods períodos ("week" o "month"), del más antiguo al actual
+func Trend(listings []db.MarketListing, opts Options, interval string, periods int, now time.Time) []TrendPoint {
+	now = now.UTC()
+	var inicio time.Time
+	var siguiente func(time.Time) time.Time
+	if interval == "week" {
+		hoy := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		// Las semanas empiezan el lunes
+		inicio = hoy.AddDate(0, 0, -((int(hoy.Weekday())+6)%7)-7*(periods-1))
+		siguiente = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	} else {
+		inicio = time.Date(now.Year(), now.Month()-time.Month(periods-1), 1, 0, 0, 0, 0, time.UTC)
+		siguiente = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	}
+
+	points := make([]TrendPoint, 0, periods)
+	for start := inicio; len(points) < periods; start = siguiente(start) {
+		end := siguiente(start)
+		// El período actual se mide hasta ahora
+		corte := end
+		if corte.After(now) {
+			corte = now
+		}
+
+		p := TrendPoint{Start: start, End: end}
+		var precios []float64
+		for i := range listings {
+			l := &listings[i]
+			if !opts.matches(l) {
+				continue
+			}
+			if !l.CreatedAt.Before(start) && l.CreatedAt.Before(end) {
+				p.New++
+			}
+			if l.RemovedAt != nil && !l.RemovedAt.Before(start) && l.RemovedAt.Before(end) {
+				p.Removed++
+			}
+			if l.CreatedAt.Before(corte) && (l.RemovedAt == nil || !l.RemovedAt.Before(corte)) {
+				p.Active++
+				if v, ok := USDPerM2(l.Precio, l.SuperficieCubierta, l.SuperficieTotal, opts.USDRate); ok {
+					precios = append(precios, v)
+				}
+			}
+		}
+		p.MedianUSDPerM2 = median(precios)
+		points = append(points, p)
+	}
+
+	return points
+}
+
+// Index guarda las estadísticas de todos los segmentos para comparar propiedades
+type Index struct {
+	segments map[Key]SegmentStats
+	usdRate  float64
+	BuiltAt  time.Time
+}
+
+// NewIndex calcula las estadísticas de todos los segmentos
+func NewIndex(listings []db.MarketListing, usdRate float64, now time.Time) *Index {
+	idx := &Index{segments: map[Key]SegmentStats{}, usdRate: usdRate, BuiltAt: now}
+	for _, s := range Segments(listings, Options{USDRate: usdRate}, now) {
+		k := Key{Location: normalize(s.Location), Operation: s.Operation}
+		if s.PropertyTypeID != nil {
+			k.PropertyTypeID = *s.PropertyTypeID
+		}
+		idx.segments[k] = s
+	}
+	return idx
+}
+
+// Compare compara el precio por m² de una propiedad con la mediana de su segmento.
+// Devuelve nil si no tiene precio por m² o el segmento tiene menos de MinSegmentSize precios.
+func (idx *Index) Compare(p *db.Propiedad) *Comparison {
+	if p.Ubicacion == nil {
+		return nil
+	}
+	valor, ok := USDPerM2(p.Precio, p.SuperficieCubierta, p.SuperficieTotal, idx.usdRate)
+	if !ok {
+		return nil
+	}
+
+	var operacion string
+	if p.Operacion != nil {
+		operacion = *p.Operacion
+	}
+	s, ok := idx.segments[keyOf(*p.Ubicacion, p.TipoPropiedad, operacion)]
+	if !ok || s.Priced < MinSegmentSize {
+		return nil
+	}
+
+	return &Comparison{
+		Location:       s.Location,
+		PropertyTypeID: s.PropertyTypeID,
+		Operation:      s.Operation,
+		USDPerM2:       math.Round(valor),
+		SegmentMedian:  math.Round(s.USDPerM2.Median),
+		SegmentCount:   s.Priced,
+		DiffPct:        math.Round((valor/s.USDPerM2.Median-1)*1000) / 10,
+	}
+}
+
+func median(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	m := percentile(sorted, 0.5)
+	return &m
+}
+
+func percentiles(values []float64) *Percentiles {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return &Percentiles{
+		P10:    percentile(sorted, 0.10),
+		P25:    percentile(sorted, 0.25),
+		Median: percentile(sorted, 0.50),
+		P75:    percentile(sorted, 0.75),
+		P90:    percentile(sorted, 0.90),
+	}
+}
+
+// percentile interpola linealmente entre los valores ordenados
+func percentile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
@@ -0,0 +1,40 @@
+package analytics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/findhouse/internal/db"
+)
+
+// Cache mantiene el índice de segmentos para no recalcularlo en cada listado
+type Cache struct {
+	db  *db.DB
+	ttl time.Duration
+
+	mu    sync.Mutex
+	index *Index
+}
+
+// NewCache crea un caché que recalcula el índice cuando tiene más de ttl
+func NewCache(database *db.DB, ttl time.Duration) *Cache {
+	return &Cache{db: database, ttl: ttl}
+}
+
+// Index devuelve el índice de segmentos, solo con precios en dólares, recalculándolo si venció
+func (c *Cache) Index() (*Index, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UTC()
+	if c.index != nil && now.Sub(c.index.BuiltAt) < c.ttl {
+		return c.index, nil
+	}
+
+	listings, err := c.db.GetMarketListings()
+	if err != nil {
+		return nil, err
+	}
+	c.index = NewIndex(listings, 0, now)
+	return c.index, nil
+}
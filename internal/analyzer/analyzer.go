@@ -3,6 +3,7 @@ package analyzer
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -13,12 +14,11 @@ import (
 	"github.com/findhouse/internal/db"
 	"github.com/findhouse/internal/models"
 	"github.com/findhouse/internal/scraper"
+	"github.com/findhouse/internal/scraper/scrapeerr"
 )
 
 // SearchAndSaveInmobiliarias busca inmobiliarias en Google Maps y guarda solo las nuevas en la DB
-func SearchAndSaveInmobiliarias(database *db.DB, zone string) error {
-	ctx := context.Background()
-
+func SearchAndSaveInmobiliarias(ctx context.Context, database *db.DB, zone string) error {
 	// Usar el scraper existente para buscar inmobiliarias
 	results, err := scraper.SearchInmobiliarias(ctx, zone)
 	if err != nil {
@@ -33,8 +33,23 @@ func SearchAndSaveInmobiliarias(database *db.DB, zone string) error {
 
 	// Guardar cada inmobiliaria en la DB solo si no existe
 	for _, result := range results {
-		// Verificar si ya existe
-		exists, err := database.ExistsInmobiliaria(result.Nombre, result.Direccion)
+		// Convertir rating de string a float64
+		rating, err := strconv.ParseFloat(result.Rating, 64)
+		if err != nil {
+			rating = 0 // Valor por defecto si hay error en la conversión
+		}
+
+		inmo := &db.Inmobiliaria{
+			Nombre:    result.Nombre,
+			URL:       result.SitioWeb,
+			Direccion: result.Direccion,
+			Telefono:  result.Telefono,
+			Rating:    rating,
+			Zona:      zone,
+		}
+
+		// Verificar si ya existe (mismo sitio web, teléfono o nombre y dirección similares)
+		exists, err := database.ExistsInmobiliaria(inmo)
 		if err != nil {
 			continue
 		}
@@ -51,21 +66,6 @@ func SearchAndSaveInmobiliarias(database *db.DB, zone string) error {
 			dudosasLista = append(dudosasLista, result.Nombre)
 		}
 
-		// Convertir rating de string a float64
-		rating, err := strconv.ParseFloat(result.Rating, 64)
-		if err != nil {
-			rating = 0 // Valor por defecto si hay error en la conversión
-		}
-
-		inmo := &db.Inmobiliaria{
-			Nombre:    result.Nombre,
-			URL:       result.SitioWeb,
-			Direccion: result.Direccion,
-			Telefono:  result.Telefono,
-			Rating:    rating,
-			Zona:      zone,
-		}
-
 		if err := database.CreateInmobiliaria(inmo); err != nil {
 			log.Printf("Error guardando inmobiliaria %s: %v\n", inmo.Nombre, err)
 			continue
@@ -96,8 +96,59 @@ func SearchAndSaveInmobiliarias(database *db.DB, zone string) error {
 	return nil
 }
 
+// DedupeAgencies busca inmobiliarias duplicadas y muestra las fusiones propuestas.
+// No modifica la base de datos: las fusiones se aplican con POST /api/agencies/{id}/merge
+func DedupeAgencies(database *db.DB) error {
+	propuestas, err := database.ProposeInmobiliariaMerges()
+	if err != nil {
+		return fmt.Errorf("error buscando inmobiliarias duplicadas: %v", err)
+	}
+
+	if len(propuestas) == 0 {
+		fmt.Println("No se encontraron inmobiliarias duplicadas")
+		return nil
+	}
+
+	fmt.Printf("Se proponen %d fusiones:\n", len(propuestas))
+	for _, p := range propuestas {
+		fmt.Printf("\n🔁 Conservar #%d %s (%s)\n", p.Keep.ID, p.Keep.Nombre, p.Keep.URL)
+		fmt.Printf("   Fusionar  #%d %s (%s)\n", p.Duplicate.ID, p.Duplicate.Nombre, p.Duplicate.URL)
+		fmt.Printf("   Coincidencia %.0f%%: %s\n", p.Match.Score*100, strings.Join(p.Match.Reasons, ", "))
+		fmt.Printf("   POST /api/agencies/%d/merge {\"duplicate_id\": %d}\n", p.Keep.ID, p.Duplicate.ID)
+	}
+
+	return nil
+}
+
+// EvaluateSavedSearches evalúa cada búsqueda guardada y registra las propiedades que coinciden por primera vez
+func EvaluateSavedSearches(database *db.DB) error {
+	busquedas, err := database.GetSavedSearches()
+	if err != nil {
+		return err
+	}
+
+	var fallidas int
+	for i := range busquedas {
+		busqueda := &busquedas[i]
+		nuevas, err := database.RecordSavedSearchMatches(busqueda)
+		if err != nil {
+			log.Printf("Error evaluando búsqueda %q: %v\n", busqueda.Name, err)
+			fallidas++
+			continue
+		}
+		if len(nuevas) > 0 {
+			fmt.Printf("🔎 Búsqueda %q: %d propiedades nuevas (%d en total)\n", busqueda.Name, len(nuevas), busqueda.Matches)
+		}
+	}
+
+	if fallidas > 0 {
+		return fmt.Errorf("fallaron %d de %d búsquedas guardadas", fallidas, len(busquedas))
+	}
+	return nil
+}
+
 // AnalyzeSystem analiza las inmobiliarias y guarda/actualiza en la base de datos
-func AnalyzeSystem(database *db.DB) error {
+func AnalyzeSystem(ctx context.Context, database *db.DB) error {
 	// Obtener inmobiliarias sin sistema identificado
 	inmobiliarias, err := database.GetInmobiliariasSinSistema()
 	if err != nil {
@@ -107,6 +158,10 @@ func AnalyzeSystem(database *db.DB) error {
 	fmt.Printf("Analizando %d inmobiliarias...\n", len(inmobiliarias))
 
 	for _, inmo := range inmobiliarias {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		system, err := scraper.AnalyzeSystem(inmo.URL)
 		if err != nil {
 			log.Printf("Error detectando sistema para %s: %v\n", inmo.Nombre, err)
@@ -130,9 +185,7 @@ func AnalyzeSystem(database *db.DB) error {
 }
 
 // SearchProperties busca propiedades en las inmobiliarias y las guarda en la DB
-func SearchProperties(database *db.DB, testMode bool, inmobiliariaFilter string) error {
-	ctx := context.Background()
-
+func SearchProperties(ctx context.Context, database *db.DB, testMode bool, inmobiliariaFilter string) error {
 	// Obtener inmobiliarias con sistema identificado
 	inmobiliarias, err := database.GetInmobiliariasSistema()
 	if err != nil {
@@ -160,66 +213,24 @@ func SearchProperties(database *db.DB, testMode bool, inmobiliariaFilter string)
 
 	var indexTest int
 	for _, inmo := range inmobiliarias {
-		fmt.Printf("\nScrapeando %s (%s)...\n", inmo.Nombre, inmo.URL)
-
-		// Crear un scraper basado en el sistema de la inmobiliaria
-		propertyScraper := scraper.NewScraper(inmo.Sistema, inmo.URL)
-		if propertyScraper == nil {
-			log.Printf("Sistema no soportado: %s\n", inmo.Sistema)
-			continue
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 
-		// Crear contexto con timeout para evitar bloqueos
-		propCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-
-		// Usar el scraper para buscar propiedades
-		properties, err := propertyScraper.SearchProperties(propCtx)
-		cancel()
-
+		resultado, err := scrapeInmobiliaria(ctx, database, inmo)
 		if err != nil {
-			log.Printf("Error scrapeando %s: %v\n", inmo.Nombre, err)
+			log.Println(err)
 			continue
 		}
 
-		fmt.Printf("Encontradas %d propiedades en %s\n", len(properties), inmo.Nombre)
-		totalPropiedades += len(properties)
-
-		// Procesar cada propiedad
-		for _, prop := range properties {
-			// Convertir de models.Property a db.Propiedad
-			propiedad := &db.Propiedad{
-				InmobiliariaID: inmo.ID,
-				Codigo:         prop.Code,
-				Titulo:         prop.Title,
-				Precio:         prop.PriceText,
-				Moneda:         prop.Currency,
-				Direccion:      prop.Address,
-				URL:            prop.URL,
-				ImagenURL:      prop.ImageURL,
-				Status:         "pending",
-			}
-
-			// Ya no vinculamos con búsqueda
-			err := database.CreatePropiedad(propiedad)
-			if err != nil {
-				log.Printf("Error guardando propiedad %s: %v\n", propiedad.Codigo, err)
-				continue
-			}
-
-			if propiedad.CreatedAt == propiedad.UpdatedAt {
-				nuevasPropiedades++
-			} else {
-				propiedadesExistentes++
-			}
-		}
+		totalPropiedades += resultado.encontradas
+		nuevasPropiedades += resultado.nuevas
+		propiedadesExistentes += resultado.existentes
 
 		indexTest++
 		if testMode && indexTest == 5 {
 			break
 		}
-
-		// Delay entre inmobiliarias para no sobrecargar
-		time.Sleep(2 * time.Second)
 	}
 
 	fmt.Printf("\nResumen:\n"+
@@ -231,6 +242,161 @@ func SearchProperties(database *db.DB, testMode bool, inmobiliariaFilter string)
 	return nil
 }
 
+// ScrapeAgency busca y guarda las propiedades de una sola inmobiliaria
+func ScrapeAgency(ctx context.Context, database *db.DB, inmobiliariaID int64) error {
+	inmo, err := database.GetInmobiliariaByID(inmobiliariaID)
+	if err != nil {
+		return err
+	}
+
+	if inmo.Disabled {
+		return fmt.Errorf("la inmobiliaria %s está deshabilitada", inmo.Nombre)
+	}
+
+	resultado, err := scrapeInmobiliaria(ctx, database, *inmo)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nResumen %s:\n"+
+		"- Total propiedades encontradas: %d\n"+
+		"- Propiedades nuevas: %d\n"+
+		"- Propiedades existentes: %d\n",
+		inmo.Nombre, resultado.encontradas, resultado.nuevas, resultado.existentes)
+
+	return nil
+}
+
+// resultadoScraping cuenta las propiedades encontradas en una inmobiliaria
+type resultadoScraping struct {
+	encontradas int
+	nuevas      int
+	existentes  int
+}
+
+// scrapeInmobiliaria busca las propiedades de una inmobiliaria y las guarda en la DB
+func scrapeInmobiliaria(ctx context.Context, database *db.DB, inmo db.Inmobiliaria) (resultadoScraping, error) {
+	var resultado resultadoScraping
+
+	fmt.Printf("\nScrapeando %s (%s)...\n", inmo.Nombre, inmo.URL)
+
+	// Crear un scraper basado en el sistema de la inmobiliaria
+	propertyScraper, err := scraper.NewScraper(inmo.Sistema, inmo.URL)
+	if err != nil {
+		return resultado, err
+	}
+
+	// Registrar la ejecución para el historial de la inmobiliaria
+	run, err := database.StartScrapeRun(inmo.ID, db.ScrapeRunSearch)
+	if err != nil {
+		log.Printf("Error registrando ejecución: %v\n", err)
+	} else {
+		defer func() {
+			run.ListingsFound = resultado.encontradas
+			run.NewCount = resultado.nuevas
+			run.UpdatedCount = resultado.existentes
+			if err := database.FinishScrapeRun(run); err != nil {
+				log.Printf("Error finalizando ejecución: %v\n", err)
+			}
+		}()
+	}
+
+	// Usar el scraper para buscar propiedades. El scraper limita la búsqueda a
+	// scraper.TimeoutBusqueda desde que el sitio le da turno.
+	properties, err := propertyScraper.SearchProperties(ctx)
+	completo := true
+	if errors.Is(err, scrapeerr.ErrIncomplete) {
+		// Se guardan las propiedades encontradas, pero no se dan de baja las que faltan
+		log.Printf("Listado incompleto de %s: %v\n", inmo.Nombre, err)
+		if run != nil {
+			run.LastError = err.Error()
+		}
+		completo, err = false, nil
+	}
+	if err != nil {
+		if run != nil {
+			run.Status = db.ScrapeRunFailed
+			run.LastError = err.Error()
+		}
+		if errors.Is(err, scrapeerr.ErrLayoutChanged) {
+			alertarScraperRoto(inmo, err)
+		}
+		return resultado, fmt.Errorf("error scrapeando %s: %w", inmo.Nombre, err)
+	}
+
+	fmt.Printf("Encontradas %d propiedades en %s\n", len(properties), inmo.Nombre)
+	resultado.encontradas = len(properties)
+
+	// Procesar cada propiedad
+	codigos := make([]string, 0, len(properties))
+	for _, prop := range properties {
+		// Convertir de models.Property a db.Propiedad
+		propiedad := &db.Propiedad{
+			InmobiliariaID: inmo.ID,
+			Codigo:         prop.Code,
+			Titulo:         prop.Title,
+			Precio:         prop.PriceText,
+			Moneda:         prop.Currency,
+			Direccion:      prop.Address,
+			URL:            prop.URL,
+			ImagenURL:      prop.ImageURL,
+			Status:         "pending",
+		}
+
+		// Ya no vinculamos con búsqueda
+		err := database.CreatePropiedad(propiedad)
+		if propiedad.Codigo != "" {
+			codigos = append(codigos, propiedad.Codigo)
+		}
+		if err != nil {
+			log.Printf("Error guardando propiedad %s: %v\n", propiedad.Codigo, err)
+			if run != nil {
+				run.FailedCount++
+				run.LastError = err.Error()
+			}
+			continue
+		}
+
+		if propiedad.CreatedAt == propiedad.UpdatedAt {
+			resultado.nuevas++
+		} else {
+			resultado.existentes++
+		}
+	}
+
+	// Las propiedades que ya no están en un listado completo se dan de baja
+	if !completo {
+		return resultado, nil
+	}
+	ausentes, err := database.MarkPropiedadesAusentes(inmo.ID, codigos)
+	if err != nil {
+		log.Printf("Error marcando propiedades dadas de baja de %s: %v\n", inmo.Nombre, err)
+		if run != nil {
+			run.LastError = err.Error()
+		}
+	} else if ausentes > 0 {
+		fmt.Printf("%d propiedades ya no están en el listado de %s\n", ausentes, inmo.Nombre)
+		if run != nil {
+			run.UnavailableCount += ausentes
+		}
+	}
+
+	return resultado, nil
+}
+
+// esperar duerme la duración indicada o hasta que se cancele el contexto
+func esperar(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Función auxiliar para convertir a puntero
 func ptr[T any](v T) *T {
 	return &v
@@ -263,42 +429,181 @@ func normalizarTipoPropiedad(tipo string) string {
 	}
 }
 
-// UpdateProperties actualiza los detalles de las propiedades en la base de datos
-func UpdateProperties(database *db.DB, testMode bool, inmobiliariaFilter string) error {
-	ctx := context.Background()
+// Parámetros de la cola de detalles
+const (
+	// Tiempo durante el cual un job tomado queda reservado para este proceso
+	leaseDetalles = 15 * time.Minute
+	// Espera máxima por reintentos pendientes antes de terminar la ejecución
+	maxEsperaReintentos = 5 * time.Minute
+	// Timeout del procesamiento de una ficha guardada
+	timeoutFicha = 2 * time.Minute
+)
 
-	// Obtener propiedades sin detalles
-	propiedades, err := database.GetPropiedadesSinDetalles()
+// extraccionDetalle es el resultado de extraer los detalles de un job
+type extraccionDetalle struct {
+	job        db.DetailJob
+	details    *models.PropertyDetails
+	err        error
+	reintentar bool
+}
+
+// UpdateProperties procesa la cola persistente de extracción de detalles.
+// Los jobs se toman con un lease, así que una ejecución interrumpida se retoma en la siguiente.
+// Al cancelarse el contexto deja de tomar jobs y devuelve a la cola los que estaban en curso.
+func UpdateProperties(ctx context.Context, database *db.DB, testMode bool, inmobiliariaFilter string) error {
+	// Encolar las propiedades sin detalles que todavía no tienen job
+	encoladas, err := database.EnqueuePendingDetailJobs()
 	if err != nil {
-		return fmt.Errorf("error obteniendo propiedades sin detalles: %v", err)
+		return err
 	}
+	fmt.Printf("Encoladas %d propiedades sin detalles\n", encoladas)
 
-	fmt.Printf("Encontradas %d propiedades sin detalles\n", len(propiedades))
+	inmobiliariaIDs, err := filtrarInmobiliarias(database, inmobiliariaFilter)
+	if err != nil {
+		return err
+	}
 
-	// Filtrar por inmobiliaria si se especificó
-	if inmobiliariaFilter != "" {
-		var propiedadesFiltradas []db.Propiedad
-		for _, prop := range propiedades {
-			// Obtener información de la inmobiliaria
-			inmobiliaria, err := database.GetInmobiliariaByID(prop.InmobiliariaID)
-			if err != nil {
+	return procesarColaDetalles(ctx, database, testMode, inmobiliariaIDs)
+}
+
+// RefreshProperties vuelve a extraer los detalles de las propiedades cuya última extracción
+// tiene más de maxAge, empezando por las favoritas y las que tienen like. Procesa como mucho
+// limit propiedades desactualizadas, además de los jobs que ya estuvieran en la cola.
+func RefreshProperties(ctx context.Context, database *db.DB, testMode bool, inmobiliariaFilter string, maxAge time.Duration, limit int) error {
+	inmobiliariaIDs, err := filtrarInmobiliarias(database, inmobiliariaFilter)
+	if err != nil {
+		return err
+	}
+
+	encoladas, err := database.EnqueueStaleDetailJobs(maxAge, limit, inmobiliariaIDs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Encoladas %d propiedades con detalles de más de %s\n", encoladas, maxAge)
+
+	return procesarColaDetalles(ctx, database, testMode, inmobiliariaIDs)
+}
+
+// ReparseSnapshots vuelve a extraer los detalles de la última ficha guardada de cada propiedad
+// y actualiza las propiedades, sin visitar los sitios. Sirve para aplicar a los datos ya
+// descargados las correcciones de los extractores. Procesa como mucho limit fichas (0 sin límite);
+// si se interrumpe, la siguiente ejecución vuelve a empezar.
+func ReparseSnapshots(ctx context.Context, database *db.DB, inmobiliariaFilter string, limit int) error {
+	inmobiliariaIDs, err := filtrarInmobiliarias(database, inmobiliariaFilter)
+	if err != nil {
+		return err
+	}
+
+	fichas, err := database.LatestDetailSnapshotIDs(time.Now(), limit, inmobiliariaIDs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Fichas guardadas a procesar: %d\n", len(fichas))
+
+	// Un extractor por inmobiliaria; nil si su sistema no permite volver a procesar fichas
+	parsers := make(map[int64]scraper.SnapshotParser)
+	var actualizadas, fallidas, sinExtractor int
+
+	for _, fichaID := range fichas {
+		if ctx.Err() != nil {
+			break
+		}
+
+		ficha, err := database.GetDetailSnapshot(fichaID)
+		if err != nil {
+			log.Printf("Error obteniendo ficha %d: %v\n", fichaID, err)
+			fallidas++
+			continue
+		}
+
+		prop, err := database.GetPropiedadByID(ficha.PropiedadID)
+		if err != nil {
+			log.Printf("Error obteniendo propiedad %d: %v\n", ficha.PropiedadID, err)
+			fallidas++
+			continue
+		}
+
+		parser, ok := parsers[prop.InmobiliariaID]
+		if !ok {
+			if inmo, err := database.GetInmobiliariaByID(prop.InmobiliariaID); err != nil {
 				log.Printf("Error obteniendo inmobiliaria %d: %v\n", prop.InmobiliariaID, err)
-				continue
+			} else if parser, err = scraper.NewSnapshotParser(inmo.Sistema, inmo.URL); err != nil {
+				log.Printf("%s: %v\n", inmo.Nombre, err)
 			}
+			parsers[prop.InmobiliariaID] = parser
+		}
+		if parser == nil {
+			sinExtractor++
+			continue
+		}
 
-			if strings.Contains(strings.ToLower(inmobiliaria.Nombre), strings.ToLower(inmobiliariaFilter)) {
-				propiedadesFiltradas = append(propiedadesFiltradas, prop)
+		fichaCtx, cancel := context.WithTimeout(ctx, timeoutFicha)
+		details, err := parser.ParseSnapshot(fichaCtx, ficha.URL, ficha.HTML)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
 			}
+			fallidas++
+			fmt.Printf("❌ Error procesando la ficha de la propiedad %s (%s): %v\n", prop.Codigo, scrapeerr.Classify(err), err)
+			continue
 		}
 
-		if len(propiedadesFiltradas) == 0 {
-			return fmt.Errorf("no se encontraron propiedades para la inmobiliaria '%s'", inmobiliariaFilter)
+		// La ficha puede ser de una propiedad ya dada de baja: se conserva su estado
+		estado := prop.Status
+		aplicarDetalles(database, prop, details)
+		prop.Status = estado
+		if err := database.ReparsePropiedadDetalles(prop); err != nil {
+			fallidas++
+			fmt.Printf("❌ Error actualizando propiedad %s: %v\n", prop.Codigo, err)
+			continue
+		}
+		if err := database.MarkDetailSnapshotReparsed(ficha.ID); err != nil {
+			log.Printf("Error registrando ficha procesada: %v\n", err)
+		}
+
+		actualizadas++
+		fmt.Printf("✓ Propiedad %s actualizada desde la ficha del %s\n", prop.Codigo, ficha.FetchedAt.Format("2006-01-02 15:04"))
+	}
+
+	fmt.Printf("\nResumen:\n"+
+		"- Propiedades actualizadas: %d\n"+
+		"- Fichas fallidas: %d\n"+
+		"- Fichas sin extractor para su sistema: %d\n",
+		actualizadas, fallidas, sinExtractor)
+
+	return ctx.Err()
+}
+
+// filtrarInmobiliarias devuelve los IDs de las inmobiliarias cuyo nombre contiene filtro,
+// o nil si no hay filtro
+func filtrarInmobiliarias(database *db.DB, filtro string) ([]int64, error) {
+	if filtro == "" {
+		return nil, nil
+	}
+
+	inmobiliarias, err := database.GetAllAgencies()
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo inmobiliarias: %v", err)
+	}
+
+	var inmobiliariaIDs []int64
+	for _, inmo := range inmobiliarias {
+		if strings.Contains(strings.ToLower(inmo.Nombre), strings.ToLower(filtro)) {
+			inmobiliariaIDs = append(inmobiliariaIDs, inmo.ID)
 		}
+	}
 
-		propiedades = propiedadesFiltradas
-		fmt.Printf("Filtrando por inmobiliaria: %s (encontradas %d propiedades)\n", inmobiliariaFilter, len(propiedades))
+	if len(inmobiliariaIDs) == 0 {
+		return nil, fmt.Errorf("no se encontró ninguna inmobiliaria con el nombre '%s'", filtro)
 	}
+	fmt.Printf("Filtrando por inmobiliaria: %s (encontradas %d)\n", filtro, len(inmobiliariaIDs))
+
+	return inmobiliariaIDs, nil
+}
 
+// procesarColaDetalles toma y procesa los jobs de detalles disponibles hasta vaciar la cola
+func procesarColaDetalles(ctx context.Context, database *db.DB, testMode bool, inmobiliariaIDs []int64) error {
 	// Definir el número de trabajadores concurrentes
 	// Ajusta este valor según la capacidad de tu sistema
 	numWorkers := 15
@@ -307,119 +612,223 @@ func UpdateProperties(database *db.DB, testMode bool, inmobiliariaFilter string)
 	}
 
 	// Limitar el número de propiedades en modo de prueba
-	if testMode && len(propiedades) > 5 {
-		propiedades = propiedades[:5]
+	limite := -1
+	if testMode {
+		limite = 5
 	}
 
-	// Crear un canal para las propiedades a procesar
-	propiedadesChan := make(chan db.Propiedad, len(propiedades))
-
-	// Crear un canal para los resultados de extracción (no de escritura)
-	extraccionesChan := make(chan struct {
-		propiedad    db.Propiedad
-		details      *models.PropertyDetails
-		exito        bool
-		noDisponible bool
-	}, len(propiedades))
+	// Los trabajadores solo extraen; todas las escrituras las hace este coordinador
+	jobsChan := make(chan db.DetailJob, numWorkers)
+	extraccionesChan := make(chan extraccionDetalle, numWorkers)
 
-	// Crear un canal para los resultados finales
-	resultadosChan := make(chan struct {
-		exito        bool
-		noDisponible bool
-		propCodigo   string
-	}, len(propiedades))
-
-	// Crear un WaitGroup para esperar a que todos los trabajadores terminen
 	var wg sync.WaitGroup
-
-	// Iniciar los trabajadores de extracción
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			extraerPropiedades(ctx, database, propiedadesChan, extraccionesChan, workerID)
+			extraerPropiedades(ctx, database, jobsChan, extraccionesChan, workerID)
 		}(i)
 	}
 
-	// Iniciar el trabajador de escritura (solo uno para evitar bloqueos)
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		procesarEscrituras(database, extraccionesChan, resultadosChan)
-	}()
+	// Una ejecución de detalles por cada inmobiliaria involucrada
+	ejecuciones := make(map[int64]*db.ScrapeRun)
 
-	// Enviar las propiedades al canal
-	for _, prop := range propiedades {
-		propiedadesChan <- prop
-	}
-	close(propiedadesChan) // Cerrar el canal cuando todas las propiedades han sido enviadas
+	// Contadores para estadísticas
+	var actualizadas, fallidas, reintentos, noDisponibles, tomados, enVuelo int
 
-	// Crear un canal para recopilar los resultados
-	doneChan := make(chan struct{})
+	// Inmobiliarias ya alertadas por un cambio de estructura en esta ejecución
+	alertadas := make(map[int64]bool)
 
-	// Contadores para estadísticas
-	var actualizadas, fallidas, noDisponibles int
-
-	// Goroutine para recopilar resultados
-	go func() {
-		for resultado := range resultadosChan {
-			if resultado.noDisponible {
-				noDisponibles++
-				fmt.Printf("⚠️ Propiedad %s no disponible\n", resultado.propCodigo)
-			} else if resultado.exito {
-				actualizadas++
-				fmt.Printf("✓ Propiedad %s actualizada exitosamente\n", resultado.propCodigo)
+	for {
+		// Tomar jobs mientras haya trabajadores libres
+		if ctx.Err() == nil && (limite < 0 || tomados < limite) {
+			libres := numWorkers - enVuelo
+			if limite >= 0 {
+				libres = min(libres, limite-tomados)
+			}
+
+			if libres > 0 {
+				jobs, err := database.LeaseDetailJobs(libres, leaseDetalles, inmobiliariaIDs)
+				if err != nil {
+					log.Printf("Error tomando jobs: %v\n", err)
+				}
+				for _, job := range jobs {
+					registrarEjecucionDetalles(database, ejecuciones, job.Propiedad.InmobiliariaID)
+					jobsChan <- job
+				}
+				tomados += len(jobs)
+				enVuelo += len(jobs)
+			}
+		}
+
+		if enVuelo == 0 {
+			if ctx.Err() != nil || (limite >= 0 && tomados >= limite) {
+				break
+			}
+
+			// No hay jobs disponibles: esperar al próximo reintento si es cercano
+			proximo, hay, err := database.NextDetailJobAttempt(inmobiliariaIDs)
+			if err != nil {
+				log.Printf("Error consultando reintentos: %v\n", err)
+				break
+			}
+			espera := time.Until(proximo)
+			if !hay || espera > maxEsperaReintentos {
+				break
+			}
+			if espera > 0 {
+				fmt.Printf("⏳ Esperando %s para reintentar propiedades fallidas\n", espera.Round(time.Second))
+				if err := esperar(ctx, espera); err != nil {
+					break
+				}
+			}
+			continue
+		}
+
+		extraccion := <-extraccionesChan
+		enVuelo--
+
+		job := extraccion.job
+		run := ejecuciones[job.Propiedad.InmobiliariaID]
+
+		// Un job cortado por el apagado no cuenta como intento fallido
+		if extraccion.err != nil && ctx.Err() != nil {
+			if err := database.ReleaseDetailJob(job.ID); err != nil {
+				log.Printf("Error devolviendo job a la cola: %v\n", err)
+			}
+			if run != nil {
+				run.ListingsFound--
+			}
+			continue
+		}
+
+		categoria := scrapeerr.Classify(extraccion.err)
+		if extraccion.err == nil {
+			extraccion.err = guardarDetalles(database, job.Propiedad, extraccion.details)
+			extraccion.reintentar = true
+		}
+		if extraccion.err == nil && extraccion.details.HTML != "" {
+			// Sin la ficha guardada solo se pierde la posibilidad de volver a procesarla
+			if err := database.SaveDetailSnapshot(job.PropiedadID, job.Propiedad.URL, extraccion.details.HTML, extraccion.details.FetchedAt); err != nil {
+				log.Printf("Error guardando ficha: %v\n", err)
+			}
+		}
+
+		switch {
+		case categoria == scrapeerr.CategoryNotFound:
+			if err := database.MarkPropiedadUnavailable(job.PropiedadID, string(categoria)); err != nil {
+				log.Printf("Error marcando propiedad no disponible: %v\n", err)
+			}
+			if err := database.CompleteDetailJob(job.ID); err != nil {
+				log.Printf("Error completando job: %v\n", err)
+			}
+			if run != nil {
+				run.UnavailableCount++
+			}
+			noDisponibles++
+			fmt.Printf("⚠️ Propiedad %s no disponible\n", job.Propiedad.Codigo)
+
+		case extraccion.err != nil:
+			if categoria != "" {
+				if err := database.SetPropiedadErrorCategory(job.PropiedadID, string(categoria)); err != nil {
+					log.Printf("Error registrando categoría de error: %v\n", err)
+				}
+			}
+			if categoria == scrapeerr.CategoryLayoutChanged && !alertadas[job.Propiedad.InmobiliariaID] {
+				alertadas[job.Propiedad.InmobiliariaID] = true
+				if inmo, err := database.GetInmobiliariaByID(job.Propiedad.InmobiliariaID); err == nil {
+					alertarScraperRoto(*inmo, extraccion.err)
+				}
+			}
+
+			estado, err := database.FailDetailJob(&job, extraccion.err, extraccion.reintentar)
+			if err != nil {
+				log.Printf("Error registrando fallo: %v\n", err)
+			}
+			if run != nil {
+				run.FailedCount++
+				run.LastError = extraccion.err.Error()
+			}
+			if estado == db.DetailJobPending {
+				reintentos++
+				fmt.Printf("🔁 Propiedad %s falló (intento %d), se reintentará: %v\n", job.Propiedad.Codigo, job.Attempts, extraccion.err)
 			} else {
 				fallidas++
-				fmt.Printf("❌ Error actualizando propiedad %s\n", resultado.propCodigo)
+				fmt.Printf("❌ Error actualizando propiedad %s: %v\n", job.Propiedad.Codigo, extraccion.err)
+			}
+
+		default:
+			if err := database.CompleteDetailJob(job.ID); err != nil {
+				log.Printf("Error completando job: %v\n", err)
 			}
+			if run != nil {
+				run.UpdatedCount++
+			}
+			actualizadas++
+			fmt.Printf("✓ Propiedad %s actualizada exitosamente\n", job.Propiedad.Codigo)
 		}
-		doneChan <- struct{}{}
-	}()
+	}
 
-	// Esperar a que todos los trabajadores terminen
+	close(jobsChan)
 	wg.Wait()
-	close(extraccionesChan) // Cerrar el canal de extracciones
-	close(resultadosChan)   // Cerrar el canal de resultados
-	<-doneChan              // Esperar a que se procesen todos los resultados
+
+	finalizarEjecuciones(database, ejecuciones)
 
 	fmt.Printf("\nResumen:\n"+
 		"- Propiedades actualizadas: %d\n"+
 		"- Propiedades fallidas: %d\n"+
+		"- Propiedades a reintentar: %d\n"+
 		"- Propiedades no disponibles: %d\n",
-		actualizadas, fallidas, noDisponibles)
+		actualizadas, fallidas, reintentos, noDisponibles)
 
-	return nil
+	return ctx.Err()
 }
 
-// extraerPropiedades extrae los detalles de las propiedades sin escribir en la base de datos
-func extraerPropiedades(ctx context.Context, database *db.DB, propiedadesChan <-chan db.Propiedad, extraccionesChan chan<- struct {
-	propiedad    db.Propiedad
-	details      *models.PropertyDetails
-	exito        bool
-	noDisponible bool
-}, workerID int) {
-	for prop := range propiedadesChan {
-		fmt.Printf("\n[Worker %d] Extrayendo propiedad %s\n", workerID, prop.Codigo)
+// registrarEjecucionDetalles cuenta un job en la ejecución de detalles de su inmobiliaria,
+// registrando la ejecución la primera vez
+func registrarEjecucionDetalles(database *db.DB, ejecuciones map[int64]*db.ScrapeRun, inmobiliariaID int64) {
+	if run, ok := ejecuciones[inmobiliariaID]; ok {
+		run.ListingsFound++
+		return
+	}
+
+	run, err := database.StartScrapeRun(inmobiliariaID, db.ScrapeRunDetails)
+	if err != nil {
+		log.Printf("Error registrando ejecución: %v\n", err)
+		return
+	}
+	run.ListingsFound = 1
+	ejecuciones[inmobiliariaID] = run
+}
+
+// finalizarEjecuciones guarda el resultado de las ejecuciones de detalles.
+// Una ejecución en la que fallaron todas las propiedades se marca como fallida.
+func finalizarEjecuciones(database *db.DB, ejecuciones map[int64]*db.ScrapeRun) {
+	for _, run := range ejecuciones {
+		if run.FailedCount > 0 && run.FailedCount == run.ListingsFound {
+			run.Status = db.ScrapeRunFailed
+		}
+		if err := database.FinishScrapeRun(run); err != nil {
+			log.Printf("Error finalizando ejecución: %v\n", err)
+		}
+	}
+}
+
+// extraerPropiedades extrae los detalles de los jobs sin escribir en la base de datos
+func extraerPropiedades(ctx context.Context, database *db.DB, jobsChan <-chan db.DetailJob, extraccionesChan chan<- extraccionDetalle, workerID int) {
+	for job := range jobsChan {
+		prop := job.Propiedad
+		fmt.Printf("\n[Worker %d] Extrayendo propiedad %s (intento %d)\n", workerID, prop.Codigo, job.Attempts)
 		fmt.Printf("   URL: %s\n", prop.URL)
 		fmt.Printf("   Inmobiliaria ID: %d\n", prop.InmobiliariaID)
 
-		resultado := struct {
-			propiedad    db.Propiedad
-			details      *models.PropertyDetails
-			exito        bool
-			noDisponible bool
-		}{
-			propiedad:    prop,
-			details:      nil,
-			exito:        false,
-			noDisponible: false,
-		}
+		resultado := extraccionDetalle{job: job}
 
 		// Obtener información de la inmobiliaria
 		inmobiliaria, err := database.GetInmobiliariaByID(prop.InmobiliariaID)
 		if err != nil {
 			log.Printf("[Worker %d] ❌ Error obteniendo inmobiliaria %d: %v\n", workerID, prop.InmobiliariaID, err)
+			resultado.err = err
 			extraccionesChan <- resultado
 			continue
 		}
@@ -427,39 +836,24 @@ func extraerPropiedades(ctx context.Context, database *db.DB, propiedadesChan <-
 		fmt.Printf("   Inmobiliaria: %s (Sistema: %s)\n", inmobiliaria.Nombre, inmobiliaria.Sistema)
 
 		// Obtener el scraper adecuado para el sistema de la inmobiliaria
-		propertyScraper := scraper.NewScraper(inmobiliaria.Sistema, inmobiliaria.URL)
-		if propertyScraper == nil {
-			log.Printf("[Worker %d] Sistema no soportado: %s\n", workerID, inmobiliaria.Sistema)
+		propertyScraper, err := scraper.NewScraper(inmobiliaria.Sistema, inmobiliaria.URL)
+		if err != nil {
+			log.Printf("[Worker %d] %v\n", workerID, err)
+			resultado.err = err
 			extraccionesChan <- resultado
 			continue
 		}
 
-		// Obtener detalles usando el contexto con timeout
-		propCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		// Obtener detalles. El scraper respeta el límite de solicitudes del sitio y corta la extracción
+		// a scraper.TimeoutDetalles desde que le da turno, así la espera en la fila no agota el timeout.
+		// Los errores transitorios se reintentan desde la cola con espera exponencial.
 		log.Printf("[Worker %d] 🔍 Intentando obtener detalles de: %s\n", workerID, prop.URL)
-		details, err := propertyScraper.GetPropertyDetails(propCtx, prop.URL)
-		cancel() // Liberamos recursos
+		details, err := propertyScraper.GetPropertyDetails(ctx, prop.URL)
 
 		if err != nil {
-			if strings.Contains(err.Error(), "context deadline exceeded") {
-				log.Printf("[Worker %d] ⌛ Timeout al procesar propiedad: %s, reintentando...\n", workerID, prop.URL)
-				// Reintento con más tiempo
-				propCtx, cancel = context.WithTimeout(ctx, 5*time.Minute)
-				details, err = propertyScraper.GetPropertyDetails(propCtx, prop.URL)
-				cancel()
-			}
-			if err != nil {
-				// Manejo de error final
-				log.Printf("[Worker %d] ❌ Error final al procesar propiedad: %v\n", workerID, err)
-				extraccionesChan <- resultado
-				continue
-			}
-		}
-
-		// Verificar si la propiedad ya no está disponible
-		if details.Descripcion == "Propiedad no disponible" {
-			log.Printf("[Worker %d] ⚠️ Propiedad no disponible: %s\n", workerID, prop.URL)
-			resultado.noDisponible = true
+			log.Printf("[Worker %d] ❌ Error al procesar propiedad (%s): %v\n", workerID, scrapeerr.Classify(err), err)
+			resultado.err = err
+			resultado.reintentar = scrapeerr.Retryable(err)
 			extraccionesChan <- resultado
 			continue
 		}
@@ -468,124 +862,91 @@ func extraerPropiedades(ctx context.Context, database *db.DB, propiedadesChan <-
 
 		// Si llegamos aquí, la extracción fue exitosa
 		resultado.details = details
-		resultado.exito = true
 		extraccionesChan <- resultado
-
-		// Delay fijo entre extracciones para evitar sobrecargar el sitio web
-		time.Sleep(1 * time.Second)
 	}
 }
 
-// procesarEscrituras procesa las escrituras en la base de datos de forma secuencial
-func procesarEscrituras(database *db.DB, extraccionesChan <-chan struct {
-	propiedad    db.Propiedad
-	details      *models.PropertyDetails
-	exito        bool
-	noDisponible bool
-}, resultadosChan chan<- struct {
-	exito        bool
-	noDisponible bool
-	propCodigo   string
-}) {
-	for extraccion := range extraccionesChan {
-		resultado := struct {
-			exito        bool
-			noDisponible bool
-			propCodigo   string
-		}{
-			exito:        false,
-			noDisponible: extraccion.noDisponible,
-			propCodigo:   extraccion.propiedad.Codigo,
-		}
-
-		// Si la propiedad no está disponible o hubo un error en la extracción, solo enviamos el resultado
-		if extraccion.noDisponible || !extraccion.exito {
-			resultadosChan <- resultado
-			continue
-		}
+// alertarScraperRoto avisa que el sitio de una inmobiliaria ya no tiene la estructura que espera su scraper
+func alertarScraperRoto(inmo db.Inmobiliaria, err error) {
+	log.Printf("🚨 El scraper de %s (%s, sistema %s) parece roto: %v\n", inmo.Nombre, inmo.URL, inmo.Sistema, err)
+}
 
-		// Procesar los datos extraídos y guardarlos en la base de datos
-		prop := extraccion.propiedad
-		details := extraccion.details
+// guardarDetalles vuelca los detalles extraídos en la propiedad y la actualiza en la base de datos
+func guardarDetalles(database *db.DB, prop db.Propiedad, details *models.PropertyDetails) error {
+	aplicarDetalles(database, &prop, details)
+	prop.Status = "completed"
 
-		// Normalizar el tipo de propiedad
-		tipoNormalizado := normalizarTipoPropiedad(details.TipoPropiedad)
+	// Actualizar la propiedad en la base de datos
+	log.Printf("[Escritor] Actualizando propiedad %s en la base de datos...\n", prop.Codigo)
+	return database.UpdatePropiedadDetalles(&prop)
+}
 
-		// Obtener el ID del tipo de propiedad
-		var tipoID int64
-		err := database.QueryRow(`
-			SELECT id FROM property_types WHERE name = ?
-		`, tipoNormalizado).Scan(&tipoID)
-		if err != nil {
-			// Si no existe, intentar insertar
-			if err == sql.ErrNoRows {
-				// Generar un código a partir del nombre
-				code := strings.ToLower(strings.ReplaceAll(tipoNormalizado, " ", "_"))
-				result, err := database.Exec(`
-					INSERT INTO property_types (code, name) VALUES (?, ?)
-				`, code, tipoNormalizado)
-				if err != nil {
-					log.Printf("[Escritor] Error al insertar tipo de propiedad '%s': %v\n", tipoNormalizado, err)
-				} else {
-					tipoID, _ = result.LastInsertId()
-				}
+// aplicarDetalles vuelca los detalles extraídos en los campos de la propiedad
+func aplicarDetalles(database *db.DB, prop *db.Propiedad, details *models.PropertyDetails) {
+	// Normalizar el tipo de propiedad
+	tipoNormalizado := normalizarTipoPropiedad(details.TipoPropiedad)
+
+	// Obtener el ID del tipo de propiedad
+	var tipoID int64
+	err := database.QueryRow(`
+		SELECT id FROM property_types WHERE name = ?
+	`, tipoNormalizado).Scan(&tipoID)
+	if err != nil {
+		// Si no existe, intentar insertar
+		if err == sql.ErrNoRows {
+			// Generar un código a partir del nombre
+			code := strings.ToLower(strings.ReplaceAll(tipoNormalizado, " ", "_"))
+			result, err := database.Exec(`
+				INSERT INTO property_types (code, name) VALUES (?, ?)
+			`, code, tipoNormalizado)
+			if err != nil {
+				log.Printf("[Escritor] Error al insertar tipo de propiedad '%s': %v\n", tipoNormalizado, err)
 			} else {
-				log.Printf("[Escritor] Error al obtener ID para tipo de propiedad '%s': %v\n", tipoNormalizado, err)
+				tipoID, _ = result.LastInsertId()
 			}
-		}
-
-		// Actualizar los campos de la propiedad con los detalles obtenidos
-		prop.TipoPropiedad = &tipoID
-		prop.Ubicacion = &details.Ubicacion
-		prop.Dormitorios = &details.Dormitorios
-		prop.Banios = &details.Banios
-		prop.Antiguedad = &details.Antiguedad
-		prop.SuperficieCubierta = &details.SuperficieCubierta
-		prop.SuperficieTotal = &details.SuperficieTotal
-		prop.SuperficieTerreno = &details.SuperficieTerreno
-		prop.Frente = &details.Frente
-		prop.Fondo = &details.Fondo
-		prop.Ambientes = &details.Ambientes
-		prop.Plantas = &details.Plantas
-		prop.Cocheras = &details.Cocheras
-		prop.Situacion = &details.Situacion
-		prop.Expensas = &details.Expensas
-		prop.Descripcion = &details.Descripcion
-		prop.Imagenes = &details.Images
-		prop.Operacion = &details.Operacion
-		prop.Condicion = &details.Condicion
-		prop.Orientacion = &details.Orientacion
-		prop.Disposicion = &details.Disposicion
-
-		// Asignar coordenadas si están disponibles
-		if details.Latitud != 0 && details.Longitud != 0 {
-			prop.Latitud = &details.Latitud
-			prop.Longitud = &details.Longitud
-		}
-
-		// Preparar las características para guardar
-		prop.Features = make(map[string][]string)
-		if len(details.Servicios) > 0 {
-			prop.Features["servicio"] = details.Servicios
-		}
-		if len(details.TiposAmbientes) > 0 {
-			prop.Features["ambiente"] = details.TiposAmbientes
-		}
-		if len(details.Adicionales) > 0 {
-			prop.Features["adicional"] = details.Adicionales
-		}
-
-		prop.Status = "completed"
-
-		// Actualizar la propiedad en la base de datos
-		log.Printf("[Escritor] Actualizando propiedad %s en la base de datos...\n", prop.Codigo)
-		err = database.UpdatePropiedadDetalles(&prop)
-		if err != nil {
-			log.Printf("[Escritor] ❌ Error actualizando propiedad: %v\n", err)
-			resultadosChan <- resultado
 		} else {
-			resultado.exito = true
-			resultadosChan <- resultado
+			log.Printf("[Escritor] Error al obtener ID para tipo de propiedad '%s': %v\n", tipoNormalizado, err)
 		}
 	}
+
+	// Actualizar los campos de la propiedad con los detalles obtenidos
+	prop.TipoPropiedad = &tipoID
+	prop.Ubicacion = &details.Ubicacion
+	prop.Dormitorios = &details.Dormitorios
+	prop.Banios = &details.Banios
+	prop.Antiguedad = &details.Antiguedad
+	prop.SuperficieCubierta = &details.SuperficieCubierta
+	prop.SuperficieTotal = &details.SuperficieTotal
+	prop.SuperficieTerreno = &details.SuperficieTerreno
+	prop.Frente = &details.Frente
+	prop.Fondo = &details.Fondo
+	prop.Ambientes = &details.Ambientes
+	prop.Plantas = &details.Plantas
+	prop.Cocheras = &details.Cocheras
+	prop.Situacion = &details.Situacion
+	prop.Expensas = &details.Expensas
+	prop.Descripcion = &details.Descripcion
+	prop.Imagenes = &details.Images
+	prop.Operacion = &details.Operacion
+	prop.Condicion = &details.Condicion
+	prop.Orientacion = &details.Orientacion
+	prop.Disposicion = &details.Disposicion
+
+	// Asignar coordenadas si están disponibles
+	if details.Latitud != 0 && details.Longitud != 0 {
+		prop.Latitud = &details.Latitud
+		prop.Longitud = &details.Longitud
+	}
+
+	// Preparar las características para guardar
+	prop.Features = make(map[string][]string)
+	if len(details.Servicios) > 0 {
+		prop.Features["servicio"] = details.Servicios
+	}
+	if len(details.TiposAmbientes) > 0 {
+		prop.Features["ambiente"] = details.TiposAmbientes
+	}
+	if len(details.Adicionales) > 0 {
+		prop.Features["adicional"] = details.Adicionales
+	}
 }
@@ -1,23 +1,98 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/findhouse/internal/analytics"
+	"github.com/findhouse/internal/analyzer"
+	"github.com/findhouse/internal/auth"
 	"github.com/findhouse/internal/db"
+	"github.com/findhouse/internal/ical"
+	"github.com/findhouse/internal/images"
+	"github.com/findhouse/internal/recommender"
+	"github.com/findhouse/internal/scraper"
 	"github.com/go-chi/chi/v5"
 )
 
 type Handler struct {
 	db *db.DB
+
+	// Inmobiliarias con un scraping en curso lanzado desde la API
+	scraping sync.Map
+
+	// Duración de las sesiones iniciadas con usuario y contraseña
+	sessionTTL time.Duration
+
+	// Directorio donde se guardan los adjuntos de las notas
+	attachmentsDir string
+
+	// Almacén local de las fotos de las propiedades
+	images *images.Store
+
+	// Estadísticas del mercado por segmento, para comparar el precio de cada propiedad
+	market *analytics.Cache
 }
 
+// Duración por defecto de una sesión
+const defaultSessionTTL = 30 * 24 * time.Hour
+
+// Directorio por defecto de los adjuntos de las notas
+const defaultAttachmentsDir = "attachments"
+
+// Directorio por defecto del almacén de fotos
+const defaultImagesDir = "images"
+
+// Cada cuánto se recalculan las estadísticas del mercado con que se comparan las propiedades
+const marketCacheTTL = 10 * time.Minute
+
 func NewHandler(db *db.DB) *Handler {
-	return &Handler{db: db}
+	return &Handler{
+		db:             db,
+		sessionTTL:     defaultSessionTTL,
+		attachmentsDir: defaultAttachmentsDir,
+		images:         images.NewStore(defaultImagesDir),
+		market:         analytics.NewCache(db, marketCacheTTL),
+	}
+}
+
+// SetAttachmentsDir cambia el directorio donde se guardan los adjuntos de las notas
+func (h *Handler) SetAttachmentsDir(dir string) {
+	if dir != "" {
+		h.attachmentsDir = dir
+	}
+}
+
+// SetImagesDir cambia el directorio del almacén de fotos, el mismo que usa el scraper con -images-dir
+func (h *Handler) SetImagesDir(dir string) {
+	if dir != "" {
+		h.images = images.NewStore(dir)
+	}
+}
+
+// SetSessionTTL cambia la duración de las sesiones nuevas
+func (h *Handler) SetSessionTTL(ttl time.Duration) {
+	if ttl > 0 {
+		h.sessionTTL = ttl
+	}
 }
 
 // PropertyResponse es la estructura de respuesta para las propiedades
@@ -39,6 +114,19 @@ type PropertyResponse struct {
 	HasNotes     bool                `json:"has_notes"`
 	IsFavorite   bool                `json:"is_favorite"`
 	Features     map[string][]string `json:"features,omitempty"`
+	// Categoría del último error al extraer los detalles (not_found, blocked, timeout, layout_changed...)
+	ErrorCategory string `json:"error_category,omitempty"`
+	// Votos de los miembros del hogar del usuario, si tiene hogar
+	Household *db.HouseholdVotes `json:"household,omitempty"`
+	// Estrellas y motivos de la calificación del usuario, en los listados de calificadas
+	Score   *int     `json:"score,omitempty"`
+	Reasons []string `json:"reasons,omitempty"`
+	// Afinidad estimada de 0 a 1 por el modelo de recomendación del usuario, en las sin calificar
+	MatchScore *float64 `json:"match_score,omitempty"`
+	// Precio por m² en dólares comparado con la mediana de su ubicación y tipo de propiedad
+	Market *analytics.Comparison `json:"market,omitempty"`
+	// Días publicada, bajas de precio y republicación, según el historial de cambios
+	Signals *db.ListingSignals `json:"signals,omitempty"`
 }
 
 type Details struct {
@@ -81,24 +169,36 @@ func (h *Handler) GetUnratedProperties(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	properties, err := h.db.GetUnratedProperties(filter)
+	userID := currentUser(r).ID
+	properties, err := h.db.GetUnratedProperties(userID, filter)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("error getting unrated properties: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	// Con modelo entrenado, primero las que más le gustarían al usuario; ?order=recent mantiene el orden por fecha
+	scores := h.matchScores(userID, properties)
+	if scores != nil && r.URL.Query().Get("order") != "recent" {
+		sort.SliceStable(properties, func(i, j int) bool {
+			return scores[properties[i].ID] > scores[properties[j].ID]
+		})
+	}
+
 	response := make([]PropertyResponse, 0, len(properties))
 	for _, p := range properties {
-		resp := h.toPropertyResponse(&p)
+		resp := h.toPropertyResponse(userID, &p)
+		if score, ok := scores[p.ID]; ok {
+			resp.MatchScore = &score
+		}
 
 		// Verificar si la propiedad tiene notas
-		hasNotes, err := h.db.PropertyHasNotes(p.ID)
+		hasNotes, err := h.db.PropertyHasNotes(userID, p.ID)
 		if err == nil {
 			resp.HasNotes = hasNotes
 		}
 
 		// Verificar si la propiedad es favorita
-		isFavorite, err := h.db.IsPropertyFavorite(p.ID)
+		isFavorite, err := h.db.IsPropertyFavorite(userID, p.ID)
 		if err == nil {
 			resp.IsFavorite = isFavorite
 		}
@@ -122,7 +222,9 @@ func (h *Handler) GetLikedProperties(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	properties, err := h.db.GetLikedProperties(filter)
+	user := currentUser(r)
+	userID := user.ID
+	properties, err := h.db.GetLikedProperties(userID, filter)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("error getting liked properties: %v", err), http.StatusInternalServerError)
 		return
@@ -130,16 +232,73 @@ func (h *Handler) GetLikedProperties(w http.ResponseWriter, r *http.Request) {
 
 	response := make([]PropertyResponse, 0, len(properties))
 	for _, p := range properties {
-		resp := h.toPropertyResponse(&p)
+		resp := h.toPropertyResponse(userID, &p)
+		resp.Household = h.householdVotes(user, p.ID)
+
+		// Estrellas y motivos de la calificación
+		if rating, err := h.db.GetPropertyRating(userID, p.ID); err == nil {
+			resp.Score = rating.Score
+			resp.Reasons = rating.Reasons
+		}
+
+		// Verificar si la propiedad tiene notas
+		hasNotes, err := h.db.PropertyHasNotes(userID, p.ID)
+		if err == nil {
+			resp.HasNotes = hasNotes
+		}
+
+		// Verificar si la propiedad es favorita
+		isFavorite, err := h.db.IsPropertyFavorite(userID, p.ID)
+		if err == nil {
+			resp.IsFavorite = isFavorite
+		}
+
+		response = append(response, resp)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"properties": response,
+		"total":      len(response),
+	})
+}
+
+// GetDislikedProperties retorna las propiedades con dislike, con sus motivos
+func (h *Handler) GetDislikedProperties(w http.ResponseWriter, r *http.Request) {
+	// Parsear filtros de la solicitud
+	filter, err := parsePropertyFilter(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error parsing filters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	user := currentUser(r)
+	userID := user.ID
+	properties, err := h.db.GetDislikedProperties(userID, filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting disliked properties: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]PropertyResponse, 0, len(properties))
+	for _, p := range properties {
+		resp := h.toPropertyResponse(userID, &p)
+		resp.Household = h.householdVotes(user, p.ID)
+
+		// Estrellas y motivos de la calificación
+		if rating, err := h.db.GetPropertyRating(userID, p.ID); err == nil {
+			resp.Score = rating.Score
+			resp.Reasons = rating.Reasons
+		}
 
 		// Verificar si la propiedad tiene notas
-		hasNotes, err := h.db.PropertyHasNotes(p.ID)
+		hasNotes, err := h.db.PropertyHasNotes(userID, p.ID)
 		if err == nil {
 			resp.HasNotes = hasNotes
 		}
 
 		// Verificar si la propiedad es favorita
-		isFavorite, err := h.db.IsPropertyFavorite(p.ID)
+		isFavorite, err := h.db.IsPropertyFavorite(userID, p.ID)
 		if err == nil {
 			resp.IsFavorite = isFavorite
 		}
@@ -154,7 +313,8 @@ func (h *Handler) GetLikedProperties(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// RateProperty califica una propiedad
+// RateProperty califica una propiedad con like o dislike, opcionalmente con estrellas
+// (solo like) y motivos de la lista de /rating-reasons
 func (h *Handler) RateProperty(w http.ResponseWriter, r *http.Request) {
 	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
@@ -163,7 +323,9 @@ func (h *Handler) RateProperty(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var request struct {
-		Rating string `json:"rating"`
+		Rating  string   `json:"rating"`
+		Score   *int     `json:"score"`
+		Reasons []string `json:"reasons"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -171,717 +333,3602 @@ func (h *Handler) RateProperty(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.db.RateProperty(propertyID, request.Rating); err != nil {
+	user := currentUser(r)
+	rating := db.PropertyRating{
+		UserID:     user.ID,
+		PropertyID: propertyID,
+		Rating:     request.Rating,
+		Score:      request.Score,
+		Reasons:    request.Reasons,
+	}
+	if err := h.db.RateProperty(&rating); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("property not found: %v", err), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, db.ErrInvalidRating) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, fmt.Sprintf("error rating property: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"success":     true,
 		"property_id": propertyID,
-		"rating":      request.Rating,
-	})
+		"rating":      rating.Rating,
+		"score":       rating.Score,
+		"reasons":     rating.Reasons,
+	}
+	// Con hogar, devolver cómo quedó la decisión conjunta
+	if votes := h.householdVotes(user, propertyID); votes != nil {
+		response["household"] = votes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
-// GetPropertyNotes obtiene las notas de una propiedad
-func (h *Handler) GetPropertyNotes(w http.ResponseWriter, r *http.Request) {
+// UnrateProperty deshace la calificación del usuario y devuelve la propiedad a la cola de sin calificar
+func (h *Handler) UnrateProperty(w http.ResponseWriter, r *http.Request) {
 	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
 		http.Error(w, "invalid property id", http.StatusBadRequest)
 		return
 	}
 
-	notes, err := h.db.GetPropertyNotes(propertyID)
+	user := currentUser(r)
+	removed, err := h.db.UnrateProperty(user.ID, propertyID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error getting property notes: %v", err), http.StatusInternalServerError)
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("rating not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error undoing rating: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":     true,
+		"property_id": propertyID,
+		"removed":     removed,
+	}
+	if votes := h.householdVotes(user, propertyID); votes != nil {
+		response["household"] = votes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RatingEventResponse es un evento del registro de calificaciones con la propiedad calificada
+type RatingEventResponse struct {
+	db.RatingHistoryEntry
+	Property *PropertyResponse `json:"property,omitempty"`
+}
+
+// GetRecentRatings devuelve las últimas decisiones del usuario, para poder deshacerlas.
+// Acepta ?limit= (por defecto 20, máximo 200).
+func (h *Handler) GetRecentRatings(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 200)
+	}
+
+	userID := currentUser(r).ID
+	events, err := h.db.GetRecentRatings(userID, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting recent ratings: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	response := make([]RatingEventResponse, 0, len(events))
+	for _, e := range events {
+		event := RatingEventResponse{RatingHistoryEntry: e}
+		if p, err := h.db.GetPropiedadByID(e.PropertyID); err == nil {
+			resp := h.toPropertyResponse(userID, p)
+			event.Property = &resp
+		}
+		response = append(response, event)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"notes": notes,
+		"ratings": response,
+		"total":   len(response),
 	})
 }
 
-// AddPropertyNote agrega una nota a una propiedad
-func (h *Handler) AddPropertyNote(w http.ResponseWriter, r *http.Request) {
-	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+// matchScores estima la afinidad del usuario con cada propiedad. Devuelve nil si el usuario
+// no tiene modelo entrenado.
+func (h *Handler) matchScores(userID int64, properties []db.Propiedad) map[int64]float64 {
+	model, err := recommender.LoadUser(h.db, userID)
 	if err != nil {
-		http.Error(w, "invalid property id", http.StatusBadRequest)
-		return
+		if !isNotFound(err) {
+			log.Printf("Error cargando modelo de recomendación: %v", err)
+		}
+		return nil
+	}
+	featureIDs, err := h.db.GetPropertyFeatureIDs()
+	if err != nil {
+		log.Printf("Error cargando características para recomendar: %v", err)
+		return nil
 	}
 
-	var request struct {
-		Text string `json:"text"`
+	scores := make(map[int64]float64, len(properties))
+	for i := range properties {
+		score := model.Score(&properties[i], featureIDs[properties[i].ID])
+		scores[properties[i].ID] = math.Round(score*1000) / 1000
 	}
+	return scores
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+// GetRecommender devuelve el estado del modelo de recomendación del usuario y las
+// características que más pesan a favor y en contra
+func (h *Handler) GetRecommender(w http.ResponseWriter, r *http.Request) {
+	model, err := recommender.LoadUser(h.db, currentUser(r).ID)
+	if err != nil {
+		if isNotFound(err) {
+			http.Error(w, "recommender has not been trained yet", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error getting recommender: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	if request.Text == "" {
-		http.Error(w, "note text cannot be empty", http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recommenderResponse(model))
+}
+
+// TrainRecommender vuelve a entrenar el modelo de recomendación con las calificaciones actuales del usuario
+func (h *Handler) TrainRecommender(w http.ResponseWriter, r *http.Request) {
+	model, err := recommender.TrainUser(h.db, currentUser(r).ID)
+	if err != nil {
+		if errors.Is(err, recommender.ErrNotEnoughRatings) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error training recommender: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	note := &db.PropertyNote{
-		PropertyID: propertyID,
-		Text:       request.Text,
+	response := recommenderResponse(model)
+	response["success"] = true
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func recommenderResponse(model *recommender.Model) map[string]interface{} {
+	positive, negative := model.TopWeights(10)
+	return map[string]interface{}{
+		"samples":    model.Samples,
+		"likes":      model.Likes,
+		"dislikes":   model.Dislikes,
+		"accuracy":   model.Accuracy,
+		"trained_at": model.TrainedAt,
+		"positive":   positive,
+		"negative":   negative,
 	}
+}
 
-	if err := h.db.AddPropertyNote(note); err != nil {
-		http.Error(w, fmt.Sprintf("error adding property note: %v", err), http.StatusInternalServerError)
+// GetRatingHistory devuelve las calificaciones que el usuario dio a una propiedad, de la más reciente a la más antigua
+func (h *Handler) GetRatingHistory(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.db.GetRatingHistory(currentUser(r).ID, propertyID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting rating history: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"note":    note,
+		"history": history,
 	})
 }
 
-// DeletePropertyNote elimina una nota de una propiedad
-func (h *Handler) DeletePropertyNote(w http.ResponseWriter, r *http.Request) {
-	noteID, err := strconv.ParseInt(chi.URLParam(r, "noteId"), 10, 64)
+// GetRatingReasons devuelve los motivos que se pueden asociar a una calificación.
+// Con ?all=true incluye los desactivados.
+func (h *Handler) GetRatingReasons(w http.ResponseWriter, r *http.Request) {
+	reasons, err := h.db.GetRatingReasons(r.URL.Query().Get("all") == "true")
 	if err != nil {
-		http.Error(w, "invalid note id", http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("error getting rating reasons: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	if err := h.db.DeletePropertyNote(noteID); err != nil {
-		http.Error(w, fmt.Sprintf("error deleting property note: %v", err), http.StatusInternalServerError)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reasons": reasons,
+	})
+}
+
+// CreateRatingReason agrega un motivo a la lista
+func (h *Handler) CreateRatingReason(w http.ResponseWriter, r *http.Request) {
+	var reason db.RatingReason
+	if err := json.NewDecoder(r.Body).Decode(&reason); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.CreateRatingReason(&reason); err != nil {
+		http.Error(w, fmt.Sprintf("error creating rating reason: %v", err), http.StatusBadRequest)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
-		"note_id": noteID,
+		"reason":  reason,
 	})
 }
 
-// TogglePropertyFavorite marca o desmarca una propiedad como favorita
-func (h *Handler) TogglePropertyFavorite(w http.ResponseWriter, r *http.Request) {
-	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+// UpdateRatingReason cambia la etiqueta de un motivo o lo activa o desactiva
+func (h *Handler) UpdateRatingReason(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
-		http.Error(w, "invalid property id", http.StatusBadRequest)
+		http.Error(w, "invalid reason id", http.StatusBadRequest)
 		return
 	}
 
 	var request struct {
-		IsFavorite bool `json:"is_favorite"`
+		Label  string `json:"label"`
+		Active *bool  `json:"active"`
 	}
-
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.db.TogglePropertyFavorite(propertyID, request.IsFavorite); err != nil {
-		http.Error(w, fmt.Sprintf("error toggling favorite: %v", err), http.StatusInternalServerError)
+	reason := db.RatingReason{ID: id, Label: request.Label, Active: true}
+	if request.Active != nil {
+		reason.Active = *request.Active
+	}
+	if err := h.db.UpdateRatingReason(&reason); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("rating reason not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error updating rating reason: %v", err), http.StatusBadRequest)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":     true,
-		"property_id": propertyID,
-		"is_favorite": request.IsFavorite,
+		"success": true,
+		"reason":  reason,
 	})
 }
 
-// GetFavoriteProperties retorna las propiedades marcadas como favoritas
-func (h *Handler) GetFavoriteProperties(w http.ResponseWriter, r *http.Request) {
-	// Parsear filtros de la solicitud
-	filter, err := parsePropertyFilter(r)
+// GetPropertyHistory devuelve los cambios por campo de una propiedad, del más reciente al más antiguo.
+// Acepta ?field= para filtrar por un campo (por ejemplo expensas o precio).
+func (h *Handler) GetPropertyHistory(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("error parsing filters: %v", err), http.StatusBadRequest)
+		http.Error(w, "invalid property id", http.StatusBadRequest)
 		return
 	}
 
-	properties, err := h.db.GetFavoriteProperties(filter)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error getting favorite properties: %v", err), http.StatusInternalServerError)
+	if _, err := h.db.GetPropiedadByID(propertyID); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("property not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error getting property: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	response := make([]PropertyResponse, 0, len(properties))
-	for _, p := range properties {
-		resp := h.toPropertyResponse(&p)
+	changes, err := h.db.GetPropertyChanges(propertyID, r.URL.Query().Get("field"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting property history: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-		// Verificar si la propiedad tiene notas
-		hasNotes, err := h.db.PropertyHasNotes(p.ID)
-		if err == nil {
-			resp.HasNotes = hasNotes
-		}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"history": changes,
+	})
+}
 
-		// Las propiedades favoritas siempre tienen IsFavorite = true
-		resp.IsFavorite = true
+// GetPropertyNotes obtiene las notas de una propiedad
+func (h *Handler) GetPropertyNotes(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
 
-		response = append(response, resp)
+	notes, err := h.db.GetPropertyNotes(currentUser(r).ID, propertyID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting property notes: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"properties": response,
-		"total":      len(response),
+		"notes": notes,
 	})
 }
 
-// Helper para limpiar el precio
-func cleanPrice(price string) string {
-	// Eliminar saltos de línea y texto extra
-	if idx := strings.Index(price, "\n"); idx != -1 {
-		price = price[:idx]
+// AddPropertyNote agrega una nota a una propiedad
+func (h *Handler) AddPropertyNote(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
 	}
-	return strings.TrimSpace(price)
-}
 
-// Helper para convertir Propiedad a PropertyResponse
-func (h *Handler) toPropertyResponse(p *db.Propiedad) PropertyResponse {
-	// Obtener inmobiliaria si es necesario
-	var agency Agency
-	if p.InmobiliariaID > 0 {
-		if i, err := h.db.GetInmobiliariaByID(p.InmobiliariaID); err == nil {
-			agency = Agency{
-				ID:   i.ID,
-				Name: i.Nombre,
-			}
-		}
+	var request struct {
+		Text string `json:"text"`
 	}
 
-	// Convertir las imágenes
-	var images []string
-	if p.Imagenes != nil {
-		images = *p.Imagenes
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	// Verificar si la propiedad tiene notas
-	hasNotes, _ := h.db.PropertyHasNotes(p.ID)
+	if request.Text == "" {
+		http.Error(w, "note text cannot be empty", http.StatusBadRequest)
+		return
+	}
 
-	// Obtener características de la propiedad
-	features, _ := h.db.GetPropertyFeaturesAsMap(p.ID)
+	note := &db.PropertyNote{
+		UserID:     currentUser(r).ID,
+		PropertyID: propertyID,
+		Text:       request.Text,
+	}
 
-	// Obtener el tipo de propiedad
-	var propertyType string
-	if p.TipoPropiedad != nil {
-		// Obtener el código del tipo de propiedad
-		code := h.getPropertyTypeCodeById(*p.TipoPropiedad)
+	if err := h.db.AddPropertyNote(note); err != nil {
+		http.Error(w, fmt.Sprintf("error adding property note: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-		// Obtener el nombre del tipo de propiedad a partir del código
-		if name, err := h.db.GetPropertyTypeNameByCode(code); err == nil {
-			propertyType = name
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"note":    note,
+	})
+}
+
+// UpdatePropertyNote cambia el texto de una nota
+func (h *Handler) UpdatePropertyNote(w http.ResponseWriter, r *http.Request) {
+	noteID, err := strconv.ParseInt(chi.URLParam(r, "noteId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Text string `json:"text"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Text == "" {
+		http.Error(w, "note text cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	note, err := h.db.UpdatePropertyNote(currentUser(r).ID, noteID, request.Text)
+	if err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("note not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error updating property note: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"note":    note,
+	})
+}
+
+// DeletePropertyNote elimina una nota de una propiedad y sus adjuntos
+func (h *Handler) DeletePropertyNote(w http.ResponseWriter, r *http.Request) {
+	noteID, err := strconv.ParseInt(chi.URLParam(r, "noteId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.DeletePropertyNote(currentUser(r).ID, noteID); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("note not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error deleting property note: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Los adjuntos de cada nota están en su propio directorio
+	if err := os.RemoveAll(filepath.Join(h.attachmentsDir, strconv.FormatInt(noteID, 10))); err != nil {
+		log.Printf("Error eliminando adjuntos de la nota %d: %v", noteID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"note_id": noteID,
+	})
+}
+
+// Tamaño máximo de un adjunto
+const maxAttachmentSize = 10 << 20
+
+// Tipos de archivo aceptados como adjuntos, con la extensión con la que se guardan
+var attachmentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// AddNoteAttachment adjunta una foto a una nota. Recibe un formulario multipart con el campo "file".
+func (h *Handler) AddNoteAttachment(w http.ResponseWriter, r *http.Request) {
+	noteID, err := strconv.ParseInt(chi.URLParam(r, "noteId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.GetPropertyNote(currentUser(r).ID, noteID); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("note not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error getting property note: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(maxAttachmentSize); err != nil {
+		http.Error(w, fmt.Sprintf("invalid upload (max %d MB): %v", maxAttachmentSize>>20, err), http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxAttachmentSize {
+		http.Error(w, fmt.Sprintf("file too large (max %d MB)", maxAttachmentSize>>20), http.StatusBadRequest)
+		return
+	}
+
+	// El tipo se detecta por el contenido, no por lo que declara el cliente
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		http.Error(w, fmt.Sprintf("error reading file: %v", err), http.StatusBadRequest)
+		return
+	}
+	contentType := http.DetectContentType(head[:n])
+	ext, ok := attachmentTypes[contentType]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported file type: %s", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, fmt.Sprintf("error reading file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	name := make([]byte, 16)
+	if _, err := rand.Read(name); err != nil {
+		http.Error(w, fmt.Sprintf("error naming file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	rel := filepath.Join(strconv.FormatInt(noteID, 10), hex.EncodeToString(name)+ext)
+	path := filepath.Join(h.attachmentsDir, rel)
+
+	size, err := saveFile(path, file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error saving file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	attachment := &db.NoteAttachment{
+		NoteID:      noteID,
+		FileName:    filepath.Base(header.Filename),
+		ContentType: contentType,
+		Size:        size,
+		Path:        rel,
+	}
+	if err := h.db.AddNoteAttachment(attachment); err != nil {
+		os.Remove(path)
+		http.Error(w, fmt.Sprintf("error adding note attachment: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"attachment": attachment,
+	})
+}
+
+// saveFile guarda el contenido de src en path, creando el directorio si hace falta
+func saveFile(path string, src io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+	dst, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	return size, nil
+}
+
+// GetNoteAttachment devuelve el archivo de un adjunto
+func (h *Handler) GetNoteAttachment(w http.ResponseWriter, r *http.Request) {
+	attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid attachment id", http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := h.db.GetNoteAttachment(currentUser(r).ID, attachmentID)
+	if err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("attachment not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error getting attachment: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(h.attachmentsDir, attachment.Path))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error opening attachment: %v", err), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.FileName}))
+	http.ServeContent(w, r, attachment.FileName, attachment.CreatedAt, file)
+}
+
+// DeleteNoteAttachment elimina un adjunto de una nota
+func (h *Handler) DeleteNoteAttachment(w http.ResponseWriter, r *http.Request) {
+	attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid attachment id", http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := h.db.DeleteNoteAttachment(currentUser(r).ID, attachmentID)
+	if err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("attachment not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error deleting attachment: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := os.Remove(filepath.Join(h.attachmentsDir, attachment.Path)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error eliminando archivo del adjunto %d: %v", attachment.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"attachment_id": attachmentID,
+	})
+}
+
+// PropertyImageResponse es una foto de una propiedad con las URLs de la copia local, si ya se descargó
+type PropertyImageResponse struct {
+	db.PropertyImage
+	LocalURL string `json:"local_url,omitempty"`
+	ThumbURL string `json:"thumb_url,omitempty"`
+}
+
+// GetPropertyImages devuelve las fotos de una propiedad y el estado de su descarga.
+// Las casi idénticas a otra foto de la propiedad se omiten salvo con ?duplicates=true.
+func (h *Handler) GetPropertyImages(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.GetPropiedadByID(propertyID); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("property not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error getting property: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	fotos, err := h.db.GetPropertyImages(propertyID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting property images: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	incluirDuplicadas := r.URL.Query().Get("duplicates") == "true"
+	response := []PropertyImageResponse{}
+	duplicadas := 0
+	for _, foto := range fotos {
+		if foto.DuplicateOf != nil {
+			duplicadas++
+			if !incluirDuplicadas {
+				continue
+			}
+		}
+
+		img := PropertyImageResponse{PropertyImage: foto}
+		if foto.Blob != nil {
+			img.LocalURL = "/api/images/" + foto.BlobHash
+			img.ThumbURL = img.LocalURL
+			if foto.Blob.HasThumb {
+				img.ThumbURL += "/thumb"
+			}
+		}
+		response = append(response, img)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"images":     response,
+		"duplicates": duplicadas,
+	})
+}
+
+// GetImage devuelve una foto del almacén local
+func (h *Handler) GetImage(w http.ResponseWriter, r *http.Request) {
+	h.serveImage(w, r, false)
+}
+
+// GetImageThumb devuelve la miniatura de una foto del almacén local
+func (h *Handler) GetImageThumb(w http.ResponseWriter, r *http.Request) {
+	h.serveImage(w, r, true)
+}
+
+// serveImage sirve una foto o su miniatura. El contenido de un hash nunca cambia, así que se puede cachear indefinidamente.
+func (h *Handler) serveImage(w http.ResponseWriter, r *http.Request, thumb bool) {
+	hash := chi.URLParam(r, "hash")
+	if !images.ValidHash(hash) {
+		http.Error(w, "invalid image hash", http.StatusBadRequest)
+		return
+	}
+
+	blob, err := h.db.GetImageBlob(hash)
+	if err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("image not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error getting image: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	path, contentType := h.images.Path(hash), blob.ContentType
+	if thumb {
+		if !blob.HasThumb {
+			http.Error(w, "image has no thumbnail", http.StatusNotFound)
+			return
+		}
+		path, contentType = h.images.ThumbPath(hash), "image/jpeg"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error opening image: %v", err), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, hash, blob.CreatedAt, file)
+}
+
+// GetChecklistItems devuelve los puntos de la lista de visita
+func (h *Handler) GetChecklistItems(w http.ResponseWriter, r *http.Request) {
+	items, err := h.db.GetChecklistItems()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting checklist items: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": items,
+	})
+}
+
+// GetPropertyChecklist devuelve la lista de visita del usuario para una propiedad
+func (h *Handler) GetPropertyChecklist(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
+
+	checklist, err := h.db.GetPropertyChecklist(currentUser(r).ID, propertyID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting checklist: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"property_id": propertyID,
+		"checklist":   checklist,
+	})
+}
+
+// UpdatePropertyChecklist guarda respuestas de la lista de visita de una propiedad.
+// Solo cambia los puntos incluidos; una respuesta vacía la borra.
+func (h *Handler) UpdatePropertyChecklist(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Answers []db.ChecklistAnswer `json:"answers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := currentUser(r).ID
+	if err := h.db.SetPropertyChecklist(userID, propertyID, request.Answers); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("property not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error updating checklist: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	checklist, err := h.db.GetPropertyChecklist(userID, propertyID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting checklist: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"property_id": propertyID,
+		"checklist":   checklist,
+	})
+}
+
+// Días que cubre GET /visits/upcoming si no se indica ?days
+const defaultUpcomingDays = 30
+
+// Días hacia atrás que incluye el calendario de visitas
+const calendarPastDays = 30
+
+// GetAgencyContacts devuelve las personas de contacto de una inmobiliaria
+func (h *Handler) GetAgencyContacts(w http.ResponseWriter, r *http.Request) {
+	agencyID, err := parseAgencyID(r)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.db.GetInmobiliariaByID(agencyID); err != nil {
+		h.agencyError(w, err, "error getting agency")
+		return
+	}
+
+	contacts, err := h.db.GetAgencyContacts(agencyID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting agency contacts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agency_id": agencyID,
+		"contacts":  contacts,
+	})
+}
+
+// agencyContactRequest son los datos editables de una persona de contacto
+type agencyContactRequest struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+	Email string `json:"email"`
+	Notes string `json:"notes"`
+}
+
+func (req agencyContactRequest) apply(c *db.AgencyContact) {
+	c.Name = req.Name
+	c.Phone = strings.TrimSpace(req.Phone)
+	c.Email = strings.TrimSpace(req.Email)
+	c.Notes = strings.TrimSpace(req.Notes)
+}
+
+// CreateAgencyContact agrega una persona de contacto a una inmobiliaria
+func (h *Handler) CreateAgencyContact(w http.ResponseWriter, r *http.Request) {
+	agencyID, err := parseAgencyID(r)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	var request agencyContactRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	contact := &db.AgencyContact{InmobiliariaID: agencyID}
+	request.apply(contact)
+	if err := h.db.CreateAgencyContact(contact); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("agency not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error creating agency contact: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"contact": contact,
+	})
+}
+
+// UpdateAgencyContact actualiza los datos de una persona de contacto
+func (h *Handler) UpdateAgencyContact(w http.ResponseWriter, r *http.Request) {
+	contactID, err := strconv.ParseInt(chi.URLParam(r, "contactId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid contact id", http.StatusBadRequest)
+		return
+	}
+
+	var request agencyContactRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	contact := &db.AgencyContact{ID: contactID}
+	request.apply(contact)
+	if err := h.db.UpdateAgencyContact(contact); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("contact not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error updating agency contact: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"contact": contact,
+	})
+}
+
+// GetPropertyContacts devuelve los contactos del usuario con la inmobiliaria por una propiedad
+func (h *Handler) GetPropertyContacts(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
+
+	contacts, err := h.db.GetPropertyContacts(currentUser(r).ID, propertyID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting property contacts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"property_id": propertyID,
+		"contacts":    contacts,
+	})
+}
+
+// AddPropertyContact registra un contacto con la inmobiliaria de una propiedad
+func (h *Handler) AddPropertyContact(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		ContactID   *int64    `json:"contact_id"`
+		Channel     string    `json:"channel"`
+		Status      string    `json:"status"`
+		Outcome     string    `json:"outcome"`
+		ContactedAt time.Time `json:"contacted_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	contact := &db.PropertyContact{
+		UserID:      currentUser(r).ID,
+		PropertyID:  propertyID,
+		ContactID:   request.ContactID,
+		Channel:     request.Channel,
+		Status:      request.Status,
+		Outcome:     strings.TrimSpace(request.Outcome),
+		ContactedAt: request.ContactedAt,
+	}
+	if err := h.db.AddPropertyContact(contact); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("property not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error adding property contact: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"contact": contact,
+	})
+}
+
+// visitRequest son los datos de una visita que el usuario agenda o actualiza
+type visitRequest struct {
+	ContactID       *int64    `json:"contact_id"`
+	ScheduledAt     time.Time `json:"scheduled_at"`
+	DurationMinutes int       `json:"duration_minutes"`
+	Status          string    `json:"status"`
+	Outcome         string    `json:"outcome"`
+}
+
+func (req visitRequest) apply(v *db.Visit) {
+	v.ContactID = req.ContactID
+	v.ScheduledAt = req.ScheduledAt
+	v.DurationMinutes = req.DurationMinutes
+	v.Status = req.Status
+	v.Outcome = strings.TrimSpace(req.Outcome)
+}
+
+// GetPropertyVisits devuelve las visitas del usuario a una propiedad
+func (h *Handler) GetPropertyVisits(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
+
+	visits, err := h.db.GetPropertyVisits(currentUser(r).ID, propertyID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting visits: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"property_id": propertyID,
+		"visits":      visits,
+	})
+}
+
+// CreateVisit agenda una visita a una propiedad
+func (h *Handler) CreateVisit(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
+
+	var request visitRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	visit := &db.Visit{UserID: currentUser(r).ID, PropertyID: propertyID}
+	request.apply(visit)
+	if err := h.db.CreateVisit(visit); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("property not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error creating visit: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"visit":   visit,
+	})
+}
+
+// UpdateVisit reprograma una visita o registra cómo resultó
+func (h *Handler) UpdateVisit(w http.ResponseWriter, r *http.Request) {
+	visitID, err := strconv.ParseInt(chi.URLParam(r, "visitId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid visit id", http.StatusBadRequest)
+		return
+	}
+
+	var request visitRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	visit := &db.Visit{ID: visitID, UserID: currentUser(r).ID}
+	request.apply(visit)
+	if err := h.db.UpdateVisit(visit); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("visit not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error updating visit: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"visit":   visit,
+	})
+}
+
+// DeleteVisit elimina una visita del usuario
+func (h *Handler) DeleteVisit(w http.ResponseWriter, r *http.Request) {
+	visitID, err := strconv.ParseInt(chi.URLParam(r, "visitId"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid visit id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.DeleteVisit(currentUser(r).ID, visitID); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("visit not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error deleting visit: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// GetUpcomingVisits devuelve las visitas agendadas del usuario para los próximos días (?days, 30 por defecto)
+func (h *Handler) GetUpcomingVisits(w http.ResponseWriter, r *http.Request) {
+	days := defaultUpcomingDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid days", http.StatusBadRequest)
+			return
+		}
+		days = min(n, 365)
+	}
+
+	from := time.Now().UTC()
+	to := from.AddDate(0, 0, days)
+	visits, err := h.db.GetUpcomingVisits(currentUser(r).ID, from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting upcoming visits: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":   from,
+		"to":     to,
+		"visits": visits,
+	})
+}
+
+// GetVisitsCalendar devuelve las visitas del usuario como calendario iCalendar, para
+// suscribirse desde una aplicación de calendario. Como esas aplicaciones no envían
+// encabezados, se autentica con una API key en ?key.
+func (h *Handler) GetVisitsCalendar(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimSpace(r.URL.Query().Get("key"))
+	if !auth.IsAPIKey(key) {
+		http.Error(w, "api key required", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.db.GetAPIKeyUser(auth.HashToken(key))
+	if err != nil {
+		if isNotFound(err) {
+			http.Error(w, "invalid or expired credentials", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error authenticating: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	visits, err := h.db.GetVisitsSince(user.ID, time.Now().AddDate(0, 0, -calendarPastDays))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting visits: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	calendar := &ical.Calendar{Name: "findhouse - visitas"}
+	for _, v := range visits {
+		summary := "Visita: " + v.PropertyTitle
+		if v.PropertyTitle == "" {
+			summary = fmt.Sprintf("Visita: propiedad %d", v.PropertyID)
+		}
+
+		var description []string
+		if v.AgencyName != "" {
+			description = append(description, "Inmobiliaria: "+v.AgencyName)
+		}
+		if v.ContactName != "" {
+			contacto := "Contacto: " + v.ContactName
+			if v.ContactPhone != "" {
+				contacto += " (" + v.ContactPhone + ")"
+			}
+			description = append(description, contacto)
+		}
+		if v.Outcome != "" {
+			description = append(description, "Resultado: "+v.Outcome)
+		}
+
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID:         fmt.Sprintf("visit-%d@findhouse", v.ID),
+			Start:       v.ScheduledAt,
+			End:         v.ScheduledAt.Add(time.Duration(v.DurationMinutes) * time.Minute),
+			Summary:     summary,
+			Location:    v.PropertyAddress,
+			Description: strings.Join(description, "\n"),
+			URL:         v.PropertyURL,
+			Cancelled:   v.Status == db.VisitCancelled,
+			Updated:     v.UpdatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="visitas.ics"`)
+	if err := calendar.Write(w); err != nil {
+		log.Printf("error escribiendo calendario de visitas: %v", err)
+	}
+}
+
+// TogglePropertyFavorite marca o desmarca una propiedad como favorita
+func (h *Handler) TogglePropertyFavorite(w http.ResponseWriter, r *http.Request) {
+	propertyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid property id", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		IsFavorite bool `json:"is_favorite"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.TogglePropertyFavorite(currentUser(r).ID, propertyID, request.IsFavorite); err != nil {
+		http.Error(w, fmt.Sprintf("error toggling favorite: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"property_id": propertyID,
+		"is_favorite": request.IsFavorite,
+	})
+}
+
+// GetFavoriteProperties retorna las propiedades marcadas como favoritas
+func (h *Handler) GetFavoriteProperties(w http.ResponseWriter, r *http.Request) {
+	// Parsear filtros de la solicitud
+	filter, err := parsePropertyFilter(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error parsing filters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	userID := currentUser(r).ID
+	properties, err := h.db.GetFavoriteProperties(userID, filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting favorite properties: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]PropertyResponse, 0, len(properties))
+	for _, p := range properties {
+		resp := h.toPropertyResponse(userID, &p)
+
+		// Verificar si la propiedad tiene notas
+		hasNotes, err := h.db.PropertyHasNotes(userID, p.ID)
+		if err == nil {
+			resp.HasNotes = hasNotes
+		}
+
+		// Las propiedades favoritas siempre tienen IsFavorite = true
+		resp.IsFavorite = true
+
+		response = append(response, resp)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"properties": response,
+		"total":      len(response),
+	})
+}
+
+// Cantidad de propiedades que se pueden comparar a la vez
+const (
+	minCompareProperties = 2
+	maxCompareProperties = 6
+)
+
+// Radio medio de la Tierra en km, para las distancias entre propiedades
+const earthRadiusKm = 6371.0
+
+// CompareRow es un dato alineado de las propiedades comparadas: un valor por propiedad,
+// en el orden de "properties", y cuáles son las mejores en ese dato si tiene un sentido
+type CompareRow struct {
+	Field  string     `json:"field"`
+	Values []*float64 `json:"values,omitempty"`
+	Text   []string   `json:"text,omitempty"`
+	Better string     `json:"better,omitempty"` // 'higher' o 'lower'
+	Best   []int64    `json:"best,omitempty"`
+}
+
+// CompareFeature indica qué propiedades comparadas tienen una característica
+type CompareFeature struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Has      []bool `json:"has"`
+}
+
+// CompareDistance es la distancia en línea recta entre dos propiedades
+type CompareDistance struct {
+	From int64   `json:"from"`
+	To   int64   `json:"to"`
+	Km   float64 `json:"km"`
+}
+
+// CompareProperties compara propiedades lado a lado (GET /properties/compare?ids=1,2,3).
+// Los precios solo se comparan entre sí si están en la misma moneda, salvo que se indique
+// ?usd_rate (pesos por dólar), que además permite calcular las expensas, publicadas en pesos,
+// como porcentaje de un precio en dólares.
+func (h *Handler) CompareProperties(w http.ResponseWriter, r *http.Request) {
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, value := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid property id: %s", value), http.StatusBadRequest)
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < minCompareProperties || len(ids) > maxCompareProperties {
+		http.Error(w, fmt.Sprintf("ids must list between %d and %d properties", minCompareProperties, maxCompareProperties), http.StatusBadRequest)
+		return
+	}
+
+	var usdRate float64
+	if value := r.URL.Query().Get("usd_rate"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			http.Error(w, "invalid usd_rate", http.StatusBadRequest)
+			return
+		}
+		usdRate = rate
+	}
+
+	userID := currentUser(r).ID
+	properties := make([]db.Propiedad, 0, len(ids))
+	for _, id := range ids {
+		p, err := h.db.GetPropiedadByID(id)
+		if err != nil {
+			if isNotFound(err) {
+				http.Error(w, fmt.Sprintf("property not found: %v", err), http.StatusNotFound)
+				return
+			}
+			http.Error(w, fmt.Sprintf("error getting property: %v", err), http.StatusInternalServerError)
+			return
+		}
+		properties = append(properties, *p)
+	}
+	response := h.toPropertyResponses(userID, properties)
+
+	// Precio en una moneda común: la de todas si coinciden, o dólares si hay cotización
+	prices := make([]*float64, len(properties))
+	currencies := make([]string, len(properties))
+	monedas := map[string]bool{}
+	for i := range properties {
+		if valor, moneda, ok := db.ParsePrecio(properties[i].Precio); ok {
+			prices[i] = &valor
+			currencies[i] = moneda
+			monedas[moneda] = true
+		}
+	}
+	comparable := len(monedas) <= 1
+	var priceCurrency string
+	for moneda := range monedas {
+		priceCurrency = moneda
+	}
+	if !comparable && usdRate > 0 {
+		priceCurrency = "USD"
+		for i := range prices {
+			if prices[i] != nil && currencies[i] == "ARS" {
+				enDolares := *prices[i] / usdRate
+				prices[i] = &enDolares
+			}
+		}
+		comparable = true
+	}
+
+	// Precio por m² y expensas sobre el precio, calculados con el precio publicado
+	perCovered := make([]*float64, len(properties))
+	perTotal := make([]*float64, len(properties))
+	expensesPct := make([]*float64, len(properties))
+	for i, p := range properties {
+		valor, moneda, ok := db.ParsePrecio(p.Precio)
+		if !ok {
+			continue
+		}
+		perCovered[i] = ratio(valor, p.SuperficieCubierta)
+		perTotal[i] = ratio(valor, p.SuperficieTotal)
+
+		if p.Expensas != nil && *p.Expensas > 0 {
+			enPesos := valor
+			if moneda == "USD" {
+				enPesos = valor * usdRate
+			}
+			if enPesos > 0 {
+				pct := *p.Expensas / enPesos * 100
+				expensesPct[i] = &pct
+			}
+		}
+	}
+	// Los precios por m² en monedas distintas solo se comparan con cotización
+	if len(monedas) > 1 && usdRate > 0 {
+		for i := range properties {
+			if currencies[i] == "ARS" {
+				perCovered[i] = divide(perCovered[i], usdRate)
+				perTotal[i] = divide(perTotal[i], usdRate)
+			}
+		}
+	}
+
+	rows := []CompareRow{
+		{Field: "price", Values: prices, Better: "lower"},
+		{Field: "price_per_m2_covered", Values: perCovered, Better: "lower"},
+		{Field: "price_per_m2_total", Values: perTotal, Better: "lower"},
+		{Field: "expenses_pct", Values: expensesPct, Better: "lower"},
+	}
+	if !comparable {
+		priceCurrency = ""
+		for i := range rows[:3] {
+			rows[i].Better = ""
+		}
+	}
+
+	detailRows := []struct {
+		field  string
+		better string
+		value  func(d Details) *float64
+	}{
+		{"rooms", "higher", func(d Details) *float64 { return intValue(d.Rooms) }},
+		{"bedrooms", "higher", func(d Details) *float64 { return intValue(d.Bedrooms) }},
+		{"bathrooms", "higher", func(d Details) *float64 { return intValue(d.Bathrooms) }},
+		{"area", "higher", func(d Details) *float64 { return d.Area }},
+		{"total_area", "higher", func(d Details) *float64 { return d.TotalArea }},
+		{"land_area", "higher", func(d Details) *float64 { return d.LandArea }},
+		{"front_size", "higher", func(d Details) *float64 { return d.FrontSize }},
+		{"back_size", "higher", func(d Details) *float64 { return d.BackSize }},
+		{"garages", "higher", func(d Details) *float64 { return intValue(d.Garages) }},
+		{"floors", "", func(d Details) *float64 { return intValue(d.Floors) }},
+		{"expenses", "lower", func(d Details) *float64 { return d.Expenses }},
+		{"age", "lower", func(d Details) *float64 { return intValue(d.Age) }},
+	}
+	for _, dr := range detailRows {
+		row := CompareRow{Field: dr.field, Values: make([]*float64, len(response)), Better: dr.better}
+		for i := range response {
+			row.Values[i] = dr.value(response[i].Details)
+		}
+		rows = append(rows, row)
+	}
+
+	status := CompareRow{Field: "status", Text: make([]string, len(response))}
+	for i := range response {
+		status.Text[i] = getString(response[i].Details.Status)
+	}
+	rows = append(rows, status)
+
+	best := map[string][]int64{}
+	for i := range rows {
+		rows[i].Best = bestProperties(ids, rows[i].Values, rows[i].Better)
+		if len(rows[i].Best) > 0 {
+			best[rows[i].Field] = rows[i].Best
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ids":        ids,
+		"properties": response,
+		"currencies": currencies,
+		// Moneda de los precios y precios por m² de "details", si son comparables
+		"price_currency": priceCurrency,
+		"details":        rows,
+		"features":       compareFeatures(response),
+		"distances":      compareDistances(properties),
+		"best":           best,
+	})
+}
+
+// ratio divide el precio por la superficie, si se conoce
+func ratio(valor float64, superficie *float64) *float64 {
+	if superficie == nil || *superficie <= 0 {
+		return nil
+	}
+	r := valor / *superficie
+	return &r
+}
+
+func divide(v *float64, d float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	r := *v / d
+	return &r
+}
+
+func intValue(v *int) *float64 {
+	if v == nil {
+		return nil
+	}
+	f := float64(*v)
+	return &f
+}
+
+// bestProperties devuelve las propiedades con el mejor valor según better; todas las empatadas.
+// No hay mejor si el dato no tiene sentido o lo tiene menos de dos propiedades.
+func bestProperties(ids []int64, values []*float64, better string) []int64 {
+	if better == "" {
+		return nil
+	}
+
+	var mejor *float64
+	conValor := 0
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		conValor++
+		if mejor == nil || (better == "higher" && *v > *mejor) || (better == "lower" && *v < *mejor) {
+			mejor = v
+		}
+	}
+	if conValor < 2 {
+		return nil
+	}
+
+	var best []int64
+	for i, v := range values {
+		if v != nil && *v == *mejor {
+			best = append(best, ids[i])
+		}
+	}
+	return best
+}
+
+// compareFeatures une las características de las propiedades comparadas, ordenadas por categoría y nombre
+func compareFeatures(properties []PropertyResponse) []CompareFeature {
+	indices := map[[2]string]int{}
+	features := []CompareFeature{}
+	for i, p := range properties {
+		for category, names := range p.Features {
+			for _, name := range names {
+				key := [2]string{category, name}
+				idx, ok := indices[key]
+				if !ok {
+					idx = len(features)
+					indices[key] = idx
+					features = append(features, CompareFeature{Category: category, Name: name, Has: make([]bool, len(properties))})
+				}
+				features[idx].Has[i] = true
+			}
+		}
+	}
+
+	sort.Slice(features, func(i, j int) bool {
+		if features[i].Category != features[j].Category {
+			return features[i].Category < features[j].Category
+		}
+		return features[i].Name < features[j].Name
+	})
+	return features
+}
+
+// compareDistances calcula la distancia entre cada par de propiedades con coordenadas
+func compareDistances(properties []db.Propiedad) []CompareDistance {
+	distances := []CompareDistance{}
+	for i := range properties {
+		for j := i + 1; j < len(properties); j++ {
+			a, b := &properties[i], &properties[j]
+			if !hasCoordinates(a) || !hasCoordinates(b) {
+				continue
+			}
+			km := haversineKm(*a.Latitud, *a.Longitud, *b.Latitud, *b.Longitud)
+			distances = append(distances, CompareDistance{From: a.ID, To: b.ID, Km: math.Round(km*100) / 100})
+		}
+	}
+	return distances
+}
+
+func hasCoordinates(p *db.Propiedad) bool {
+	return p.Latitud != nil && p.Longitud != nil && (*p.Latitud != 0 || *p.Longitud != 0)
+}
+
+// haversineKm es la distancia en km sobre la superficie de la Tierra entre dos coordenadas
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// Períodos por defecto y máximos de la tendencia del inventario
+const (
+	defaultTrendPeriods = 12
+	maxTrendPeriods     = 104
+)
+
+// parseMarketOptions lee el segmento pedido (location, property_type_id, operation) y la cotización (usd_rate)
+func parseMarketOptions(r *http.Request) (analytics.Options, error) {
+	query := r.URL.Query()
+	opts := analytics.Options{
+		Location:  strings.TrimSpace(query.Get("location")),
+		Operation: strings.TrimSpace(query.Get("operation")),
+	}
+
+	if value := query.Get("property_type_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid property_type_id")
+		}
+		opts.PropertyTypeID = &id
+	}
+	if value := query.Get("usd_rate"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			return opts, fmt.Errorf("invalid usd_rate")
+		}
+		opts.USDRate = rate
+	}
+
+	return opts, nil
+}
+
+// GetMarketSegments devuelve, por ubicación, tipo de propiedad y operación, la cantidad de
+// propiedades, los percentiles del precio por m² en dólares, la mediana de las expensas y
+// la mediana de los días publicadas. Se puede acotar con location, property_type_id y operation.
+func (h *Handler) GetMarketSegments(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseMarketOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	listings, err := h.db.GetMarketListings()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting market data: %v", err), http.StatusInternalServerError)
+		return
+	}
+	segments := analytics.Segments(listings, opts, time.Now().UTC())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"segments": segments,
+		"total":    len(segments),
+	})
+}
+
+// GetMarketTrend devuelve la evolución del inventario por semana o mes (?interval=week|month)
+// en los últimos ?periods períodos, con los mismos filtros que GetMarketSegments
+func (h *Handler) GetMarketTrend(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseMarketOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "month"
+	}
+	if interval != "week" && interval != "month" {
+		http.Error(w, "interval must be week or month", http.StatusBadRequest)
+		return
+	}
+
+	periods := defaultTrendPeriods
+	if value := r.URL.Query().Get("periods"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid periods", http.StatusBadRequest)
+			return
+		}
+		periods = min(n, maxTrendPeriods)
+	}
+
+	listings, err := h.db.GetMarketListings()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting market data: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"interval": interval,
+		"trend":    analytics.Trend(listings, opts, interval, periods, time.Now()),
+	})
+}
+
+// Helper para limpiar el precio
+func cleanPrice(price string) string {
+	// Eliminar saltos de línea y texto extra
+	if idx := strings.Index(price, "\n"); idx != -1 {
+		price = price[:idx]
+	}
+	return strings.TrimSpace(price)
+}
+
+// Helper para convertir Propiedad a PropertyResponse, con las notas del usuario
+func (h *Handler) toPropertyResponse(userID int64, p *db.Propiedad) PropertyResponse {
+	// Obtener inmobiliaria si es necesario
+	var agency Agency
+	if p.InmobiliariaID > 0 {
+		if i, err := h.db.GetInmobiliariaByID(p.InmobiliariaID); err == nil {
+			agency = Agency{
+				ID:   i.ID,
+				Name: i.Nombre,
+			}
+		}
+	}
+
+	// Convertir las imágenes
+	var images []string
+	if p.Imagenes != nil {
+		images = *p.Imagenes
+	}
+
+	// Verificar si la propiedad tiene notas
+	hasNotes, _ := h.db.PropertyHasNotes(userID, p.ID)
+
+	// Obtener características de la propiedad
+	features, _ := h.db.GetPropertyFeaturesAsMap(p.ID)
+
+	// Indicadores calculados a partir del historial de la publicación
+	signals, _ := h.db.GetListingSignals(p.ID)
+
+	// Obtener el tipo de propiedad
+	var propertyType string
+	if p.TipoPropiedad != nil {
+		// Obtener el código del tipo de propiedad
+		code := h.getPropertyTypeCodeById(*p.TipoPropiedad)
+
+		// Obtener el nombre del tipo de propiedad a partir del código
+		if name, err := h.db.GetPropertyTypeNameByCode(code); err == nil {
+			propertyType = name
 		} else {
 			// Si hay error, usar el código como fallback
 			propertyType = code
 		}
 	}
 
-	return PropertyResponse{
-		ID:           p.ID,
-		Title:        p.Titulo,
-		Code:         p.Codigo,
-		Price:        cleanPrice(p.Precio),
-		Location:     getLocation(p),
-		PropertyType: propertyType,
-		ImageURL:     p.ImagenURL,
-		Images:       images,
-		URL:          p.URL,
-		Description:  getString(p.Descripcion),
-		LastUpdated:  p.UpdatedAt,
-		CreatedAt:    p.CreatedAt,
-		Details: Details{
-			Bedrooms:  p.Dormitorios,
-			Bathrooms: p.Banios,
-			Area:      p.SuperficieCubierta,
-			TotalArea: p.SuperficieTotal,
-			LandArea:  p.SuperficieTerreno,
-			Rooms:     p.Ambientes,
-			Floors:    p.Plantas,
-			Garages:   p.Cocheras,
-			Status:    p.Situacion,
-			Expenses:  p.Expensas,
-			Age:       p.Antiguedad,
-			FrontSize: p.Frente,
-			BackSize:  p.Fondo,
-			Latitude:  p.Latitud,
-			Longitude: p.Longitud,
-		},
-		Agency:     agency,
-		HasNotes:   hasNotes,
-		IsFavorite: p.IsFavorite,
-		Features:   features,
+	return PropertyResponse{
+		ID:           p.ID,
+		Title:        p.Titulo,
+		Code:         p.Codigo,
+		Price:        cleanPrice(p.Precio),
+		Location:     getLocation(p),
+		PropertyType: propertyType,
+		ImageURL:     p.ImagenURL,
+		Images:       images,
+		URL:          p.URL,
+		Description:  getString(p.Descripcion),
+		LastUpdated:  p.UpdatedAt,
+		CreatedAt:    p.CreatedAt,
+		Details: Details{
+			Bedrooms:  p.Dormitorios,
+			Bathrooms: p.Banios,
+			Area:      p.SuperficieCubierta,
+			TotalArea: p.SuperficieTotal,
+			LandArea:  p.SuperficieTerreno,
+			Rooms:     p.Ambientes,
+			Floors:    p.Plantas,
+			Garages:   p.Cocheras,
+			Status:    p.Situacion,
+			Expenses:  p.Expensas,
+			Age:       p.Antiguedad,
+			FrontSize: p.Frente,
+			BackSize:  p.Fondo,
+			Latitude:  p.Latitud,
+			Longitude: p.Longitud,
+		},
+		Agency:     agency,
+		HasNotes:   hasNotes,
+		IsFavorite: p.IsFavorite,
+		Features:   features,
+
+		ErrorCategory: getString(p.ErrorCategory),
+		Market:        h.marketComparison(p),
+		Signals:       signals,
+	}
+}
+
+// marketComparison compara el precio por m² de la propiedad con la mediana de su segmento
+func (h *Handler) marketComparison(p *db.Propiedad) *analytics.Comparison {
+	index, err := h.market.Index()
+	if err != nil {
+		log.Printf("error calculando estadísticas del mercado: %v", err)
+		return nil
+	}
+	return index.Compare(p)
+}
+
+// Helper para obtener la ubicación completa
+func getLocation(p *db.Propiedad) string {
+	if p.Direccion != "" {
+		return p.Direccion
+	}
+	if p.Ubicacion != nil {
+		return *p.Ubicacion
+	}
+	return ""
+}
+
+// Helper para manejar punteros string
+func getString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// parsePropertyFilter parsea los filtros de la solicitud
+func parsePropertyFilter(r *http.Request) (*db.PropertyFilter, error) {
+	// Si es GET, parsear de query params
+	if r.Method == http.MethodGet {
+		return parseFilterFromQueryParams(r)
+	}
+
+	// Si es POST, parsear del body
+	if r.Method == http.MethodPost {
+		var filter db.PropertyFilter
+		if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
+			return nil, fmt.Errorf("error decoding filter: %v", err)
+		}
+		return &filter, nil
+	}
+
+	// Por defecto, retornar un filtro vacío
+	return &db.PropertyFilter{}, nil
+}
+
+// parseFilterFromQueryParams parsea los filtros de los query params
+func parseFilterFromQueryParams(r *http.Request) (*db.PropertyFilter, error) {
+	filter := &db.PropertyFilter{}
+
+	// Tipo de propiedad (por código)
+	if propertyType := r.URL.Query().Get("property_type"); propertyType != "" {
+		filter.PropertyType = propertyType
+		fmt.Printf("Filtro property_type (código): %s\n", propertyType)
+	}
+
+	// Tipo de propiedad (por ID)
+	if propertyTypeID := r.URL.Query().Get("property_type_id"); propertyTypeID != "" {
+		id, err := strconv.ParseInt(propertyTypeID, 10, 64)
+		if err != nil {
+			fmt.Printf("Error al convertir property_type_id a int64: %v\n", err)
+		} else {
+			filter.PropertyTypeID = &id
+			fmt.Printf("Filtro property_type_id: %d\n", id)
+		}
+	}
+
+	// Múltiples tipos de propiedad (por IDs)
+	if propertyTypeIDs := r.URL.Query().Get("property_type_ids"); propertyTypeIDs != "" {
+		idStrings := strings.Split(propertyTypeIDs, ",")
+		var ids []int64
+
+		for _, idStr := range idStrings {
+			id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+			if err != nil {
+				fmt.Printf("Error al convertir un ID de property_type_ids a int64: %v\n", err)
+				continue
+			}
+			ids = append(ids, id)
+		}
+
+		if len(ids) > 0 {
+			filter.PropertyTypeIDs = ids
+			fmt.Printf("Filtro property_type_ids: %v\n", ids)
+		}
+	}
+
+	// Ubicaciones
+	if locations := r.URL.Query().Get("locations"); locations != "" {
+		filter.Locations = strings.Split(locations, ",")
+		fmt.Printf("Filtro locations: %v\n", filter.Locations)
+	}
+
+	// Características - manejar múltiples formatos posibles
+	// 1. Formato de array: features[]=1&features[]=2
+	// 2. Formato de cadena separada por comas: features=1,2
+	features := r.URL.Query()["features[]"] // Intentar obtener como array
+	if len(features) == 0 {
+		// Si no hay resultados, intentar como parámetro simple
+		if featuresStr := r.URL.Query().Get("features"); featuresStr != "" {
+			features = strings.Split(featuresStr, ",")
+		}
+	}
+
+	// Limpiar valores vacíos
+	var cleanFeatures []string
+	for _, f := range features {
+		if f != "" {
+			cleanFeatures = append(cleanFeatures, f)
+		}
+	}
+
+	if len(cleanFeatures) > 0 {
+		filter.Features = cleanFeatures
+		fmt.Printf("Filtro features: %v\n", filter.Features)
+	}
+
+	// Precio mínimo
+	if priceMin := r.URL.Query().Get("price_min"); priceMin != "" {
+		min, err := strconv.ParseFloat(priceMin, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price_min: %v", err)
+		}
+		filter.PriceMin = &min
+	}
+
+	// Precio máximo
+	if priceMax := r.URL.Query().Get("price_max"); priceMax != "" {
+		max, err := strconv.ParseFloat(priceMax, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price_max: %v", err)
+		}
+		filter.PriceMax = &max
+	}
+
+	// Moneda - solo establecer si se proporciona explícitamente o si hay filtros de precio
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		filter.Currency = currency
+	} else if filter.PriceMin != nil || filter.PriceMax != nil {
+		// Si hay filtros de precio pero no se especificó moneda, usar ARS por defecto
+		filter.Currency = "ARS"
+	}
+
+	// Tamaño mínimo (compatibilidad con versión anterior)
+	if sizeMin := r.URL.Query().Get("size_min"); sizeMin != "" {
+		min, err := strconv.ParseFloat(sizeMin, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size_min: %v", err)
+		}
+		filter.SizeMin = &min
+	}
+
+	// Tamaño máximo (compatibilidad con versión anterior)
+	if sizeMax := r.URL.Query().Get("size_max"); sizeMax != "" {
+		max, err := strconv.ParseFloat(sizeMax, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size_max: %v", err)
+		}
+		filter.SizeMax = &max
+	}
+
+	// Superficie Total mínima
+	if totalAreaMin := r.URL.Query().Get("total_area_min"); totalAreaMin != "" {
+		min, err := strconv.ParseFloat(totalAreaMin, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid total_area_min: %v", err)
+		}
+		filter.TotalAreaMin = &min
+	}
+
+	// Superficie Total máxima
+	if totalAreaMax := r.URL.Query().Get("total_area_max"); totalAreaMax != "" {
+		max, err := strconv.ParseFloat(totalAreaMax, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid total_area_max: %v", err)
+		}
+		filter.TotalAreaMax = &max
+	}
+
+	// Superficie Cubierta mínima
+	if coveredAreaMin := r.URL.Query().Get("covered_area_min"); coveredAreaMin != "" {
+		min, err := strconv.ParseFloat(coveredAreaMin, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid covered_area_min: %v", err)
+		}
+		filter.CoveredAreaMin = &min
+	}
+
+	// Superficie Cubierta máxima
+	if coveredAreaMax := r.URL.Query().Get("covered_area_max"); coveredAreaMax != "" {
+		max, err := strconv.ParseFloat(coveredAreaMax, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid covered_area_max: %v", err)
+		}
+		filter.CoveredAreaMax = &max
+	}
+
+	// Superficie Terreno mínima
+	if landAreaMin := r.URL.Query().Get("land_area_min"); landAreaMin != "" {
+		min, err := strconv.ParseFloat(landAreaMin, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid land_area_min: %v", err)
+		}
+		filter.LandAreaMin = &min
+	}
+
+	// Superficie Terreno máxima
+	if landAreaMax := r.URL.Query().Get("land_area_max"); landAreaMax != "" {
+		max, err := strconv.ParseFloat(landAreaMax, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid land_area_max: %v", err)
+		}
+		filter.LandAreaMax = &max
+	}
+
+	// Frente
+	if front := r.URL.Query().Get("front"); front != "" {
+		frontValue, err := strconv.ParseFloat(front, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid front: %v", err)
+		}
+		filter.Front = &frontValue
+	}
+
+	// Fondo
+	if back := r.URL.Query().Get("back"); back != "" {
+		backValue, err := strconv.ParseFloat(back, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid back: %v", err)
+		}
+		filter.Back = &backValue
+	}
+
+	// Ambientes
+	if rooms := r.URL.Query().Get("rooms"); rooms != "" {
+		roomsInt, err := strconv.Atoi(rooms)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rooms: %v", err)
+		}
+		filter.Rooms = &roomsInt
+	}
+
+	// Baños
+	if bathrooms := r.URL.Query().Get("bathrooms"); bathrooms != "" {
+		bathroomsInt, err := strconv.Atoi(bathrooms)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bathrooms: %v", err)
+		}
+		filter.Bathrooms = &bathroomsInt
+	}
+
+	// Antigüedad
+	if antiquity := r.URL.Query().Get("antiquity"); antiquity != "" {
+		antiquityInt, err := strconv.Atoi(antiquity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid antiquity: %v", err)
+		}
+		filter.Antiquity = &antiquityInt
+	}
+
+	// Disposición
+	if disposition := r.URL.Query().Get("disposition"); disposition != "" {
+		filter.Disposition = strings.Split(disposition, ",")
+	}
+
+	// Orientación
+	if orientation := r.URL.Query().Get("orientation"); orientation != "" {
+		filter.Orientation = strings.Split(orientation, ",")
+	}
+
+	// Condición
+	if condition := r.URL.Query().Get("condition"); condition != "" {
+		filter.Condition = strings.Split(condition, ",")
+	}
+
+	// Tipo de operación
+	if operationType := r.URL.Query().Get("operation_type"); operationType != "" {
+		filter.OperationType = strings.Split(operationType, ",")
+	}
+
+	// Situación
+	if situation := r.URL.Query().Get("situation"); situation != "" {
+		filter.Situation = strings.Split(situation, ",")
+	}
+
+	// Inmobiliarias
+	if agencies := r.URL.Query().Get("agencies"); agencies != "" {
+		// Convertir los IDs de string a int64
+		agencyIDs := strings.Split(agencies, ",")
+		var agencyIDsInt []int64
+		for _, idStr := range agencyIDs {
+			id, err := strconv.ParseInt(idStr, 10, 64)
+			if err != nil {
+				fmt.Printf("Error al convertir ID de inmobiliaria '%s' a int64: %v\n", idStr, err)
+				continue
+			}
+			agencyIDsInt = append(agencyIDsInt, id)
+		}
+		filter.AgencyIDs = agencyIDsInt
+	}
+
+	// Motivos de la calificación
+	if reasons := r.URL.Query().Get("reasons"); reasons != "" {
+		filter.Reasons = strings.Split(reasons, ",")
+	}
+
+	// Respuestas de la lista de visita, como item:respuesta separados por coma
+	if checklist := r.URL.Query().Get("checklist"); checklist != "" {
+		filter.Checklist = map[string]string{}
+		for _, pair := range strings.Split(checklist, ",") {
+			item, answer, ok := strings.Cut(pair, ":")
+			if !ok || item == "" || !db.ValidChecklistAnswer(answer) {
+				return nil, fmt.Errorf("invalid checklist filter: %q", pair)
+			}
+			filter.Checklist[item] = answer
+		}
+	}
+
+	// Bajas de precio y días publicada
+	for _, param := range []struct {
+		name  string
+		value **int
+	}{
+		{"price_drop_days", &filter.PriceDropDays},
+		{"min_price_drops", &filter.MinPriceDrops},
+		{"min_days_on_market", &filter.MinDaysOnMarket},
+		{"max_days_on_market", &filter.MaxDaysOnMarket},
+	} {
+		if value := r.URL.Query().Get(param.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s: %q", param.name, value)
+			}
+			*param.value = &n
+		}
+	}
+
+	// Republicadas (true) o no (false)
+	if relisted := r.URL.Query().Get("relisted"); relisted != "" {
+		value, err := strconv.ParseBool(relisted)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relisted: %v", err)
+		}
+		filter.Relisted = &value
+	}
+
+	// Solo con notas
+	if showOnlyWithNotes := r.URL.Query().Get("show_only_with_notes"); showOnlyWithNotes == "true" {
+		filter.ShowOnlyWithNotes = true
+	}
+
+	return filter, nil
+}
+
+// GetAvailableFeatures retorna todas las características disponibles agrupadas por categoría
+func (h *Handler) GetAvailableFeatures(w http.ResponseWriter, r *http.Request) {
+	features, err := h.db.GetAllFeatures()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error obteniendo características: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Agrupar características por categoría
+	categorizedFeatures := make(map[string][]map[string]interface{})
+	for _, feature := range features {
+		if _, exists := categorizedFeatures[feature.Category]; !exists {
+			categorizedFeatures[feature.Category] = []map[string]interface{}{}
+		}
+
+		featureMap := map[string]interface{}{
+			"id":   feature.ID, // Usar el ID numérico
+			"name": feature.Name,
+		}
+		categorizedFeatures[feature.Category] = append(categorizedFeatures[feature.Category], featureMap)
+	}
+
+	// Convertir a formato esperado por el frontend
+	var response []map[string]interface{}
+	for category, features := range categorizedFeatures {
+		categoryMap := map[string]interface{}{
+			"id":       strings.ToLower(category),
+			"name":     strings.ToUpper(category),
+			"features": features,
+		}
+		response = append(response, categoryMap)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"categories": response,
+	})
+}
+
+// GetPropertyTypes devuelve los tipos de propiedades disponibles
+func (h *Handler) GetPropertyTypes(w http.ResponseWriter, r *http.Request) {
+	types, err := h.db.GetAllPropertyTypes()
+	if err != nil {
+		fmt.Printf("Error al obtener tipos de propiedad: %v\n", err)
+		http.Error(w, fmt.Sprintf("Error al obtener tipos de propiedad: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(types)
+}
+
+// GetListValues devuelve los valores de una lista específica
+func (h *Handler) GetListValues(w http.ResponseWriter, r *http.Request) {
+	listName := chi.URLParam(r, "listName")
+	if listName == "" {
+		http.Error(w, "Nombre de lista no especificado", http.StatusBadRequest)
+		return
+	}
+
+	values, err := h.db.GetListValuesByName(listName)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al obtener valores de la lista %s: %v", listName, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(values)
+}
+
+// GetAgencies devuelve la lista de inmobiliarias
+func (h *Handler) GetAgencies(w http.ResponseWriter, r *http.Request) {
+	agencies, err := h.db.GetAllAgencies()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error al obtener inmobiliarias: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Si no hay inmobiliarias, devolver un array vacío en lugar de null
+	if agencies == nil {
+		agencies = []db.Inmobiliaria{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if err := json.NewEncoder(w).Encode(agencies); err != nil {
+		http.Error(w, fmt.Sprintf("Error al codificar respuesta: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// MergeAgencies fusiona una inmobiliaria duplicada en la inmobiliaria de la URL
+func (h *Handler) MergeAgencies(w http.ResponseWriter, r *http.Request) {
+	keepID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		DuplicateID int64 `json:"duplicate_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.DuplicateID == 0 || request.DuplicateID == keepID {
+		http.Error(w, "duplicate_id must be a different agency", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.db.MergeInmobiliarias(keepID, request.DuplicateID)
+	if err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("agency not found: %v", err), http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("error merging agencies: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":           true,
+		"agency":            result.Agency,
+		"moved_properties":  result.MovedProperties,
+		"merged_properties": result.MergedProperties,
+	})
+}
+
+// agencyRequest es el cuerpo de las solicitudes de alta y edición de inmobiliarias.
+// En la edición, los campos omitidos conservan su valor actual.
+type agencyRequest struct {
+	Name    *string `json:"name"`
+	URL     *string `json:"url"`
+	System  *string `json:"system"`
+	Zone    *string `json:"zone"`
+	Phone   *string `json:"phone"`
+	Address *string `json:"address"`
+}
+
+// apply copia los campos presentes en la solicitud a la inmobiliaria y los valida
+func (req *agencyRequest) apply(agency *db.Inmobiliaria) error {
+	if req.Name != nil {
+		agency.Nombre = strings.TrimSpace(*req.Name)
+	}
+	if req.URL != nil {
+		agency.URL = strings.TrimSpace(*req.URL)
+	}
+	if req.System != nil {
+		agency.Sistema = strings.TrimSpace(*req.System)
+	}
+	if req.Zone != nil {
+		agency.Zona = strings.TrimSpace(*req.Zone)
+	}
+	if req.Phone != nil {
+		agency.Telefono = strings.TrimSpace(*req.Phone)
+	}
+	if req.Address != nil {
+		agency.Direccion = strings.TrimSpace(*req.Address)
+	}
+
+	if agency.Nombre == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if agency.URL != "" {
+		normalized, err := normalizeAgencyURL(agency.URL)
+		if err != nil {
+			return err
+		}
+		agency.URL = normalized
+	}
+
+	// El sistema vacío o "No identificado" deja la inmobiliaria pendiente de detección
+	if agency.Sistema != "" && agency.Sistema != "No identificado" {
+		system, ok := scraper.SistemaConocido(agency.Sistema)
+		if !ok {
+			return fmt.Errorf("unknown system: %s", agency.Sistema)
+		}
+		agency.Sistema = system
+	}
+
+	return nil
+}
+
+// normalizeAgencyURL valida la URL del sitio de una inmobiliaria y le agrega el esquema si falta
+func normalizeAgencyURL(raw string) (string, error) {
+	if !strings.HasPrefix(raw, "http://") && !strings.HasPrefix(raw, "https://") {
+		raw = "https://" + raw
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || !strings.Contains(parsed.Host, ".") {
+		return "", fmt.Errorf("invalid url: %s", raw)
+	}
+
+	return parsed.String(), nil
+}
+
+// parseAgencyID obtiene el id de inmobiliaria de la URL
+func parseAgencyID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+}
+
+// GetAgency devuelve una inmobiliaria
+func (h *Handler) GetAgency(w http.ResponseWriter, r *http.Request) {
+	agencyID, err := parseAgencyID(r)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	agency, err := h.db.GetInmobiliariaByID(agencyID)
+	if err != nil {
+		h.agencyError(w, err, "error getting agency")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agency)
+}
+
+// CreateAgency da de alta una inmobiliaria.
+// Si ya existe una inmobiliaria equivalente responde 409, salvo que se indique ?force=true
+func (h *Handler) CreateAgency(w http.ResponseWriter, r *http.Request) {
+	var request agencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	agency := &db.Inmobiliaria{}
+	if err := request.apply(agency); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkAgencyConflict(w, r, agency) {
+		return
+	}
+
+	if err := h.db.CreateInmobiliaria(agency); err != nil {
+		http.Error(w, fmt.Sprintf("error creating agency: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"agency":  agency,
+	})
+}
+
+// UpdateAgency modifica los datos de una inmobiliaria
+func (h *Handler) UpdateAgency(w http.ResponseWriter, r *http.Request) {
+	agencyID, err := parseAgencyID(r)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	var request agencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	agency, err := h.db.GetInmobiliariaByID(agencyID)
+	if err != nil {
+		h.agencyError(w, err, "error getting agency")
+		return
+	}
+
+	if err := request.apply(agency); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkAgencyConflict(w, r, agency) {
+		return
+	}
+
+	if err := h.db.UpdateInmobiliaria(agency); err != nil {
+		h.agencyError(w, err, "error updating agency")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"agency":  agency,
+	})
+}
+
+// DeleteAgency elimina una inmobiliaria sin propiedades
+func (h *Handler) DeleteAgency(w http.ResponseWriter, r *http.Request) {
+	agencyID, err := parseAgencyID(r)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.DeleteInmobiliaria(agencyID); err != nil {
+		if errors.Is(err, db.ErrInmobiliariaConPropiedades) {
+			http.Error(w, fmt.Sprintf("%v: disable or merge it instead", err), http.StatusConflict)
+			return
+		}
+		h.agencyError(w, err, "error deleting agency")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"agency_id": agencyID,
+	})
+}
+
+// SetAgencyDisabled habilita o deshabilita una inmobiliaria
+func (h *Handler) SetAgencyDisabled(w http.ResponseWriter, r *http.Request) {
+	agencyID, err := parseAgencyID(r)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		Disabled bool `json:"disabled"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.SetInmobiliariaDisabled(agencyID, request.Disabled); err != nil {
+		h.agencyError(w, err, "error updating agency")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"agency_id": agencyID,
+		"disabled":  request.Disabled,
+	})
+}
+
+// DetectAgencySystem vuelve a detectar el sistema del sitio de una inmobiliaria
+func (h *Handler) DetectAgencySystem(w http.ResponseWriter, r *http.Request) {
+	agencyID, err := parseAgencyID(r)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	agency, err := h.db.GetInmobiliariaByID(agencyID)
+	if err != nil {
+		h.agencyError(w, err, "error getting agency")
+		return
+	}
+
+	if agency.URL == "" {
+		http.Error(w, "agency has no url", http.StatusUnprocessableEntity)
+		return
+	}
+
+	system, err := scraper.AnalyzeSystem(agency.URL)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error detecting system: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	previous := agency.Sistema
+	agency.Sistema = system
+	agency.UpdatedAt = time.Now()
+	if err := h.db.UpdateInmobiliariaSistema(agency); err != nil {
+		http.Error(w, fmt.Sprintf("error updating agency: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = scraper.NewScraper(system, agency.URL)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"agency":          agency,
+		"previous_system": previous,
+		"supported":       err == nil,
+	})
+}
+
+// ScrapeAgency lanza en segundo plano la búsqueda de propiedades de una inmobiliaria
+func (h *Handler) ScrapeAgency(w http.ResponseWriter, r *http.Request) {
+	agencyID, err := parseAgencyID(r)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	agency, err := h.db.GetInmobiliariaByID(agencyID)
+	if err != nil {
+		h.agencyError(w, err, "error getting agency")
+		return
+	}
+
+	if agency.Disabled {
+		http.Error(w, "agency is disabled", http.StatusConflict)
+		return
+	}
+
+	if _, err := scraper.NewScraper(agency.Sistema, agency.URL); err != nil {
+		http.Error(w, fmt.Sprintf("unsupported system: %q", agency.Sistema), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if _, running := h.scraping.LoadOrStore(agencyID, true); running {
+		http.Error(w, "agency is already being scraped", http.StatusConflict)
+		return
+	}
+
+	go func() {
+		defer h.scraping.Delete(agencyID)
+		if err := analyzer.ScrapeAgency(context.Background(), h.db, agencyID); err != nil {
+			log.Printf("Error scrapeando inmobiliaria %d: %v", agencyID, err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"agency_id": agencyID,
+		"status":    "started",
+	})
+}
+
+// GetAgencyStats devuelve el historial de scraping de una inmobiliaria.
+// Acepta ?limit=N (por defecto 30 ejecuciones).
+func (h *Handler) GetAgencyStats(w http.ResponseWriter, r *http.Request) {
+	agencyID, err := parseAgencyID(r)
+	if err != nil {
+		http.Error(w, "invalid agency id", http.StatusBadRequest)
+		return
+	}
+
+	limit := 30
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	agency, err := h.db.GetInmobiliariaByID(agencyID)
+	if err != nil {
+		h.agencyError(w, err, "error getting agency")
+		return
+	}
+
+	stats, err := h.db.GetAgencyScrapeStats(agencyID, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting agency stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"agency":       agency,
+		"runs":         stats.Runs,
+		"last_success": stats.LastSuccess,
+		"last_failure": stats.LastFailure,
+		"drop":         stats.Drop,
+	})
+}
+
+// checkAgencyConflict responde 409 si la inmobiliaria coincide con otra existente.
+// Devuelve true si se puede continuar.
+func (h *Handler) checkAgencyConflict(w http.ResponseWriter, r *http.Request, agency *db.Inmobiliaria) bool {
+	if r.URL.Query().Get("force") == "true" {
+		return true
+	}
+
+	existing, match, err := h.db.FindMatchingInmobiliaria(agency)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error checking duplicates: %v", err), http.StatusInternalServerError)
+		return false
+	}
+
+	if existing == nil {
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":    "agency matches an existing agency; use ?force=true to save anyway",
+		"existing": existing,
+		"match":    match,
+	})
+	return false
+}
+
+// agencyError responde 404 si la inmobiliaria no existe y 500 en otro caso
+func (h *Handler) agencyError(w http.ResponseWriter, err error, message string) {
+	if isNotFound(err) {
+		http.Error(w, fmt.Sprintf("agency not found: %v", err), http.StatusNotFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", message, err), http.StatusInternalServerError)
+}
+
+// isNotFound indica si el error proviene de un registro inexistente
+func isNotFound(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
+// getPropertyTypeCode obtiene el código del tipo de propiedad
+func (h *Handler) getPropertyTypeCode(propertyType string) string {
+	if propertyType == "" {
+		return ""
+	}
+
+	// Consultar en la base de datos el código correspondiente al nombre
+	query := `SELECT code FROM property_types WHERE name = ?`
+	var code string
+
+	err := h.db.QueryRow(query, propertyType).Scan(&code)
+	if err != nil {
+		// Si hay error, intentamos hacer una coincidencia aproximada
+		fmt.Printf("Error al obtener código para tipo de propiedad '%s': %v\n", propertyType, err)
+
+		// Mapeo básico para compatibilidad
+		switch strings.ToLower(propertyType) {
+		case "casa", "chalet", "casa chalet", "casa quinta", "quinta":
+			return "house"
+		case "departamento", "depto", "dpto", "dpto.", "depto.", "departamento con dependencia":
+			return "apartment"
+		case "ph", "p.h.", "p.h", "propiedad horizontal":
+			return "ph"
+		case "local", "local comercial", "fondo de comercio":
+			return "local"
+		case "oficina", "consultorio", "estudio":
+			return "office"
+		case "terreno", "lote", "lote de terreno", "fracción", "fraccion":
+			return "land"
+		case "galpón", "galpon", "depósito", "deposito", "nave industrial":
+			return "warehouse"
+		default:
+			// Si no hay coincidencia, devolvemos el tipo en minúsculas como fallback
+			return strings.ToLower(strings.ReplaceAll(propertyType, " ", "_"))
+		}
+	}
+
+	return code
+}
+
+// getPropertyTypeCodeById obtiene el código del tipo de propiedad a partir del ID
+func (h *Handler) getPropertyTypeCodeById(propertyType int64) string {
+	// Consultar en la base de datos el código correspondiente al ID
+	query := `SELECT code FROM property_types WHERE id = ?`
+	var code string
+
+	err := h.db.QueryRow(query, propertyType).Scan(&code)
+	if err != nil {
+		// Si hay error, intentamos hacer una coincidencia aproximada
+		fmt.Printf("Error al obtener código para tipo de propiedad ID %d: %v\n", propertyType, err)
+
+		// Mapeo básico para compatibilidad
+		switch propertyType {
+		case 1:
+			return "house"
+		case 2:
+			return "apartment"
+		case 3:
+			return "ph"
+		case 4:
+			return "local"
+		case 5:
+			return "office"
+		case 6:
+			return "land"
+		case 7:
+			return "warehouse"
+		default:
+			// Si no hay coincidencia, devolvemos el ID como fallback
+			return fmt.Sprintf("type_%d", propertyType)
+		}
 	}
+
+	return code
 }
 
-// Helper para obtener la ubicación completa
-func getLocation(p *db.Propiedad) string {
-	if p.Direccion != "" {
-		return p.Direccion
+// savedSearchRequest es el cuerpo para crear o modificar una búsqueda guardada.
+// En la modificación los campos ausentes se conservan.
+type savedSearchRequest struct {
+	Name   *string            `json:"name"`
+	Filter *db.PropertyFilter `json:"filter"`
+}
+
+// apply copia los campos presentes en la solicitud a la búsqueda y los valida
+func (req *savedSearchRequest) apply(search *db.SavedSearch) error {
+	if req.Name != nil {
+		search.Name = strings.TrimSpace(*req.Name)
 	}
-	if p.Ubicacion != nil {
-		return *p.Ubicacion
+	if req.Filter != nil {
+		search.Filter = *req.Filter
+	}
+
+	if search.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	return nil
+}
+
+// parseSavedSearchID obtiene el ID de búsqueda de la URL
+func parseSavedSearchID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+}
+
+// getSavedSearch obtiene una búsqueda guardada del usuario de la solicitud.
+// Las búsquedas de otros usuarios se tratan como inexistentes.
+func (h *Handler) getSavedSearch(r *http.Request, id int64) (*db.SavedSearch, error) {
+	search, err := h.db.GetSavedSearch(id)
+	if err != nil {
+		return nil, err
+	}
+	if search.UserID != currentUser(r).ID {
+		return nil, fmt.Errorf("error obteniendo búsqueda %d: %w", id, sql.ErrNoRows)
+	}
+	return search, nil
+}
+
+// savedSearchError responde 404 si la búsqueda no existe o 500 en otro caso
+func (h *Handler) savedSearchError(w http.ResponseWriter, err error, message string) {
+	if isNotFound(err) {
+		http.Error(w, fmt.Sprintf("saved search not found: %v", err), http.StatusNotFound)
+		return
+	}
+	http.Error(w, fmt.Sprintf("%s: %v", message, err), http.StatusInternalServerError)
+}
+
+// toPropertyResponses convierte una lista de propiedades al formato de la API, con las notas y favoritos del usuario
+func (h *Handler) toPropertyResponses(userID int64, properties []db.Propiedad) []PropertyResponse {
+	response := make([]PropertyResponse, 0, len(properties))
+	for _, p := range properties {
+		resp := h.toPropertyResponse(userID, &p)
+		if isFavorite, err := h.db.IsPropertyFavorite(userID, p.ID); err == nil {
+			resp.IsFavorite = isFavorite
+		}
+		response = append(response, resp)
+	}
+	return response
+}
+
+// GetSavedSearches devuelve las búsquedas guardadas
+func (h *Handler) GetSavedSearches(w http.ResponseWriter, r *http.Request) {
+	searches, err := h.db.GetUserSavedSearches(currentUser(r).ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting saved searches: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"searches": searches,
+		"total":    len(searches),
+	})
+}
+
+// GetSavedSearch devuelve una búsqueda guardada
+func (h *Handler) GetSavedSearch(w http.ResponseWriter, r *http.Request) {
+	searchID, err := parseSavedSearchID(r)
+	if err != nil {
+		http.Error(w, "invalid saved search id", http.StatusBadRequest)
+		return
+	}
+
+	search, err := h.getSavedSearch(r, searchID)
+	if err != nil {
+		h.savedSearchError(w, err, "error getting saved search")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"search": search,
+	})
+}
+
+// CreateSavedSearch guarda una búsqueda con su filtro
+func (h *Handler) CreateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	var request savedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	search := &db.SavedSearch{UserID: currentUser(r).ID}
+	if err := request.apply(search); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.CreateSavedSearch(search); err != nil {
+		http.Error(w, fmt.Sprintf("error creating saved search: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"search":  search,
+	})
+}
+
+// UpdateSavedSearch modifica el nombre o el filtro de una búsqueda guardada
+func (h *Handler) UpdateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	searchID, err := parseSavedSearchID(r)
+	if err != nil {
+		http.Error(w, "invalid saved search id", http.StatusBadRequest)
+		return
+	}
+
+	var request savedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	search, err := h.getSavedSearch(r, searchID)
+	if err != nil {
+		h.savedSearchError(w, err, "error getting saved search")
+		return
+	}
+
+	if err := request.apply(search); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.UpdateSavedSearch(search); err != nil {
+		h.savedSearchError(w, err, "error updating saved search")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"search":  search,
+	})
+}
+
+// DeleteSavedSearch elimina una búsqueda guardada
+func (h *Handler) DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	searchID, err := parseSavedSearchID(r)
+	if err != nil {
+		http.Error(w, "invalid saved search id", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.getSavedSearch(r, searchID); err != nil {
+		h.savedSearchError(w, err, "error getting saved search")
+		return
+	}
+
+	if err := h.db.DeleteSavedSearch(searchID); err != nil {
+		h.savedSearchError(w, err, "error deleting saved search")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"search_id": searchID,
+	})
+}
+
+// RunSavedSearch devuelve las propiedades que coinciden ahora con una búsqueda guardada
+func (h *Handler) RunSavedSearch(w http.ResponseWriter, r *http.Request) {
+	searchID, err := parseSavedSearchID(r)
+	if err != nil {
+		http.Error(w, "invalid saved search id", http.StatusBadRequest)
+		return
+	}
+
+	search, err := h.getSavedSearch(r, searchID)
+	if err != nil {
+		h.savedSearchError(w, err, "error getting saved search")
+		return
+	}
+
+	properties, err := h.db.RunSavedSearch(search)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error running saved search: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := h.toPropertyResponses(currentUser(r).ID, properties)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"search":     search,
+		"properties": response,
+		"total":      len(response),
+	})
+}
+
+// GetSavedSearchMatches devuelve las propiedades que empezaron a coincidir con una búsqueda guardada,
+// según las evaluaciones del daemon. Acepta ?since= (RFC 3339) para ver solo las recientes.
+func (h *Handler) GetSavedSearchMatches(w http.ResponseWriter, r *http.Request) {
+	searchID, err := parseSavedSearchID(r)
+	if err != nil {
+		http.Error(w, "invalid saved search id", http.StatusBadRequest)
+		return
+	}
+
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "invalid since: expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := h.getSavedSearch(r, searchID); err != nil {
+		h.savedSearchError(w, err, "error getting saved search")
+		return
+	}
+
+	properties, err := h.db.GetSavedSearchMatches(searchID, since)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting saved search matches: %v", err), http.StatusInternalServerError)
+		return
 	}
-	return ""
+
+	response := h.toPropertyResponses(currentUser(r).ID, properties)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"properties": response,
+		"total":      len(response),
+	})
 }
 
-// Helper para manejar punteros string
-func getString(s *string) string {
-	if s == nil {
-		return ""
+type contextKey string
+
+// Claves del contexto de la solicitud que completa Authenticate
+const (
+	userContextKey  contextKey = "user"
+	tokenContextKey contextKey = "token"
+)
+
+// apiKeyHeader es la alternativa a "Authorization: Bearer" para las API keys
+const apiKeyHeader = "X-API-Key"
+
+// requestToken obtiene el token de sesión o la API key de la solicitud
+func requestToken(r *http.Request) string {
+	if value := r.Header.Get("Authorization"); value != "" {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
 	}
-	return *s
+	return strings.TrimSpace(r.Header.Get(apiKeyHeader))
 }
 
-// parsePropertyFilter parsea los filtros de la solicitud
-func parsePropertyFilter(r *http.Request) (*db.PropertyFilter, error) {
-	// Si es GET, parsear de query params
-	if r.Method == http.MethodGet {
-		return parseFilterFromQueryParams(r)
-	}
+// Authenticate exige un token de sesión o una API key válidos y guarda el usuario en el contexto
+func (h *Handler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := requestToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
 
-	// Si es POST, parsear del body
-	if r.Method == http.MethodPost {
-		var filter db.PropertyFilter
-		if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
-			return nil, fmt.Errorf("error decoding filter: %v", err)
+		var user *db.User
+		var err error
+		if auth.IsAPIKey(token) {
+			user, err = h.db.GetAPIKeyUser(auth.HashToken(token))
+		} else {
+			user, err = h.db.GetSessionUser(auth.HashToken(token))
 		}
-		return &filter, nil
+		if err != nil {
+			if isNotFound(err) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "invalid or expired credentials", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, fmt.Sprintf("error authenticating: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, tokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireRole rechaza con 403 a los usuarios sin al menos los permisos de role.
+// Debe usarse después de Authenticate.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !currentUser(r).HasRole(role) {
+				http.Error(w, fmt.Sprintf("forbidden: requires role %s", role), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
+}
 
-	// Por defecto, retornar un filtro vacío
-	return &db.PropertyFilter{}, nil
+// currentUser devuelve el usuario autenticado de la solicitud.
+// Fuera de Authenticate devuelve un usuario sin permisos.
+func currentUser(r *http.Request) *db.User {
+	if user, ok := r.Context().Value(userContextKey).(*db.User); ok {
+		return user
+	}
+	return &db.User{}
 }
 
-// parseFilterFromQueryParams parsea los filtros de los query params
-func parseFilterFromQueryParams(r *http.Request) (*db.PropertyFilter, error) {
-	filter := &db.PropertyFilter{}
+// Login inicia una sesión con usuario y contraseña y devuelve el token
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
 
-	// Tipo de propiedad (por código)
-	if propertyType := r.URL.Query().Get("property_type"); propertyType != "" {
-		filter.PropertyType = propertyType
-		fmt.Printf("Filtro property_type (código): %s\n", propertyType)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	// Tipo de propiedad (por ID)
-	if propertyTypeID := r.URL.Query().Get("property_type_id"); propertyTypeID != "" {
-		id, err := strconv.ParseInt(propertyTypeID, 10, 64)
-		if err != nil {
-			fmt.Printf("Error al convertir property_type_id a int64: %v\n", err)
-		} else {
-			filter.PropertyTypeID = &id
-			fmt.Printf("Filtro property_type_id: %d\n", id)
-		}
+	user, hash, err := h.db.GetUserCredentials(request.Username)
+	if err != nil && !isNotFound(err) {
+		http.Error(w, fmt.Sprintf("error logging in: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Mismo mensaje si el usuario no existe o la contraseña no coincide
+	if err != nil || !auth.CheckPassword(hash, request.Password) {
+		http.Error(w, "invalid username or password", http.StatusUnauthorized)
+		return
 	}
 
-	// Múltiples tipos de propiedad (por IDs)
-	if propertyTypeIDs := r.URL.Query().Get("property_type_ids"); propertyTypeIDs != "" {
-		idStrings := strings.Split(propertyTypeIDs, ",")
-		var ids []int64
+	token, tokenHash, err := auth.NewSessionToken()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error logging in: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-		for _, idStr := range idStrings {
-			id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
-			if err != nil {
-				fmt.Printf("Error al convertir un ID de property_type_ids a int64: %v\n", err)
-				continue
-			}
-			ids = append(ids, id)
-		}
+	expiresAt := time.Now().Add(h.sessionTTL).UTC()
+	if err := h.db.CreateSession(user.ID, tokenHash, expiresAt); err != nil {
+		http.Error(w, fmt.Sprintf("error logging in: %v", err), http.StatusInternalServerError)
+		return
+	}
 
-		if len(ids) > 0 {
-			filter.PropertyTypeIDs = ids
-			fmt.Printf("Filtro property_type_ids: %v\n", ids)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"expires_at": expiresAt,
+		"user":       user,
+	})
+}
+
+// Logout cierra la sesión de la solicitud. Con una API key no hace nada: las claves se revocan.
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	if token != "" && !auth.IsAPIKey(token) {
+		if err := h.db.DeleteSession(auth.HashToken(token)); err != nil {
+			http.Error(w, fmt.Sprintf("error logging out: %v", err), http.StatusInternalServerError)
+			return
 		}
 	}
 
-	// Ubicaciones
-	if locations := r.URL.Query().Get("locations"); locations != "" {
-		filter.Locations = strings.Split(locations, ",")
-		fmt.Printf("Filtro locations: %v\n", filter.Locations)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// GetAPIKeys devuelve las API keys del usuario, sin las claves
+func (h *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.db.GetAPIKeys(currentUser(r).ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting api keys: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Características - manejar múltiples formatos posibles
-	// 1. Formato de array: features[]=1&features[]=2
-	// 2. Formato de cadena separada por comas: features=1,2
-	features := r.URL.Query()["features[]"] // Intentar obtener como array
-	if len(features) == 0 {
-		// Si no hay resultados, intentar como parámetro simple
-		if featuresStr := r.URL.Query().Get("features"); featuresStr != "" {
-			features = strings.Split(featuresStr, ",")
-		}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_keys": keys,
+		"total":    len(keys),
+	})
+}
+
+// CreateAPIKey crea una API key para el usuario. La clave solo se muestra en esta respuesta.
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
 	}
 
-	// Limpiar valores vacíos
-	var cleanFeatures []string
-	for _, f := range features {
-		if f != "" {
-			cleanFeatures = append(cleanFeatures, f)
-		}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	if len(cleanFeatures) > 0 {
-		filter.Features = cleanFeatures
-		fmt.Printf("Filtro features: %v\n", filter.Features)
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
 	}
 
-	// Precio mínimo
-	if priceMin := r.URL.Query().Get("price_min"); priceMin != "" {
-		min, err := strconv.ParseFloat(priceMin, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid price_min: %v", err)
-		}
-		filter.PriceMin = &min
+	key, prefix, keyHash, err := auth.NewAPIKey()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error creating api key: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Precio máximo
-	if priceMax := r.URL.Query().Get("price_max"); priceMax != "" {
-		max, err := strconv.ParseFloat(priceMax, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid price_max: %v", err)
-		}
-		filter.PriceMax = &max
+	apiKey := &db.APIKey{UserID: currentUser(r).ID, Name: request.Name, Prefix: prefix}
+	if err := h.db.CreateAPIKey(apiKey, keyHash); err != nil {
+		http.Error(w, fmt.Sprintf("error creating api key: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Moneda - solo establecer si se proporciona explícitamente o si hay filtros de precio
-	if currency := r.URL.Query().Get("currency"); currency != "" {
-		filter.Currency = currency
-	} else if filter.PriceMin != nil || filter.PriceMax != nil {
-		// Si hay filtros de precio pero no se especificó moneda, usar ARS por defecto
-		filter.Currency = "ARS"
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// RevokeAPIKey revoca una API key del usuario
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid api key id", http.StatusBadRequest)
+		return
 	}
 
-	// Tamaño mínimo (compatibilidad con versión anterior)
-	if sizeMin := r.URL.Query().Get("size_min"); sizeMin != "" {
-		min, err := strconv.ParseFloat(sizeMin, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid size_min: %v", err)
+	if err := h.db.RevokeAPIKey(currentUser(r).ID, keyID); err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("api key not found: %v", err), http.StatusNotFound)
+			return
 		}
-		filter.SizeMin = &min
+		http.Error(w, fmt.Sprintf("error revoking api key: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Tamaño máximo (compatibilidad con versión anterior)
-	if sizeMax := r.URL.Query().Get("size_max"); sizeMax != "" {
-		max, err := strconv.ParseFloat(sizeMax, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid size_max: %v", err)
-		}
-		filter.SizeMax = &max
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"api_key_id": keyID,
+	})
+}
+
+// userRequest es el cuerpo para crear o modificar un usuario.
+// En la modificación los campos ausentes se conservan.
+type userRequest struct {
+	Name     *string `json:"name"`
+	Password *string `json:"password"`
+	Role     *string `json:"role"`
+}
+
+// GetUsers devuelve los usuarios
+func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.db.GetUsers()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting users: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Superficie Total mínima
-	if totalAreaMin := r.URL.Query().Get("total_area_min"); totalAreaMin != "" {
-		min, err := strconv.ParseFloat(totalAreaMin, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid total_area_min: %v", err)
-		}
-		filter.TotalAreaMin = &min
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": users,
+		"total": len(users),
+	})
+}
+
+// GetCurrentUser devuelve el usuario autenticado
+func (h *Handler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user": currentUser(r),
+	})
+}
+
+// CreateUser crea un usuario con contraseña y rol (member si no se indica)
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var request userRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	// Superficie Total máxima
-	if totalAreaMax := r.URL.Query().Get("total_area_max"); totalAreaMax != "" {
-		max, err := strconv.ParseFloat(totalAreaMax, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid total_area_max: %v", err)
-		}
-		filter.TotalAreaMax = &max
+	if request.Name == nil || strings.TrimSpace(*request.Name) == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
 	}
 
-	// Superficie Cubierta mínima
-	if coveredAreaMin := r.URL.Query().Get("covered_area_min"); coveredAreaMin != "" {
-		min, err := strconv.ParseFloat(coveredAreaMin, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid covered_area_min: %v", err)
+	user := &db.User{Name: *request.Name}
+	if request.Role != nil {
+		if !db.ValidRole(*request.Role) {
+			http.Error(w, fmt.Sprintf("invalid role: %s", *request.Role), http.StatusBadRequest)
+			return
 		}
-		filter.CoveredAreaMin = &min
+		user.Role = *request.Role
 	}
 
-	// Superficie Cubierta máxima
-	if coveredAreaMax := r.URL.Query().Get("covered_area_max"); coveredAreaMax != "" {
-		max, err := strconv.ParseFloat(coveredAreaMax, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid covered_area_max: %v", err)
+	var hash string
+	if request.Password != nil {
+		var err error
+		if hash, err = auth.HashPassword(*request.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		filter.CoveredAreaMax = &max
 	}
 
-	// Superficie Terreno mínima
-	if landAreaMin := r.URL.Query().Get("land_area_min"); landAreaMin != "" {
-		min, err := strconv.ParseFloat(landAreaMin, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid land_area_min: %v", err)
+	if err := h.db.CreateUser(user); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			http.Error(w, fmt.Sprintf("user %q already exists", user.Name), http.StatusConflict)
+			return
 		}
-		filter.LandAreaMin = &min
+		http.Error(w, fmt.Sprintf("error creating user: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Superficie Terreno máxima
-	if landAreaMax := r.URL.Query().Get("land_area_max"); landAreaMax != "" {
-		max, err := strconv.ParseFloat(landAreaMax, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid land_area_max: %v", err)
+	if hash != "" {
+		if err := h.db.SetUserPassword(user.ID, hash); err != nil {
+			http.Error(w, fmt.Sprintf("error setting password: %v", err), http.StatusInternalServerError)
+			return
 		}
-		filter.LandAreaMax = &max
 	}
 
-	// Frente
-	if front := r.URL.Query().Get("front"); front != "" {
-		frontValue, err := strconv.ParseFloat(front, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid front: %v", err)
-		}
-		filter.Front = &frontValue
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"user":    user,
+	})
+}
+
+// UpdateUser cambia el rol o la contraseña de un usuario. Cambiar la contraseña cierra sus sesiones.
+func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
 	}
 
-	// Fondo
-	if back := r.URL.Query().Get("back"); back != "" {
-		backValue, err := strconv.ParseFloat(back, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid back: %v", err)
-		}
-		filter.Back = &backValue
+	var request userRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	// Ambientes
-	if rooms := r.URL.Query().Get("rooms"); rooms != "" {
-		roomsInt, err := strconv.Atoi(rooms)
-		if err != nil {
-			return nil, fmt.Errorf("invalid rooms: %v", err)
+	if request.Name != nil {
+		http.Error(w, "user name cannot be changed", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.db.GetUserByID(userID)
+	if err != nil {
+		if isNotFound(err) {
+			http.Error(w, fmt.Sprintf("user not found: %v", err), http.StatusNotFound)
+			return
 		}
-		filter.Rooms = &roomsInt
+		http.Error(w, fmt.Sprintf("error getting user: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Baños
-	if bathrooms := r.URL.Query().Get("bathrooms"); bathrooms != "" {
-		bathroomsInt, err := strconv.Atoi(bathrooms)
-		if err != nil {
-			return nil, fmt.Errorf("invalid bathrooms: %v", err)
+	if request.Role != nil {
+		if !db.ValidRole(*request.Role) {
+			http.Error(w, fmt.Sprintf("invalid role: %s", *request.Role), http.StatusBadRequest)
+			return
 		}
-		filter.Bathrooms = &bathroomsInt
+		// Un administrador no puede quitarse el rol a sí mismo y quedar sin acceso a la administración
+		if user.ID == currentUser(r).ID && *request.Role != db.RoleAdmin {
+			http.Error(w, "cannot remove your own admin role", http.StatusBadRequest)
+			return
+		}
+		if err := h.db.SetUserRole(user.ID, *request.Role); err != nil {
+			http.Error(w, fmt.Sprintf("error updating role: %v", err), http.StatusInternalServerError)
+			return
+		}
+		user.Role = *request.Role
 	}
 
-	// Antigüedad
-	if antiquity := r.URL.Query().Get("antiquity"); antiquity != "" {
-		antiquityInt, err := strconv.Atoi(antiquity)
+	if request.Password != nil {
+		hash, err := auth.HashPassword(*request.Password)
 		if err != nil {
-			return nil, fmt.Errorf("invalid antiquity: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.db.SetUserPassword(user.ID, hash); err != nil {
+			http.Error(w, fmt.Sprintf("error setting password: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := h.db.DeleteUserSessions(user.ID); err != nil {
+			http.Error(w, fmt.Sprintf("error closing sessions: %v", err), http.StatusInternalServerError)
+			return
 		}
-		filter.Antiquity = &antiquityInt
 	}
 
-	// Disposición
-	if disposition := r.URL.Query().Get("disposition"); disposition != "" {
-		filter.Disposition = strings.Split(disposition, ",")
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"user":    user,
+	})
+}
 
-	// Orientación
-	if orientation := r.URL.Query().Get("orientation"); orientation != "" {
-		filter.Orientation = strings.Split(orientation, ",")
+// householdVotes devuelve los votos del hogar del usuario sobre una propiedad, o nil si no tiene hogar
+func (h *Handler) householdVotes(user *db.User, propertyID int64) *db.HouseholdVotes {
+	if user.HouseholdID == nil {
+		return nil
+	}
+	votes, err := h.db.GetHouseholdVotes(*user.HouseholdID, propertyID)
+	if err != nil {
+		log.Printf("Error obteniendo votos del hogar %d: %v", *user.HouseholdID, err)
+		return nil
 	}
+	return votes
+}
 
-	// Condición
-	if condition := r.URL.Query().Get("condition"); condition != "" {
-		filter.Condition = strings.Split(condition, ",")
+// currentHousehold obtiene el hogar del usuario de la solicitud; responde 404 si no tiene
+func (h *Handler) currentHousehold(w http.ResponseWriter, r *http.Request) (*db.Household, bool) {
+	user := currentUser(r)
+	if user.HouseholdID == nil {
+		http.Error(w, "user does not belong to a household", http.StatusNotFound)
+		return nil, false
 	}
 
-	// Tipo de operación
-	if operationType := r.URL.Query().Get("operation_type"); operationType != "" {
-		filter.OperationType = strings.Split(operationType, ",")
+	household, err := h.db.GetHousehold(*user.HouseholdID)
+	if err != nil {
+		h.householdError(w, err, "error getting household")
+		return nil, false
 	}
+	return household, true
+}
 
-	// Situación
-	if situation := r.URL.Query().Get("situation"); situation != "" {
-		filter.Situation = strings.Split(situation, ",")
+// householdError responde 404 si el hogar o el usuario no existe o 500 en otro caso
+func (h *Handler) householdError(w http.ResponseWriter, err error, message string) {
+	if isNotFound(err) {
+		http.Error(w, fmt.Sprintf("not found: %v", err), http.StatusNotFound)
+		return
 	}
+	http.Error(w, fmt.Sprintf("%s: %v", message, err), http.StatusInternalServerError)
+}
 
-	// Inmobiliarias
-	if agencies := r.URL.Query().Get("agencies"); agencies != "" {
-		// Convertir los IDs de string a int64
-		agencyIDs := strings.Split(agencies, ",")
-		var agencyIDsInt []int64
-		for _, idStr := range agencyIDs {
-			id, err := strconv.ParseInt(idStr, 10, 64)
-			if err != nil {
-				fmt.Printf("Error al convertir ID de inmobiliaria '%s' a int64: %v\n", idStr, err)
-				continue
-			}
-			agencyIDsInt = append(agencyIDsInt, id)
-		}
-		filter.AgencyIDs = agencyIDsInt
+// GetCurrentHousehold devuelve el hogar del usuario con sus miembros
+func (h *Handler) GetCurrentHousehold(w http.ResponseWriter, r *http.Request) {
+	household, ok := h.currentHousehold(w, r)
+	if !ok {
+		return
 	}
 
-	// Solo con notas
-	if showOnlyWithNotes := r.URL.Query().Get("show_only_with_notes"); showOnlyWithNotes == "true" {
-		filter.ShowOnlyWithNotes = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"household": household,
+	})
+}
+
+// GetHouseholdProperties devuelve las propiedades votadas por el hogar del usuario con el voto de cada miembro.
+// Acepta ?outcome= (match, split, vetoed, pending) y los filtros de propiedades.
+func (h *Handler) GetHouseholdProperties(w http.ResponseWriter, r *http.Request) {
+	outcome := r.URL.Query().Get("outcome")
+	if outcome != "" && !db.ValidHouseholdOutcome(outcome) {
+		http.Error(w, fmt.Sprintf("invalid outcome: %s", outcome), http.StatusBadRequest)
+		return
 	}
+	h.writeHouseholdProperties(w, r, outcome)
+}
 
-	return filter, nil
+// GetHouseholdMatches devuelve las propiedades a las que todos los miembros del hogar dieron like
+func (h *Handler) GetHouseholdMatches(w http.ResponseWriter, r *http.Request) {
+	h.writeHouseholdProperties(w, r, db.HouseholdMatch)
 }
 
-// GetAvailableFeatures retorna todas las características disponibles agrupadas por categoría
-func (h *Handler) GetAvailableFeatures(w http.ResponseWriter, r *http.Request) {
-	features, err := h.db.GetAllFeatures()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error obteniendo características: %v", err), http.StatusInternalServerError)
+func (h *Handler) writeHouseholdProperties(w http.ResponseWriter, r *http.Request, outcome string) {
+	household, ok := h.currentHousehold(w, r)
+	if !ok {
 		return
 	}
 
-	// Agrupar características por categoría
-	categorizedFeatures := make(map[string][]map[string]interface{})
-	for _, feature := range features {
-		if _, exists := categorizedFeatures[feature.Category]; !exists {
-			categorizedFeatures[feature.Category] = []map[string]interface{}{}
-		}
+	filter, err := parsePropertyFilter(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error parsing filters: %v", err), http.StatusBadRequest)
+		return
+	}
 
-		featureMap := map[string]interface{}{
-			"id":   feature.ID, // Usar el ID numérico
-			"name": feature.Name,
-		}
-		categorizedFeatures[feature.Category] = append(categorizedFeatures[feature.Category], featureMap)
+	user := currentUser(r)
+	filter.UserID = user.ID
+	properties, err := h.db.GetHouseholdProperties(household.ID, outcome, filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error getting household properties: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	// Convertir a formato esperado por el frontend
-	var response []map[string]interface{}
-	for category, features := range categorizedFeatures {
-		categoryMap := map[string]interface{}{
-			"id":       strings.ToLower(category),
-			"name":     strings.ToUpper(category),
-			"features": features,
-		}
-		response = append(response, categoryMap)
+	response := h.toPropertyResponses(user.ID, properties)
+	for i := range response {
+		response[i].Household = h.householdVotes(user, response[i].ID)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"categories": response,
+		"household":  household,
+		"properties": response,
+		"total":      len(response),
 	})
 }
 
-// GetPropertyTypes devuelve los tipos de propiedades disponibles
-func (h *Handler) GetPropertyTypes(w http.ResponseWriter, r *http.Request) {
-	types, err := h.db.GetAllPropertyTypes()
+// GetHouseholds devuelve todos los hogares con sus miembros
+func (h *Handler) GetHouseholds(w http.ResponseWriter, r *http.Request) {
+	households, err := h.db.GetHouseholds()
 	if err != nil {
-		fmt.Printf("Error al obtener tipos de propiedad: %v\n", err)
-		http.Error(w, fmt.Sprintf("Error al obtener tipos de propiedad: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("error getting households: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	json.NewEncoder(w).Encode(types)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"households": households,
+		"total":      len(households),
+	})
 }
 
-// GetListValues devuelve los valores de una lista específica
-func (h *Handler) GetListValues(w http.ResponseWriter, r *http.Request) {
-	listName := chi.URLParam(r, "listName")
-	if listName == "" {
-		http.Error(w, "Nombre de lista no especificado", http.StatusBadRequest)
+// CreateHousehold crea un hogar sin miembros
+func (h *Handler) CreateHousehold(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	values, err := h.db.GetListValuesByName(listName)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error al obtener valores de la lista %s: %v", listName, err), http.StatusInternalServerError)
+	if strings.TrimSpace(request.Name) == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	household := &db.Household{Name: request.Name}
+	if err := h.db.CreateHousehold(household); err != nil {
+		http.Error(w, fmt.Sprintf("error creating household: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(values)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"household": household,
+	})
 }
 
-// GetAgencies devuelve la lista de inmobiliarias
-func (h *Handler) GetAgencies(w http.ResponseWriter, r *http.Request) {
-	agencies, err := h.db.GetAllAgencies()
+// DeleteHousehold elimina un hogar; sus miembros conservan sus calificaciones
+func (h *Handler) DeleteHousehold(w http.ResponseWriter, r *http.Request) {
+	householdID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error al obtener inmobiliarias: %v", err), http.StatusInternalServerError)
+		http.Error(w, "invalid household id", http.StatusBadRequest)
 		return
 	}
 
-	// Si no hay inmobiliarias, devolver un array vacío en lugar de null
-	if agencies == nil {
-		agencies = []db.Inmobiliaria{}
+	if err := h.db.DeleteHousehold(householdID); err != nil {
+		h.householdError(w, err, "error deleting household")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	if err := json.NewEncoder(w).Encode(agencies); err != nil {
-		http.Error(w, fmt.Sprintf("Error al codificar respuesta: %v", err), http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"household_id": householdID,
+	})
+}
+
+// parseHouseholdMember obtiene los IDs de hogar y usuario de la URL
+func parseHouseholdMember(r *http.Request) (int64, int64, error) {
+	householdID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		return 0, 0, err
 	}
+	return householdID, userID, nil
 }
 
-// getPropertyTypeCode obtiene el código del tipo de propiedad
-func (h *Handler) getPropertyTypeCode(propertyType string) string {
-	if propertyType == "" {
-		return ""
+// AddHouseholdMember agrega un usuario a un hogar (y lo quita del anterior si tenía)
+func (h *Handler) AddHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	householdID, userID, err := parseHouseholdMember(r)
+	if err != nil {
+		http.Error(w, "invalid household or user id", http.StatusBadRequest)
+		return
 	}
 
-	// Consultar en la base de datos el código correspondiente al nombre
-	query := `SELECT code FROM property_types WHERE name = ?`
-	var code string
+	if err := h.db.SetUserHousehold(userID, &householdID); err != nil {
+		h.householdError(w, err, "error adding household member")
+		return
+	}
 
-	err := h.db.QueryRow(query, propertyType).Scan(&code)
+	household, err := h.db.GetHousehold(householdID)
 	if err != nil {
-		// Si hay error, intentamos hacer una coincidencia aproximada
-		fmt.Printf("Error al obtener código para tipo de propiedad '%s': %v\n", propertyType, err)
-
-		// Mapeo básico para compatibilidad
-		switch strings.ToLower(propertyType) {
-		case "casa", "chalet", "casa chalet", "casa quinta", "quinta":
-			return "house"
-		case "departamento", "depto", "dpto", "dpto.", "depto.", "departamento con dependencia":
-			return "apartment"
-		case "ph", "p.h.", "p.h", "propiedad horizontal":
-			return "ph"
-		case "local", "local comercial", "fondo de comercio":
-			return "local"
-		case "oficina", "consultorio", "estudio":
-			return "office"
-		case "terreno", "lote", "lote de terreno", "fracción", "fraccion":
-			return "land"
-		case "galpón", "galpon", "depósito", "deposito", "nave industrial":
-			return "warehouse"
-		default:
-			// Si no hay coincidencia, devolvemos el tipo en minúsculas como fallback
-			return strings.ToLower(strings.ReplaceAll(propertyType, " ", "_"))
-		}
+		h.householdError(w, err, "error getting household")
+		return
 	}
 
-	return code
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"household": household,
+	})
 }
 
-// getPropertyTypeCodeById obtiene el código del tipo de propiedad a partir del ID
-func (h *Handler) getPropertyTypeCodeById(propertyType int64) string {
-	// Consultar en la base de datos el código correspondiente al ID
-	query := `SELECT code FROM property_types WHERE id = ?`
-	var code string
+// RemoveHouseholdMember quita un usuario de un hogar
+func (h *Handler) RemoveHouseholdMember(w http.ResponseWriter, r *http.Request) {
+	householdID, userID, err := parseHouseholdMember(r)
+	if err != nil {
+		http.Error(w, "invalid household or user id", http.StatusBadRequest)
+		return
+	}
 
-	err := h.db.QueryRow(query, propertyType).Scan(&code)
+	user, err := h.db.GetUserByID(userID)
 	if err != nil {
-		// Si hay error, intentamos hacer una coincidencia aproximada
-		fmt.Printf("Error al obtener código para tipo de propiedad ID %d: %v\n", propertyType, err)
+		h.householdError(w, err, "error getting user")
+		return
+	}
+	if user.HouseholdID == nil || *user.HouseholdID != householdID {
+		http.Error(w, fmt.Sprintf("user %d is not a member of household %d", userID, householdID), http.StatusNotFound)
+		return
+	}
 
-		// Mapeo básico para compatibilidad
-		switch propertyType {
-		case 1:
-			return "house"
-		case 2:
-			return "apartment"
-		case 3:
-			return "ph"
-		case 4:
-			return "local"
-		case 5:
-			return "office"
-		case 6:
-			return "land"
-		case 7:
-			return "warehouse"
-		default:
-			// Si no hay coincidencia, devolvemos el ID como fallback
-			return fmt.Sprintf("type_%d", propertyType)
-		}
+	if err := h.db.SetUserHousehold(userID, nil); err != nil {
+		h.householdError(w, err, "error removing household member")
+		return
 	}
 
-	return code
+	household, err := h.db.GetHousehold(householdID)
+	if err != nil {
+		h.householdError(w, err, "error getting household")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"household": household,
+	})
 }
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Prefijos de los tokens, para distinguir una sesión de una API key
+const (
+	sessionPrefix = "fhs_"
+	apiKeyPrefix  = "fhk_"
+)
+
+// Largo mínimo de una contraseña
+const MinPasswordLength = 8
+
+// Caracteres de la API key que se guardan en claro para reconocerla
+const apiKeyVisible = 8
+
+// HashPassword devuelve el hash bcrypt de una contraseña
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("la contraseña debe tener al menos %d caracteres", MinPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("error generando hash de contraseña: %v", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword indica si password corresponde al hash. Un hash vacío nunca coincide.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NewSessionToken genera un token de sesión y el hash con el que se guarda
+func NewSessionToken() (token, hash string, err error) {
+	token, err = randomToken(sessionPrefix)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// NewAPIKey genera una API key, el prefijo que se muestra en los listados y el hash con el que se guarda
+func NewAPIKey() (key, prefix, hash string, err error) {
+	key, err = randomToken(apiKeyPrefix)
+	if err != nil {
+		return "", "", "", err
+	}
+	return key, key[:len(apiKeyPrefix)+apiKeyVisible], HashToken(key), nil
+}
+
+// IsAPIKey indica si el token es una API key y no un token de sesión
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// HashToken devuelve el hash con el que se guarda un token. Los tokens son aleatorios
+// y largos, así que alcanza con SHA-256.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generando token: %v", err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// MarketListing son los datos de una propiedad que usan las estadísticas del mercado
+type MarketListing struct {
+	ID                 int64
+	Ubicacion          string
+	TipoPropiedad      *int64
+	Operacion          string
+	Precio             string
+	SuperficieCubierta *float64
+	SuperficieTotal    *float64
+	Expensas           *float64
+	CreatedAt          time.Time
+	// Momento en que se dio de baja, si hoy no está disponible
+	RemovedAt *time.Time
+}
+
+// GetMarketListings devuelve todas las propiedades con ubicación, disponibles o dadas de baja
+func (db *DB) GetMarketListings() ([]MarketListing, error) {
+	// La baja es el último cambio de status a 'unavailable'; solo cuenta si sigue dada de baja
+	query := `
+		SELECT p.id, p.ubicacion, p.tipo_propiedad, COALESCE(p.operacion, ''), COALESCE(p.precio, ''),
+			NULLIF(p.superficie_cubierta, ''), NULLIF(p.superficie_total, ''), NULLIF(p.expensas, ''),
+			p.created_at, COALESCE(p.status, ''), c.created_at
+		FROM propiedades p
+		LEFT JOIN property_changes c ON c.id = (
+			SELECT MAX(id) FROM property_changes
+			WHERE propiedad_id = p.id AND field = 'status' AND new_value = 'unavailable'
+		)
+		WHERE COALESCE(TRIM(p.ubicacion), '') != ''`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando propiedades para estadísticas: %v", err)
+	}
+	defer rows.Close()
+
+	listings := []MarketListing{}
+	for rows.Next() {
+		var l MarketListing
+		var status string
+		err := rows.Scan(&l.ID, &l.Ubicacion, &l.TipoPropiedad, &l.Operacion, &l.Precio,
+			&l.SuperficieCubierta, &l.SuperficieTotal, &l.Expensas, &l.CreatedAt, &status, &l.RemovedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando propiedad para estadísticas: %v", err)
+		}
+		if status != "unavailable" {
+			l.RemovedAt = nil
+		}
+		listings = append(listings, l)
+	}
+
+	return listings, rows.Err()
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CreateSession registra una sesión del usuario identificada por el hash de su token
+func (db *DB) CreateSession(userID int64, tokenHash string, expiresAt time.Time) error {
+	now := time.Now().UTC()
+
+	// Aprovechar para limpiar las sesiones vencidas
+	if _, err := db.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, now); err != nil {
+		return fmt.Errorf("error eliminando sesiones vencidas: %v", err)
+	}
+
+	query := `INSERT INTO sessions (user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)`
+	if _, err := db.Exec(query, userID, tokenHash, now, expiresAt.UTC()); err != nil {
+		return fmt.Errorf("error creando sesión del usuario %d: %v", userID, err)
+	}
+
+	return nil
+}
+
+// GetSessionUser devuelve el usuario de una sesión vigente. Devuelve sql.ErrNoRows
+// si la sesión no existe o venció.
+func (db *DB) GetSessionUser(tokenHash string) (*User, error) {
+	now := time.Now().UTC()
+	query := `
+		UPDATE sessions SET last_used_at = ?
+		WHERE token_hash = ? AND expires_at > ?
+		RETURNING user_id`
+
+	var userID int64
+	err := db.QueryRow(query, now, tokenHash, now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("sesión inválida: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo sesión: %v", err)
+	}
+
+	return db.GetUserByID(userID)
+}
+
+// DeleteSession cierra una sesión
+func (db *DB) DeleteSession(tokenHash string) error {
+	if _, err := db.Exec(`DELETE FROM sessions WHERE token_hash = ?`, tokenHash); err != nil {
+		return fmt.Errorf("error cerrando sesión: %v", err)
+	}
+	return nil
+}
+
+// DeleteUserSessions cierra todas las sesiones de un usuario, por ejemplo al cambiar su contraseña
+func (db *DB) DeleteUserSessions(userID int64) error {
+	if _, err := db.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("error cerrando sesiones del usuario %d: %v", userID, err)
+	}
+	return nil
+}
+
+// CreateAPIKey registra una API key identificada por el hash de la clave
+func (db *DB) CreateAPIKey(k *APIKey, keyHash string) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id, created_at`
+
+	err := db.QueryRow(query, k.UserID, k.Name, k.Prefix, keyHash, time.Now().UTC()).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando API key: %v", err)
+	}
+
+	return nil
+}
+
+// GetAPIKeyUser devuelve el usuario de una API key vigente. Devuelve sql.ErrNoRows
+// si la clave no existe o fue revocada.
+func (db *DB) GetAPIKeyUser(keyHash string) (*User, error) {
+	query := `
+		UPDATE api_keys SET last_used_at = ?
+		WHERE key_hash = ? AND revoked_at IS NULL
+		RETURNING user_id`
+
+	var userID int64
+	err := db.QueryRow(query, time.Now().UTC(), keyHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("API key inválida: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo API key: %v", err)
+	}
+
+	return db.GetUserByID(userID)
+}
+
+// GetAPIKeys devuelve las API keys de un usuario, incluidas las revocadas
+func (db *DB) GetAPIKeys(userID int64) ([]APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, created_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE user_id = ?
+		ORDER BY created_at DESC`
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando API keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			return nil, fmt.Errorf("error escaneando API key: %v", err)
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revoca una API key del usuario. Devuelve sql.ErrNoRows si no existe,
+// es de otro usuario o ya estaba revocada.
+func (db *DB) RevokeAPIKey(userID, id int64) error {
+	query := `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`
+
+	result, err := db.Exec(query, time.Now().UTC(), id, userID)
+	if err != nil {
+		return fmt.Errorf("error revocando API key %d: %v", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("error revocando API key %d: %w", id, sql.ErrNoRows)
+	}
+
+	return nil
+}
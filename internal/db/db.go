@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -21,6 +22,15 @@ type DB struct {
 	*sql.DB
 }
 
+// formatoSQLite es el formato de CURRENT_TIMESTAMP
+const formatoSQLite = "2006-01-02 15:04:05"
+
+// sqliteTime formatea t en UTC como CURRENT_TIMESTAMP, para que las fechas escritas desde Go
+// y las escritas por SQLite se puedan comparar como texto
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(formatoSQLite)
+}
+
 func New(dbPath string) (*DB, error) {
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
@@ -45,12 +55,12 @@ func New(dbPath string) (*DB, error) {
 // CreateInmobiliaria inserta una nueva inmobiliaria en la base de datos
 func (db *DB) CreateInmobiliaria(i *Inmobiliaria) error {
 	query := `
-		INSERT INTO inmobiliarias (nombre, url, sistema, zona, rating, direccion, telefono)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO inmobiliarias (nombre, url, sistema, zona, rating, direccion, telefono, disabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		RETURNING id, created_at, updated_at`
 
 	return db.QueryRow(query,
-		i.Nombre, i.URL, i.Sistema, i.Zona, i.Rating, i.Direccion, i.Telefono,
+		i.Nombre, i.URL, i.Sistema, i.Zona, i.Rating, i.Direccion, i.Telefono, i.Disabled,
 	).Scan(&i.ID, &i.CreatedAt, &i.UpdatedAt)
 }
 
@@ -66,45 +76,85 @@ func (db *DB) CreateBusqueda(b *Busqueda) error {
 	).Scan(&b.ID, &b.CreatedAt)
 }
 
-// CreatePropiedad inserta una nueva propiedad en la base de datos
+// CreatePropiedad inserta una nueva propiedad en la base de datos.
+// La identidad de una propiedad es (inmobiliaria_id, codigo); si el sitio no publica
+// un código se usa la URL. Si ya existe, solo se actualizan los datos del listado:
+// los detalles y el status los mantiene el proceso de actualización de propiedades.
+// Los cambios en los datos del listado quedan registrados en property_changes.
 func (db *DB) CreatePropiedad(p *Propiedad) error {
+	if p.InmobiliariaID == 0 {
+		return fmt.Errorf("la propiedad %q no tiene inmobiliaria", p.Codigo)
+	}
+
+	p.Codigo = strings.TrimSpace(p.Codigo)
+	if p.Codigo == "" {
+		p.Codigo = strings.TrimSpace(p.URL)
+	}
+	if p.Codigo == "" {
+		return fmt.Errorf("la propiedad no tiene código ni URL (inmobiliaria %d)", p.InmobiliariaID)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Datos del listado anteriores, si la propiedad ya existía
+	var anterior *Propiedad
+	var existente Propiedad
+	err = tx.QueryRow(`
+		SELECT id, COALESCE(titulo, ''), COALESCE(precio, ''), COALESCE(moneda, ''), COALESCE(direccion, ''),
+			COALESCE(url, ''), COALESCE(imagen_url, ''), COALESCE(status, '')
+		FROM propiedades
+		WHERE inmobiliaria_id = ? AND codigo = ?`, p.InmobiliariaID, p.Codigo,
+	).Scan(&existente.ID, &existente.Titulo, &existente.Precio, &existente.Moneda, &existente.Direccion,
+		&existente.URL, &existente.ImagenURL, &existente.Status)
+	if err == nil {
+		anterior = &existente
+	} else if err != sql.ErrNoRows {
+		return fmt.Errorf("error consultando propiedad %q: %v", p.Codigo, err)
+	}
+
 	query := `
         INSERT INTO propiedades (
             inmobiliaria_id, codigo, titulo, precio, moneda, direccion, url, imagen_url,
-            tipo_propiedad, ubicacion, dormitorios, banios, antiguedad, 
+            tipo_propiedad, ubicacion, dormitorios, banios, antiguedad,
             superficie_cubierta, superficie_total, frente, fondo, ambientes,
             expensas, descripcion, status
         )
         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-        ON CONFLICT(codigo) DO UPDATE SET
+        ON CONFLICT(inmobiliaria_id, codigo) DO UPDATE SET
             titulo = excluded.titulo,
             precio = excluded.precio,
             moneda = excluded.moneda,
             direccion = excluded.direccion,
             url = excluded.url,
             imagen_url = excluded.imagen_url,
-            tipo_propiedad = excluded.tipo_propiedad,
-            ubicacion = excluded.ubicacion,
-            dormitorios = excluded.dormitorios,
-            banios = excluded.banios,
-            antiguedad = excluded.antiguedad,
-            superficie_cubierta = excluded.superficie_cubierta,
-            superficie_total = excluded.superficie_total,
-            frente = excluded.frente,
-            fondo = excluded.fondo,
-            ambientes = excluded.ambientes,
-            expensas = excluded.expensas,
-            descripcion = excluded.descripcion,
-            status = excluded.status,
+            -- Un aviso dado de baja que vuelve a aparecer en el listado se vuelve a procesar
+            status = CASE WHEN propiedades.status = 'unavailable' THEN 'pending' ELSE propiedades.status END,
             updated_at = CURRENT_TIMESTAMP
-        RETURNING id, created_at, updated_at`
+        RETURNING id, status, created_at, updated_at`
 
-	return db.QueryRow(query,
+	actual := *p
+	err = tx.QueryRow(query,
 		p.InmobiliariaID, p.Codigo, p.Titulo, p.Precio, p.Moneda, p.Direccion, p.URL, p.ImagenURL,
 		p.TipoPropiedad, p.Ubicacion, p.Dormitorios, p.Banios, p.Antiguedad,
 		p.SuperficieCubierta, p.SuperficieTotal, p.Frente, p.Fondo, p.Ambientes,
 		p.Expensas, p.Descripcion, p.Status,
-	).Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt)
+	).Scan(&p.ID, &actual.Status, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if anterior != nil {
+		cambios := compararCampos(p.ID, camposListado(anterior), camposListado(&actual))
+		if err := insertPropertyChanges(tx, cambios); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // LinkBusquedaPropiedad vincula una búsqueda con una propiedad
@@ -121,9 +171,10 @@ func (db *DB) LinkBusquedaPropiedad(busquedaID, propiedadID int64) error {
 // GetInmobiliariasSinSistema retorna las inmobiliarias que no tienen sistema identificado
 func (db *DB) GetInmobiliariasSinSistema() ([]Inmobiliaria, error) {
 	query := `
-		SELECT id, nombre, url, sistema, zona, rating, direccion, telefono, created_at, updated_at
+		SELECT id, nombre, url, sistema, zona, rating, direccion, telefono, disabled, created_at, updated_at
 		FROM inmobiliarias
-		WHERE sistema IS NULL OR sistema = '' or sistema = 'No identificado'`
+		WHERE (sistema IS NULL OR sistema = '' or sistema = 'No identificado')
+		AND NOT disabled`
 
 	rows, err := db.Query(query)
 	if err != nil {
@@ -136,7 +187,7 @@ func (db *DB) GetInmobiliariasSinSistema() ([]Inmobiliaria, error) {
 		var i Inmobiliaria
 		err := rows.Scan(
 			&i.ID, &i.Nombre, &i.URL, &i.Sistema, &i.Zona, &i.Rating,
-			&i.Direccion, &i.Telefono, &i.CreatedAt, &i.UpdatedAt,
+			&i.Direccion, &i.Telefono, &i.Disabled, &i.CreatedAt, &i.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -174,84 +225,15 @@ func normalizarTexto(texto string) string {
 	return replacer.Replace(texto)
 }
 
-// ExistsInmobiliaria verifica si ya existe una inmobiliaria similar
-func (db *DB) ExistsInmobiliaria(nombre, direccion string) (bool, error) {
-	var exists bool
-
-	nombreNormalizado := normalizarTexto(nombre)
-	direccionNormalizada := normalizarTexto(direccion)
-
-	query := `
-		WITH normalized_data AS (
-			SELECT 
-				id,
-				LOWER(
-					REPLACE(
-						REPLACE(
-							REPLACE(
-								REPLACE(
-									REPLACE(
-										REPLACE(nombre, ' ', ''),
-										'.', ''
-									),
-									',', ''
-								),
-								'-', ''
-							),
-							'&', ''
-						),
-						'''', ''
-					)
-				) as nombre_norm,
-				LOWER(
-					REPLACE(
-						REPLACE(
-							REPLACE(
-								REPLACE(
-									REPLACE(
-										REPLACE(direccion, ' ', ''),
-										'.', ''
-									),
-									',', ''
-								),
-								'-', ''
-							),
-							'&', ''
-						),
-						'''', ''
-					)
-				) as direccion_norm
-			FROM inmobiliarias
-		)
-		SELECT EXISTS(
-			SELECT 1 FROM normalized_data 
-			WHERE 
-				nombre_norm LIKE ? 
-				OR (
-					direccion_norm != ''
-					AND direccion_norm LIKE ?
-				)
-		)`
-
-	// Agregar comodines para búsqueda más flexible
-	nombreBusqueda := "%" + nombreNormalizado + "%"
-	direccionBusqueda := "%" + direccionNormalizada + "%"
-	err := db.QueryRow(query, nombreBusqueda, direccionBusqueda).Scan(&exists)
-	if err != nil {
-		return false, err
-	}
-
-	return exists, nil
-}
-
 // GetInmobiliariasSistema retorna las inmobiliarias que tienen sistema identificado
 func (db *DB) GetInmobiliariasSistema() ([]Inmobiliaria, error) {
 	query := `
-		SELECT id, nombre, url, sistema, zona, rating, direccion, telefono, created_at, updated_at
+		SELECT id, nombre, url, sistema, zona, rating, direccion, telefono, disabled, created_at, updated_at
 		FROM inmobiliarias
 		WHERE sistema IS NOT NULL 
 		AND sistema != '' 
 		AND sistema != 'No identificado'
+		AND NOT disabled
 		ORDER BY nombre`
 
 	rows, err := db.Query(query)
@@ -265,7 +247,7 @@ func (db *DB) GetInmobiliariasSistema() ([]Inmobiliaria, error) {
 		var i Inmobiliaria
 		err := rows.Scan(
 			&i.ID, &i.Nombre, &i.URL, &i.Sistema, &i.Zona, &i.Rating,
-			&i.Direccion, &i.Telefono, &i.CreatedAt, &i.UpdatedAt,
+			&i.Direccion, &i.Telefono, &i.Disabled, &i.CreatedAt, &i.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -362,7 +344,7 @@ func (db *DB) GetPropiedadesSinDetalles() ([]Propiedad, error) {
 			situacion,
 			NULLIF(expensas, '') as expensas,
 			descripcion, status, operacion, condicion, orientacion, disposicion,
-			latitud, longitud
+			latitud, longitud, error_category
 		FROM propiedades
 		WHERE status = 'pending'
 		ORDER BY created_at DESC`
@@ -376,13 +358,175 @@ func (db *DB) GetPropiedadesSinDetalles() ([]Propiedad, error) {
 	return scanPropiedades(rows)
 }
 
-// UpdatePropiedadDetalles actualiza solo los campos de detalles de una propiedad
+// GetPropiedadByID retorna una propiedad por su ID
+func (db *DB) GetPropiedadByID(id int64) (*Propiedad, error) {
+	query := `
+		SELECT 
+			id, inmobiliaria_id, codigo, titulo, precio, direccion, url, imagen_url,
+			NULLIF(imagenes, '') as imagenes,
+			created_at, updated_at,
+			tipo_propiedad, ubicacion, 
+			NULLIF(dormitorios, '') as dormitorios,
+			NULLIF(banios, '') as banios,
+			antiguedad,
+			NULLIF(superficie_cubierta, '') as superficie_cubierta,
+			NULLIF(superficie_total, '') as superficie_total,
+			NULLIF(superficie_terreno, '') as superficie_terreno,
+			NULLIF(frente, '') as frente,
+			NULLIF(fondo, '') as fondo,
+			NULLIF(ambientes, '') as ambientes,
+			NULLIF(plantas, '') as plantas,
+			NULLIF(cocheras, '') as cocheras,
+			situacion,
+			NULLIF(expensas, '') as expensas,
+			descripcion, status, operacion, condicion, orientacion, disposicion,
+			latitud, longitud, error_category
+		FROM propiedades
+		WHERE id = ?`
+
+	rows, err := db.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando propiedad %d: %v", id, err)
+	}
+	defer rows.Close()
+
+	propiedades, err := scanPropiedades(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(propiedades) == 0 {
+		return nil, fmt.Errorf("error obteniendo propiedad %d: %w", id, sql.ErrNoRows)
+	}
+
+	return &propiedades[0], nil
+}
+
+// SetPropiedadErrorCategory registra la categoría del último error al extraer los detalles de una propiedad
+func (db *DB) SetPropiedadErrorCategory(id int64, category string) error {
+	query := `UPDATE propiedades SET error_category = NULLIF(?, '') WHERE id = ?`
+
+	if _, err := db.Exec(query, category, id); err != nil {
+		return fmt.Errorf("error registrando categoría de error de la propiedad %d: %v", id, err)
+	}
+
+	return nil
+}
+
+// MarkPropiedadUnavailable marca una propiedad como dada de baja en el sitio.
+// Deja de procesarse hasta que vuelva a aparecer en el listado.
+func (db *DB) MarkPropiedadUnavailable(id int64, category string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow(`SELECT COALESCE(status, '') FROM propiedades WHERE id = ?`, id).Scan(&status); err != nil {
+		return fmt.Errorf("error consultando la propiedad %d: %w", id, err)
+	}
+
+	query := `
+		UPDATE propiedades
+		SET status = 'unavailable', error_category = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	if _, err := tx.Exec(query, category, id); err != nil {
+		return fmt.Errorf("error marcando la propiedad %d como no disponible: %v", id, err)
+	}
+
+	cambios := compararCampos(id, []campoDetalle{{"status", status}}, []campoDetalle{{"status", "unavailable"}})
+	if err := insertPropertyChanges(tx, cambios); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MarkPropiedadesAusentes marca como dadas de baja las propiedades de la inmobiliaria que no
+// aparecieron entre los códigos de un recorrido completo de su listado, y devuelve cuántas marcó.
+// Si un código vuelve a aparecer, CreatePropiedad lo pasa a pending y registra el cambio de status.
+// Sin códigos no marca nada: un listado vacío suele ser un scraper roto, no una inmobiliaria sin avisos.
+func (db *DB) MarkPropiedadesAusentes(inmobiliariaID int64, codigos []string) (int, error) {
+	if len(codigos) == 0 {
+		return 0, nil
+	}
+
+	vistos := make(map[string]bool, len(codigos))
+	for _, codigo := range codigos {
+		vistos[codigo] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, codigo, COALESCE(status, '')
+		FROM propiedades
+		WHERE inmobiliaria_id = ? AND COALESCE(status, '') != 'unavailable'`, inmobiliariaID)
+	if err != nil {
+		return 0, fmt.Errorf("error consultando propiedades de la inmobiliaria %d: %v", inmobiliariaID, err)
+	}
+
+	var cambios []PropertyChange
+	for rows.Next() {
+		var id int64
+		var codigo, status string
+		if err := rows.Scan(&id, &codigo, &status); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error escaneando propiedad: %v", err)
+		}
+		if !vistos[codigo] {
+			cambios = append(cambios, compararCampos(id, []campoDetalle{{"status", status}}, []campoDetalle{{"status", "unavailable"}})...)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, cambio := range cambios {
+		query := `UPDATE propiedades SET status = 'unavailable', updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+		if _, err := tx.Exec(query, cambio.PropertyID); err != nil {
+			return 0, fmt.Errorf("error marcando la propiedad %d como no disponible: %v", cambio.PropertyID, err)
+		}
+	}
+	if err := insertPropertyChanges(tx, cambios); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(cambios), nil
+}
+
+// UpdatePropiedadDetalles actualiza solo los campos de detalles de una propiedad.
+// Si la propiedad ya tenía detalles, registra qué campos cambiaron en property_changes.
 func (db *DB) UpdatePropiedadDetalles(p *Propiedad) error {
+	return db.actualizarDetalles(p, false)
+}
+
+// ReparsePropiedadDetalles actualiza los campos de detalles con los extraídos de nuevo de una ficha guardada.
+// No registra cambios ni la fecha de extracción: las diferencias vienen del extractor, no del aviso.
+func (db *DB) ReparsePropiedadDetalles(p *Propiedad) error {
+	return db.actualizarDetalles(p, true)
+}
+
+// actualizarDetalles guarda los detalles de una propiedad; reparse indica que vienen de una ficha guardada
+func (db *DB) actualizarDetalles(p *Propiedad, reparse bool) error {
 	fmt.Printf("Actualizando detalles de propiedad ID: %d\n", p.ID)
 
+	anterior, err := db.GetPropiedadByID(p.ID)
+	if err != nil {
+		return err
+	}
+
 	// Convertir el slice de imágenes a JSON
 	var imagenesJSON []byte
-	var err error
 	if p.Imagenes != nil {
 		imagenesJSON, err = json.Marshal(*p.Imagenes)
 		if err != nil {
@@ -395,12 +539,7 @@ func (db *DB) UpdatePropiedadDetalles(p *Propiedad) error {
 	if err != nil {
 		return fmt.Errorf("error iniciando transacción: %v", err)
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-			return
-		}
-	}()
+	defer tx.Rollback()
 
 	query := `
 		UPDATE propiedades 
@@ -429,11 +568,19 @@ func (db *DB) UpdatePropiedadDetalles(p *Propiedad) error {
 			disposicion = ?,
 			latitud = ?,
 			longitud = ?,
+			%s
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 		RETURNING created_at, updated_at`
 
-	err = tx.QueryRow(query,
+	// Una extracción nueva reemplaza el error de la anterior y marca la fecha de los detalles
+	extraccion := `error_category = NULL, details_updated_at = ?,`
+	if reparse {
+		extraccion = ""
+	}
+	query = fmt.Sprintf(query, extraccion)
+
+	args := []interface{}{
 		p.TipoPropiedad,
 		string(imagenesJSON), // Convertimos el JSON a string
 		p.Ubicacion,
@@ -458,8 +605,13 @@ func (db *DB) UpdatePropiedadDetalles(p *Propiedad) error {
 		p.Disposicion,
 		p.Latitud,
 		p.Longitud,
-		p.ID,
-	).Scan(&p.CreatedAt, &p.UpdatedAt)
+	}
+	if !reparse {
+		args = append(args, time.Now().UTC())
+	}
+	args = append(args, p.ID)
+
+	err = tx.QueryRow(query, args...).Scan(&p.CreatedAt, &p.UpdatedAt)
 
 	if err != nil {
 		return fmt.Errorf("error actualizando detalles de propiedad %d: %v", p.ID, err)
@@ -472,6 +624,17 @@ func (db *DB) UpdatePropiedadDetalles(p *Propiedad) error {
 		}
 	}
 
+	// La primera extracción completa campos vacíos: solo se registran los cambios de las siguientes
+	if anterior.Status == "completed" && !reparse {
+		cambios := compararCampos(p.ID, camposDetalles(anterior), camposDetalles(p))
+		if len(cambios) > 0 {
+			fmt.Printf("Campos modificados en propiedad %d: %d\n", p.ID, len(cambios))
+		}
+		if err := insertPropertyChanges(tx, cambios); err != nil {
+			return err
+		}
+	}
+
 	// Confirmar la transacción
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("error confirmando transacción: %v", err)
@@ -604,24 +767,24 @@ func (db *DB) SavePropertyFeatures(propertyID int64, features map[string][]strin
 
 func (db *DB) GetInmobiliariaByID(id int64) (*Inmobiliaria, error) {
 	query := `
-		SELECT id, nombre, url, sistema, zona, rating, direccion, telefono, created_at, updated_at
+		SELECT id, nombre, url, sistema, zona, rating, direccion, telefono, disabled, created_at, updated_at
 		FROM inmobiliarias
 		WHERE id = ?`
 
 	var i Inmobiliaria
 	err := db.QueryRow(query, id).Scan(
 		&i.ID, &i.Nombre, &i.URL, &i.Sistema, &i.Zona, &i.Rating,
-		&i.Direccion, &i.Telefono, &i.CreatedAt, &i.UpdatedAt,
+		&i.Direccion, &i.Telefono, &i.Disabled, &i.CreatedAt, &i.UpdatedAt,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("error obteniendo inmobiliaria %d: %v", id, err)
+		return nil, fmt.Errorf("error obteniendo inmobiliaria %d: %w", id, err)
 	}
 
 	return &i, nil
 }
 
-// GetUnratedProperties retorna las propiedades sin calificar
-func (db *DB) GetUnratedProperties(filter *PropertyFilter) ([]Propiedad, error) {
+// GetUnratedProperties retorna las propiedades que el usuario no calificó
+func (db *DB) GetUnratedProperties(userID int64, filter *PropertyFilter) ([]Propiedad, error) {
 	baseQuery := `
 		SELECT 
 			p.id, p.inmobiliaria_id, p.codigo, p.titulo, p.precio, p.direccion, 
@@ -630,15 +793,17 @@ func (db *DB) GetUnratedProperties(filter *PropertyFilter) ([]Propiedad, error)
 			p.superficie_cubierta, p.superficie_total, p.superficie_terreno,
 			p.frente, p.fondo, p.ambientes, p.plantas, p.cocheras,
 			p.situacion, p.expensas, p.descripcion, p.status, p.operacion,
-			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud
+			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud, p.error_category
 		FROM propiedades p
 		WHERE NOT EXISTS (
 			SELECT 1 
 			FROM property_ratings r 
-			WHERE r.property_id = p.id
+			WHERE r.property_id = p.id AND r.user_id = ?
 		)`
 
+	filter.UserID = userID
 	whereConditions, args := buildFilterConditions(filter)
+	args = append([]interface{}{userID}, args...)
 
 	// Agregar condiciones WHERE si existen
 	if len(whereConditions) > 0 {
@@ -657,8 +822,8 @@ func (db *DB) GetUnratedProperties(filter *PropertyFilter) ([]Propiedad, error)
 	return propiedades, err
 }
 
-// GetLikedProperties retorna las propiedades que tienen like
-func (db *DB) GetLikedProperties(filter *PropertyFilter) ([]Propiedad, error) {
+// GetLikedProperties retorna las propiedades a las que el usuario dio like
+func (db *DB) GetLikedProperties(userID int64, filter *PropertyFilter) ([]Propiedad, error) {
 	baseQuery := `
 		SELECT 
 			p.id, p.inmobiliaria_id, p.codigo, p.titulo, p.precio, p.direccion, 
@@ -667,19 +832,21 @@ func (db *DB) GetLikedProperties(filter *PropertyFilter) ([]Propiedad, error) {
 			p.superficie_cubierta, p.superficie_total, p.superficie_terreno,
 			p.frente, p.fondo, p.ambientes, p.plantas, p.cocheras,
 			p.situacion, p.expensas, p.descripcion, p.status, p.operacion,
-			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud
+			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud, p.error_category
 		FROM propiedades p
-		INNER JOIN property_ratings r ON r.property_id = p.id
+		INNER JOIN property_ratings r ON r.property_id = p.id AND r.user_id = ?
 		WHERE r.rating = 'like'`
 
+	filter.UserID = userID
 	whereConditions, args := buildFilterConditions(filter)
+	args = append([]interface{}{userID}, args...)
 
 	// Agregar condiciones WHERE si existen
 	if len(whereConditions) > 0 {
 		baseQuery += " AND " + strings.Join(whereConditions, " AND ")
 	}
 
-	baseQuery += " ORDER BY r.created_at DESC"
+	baseQuery += " ORDER BY r.updated_at DESC"
 
 	rows, err := db.Query(baseQuery, args...)
 	if err != nil {
@@ -690,6 +857,41 @@ func (db *DB) GetLikedProperties(filter *PropertyFilter) ([]Propiedad, error) {
 	return scanPropiedades(rows)
 }
 
+// GetDislikedProperties retorna las propiedades que el usuario descartó con dislike
+func (db *DB) GetDislikedProperties(userID int64, filter *PropertyFilter) ([]Propiedad, error) {
+	baseQuery := `
+		SELECT 
+			p.id, p.inmobiliaria_id, p.codigo, p.titulo, p.precio, p.direccion, 
+			p.url, p.imagen_url, p.imagenes, p.created_at, p.updated_at,
+			p.tipo_propiedad, p.ubicacion, p.dormitorios, p.banios, p.antiguedad,
+			p.superficie_cubierta, p.superficie_total, p.superficie_terreno,
+			p.frente, p.fondo, p.ambientes, p.plantas, p.cocheras,
+			p.situacion, p.expensas, p.descripcion, p.status, p.operacion,
+			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud, p.error_category
+		FROM propiedades p
+		INNER JOIN property_ratings r ON r.property_id = p.id AND r.user_id = ?
+		WHERE r.rating = 'dislike'`
+
+	filter.UserID = userID
+	whereConditions, args := buildFilterConditions(filter)
+	args = append([]interface{}{userID}, args...)
+
+	// Agregar condiciones WHERE si existen
+	if len(whereConditions) > 0 {
+		baseQuery += " AND " + strings.Join(whereConditions, " AND ")
+	}
+
+	baseQuery += " ORDER BY r.updated_at DESC"
+
+	rows, err := db.Query(baseQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando propiedades con dislike: %v", err)
+	}
+	defer rows.Close()
+
+	return scanPropiedades(rows)
+}
+
 // Helper para escanear propiedades desde filas de resultados
 func scanPropiedades(rows *sql.Rows) ([]Propiedad, error) {
 	var propiedades []Propiedad
@@ -707,7 +909,7 @@ func scanPropiedades(rows *sql.Rows) ([]Propiedad, error) {
 			&p.SuperficieCubierta, &p.SuperficieTotal, &p.SuperficieTerreno,
 			&p.Frente, &p.Fondo, &p.Ambientes, &p.Plantas, &p.Cocheras,
 			&p.Situacion, &p.Expensas, &p.Descripcion, &p.Status, &p.Operacion,
-			&p.Condicion, &p.Orientacion, &p.Disposicion, &p.Latitud, &p.Longitud,
+			&p.Condicion, &p.Orientacion, &p.Disposicion, &p.Latitud, &p.Longitud, &p.ErrorCategory,
 		)
 
 		if err != nil {
@@ -739,6 +941,33 @@ func scanPropiedades(rows *sql.Rows) ([]Propiedad, error) {
 	return propiedades, nil
 }
 
+// ParsePrecio interpreta el precio publicado ("USD 120.000", "$ 350.000") con el mismo
+// criterio que el filtro de precios: USD si lo indica el texto, ARS si no
+func ParsePrecio(precio string) (valor float64, moneda string, ok bool) {
+	texto := strings.ToUpper(precio)
+	if idx := strings.Index(texto, "\n"); idx != -1 {
+		texto = texto[:idx]
+	}
+
+	moneda = "ARS"
+	if strings.Contains(texto, "USD") || strings.Contains(texto, "U$S") {
+		moneda = "USD"
+	}
+
+	var digitos strings.Builder
+	for _, r := range texto {
+		if r >= '0' && r <= '9' {
+			digitos.WriteRune(r)
+		}
+	}
+
+	valor, err := strconv.ParseFloat(digitos.String(), 64)
+	if err != nil || valor <= 0 {
+		return 0, "", false
+	}
+	return valor, moneda, true
+}
+
 // buildFilterConditions construye las condiciones WHERE y los argumentos para los filtros
 func buildFilterConditions(filter *PropertyFilter) ([]string, []interface{}) {
 	if filter == nil {
@@ -791,7 +1020,7 @@ func buildFilterConditions(filter *PropertyFilter) ([]string, []interface{}) {
 		}
 
 		// Extraer el valor numérico del precio
-		priceExtract := `CAST(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(p.precio, 'USD', ''), 'U$S', ''), '$', ''), '.', ''), ',', '') AS NUMERIC)`
+		priceExtract := precioSQL("p.precio")
 
 		if filter.PriceMin != nil {
 			conditions = append(conditions, fmt.Sprintf("%s >= ?", priceExtract))
@@ -942,18 +1171,88 @@ func buildFilterConditions(filter *PropertyFilter) ([]string, []interface{}) {
 		conditions = append(conditions, featureCondition)
 	}
 
-	// Filtro para mostrar solo propiedades con notas
+	// Filtro para mostrar solo propiedades con notas del usuario
 	if filter.ShowOnlyWithNotes {
 		conditions = append(conditions, `EXISTS (
-			SELECT 1 FROM property_notes n WHERE n.property_id = p.id
+			SELECT 1 FROM property_notes n WHERE n.property_id = p.id AND n.user_id = ?
 		)`)
+		args = append(args, filterUserID(filter))
 	}
 
-	// Filtro para mostrar solo propiedades favoritas
+	// Filtro para mostrar solo propiedades favoritas del usuario
 	if filter.ShowOnlyFavorites {
 		conditions = append(conditions, `EXISTS (
-			SELECT 1 FROM property_ratings r WHERE r.property_id = p.id AND r.is_favorite = 1
+			SELECT 1 FROM property_ratings r WHERE r.property_id = p.id AND r.user_id = ? AND r.is_favorite = 1
+		)`)
+		args = append(args, filterUserID(filter))
+	}
+
+	// Filtro por motivos de la calificación del usuario (cualquiera de ellos)
+	if len(filter.Reasons) > 0 {
+		placeholders := make([]string, len(filter.Reasons))
+		args = append(args, filterUserID(filter))
+		for i, reason := range filter.Reasons {
+			placeholders[i] = "?"
+			args = append(args, reason)
+		}
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM property_ratings rt
+			JOIN property_rating_reasons rr ON rr.rating_id = rt.id
+			JOIN rating_reasons rs ON rs.id = rr.reason_id
+			WHERE rt.property_id = p.id AND rt.user_id = ? AND rs.code IN (%s)
+		)`, strings.Join(placeholders, ",")))
+	}
+
+	// Filtro por respuestas de la lista de visita del usuario (todas deben coincidir)
+	if len(filter.Checklist) > 0 {
+		items := make([]string, 0, len(filter.Checklist))
+		for item := range filter.Checklist {
+			items = append(items, item)
+		}
+		sort.Strings(items)
+
+		for _, item := range items {
+			conditions = append(conditions, `EXISTS (
+				SELECT 1 FROM property_checklist pc
+				JOIN checklist_items ci ON ci.id = pc.item_id
+				WHERE pc.property_id = p.id AND pc.user_id = ? AND ci.code = ? AND pc.answer = ?
+			)`)
+			args = append(args, filterUserID(filter), item, filter.Checklist[item])
+		}
+	}
+
+	// Filtros por bajas de precio, días publicada y republicación
+	if filter.PriceDropDays != nil {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM property_changes c
+			WHERE c.propiedad_id = p.id AND c.field = 'precio' AND `+bajaDePrecioSQL+` AND c.created_at >= datetime('now', ?)
 		)`)
+		args = append(args, fmt.Sprintf("-%d days", *filter.PriceDropDays))
+	}
+	if filter.MinPriceDrops != nil {
+		conditions = append(conditions, `(
+			SELECT COUNT(*) FROM property_changes c
+			WHERE c.propiedad_id = p.id AND c.field = 'precio' AND `+bajaDePrecioSQL+`
+		) >= ?`)
+		args = append(args, *filter.MinPriceDrops)
+	}
+	if filter.MinDaysOnMarket != nil {
+		conditions = append(conditions, diasPublicadaSQL+" >= ?")
+		args = append(args, *filter.MinDaysOnMarket)
+	}
+	if filter.MaxDaysOnMarket != nil {
+		conditions = append(conditions, diasPublicadaSQL+" < ?")
+		args = append(args, *filter.MaxDaysOnMarket+1)
+	}
+	if filter.Relisted != nil {
+		relisted := `EXISTS (
+			SELECT 1 FROM property_changes c
+			WHERE c.propiedad_id = p.id AND c.field = 'status' AND c.old_value = 'unavailable'
+		)`
+		if !*filter.Relisted {
+			relisted = "NOT " + relisted
+		}
+		conditions = append(conditions, relisted)
 	}
 
 	// Filtro por disposición
@@ -1019,72 +1318,103 @@ func buildFilterConditions(filter *PropertyFilter) ([]string, []interface{}) {
 	return conditions, args
 }
 
-// RateProperty califica una propiedad como like o dislike
-func (db *DB) RateProperty(propertyID int64, rating string) error {
-	if rating != "like" && rating != "dislike" {
-		return fmt.Errorf("rating inválido: %s", rating)
+// filterUserID devuelve el usuario del filtro, o el usuario por defecto si no tiene
+func filterUserID(filter *PropertyFilter) int64 {
+	if filter.UserID == 0 {
+		return DefaultUserID
+	}
+	return filter.UserID
+}
+
+// RateProperty califica una propiedad para rating.UserID con like o dislike, opcionalmente con
+// estrellas (solo los like) y motivos de rating_reasons. Volver a calificar reemplaza la
+// calificación vigente, conserva su fecha original y agrega una entrada al historial.
+func (db *DB) RateProperty(rating *PropertyRating) error {
+	if rating.UserID == 0 {
+		rating.UserID = DefaultUserID
+	}
+	if rating.Rating != "like" && rating.Rating != "dislike" {
+		return fmt.Errorf("%w: rating desconocido: %s", ErrInvalidRating, rating.Rating)
+	}
+	if rating.Score != nil {
+		if rating.Rating != "like" {
+			return fmt.Errorf("%w: solo se pueden dar estrellas a una propiedad con like", ErrInvalidRating)
+		}
+		if *rating.Score < 1 || *rating.Score > 5 {
+			return fmt.Errorf("%w: puntaje %d, debe ser de 1 a 5", ErrInvalidRating, *rating.Score)
+		}
 	}
 
 	// Verificar si la propiedad existe
 	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM propiedades WHERE id = ?)", propertyID).Scan(&exists)
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM propiedades WHERE id = ?)", rating.PropertyID).Scan(&exists)
 	if err != nil {
-		return fmt.Errorf("error verificando propiedad %d: %v", propertyID, err)
+		return fmt.Errorf("error verificando propiedad %d: %v", rating.PropertyID, err)
 	}
 	if !exists {
-		return fmt.Errorf("la propiedad %d no existe", propertyID)
+		return fmt.Errorf("la propiedad %d no existe: %w", rating.PropertyID, sql.ErrNoRows)
 	}
 
-	// Verificar si ya existe un rating para mantener el estado de favorito
-	var isFavorite bool
-	var hasRating bool
-	err = db.QueryRow(`
-		SELECT EXISTS(SELECT 1 FROM property_ratings WHERE property_id = ?),
-		       IFNULL((SELECT is_favorite FROM property_ratings WHERE property_id = ?), 0)
-	`, propertyID, propertyID).Scan(&hasRating, &isFavorite)
+	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("error verificando rating existente: %v", err)
+		return fmt.Errorf("error iniciando transacción: %v", err)
 	}
+	defer tx.Rollback()
 
-	// Si cambiamos de like a dislike, quitamos el favorito
-	if rating == "dislike" {
-		isFavorite = false
+	reasonIDs, codes, err := resolveRatingReasons(tx, rating.Reasons)
+	if err != nil {
+		return err
 	}
 
-	// Intentar insertar o actualizar
+	// Al pasar a dislike se quita el favorito; en otro caso se mantiene
+	now := time.Now().UTC()
 	query := `
-		INSERT INTO property_ratings (property_id, rating, is_favorite)
-		VALUES (?, ?, ?)
-		ON CONFLICT(property_id) DO UPDATE SET
+		INSERT INTO property_ratings (user_id, property_id, rating, score, is_favorite, created_at, updated_at)
+		VALUES (?, ?, ?, ?, 0, ?, ?)
+		ON CONFLICT(user_id, property_id) DO UPDATE SET
 			rating = excluded.rating,
-			is_favorite = CASE 
+			score = excluded.score,
+			is_favorite = CASE
 				WHEN excluded.rating = 'dislike' THEN 0
-				ELSE excluded.is_favorite
+				ELSE property_ratings.is_favorite
 			END,
-			created_at = CURRENT_TIMESTAMP`
+			updated_at = excluded.updated_at
+		RETURNING id, is_favorite, created_at, updated_at`
 
-	result, err := db.Exec(query, propertyID, rating, isFavorite)
+	err = tx.QueryRow(query, rating.UserID, rating.PropertyID, rating.Rating, rating.Score, now, now).
+		Scan(&rating.ID, &rating.IsFavorite, &rating.CreatedAt, &rating.UpdatedAt)
 	if err != nil {
-		return fmt.Errorf("error calificando propiedad %d: %v", propertyID, err)
+		return fmt.Errorf("error calificando propiedad %d: %v", rating.PropertyID, err)
 	}
 
-	rows, _ := result.RowsAffected()
-	fmt.Printf("Filas afectadas: %d\n", rows)
+	if _, err := tx.Exec(`DELETE FROM property_rating_reasons WHERE rating_id = ?`, rating.ID); err != nil {
+		return fmt.Errorf("error reemplazando motivos de la calificación %d: %v", rating.ID, err)
+	}
+	for _, reasonID := range reasonIDs {
+		if _, err := tx.Exec(`INSERT INTO property_rating_reasons (rating_id, reason_id) VALUES (?, ?)`, rating.ID, reasonID); err != nil {
+			return fmt.Errorf("error guardando motivo de la calificación %d: %v", rating.ID, err)
+		}
+	}
+	rating.Reasons = codes
 
-	return nil
+	if err := recordRatingEvent(tx, RatingEventRated, rating, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// GetPropertyNotes obtiene todas las notas de una propiedad
-func (db *DB) GetPropertyNotes(propertyID int64) ([]PropertyNote, error) {
+// GetPropertyNotes obtiene las notas de un usuario sobre una propiedad
+func (db *DB) GetPropertyNotes(userID, propertyID int64) ([]PropertyNote, error) {
 	query := `
-		SELECT id, property_id, note, created_at, updated_at
+		SELECT id, user_id, property_id, note, created_at, updated_at
 		FROM property_notes
-		WHERE property_id = ?
+		WHERE property_id = ? AND user_id = ?
 		ORDER BY created_at ASC
 	`
 
 	// Usar directamente el método Query del sql.DB subyacente
-	rows, err := db.DB.Query(query, propertyID)
+	rows, err := db.DB.Query(query, propertyID, userID)
 	if err != nil {
 		return nil, fmt.Errorf("error getting property notes: %w", err)
 	}
@@ -1093,7 +1423,7 @@ func (db *DB) GetPropertyNotes(propertyID int64) ([]PropertyNote, error) {
 	notes := []PropertyNote{} // Inicializar como slice vacío en lugar de nil
 	for rows.Next() {
 		var note PropertyNote
-		if err := rows.Scan(&note.ID, &note.PropertyID, &note.Text, &note.CreatedAt, &note.UpdatedAt); err != nil {
+		if err := rows.Scan(&note.ID, &note.UserID, &note.PropertyID, &note.Text, &note.CreatedAt, &note.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("error scanning property note: %w", err)
 		}
 		notes = append(notes, note)
@@ -1103,17 +1433,25 @@ func (db *DB) GetPropertyNotes(propertyID int64) ([]PropertyNote, error) {
 		return nil, fmt.Errorf("error iterating property notes: %w", err)
 	}
 
+	if err := db.loadNoteAttachments(notes); err != nil {
+		return nil, err
+	}
+
 	return notes, nil
 }
 
-// AddPropertyNote agrega una nota a una propiedad
+// AddPropertyNote agrega una nota de note.UserID a una propiedad
 func (db *DB) AddPropertyNote(note *PropertyNote) error {
+	if note.UserID == 0 {
+		note.UserID = DefaultUserID
+	}
+
 	query := `
-		INSERT INTO property_notes (property_id, note, created_at, updated_at)
-		VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		INSERT INTO property_notes (user_id, property_id, note, created_at, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
 	`
 
-	result, err := db.DB.Exec(query, note.PropertyID, note.Text)
+	result, err := db.DB.Exec(query, note.UserID, note.PropertyID, note.Text)
 	if err != nil {
 		return fmt.Errorf("error adding property note: %w", err)
 	}
@@ -1126,28 +1464,41 @@ func (db *DB) AddPropertyNote(note *PropertyNote) error {
 	note.ID = id
 	note.CreatedAt = time.Now()
 	note.UpdatedAt = time.Now()
+	note.Attachments = []NoteAttachment{}
 
 	return nil
 }
 
-// DeletePropertyNote elimina una nota de una propiedad
-func (db *DB) DeletePropertyNote(noteID int64) error {
-	query := `DELETE FROM property_notes WHERE id = ?`
+// DeletePropertyNote elimina una nota de un usuario junto con el registro de sus adjuntos.
+// Devuelve sql.ErrNoRows si la nota no existe o es de otro usuario.
+func (db *DB) DeletePropertyNote(userID, noteID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	_, err := db.DB.Exec(query, noteID)
+	result, err := tx.Exec(`DELETE FROM property_notes WHERE id = ? AND user_id = ?`, noteID, userID)
 	if err != nil {
 		return fmt.Errorf("error deleting property note: %w", err)
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("error deleting property note %d: %w", noteID, sql.ErrNoRows)
+	}
 
-	return nil
+	if _, err := tx.Exec(`DELETE FROM note_attachments WHERE note_id = ?`, noteID); err != nil {
+		return fmt.Errorf("error deleting note attachments: %w", err)
+	}
+
+	return tx.Commit()
 }
 
-// PropertyHasNotes verifica si una propiedad tiene notas
-func (db *DB) PropertyHasNotes(propertyID int64) (bool, error) {
-	query := `SELECT COUNT(*) FROM property_notes WHERE property_id = ?`
+// PropertyHasNotes verifica si el usuario tiene notas sobre una propiedad
+func (db *DB) PropertyHasNotes(userID, propertyID int64) (bool, error) {
+	query := `SELECT COUNT(*) FROM property_notes WHERE property_id = ? AND user_id = ?`
 
 	var count int
-	err := db.DB.QueryRow(query, propertyID).Scan(&count)
+	err := db.DB.QueryRow(query, propertyID, userID).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("error checking if property has notes: %w", err)
 	}
@@ -1155,8 +1506,8 @@ func (db *DB) PropertyHasNotes(propertyID int64) (bool, error) {
 	return count > 0, nil
 }
 
-// TogglePropertyFavorite marca o desmarca una propiedad como favorita
-func (db *DB) TogglePropertyFavorite(propertyID int64, isFavorite bool) error {
+// TogglePropertyFavorite marca o desmarca una propiedad como favorita de un usuario
+func (db *DB) TogglePropertyFavorite(userID, propertyID int64, isFavorite bool) error {
 	// Verificar si la propiedad existe
 	var exists bool
 	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM propiedades WHERE id = ?)", propertyID).Scan(&exists)
@@ -1169,7 +1520,7 @@ func (db *DB) TogglePropertyFavorite(propertyID int64, isFavorite bool) error {
 
 	// Verificar si la propiedad ya tiene un rating
 	var hasRating bool
-	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM property_ratings WHERE property_id = ?)", propertyID).Scan(&hasRating)
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM property_ratings WHERE user_id = ? AND property_id = ?)", userID, propertyID).Scan(&hasRating)
 	if err != nil {
 		return fmt.Errorf("error verificando rating de propiedad %d: %v", propertyID, err)
 	}
@@ -1179,9 +1530,9 @@ func (db *DB) TogglePropertyFavorite(propertyID int64, isFavorite bool) error {
 		query := `
 			UPDATE property_ratings
 			SET is_favorite = ?
-			WHERE property_id = ?`
+			WHERE user_id = ? AND property_id = ?`
 
-		_, err = db.Exec(query, isFavorite, propertyID)
+		_, err = db.Exec(query, isFavorite, userID, propertyID)
 		if err != nil {
 			return fmt.Errorf("error actualizando favorito para propiedad %d: %v", propertyID, err)
 		}
@@ -1193,8 +1544,8 @@ func (db *DB) TogglePropertyFavorite(propertyID int64, isFavorite bool) error {
 	return nil
 }
 
-// GetFavoriteProperties retorna las propiedades marcadas como favoritas
-func (db *DB) GetFavoriteProperties(filter *PropertyFilter) ([]Propiedad, error) {
+// GetFavoriteProperties retorna las propiedades que el usuario marcó como favoritas
+func (db *DB) GetFavoriteProperties(userID int64, filter *PropertyFilter) ([]Propiedad, error) {
 	baseQuery := `
 		SELECT 
 			p.id, p.inmobiliaria_id, p.codigo, p.titulo, p.precio, p.direccion, 
@@ -1203,19 +1554,21 @@ func (db *DB) GetFavoriteProperties(filter *PropertyFilter) ([]Propiedad, error)
 			p.superficie_cubierta, p.superficie_total, p.superficie_terreno,
 			p.frente, p.fondo, p.ambientes, p.plantas, p.cocheras,
 			p.situacion, p.expensas, p.descripcion, p.status, p.operacion,
-			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud
+			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud, p.error_category
 		FROM propiedades p
-		INNER JOIN property_ratings r ON r.property_id = p.id
+		INNER JOIN property_ratings r ON r.property_id = p.id AND r.user_id = ?
 		WHERE r.rating = 'like' AND r.is_favorite = 1`
 
+	filter.UserID = userID
 	whereConditions, args := buildFilterConditions(filter)
+	args = append([]interface{}{userID}, args...)
 
 	// Agregar condiciones WHERE si existen
 	if len(whereConditions) > 0 {
 		baseQuery += " AND " + strings.Join(whereConditions, " AND ")
 	}
 
-	baseQuery += " ORDER BY r.created_at DESC"
+	baseQuery += " ORDER BY r.updated_at DESC"
 
 	rows, err := db.Query(baseQuery, args...)
 	if err != nil {
@@ -1226,12 +1579,12 @@ func (db *DB) GetFavoriteProperties(filter *PropertyFilter) ([]Propiedad, error)
 	return scanPropiedades(rows)
 }
 
-// IsPropertyFavorite verifica si una propiedad está marcada como favorita
-func (db *DB) IsPropertyFavorite(propertyID int64) (bool, error) {
-	query := `SELECT is_favorite FROM property_ratings WHERE property_id = ?`
+// IsPropertyFavorite verifica si el usuario marcó una propiedad como favorita
+func (db *DB) IsPropertyFavorite(userID, propertyID int64) (bool, error) {
+	query := `SELECT is_favorite FROM property_ratings WHERE user_id = ? AND property_id = ?`
 
 	var isFavorite bool
-	err := db.QueryRow(query, propertyID).Scan(&isFavorite)
+	err := db.QueryRow(query, userID, propertyID).Scan(&isFavorite)
 	if err == sql.ErrNoRows {
 		// Si no hay registro, no es favorita
 		return false, nil
@@ -1458,7 +1811,7 @@ func createDefaultListValues(listName string, values []string) []ListValue {
 
 // GetAllAgencies obtiene todas las inmobiliarias
 func (db *DB) GetAllAgencies() ([]Inmobiliaria, error) {
-	query := `SELECT id, nombre, url, sistema, zona, rating, direccion, telefono, created_at, updated_at FROM inmobiliarias ORDER BY nombre`
+	query := `SELECT id, nombre, url, sistema, zona, rating, direccion, telefono, disabled, created_at, updated_at FROM inmobiliarias ORDER BY nombre`
 
 	rows, err := db.Query(query)
 	if err != nil {
@@ -1478,6 +1831,7 @@ func (db *DB) GetAllAgencies() ([]Inmobiliaria, error) {
 			&agency.Rating,
 			&agency.Direccion,
 			&agency.Telefono,
+			&agency.Disabled,
 			&agency.CreatedAt,
 			&agency.UpdatedAt,
 		)
@@ -0,0 +1,294 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Estados de los jobs de extracción de detalles
+const (
+	DetailJobPending = "pending"
+	DetailJobRunning = "running"
+	DetailJobDone    = "done"
+	DetailJobFailed  = "failed"
+)
+
+// Política de reintentos de los jobs de detalles
+const (
+	MaxDetailJobAttempts = 5
+	detailJobBackoffBase = 30 * time.Second
+	detailJobBackoffMax  = 30 * time.Minute
+)
+
+// DetailJob es un job de extracción de detalles de una propiedad
+type DetailJob struct {
+	ID            int64      `db:"id" json:"id"`
+	PropiedadID   int64      `db:"propiedad_id" json:"property_id"`
+	Status        string     `db:"status" json:"status"`
+	Attempts      int        `db:"attempts" json:"attempts"`
+	NextAttemptAt time.Time  `db:"next_attempt_at" json:"next_attempt_at"`
+	LeaseUntil    *time.Time `db:"lease_until" json:"lease_until,omitempty"`
+	LastError     string     `db:"last_error" json:"last_error,omitempty"`
+
+	// Propiedad a procesar, cargada al tomar el job
+	Propiedad Propiedad `db:"-" json:"-"`
+}
+
+// DetailJobBackoff devuelve la espera antes del próximo intento luego de attempts intentos fallidos
+func DetailJobBackoff(attempts int) time.Duration {
+	espera := detailJobBackoffBase
+	for i := 1; i < attempts; i++ {
+		espera *= 2
+		if espera >= detailJobBackoffMax {
+			return detailJobBackoffMax
+		}
+	}
+	return espera
+}
+
+// EnqueuePendingDetailJobs crea jobs para las propiedades pendientes de detalles.
+// Las propiedades que volvieron a quedar pendientes después de un job terminado se vuelven a encolar,
+// y los jobs fallidos se reintentan si la propiedad se volvió a ver en el listado desde el último fallo.
+func (db *DB) EnqueuePendingDetailJobs() (int, error) {
+	query := `
+		INSERT INTO detail_jobs (propiedad_id)
+		SELECT id FROM propiedades WHERE status = 'pending'
+		ON CONFLICT(propiedad_id) DO UPDATE SET
+			status = 'pending',
+			attempts = 0,
+			next_attempt_at = CURRENT_TIMESTAMP,
+			lease_until = NULL,
+			last_error = NULL,
+			priority = 0,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE detail_jobs.status = 'done'`
+
+	result, err := db.Exec(query)
+	if err != nil {
+		return 0, fmt.Errorf("error encolando propiedades pendientes: %v", err)
+	}
+
+	encoladas, _ := result.RowsAffected()
+
+	// Los jobs fallidos se reintentan cuando la propiedad vuelve a aparecer en el listado
+	result, err = db.Exec(`
+		UPDATE detail_jobs
+		SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'failed'
+		AND propiedad_id IN (
+			SELECT p.id FROM propiedades p
+			WHERE p.id = detail_jobs.propiedad_id AND p.status = 'pending' AND p.updated_at > detail_jobs.updated_at
+		)`)
+	if err != nil {
+		return 0, fmt.Errorf("error reencolando jobs fallidos: %v", err)
+	}
+
+	reencolados, _ := result.RowsAffected()
+	return int(encoladas + reencolados), nil
+}
+
+// EnqueueStaleDetailJobs encola para re-extraer hasta limit propiedades cuyos detalles tienen más de maxAge.
+// Las favoritas y las que tienen like se encolan primero y con mayor prioridad.
+func (db *DB) EnqueueStaleDetailJobs(maxAge time.Duration, limit int, inmobiliariaIDs []int64) (int, error) {
+	filtro, args := filtroInmobiliariasJob(inmobiliariaIDs)
+	args = append([]interface{}{time.Now().UTC().Add(-maxAge)}, args...)
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		INSERT INTO detail_jobs (propiedad_id, priority)
+		SELECT p.id,
+			CASE
+				WHEN EXISTS (SELECT 1 FROM property_ratings r WHERE r.property_id = p.id AND r.is_favorite = 1) THEN 2
+				WHEN EXISTS (SELECT 1 FROM property_ratings r WHERE r.property_id = p.id AND r.rating = 'like') THEN 1
+				ELSE 0
+			END AS prioridad
+		FROM propiedades p
+		LEFT JOIN inmobiliarias i ON i.id = p.inmobiliaria_id
+		WHERE p.status = 'completed'
+		AND (p.details_updated_at IS NULL OR p.details_updated_at < ?)
+		AND NOT COALESCE(i.disabled, 0)
+		AND NOT EXISTS (
+			SELECT 1 FROM detail_jobs j
+			WHERE j.propiedad_id = p.id AND j.status IN ('pending', 'running')
+		)%s
+		ORDER BY prioridad DESC, p.details_updated_at
+		LIMIT ?
+		ON CONFLICT(propiedad_id) DO UPDATE SET
+			status = 'pending',
+			attempts = 0,
+			next_attempt_at = CURRENT_TIMESTAMP,
+			lease_until = NULL,
+			last_error = NULL,
+			priority = excluded.priority,
+			updated_at = CURRENT_TIMESTAMP`, filtro)
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error encolando propiedades desactualizadas: %v", err)
+	}
+
+	encoladas, _ := result.RowsAffected()
+	return int(encoladas), nil
+}
+
+// detailJobsDisponibles arma la condición de los jobs que se pueden tomar ahora:
+// pendientes cuyo próximo intento ya llegó, o en curso con el lease vencido (proceso interrumpido).
+// Los jobs de inmobiliarias deshabilitadas no se toman.
+func detailJobsDisponibles(now time.Time, inmobiliariaIDs []int64) (string, []interface{}) {
+	condicion := `
+		((j.status = 'pending' AND j.next_attempt_at <= ?) OR (j.status = 'running' AND j.lease_until < ?))
+		AND NOT COALESCE(i.disabled, 0)`
+	args := []interface{}{now, now}
+
+	filtro, filtroArgs := filtroInmobiliariasJob(inmobiliariaIDs)
+	return condicion + filtro, append(args, filtroArgs...)
+}
+
+// filtroInmobiliariasJob restringe los jobs a las propiedades de las inmobiliarias indicadas
+func filtroInmobiliariasJob(inmobiliariaIDs []int64) (string, []interface{}) {
+	if len(inmobiliariaIDs) == 0 {
+		return "", nil
+	}
+
+	placeholders := make([]string, len(inmobiliariaIDs))
+	args := make([]interface{}, len(inmobiliariaIDs))
+	for i, id := range inmobiliariaIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return fmt.Sprintf(" AND p.inmobiliaria_id IN (%s)", strings.Join(placeholders, ",")), args
+}
+
+// LeaseDetailJobs toma hasta limit jobs disponibles y los marca en curso hasta que venza el lease
+func (db *DB) LeaseDetailJobs(limit int, lease time.Duration, inmobiliariaIDs []int64) ([]DetailJob, error) {
+	now := time.Now().UTC()
+	condicion, args := detailJobsDisponibles(now, inmobiliariaIDs)
+
+	query := fmt.Sprintf(`
+		UPDATE detail_jobs
+		SET status = 'running', attempts = attempts + 1, lease_until = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT j.id
+			FROM detail_jobs j
+			JOIN propiedades p ON p.id = j.propiedad_id
+			LEFT JOIN inmobiliarias i ON i.id = p.inmobiliaria_id
+			WHERE %s
+			-- Primero los de mayor prioridad, intercalando inmobiliarias para no concentrar los workers en un mismo sitio
+			ORDER BY j.priority DESC, ROW_NUMBER() OVER (PARTITION BY p.inmobiliaria_id, j.priority ORDER BY j.next_attempt_at, j.id), j.next_attempt_at, j.id
+			LIMIT ?
+		)
+		RETURNING id, propiedad_id, status, attempts, next_attempt_at, lease_until`, condicion)
+
+	args = append([]interface{}{now.Add(lease)}, args...)
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error tomando jobs de detalles: %v", err)
+	}
+
+	var jobs []DetailJob
+	for rows.Next() {
+		var job DetailJob
+		if err := rows.Scan(&job.ID, &job.PropiedadID, &job.Status, &job.Attempts, &job.NextAttemptAt, &job.LeaseUntil); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error escaneando job de detalles: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterando jobs de detalles: %v", err)
+	}
+
+	for i := range jobs {
+		propiedad, err := db.GetPropiedadByID(jobs[i].PropiedadID)
+		if err != nil {
+			return nil, err
+		}
+		jobs[i].Propiedad = *propiedad
+	}
+
+	return jobs, nil
+}
+
+// NextDetailJobAttempt devuelve cuándo vence el próximo reintento pendiente.
+// Devuelve false si no quedan jobs pendientes.
+func (db *DB) NextDetailJobAttempt(inmobiliariaIDs []int64) (time.Time, bool, error) {
+	filtro, args := filtroInmobiliariasJob(inmobiliariaIDs)
+	condicion := `j.status = 'pending' AND NOT COALESCE(i.disabled, 0)` + filtro
+
+	query := fmt.Sprintf(`
+		SELECT j.next_attempt_at
+		FROM detail_jobs j
+		JOIN propiedades p ON p.id = j.propiedad_id
+		LEFT JOIN inmobiliarias i ON i.id = p.inmobiliaria_id
+		WHERE %s
+		ORDER BY j.next_attempt_at
+		LIMIT 1`, condicion)
+
+	var next time.Time
+	err := db.QueryRow(query, args...).Scan(&next)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("error consultando próximo reintento: %v", err)
+	}
+
+	return next, true, nil
+}
+
+// CompleteDetailJob marca un job como terminado
+func (db *DB) CompleteDetailJob(jobID int64) error {
+	query := `
+		UPDATE detail_jobs
+		SET status = 'done', lease_until = NULL, last_error = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	if _, err := db.Exec(query, jobID); err != nil {
+		return fmt.Errorf("error completando job %d: %v", jobID, err)
+	}
+
+	return nil
+}
+
+// ReleaseDetailJob devuelve a la cola un job interrumpido sin contarlo como intento
+func (db *DB) ReleaseDetailJob(jobID int64) error {
+	query := `
+		UPDATE detail_jobs
+		SET status = 'pending', attempts = MAX(attempts - 1, 0), lease_until = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'running'`
+
+	if _, err := db.Exec(query, jobID); err != nil {
+		return fmt.Errorf("error liberando job %d: %v", jobID, err)
+	}
+
+	return nil
+}
+
+// FailDetailJob registra el fallo de un job. Si retry es true y quedan intentos,
+// el job vuelve a quedar pendiente con espera exponencial; si no, queda fallido.
+// Devuelve el estado resultante.
+func (db *DB) FailDetailJob(job *DetailJob, cause error, retry bool) (string, error) {
+	job.LastError = cause.Error()
+	job.LeaseUntil = nil
+	job.Status = DetailJobFailed
+	if retry && job.Attempts < MaxDetailJobAttempts {
+		job.Status = DetailJobPending
+		job.NextAttemptAt = time.Now().UTC().Add(DetailJobBackoff(job.Attempts))
+	}
+
+	query := `
+		UPDATE detail_jobs
+		SET status = ?, next_attempt_at = ?, lease_until = NULL, last_error = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+
+	if _, err := db.Exec(query, job.Status, job.NextAttemptAt, job.LastError, job.ID); err != nil {
+		return "", fmt.Errorf("error registrando fallo del job %d: %v", job.ID, err)
+	}
+
+	return job.Status, nil
+}
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Resultado combinado de los votos de un hogar sobre una propiedad
+const (
+	HouseholdMatch   = "match"   // Todos los miembros dieron like
+	HouseholdSplit   = "split"   // Hay likes y dislikes
+	HouseholdVetoed  = "vetoed"  // Hay dislikes y ningún like
+	HouseholdPending = "pending" // Hay likes pero faltan votos
+)
+
+// outcomeHogar calcula en SQL el resultado combinado a partir de los conteos de votos
+// (likes, dislikes) y de la cantidad de miembros (miembros). Debe coincidir con householdOutcome.
+const outcomeHogar = `
+	CASE
+		WHEN v.likes = v.miembros THEN 'match'
+		WHEN v.likes > 0 AND v.dislikes > 0 THEN 'split'
+		WHEN v.dislikes > 0 THEN 'vetoed'
+		ELSE 'pending'
+	END`
+
+// householdOutcome calcula el resultado combinado de los votos de un hogar
+func householdOutcome(miembros, likes, dislikes int) string {
+	switch {
+	case miembros > 0 && likes == miembros:
+		return HouseholdMatch
+	case likes > 0 && dislikes > 0:
+		return HouseholdSplit
+	case dislikes > 0:
+		return HouseholdVetoed
+	default:
+		return HouseholdPending
+	}
+}
+
+// ValidHouseholdOutcome indica si outcome es un resultado combinado conocido
+func ValidHouseholdOutcome(outcome string) bool {
+	switch outcome {
+	case HouseholdMatch, HouseholdSplit, HouseholdVetoed, HouseholdPending:
+		return true
+	}
+	return false
+}
+
+// CreateHousehold crea un hogar sin miembros
+func (db *DB) CreateHousehold(h *Household) error {
+	h.Name = strings.TrimSpace(h.Name)
+	if h.Name == "" {
+		return fmt.Errorf("el hogar no tiene nombre")
+	}
+
+	query := `INSERT INTO households (name) VALUES (?) RETURNING id, created_at`
+	if err := db.QueryRow(query, h.Name).Scan(&h.ID, &h.CreatedAt); err != nil {
+		return fmt.Errorf("error creando hogar %q: %v", h.Name, err)
+	}
+	h.Members = []User{}
+
+	return nil
+}
+
+// GetHouseholds devuelve los hogares con sus miembros
+func (db *DB) GetHouseholds() ([]Household, error) {
+	rows, err := db.Query(`SELECT id, name, created_at FROM households ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando hogares: %v", err)
+	}
+
+	households := []Household{}
+	for rows.Next() {
+		var h Household
+		if err := rows.Scan(&h.ID, &h.Name, &h.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error escaneando hogar: %v", err)
+		}
+		households = append(households, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterando hogares: %v", err)
+	}
+
+	for i := range households {
+		if households[i].Members, err = db.getHouseholdMembers(households[i].ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return households, nil
+}
+
+// GetHousehold devuelve un hogar con sus miembros
+func (db *DB) GetHousehold(id int64) (*Household, error) {
+	var h Household
+	err := db.QueryRow(`SELECT id, name, created_at FROM households WHERE id = ?`, id).Scan(&h.ID, &h.Name, &h.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("error obteniendo hogar %d: %w", id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo hogar %d: %v", id, err)
+	}
+
+	if h.Members, err = db.getHouseholdMembers(id); err != nil {
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+func (db *DB) getHouseholdMembers(householdID int64) ([]User, error) {
+	rows, err := db.Query(`SELECT `+columnasUsuario+` FROM users WHERE household_id = ? ORDER BY id`, householdID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando miembros del hogar %d: %v", householdID, err)
+	}
+	defer rows.Close()
+
+	members := []User{}
+	for rows.Next() {
+		var u User
+		if err := scanUser(rows, &u); err != nil {
+			return nil, fmt.Errorf("error escaneando miembro del hogar: %v", err)
+		}
+		members = append(members, u)
+	}
+
+	return members, rows.Err()
+}
+
+// DeleteHousehold elimina un hogar; sus miembros quedan sin hogar y conservan sus calificaciones
+func (db *DB) DeleteHousehold(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM households WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("error eliminando hogar %d: %v", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("error eliminando hogar %d: %w", id, sql.ErrNoRows)
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET household_id = NULL WHERE household_id = ?`, id); err != nil {
+		return fmt.Errorf("error quitando miembros del hogar %d: %v", id, err)
+	}
+
+	return tx.Commit()
+}
+
+// SetUserHousehold agrega un usuario a un hogar, o lo quita si householdID es nil.
+// Un usuario pertenece a un solo hogar: agregarlo a otro lo quita del anterior.
+func (db *DB) SetUserHousehold(userID int64, householdID *int64) error {
+	if householdID != nil {
+		if _, err := db.GetHousehold(*householdID); err != nil {
+			return err
+		}
+	}
+
+	result, err := db.Exec(`UPDATE users SET household_id = ? WHERE id = ?`, householdID, userID)
+	if err != nil {
+		return fmt.Errorf("error cambiando hogar del usuario %d: %v", userID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("error cambiando hogar del usuario %d: %w", userID, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// GetHouseholdVotes devuelve el voto de cada miembro del hogar sobre una propiedad y el resultado combinado
+func (db *DB) GetHouseholdVotes(householdID, propertyID int64) (*HouseholdVotes, error) {
+	query := `
+		SELECT u.id, u.name, COALESCE(r.rating, '')
+		FROM users u
+		LEFT JOIN property_ratings r ON r.user_id = u.id AND r.property_id = ?
+		WHERE u.household_id = ?
+		ORDER BY u.id`
+
+	rows, err := db.Query(query, propertyID, householdID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando votos del hogar %d: %v", householdID, err)
+	}
+	defer rows.Close()
+
+	votes := &HouseholdVotes{PropertyID: propertyID, Votes: []HouseholdVote{}}
+	for rows.Next() {
+		var v HouseholdVote
+		if err := rows.Scan(&v.UserID, &v.UserName, &v.Rating); err != nil {
+			return nil, fmt.Errorf("error escaneando voto del hogar: %v", err)
+		}
+
+		switch v.Rating {
+		case "like":
+			votes.Likes++
+		case "dislike":
+			votes.Dislikes++
+		default:
+			votes.Pending++
+		}
+		votes.Votes = append(votes.Votes, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterando votos del hogar: %v", err)
+	}
+
+	votes.Outcome = householdOutcome(len(votes.Votes), votes.Likes, votes.Dislikes)
+	return votes, nil
+}
+
+// GetHouseholdProperties devuelve las propiedades que votó al menos un miembro del hogar
+// con el resultado combinado outcome (vacío para cualquiera), de la votada más recientemente a la más antigua
+func (db *DB) GetHouseholdProperties(householdID int64, outcome string, filter *PropertyFilter) ([]Propiedad, error) {
+	baseQuery := `
+		WITH v AS (
+			SELECT r.property_id,
+				SUM(r.rating = 'like') AS likes,
+				SUM(r.rating = 'dislike') AS dislikes,
+				MAX(r.updated_at) AS ultimo_voto,
+				(SELECT COUNT(*) FROM users WHERE household_id = ?) AS miembros
+			FROM property_ratings r
+			JOIN users u ON u.id = r.user_id
+			WHERE u.household_id = ?
+			GROUP BY r.property_id
+		)
+		SELECT
+			p.id, p.inmobiliaria_id, p.codigo, p.titulo, p.precio, p.direccion,
+			p.url, p.imagen_url, p.imagenes, p.created_at, p.updated_at,
+			p.tipo_propiedad, p.ubicacion, p.dormitorios, p.banios, p.antiguedad,
+			p.superficie_cubierta, p.superficie_total, p.superficie_terreno,
+			p.frente, p.fondo, p.ambientes, p.plantas, p.cocheras,
+			p.situacion, p.expensas, p.descripcion, p.status, p.operacion,
+			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud, p.error_category
+		FROM propiedades p
+		INNER JOIN v ON v.property_id = p.id
+		WHERE 1 = 1`
+
+	args := []interface{}{householdID, householdID}
+	if outcome != "" {
+		baseQuery += " AND " + outcomeHogar + " = ?"
+		args = append(args, outcome)
+	}
+
+	whereConditions, filterArgs := buildFilterConditions(filter)
+	if len(whereConditions) > 0 {
+		baseQuery += " AND " + strings.Join(whereConditions, " AND ")
+		args = append(args, filterArgs...)
+	}
+
+	baseQuery += " ORDER BY v.ultimo_voto DESC"
+
+	rows, err := db.Query(baseQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando propiedades del hogar %d: %v", householdID, err)
+	}
+	defer rows.Close()
+
+	return scanPropiedades(rows)
+}
@@ -0,0 +1,294 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Estados de la descarga de una foto
+const (
+	ImagePending = "pending"
+	ImageDone    = "done"
+	ImageFailed  = "failed"
+)
+
+// Política de reintentos de las descargas de fotos
+const (
+	MaxImageAttempts = 5
+	imageBackoffBase = 5 * time.Minute
+	imageBackoffMax  = 24 * time.Hour
+)
+
+// ImageBackoff devuelve la espera antes del próximo intento luego de attempts intentos fallidos
+func ImageBackoff(attempts int) time.Duration {
+	espera := imageBackoffBase
+	for i := 1; i < attempts; i++ {
+		espera *= 2
+		if espera >= imageBackoffMax {
+			return imageBackoffMax
+		}
+	}
+	return espera
+}
+
+// EnqueuePropertyImages registra para descargar las fotos de las propiedades (imagen_url e imagenes)
+// que todavía no están en la cola. Devuelve cuántas agregó.
+func (db *DB) EnqueuePropertyImages() (int, error) {
+	rows, err := db.Query(`
+		SELECT id, COALESCE(imagen_url, ''), COALESCE(imagenes, '')
+		FROM propiedades
+		WHERE COALESCE(status, '') != 'unavailable'`)
+	if err != nil {
+		return 0, fmt.Errorf("error consultando fotos de las propiedades: %v", err)
+	}
+
+	type foto struct {
+		propertyID int64
+		url        string
+		position   int
+	}
+	var fotos []foto
+	for rows.Next() {
+		var id int64
+		var principal, imagenesJSON string
+		if err := rows.Scan(&id, &principal, &imagenesJSON); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error escaneando fotos de la propiedad: %v", err)
+		}
+
+		urls := []string{principal}
+		var imagenes []string
+		if imagenesJSON != "" && json.Unmarshal([]byte(imagenesJSON), &imagenes) == nil {
+			urls = append(urls, imagenes...)
+		}
+
+		vistas := map[string]bool{}
+		for _, u := range urls {
+			u = strings.TrimSpace(u)
+			if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") || vistas[u] {
+				continue
+			}
+			vistas[u] = true
+			fotos = append(fotos, foto{propertyID: id, url: u, position: len(vistas) - 1})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterando propiedades: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO property_images (property_id, url, position) VALUES (?, ?, ?)`)
+	if err != nil {
+		return 0, fmt.Errorf("error preparando cola de fotos: %v", err)
+	}
+	defer stmt.Close()
+
+	agregadas := 0
+	for _, f := range fotos {
+		result, err := stmt.Exec(f.propertyID, f.url, f.position)
+		if err != nil {
+			return 0, fmt.Errorf("error encolando foto de la propiedad %d: %v", f.propertyID, err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			agregadas++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error confirmando transacción: %v", err)
+	}
+	return agregadas, nil
+}
+
+// columnasImagen son las columnas que lee scanPropertyImage
+const columnasImagen = `id, property_id, url, position, status, attempts, next_attempt_at,
+	COALESCE(last_error, ''), COALESCE(blob_hash, ''), duplicate_of, fetched_at`
+
+func scanPropertyImage(row scanner, img *PropertyImage) error {
+	return row.Scan(&img.ID, &img.PropertyID, &img.URL, &img.Position, &img.Status, &img.Attempts, &img.NextAttemptAt,
+		&img.LastError, &img.BlobHash, &img.DuplicateOf, &img.FetchedAt)
+}
+
+// PendingImages devuelve hasta limit fotos pendientes con el próximo intento antes de until,
+// primero la principal de cada propiedad
+func (db *DB) PendingImages(until time.Time, limit int) ([]PropertyImage, error) {
+	query := `
+		SELECT ` + columnasImagen + `
+		FROM property_images
+		WHERE status = 'pending' AND next_attempt_at <= ?
+		ORDER BY position, next_attempt_at, id
+		LIMIT ?`
+
+	rows, err := db.Query(query, until.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando fotos pendientes: %v", err)
+	}
+	defer rows.Close()
+
+	var images []PropertyImage
+	for rows.Next() {
+		var img PropertyImage
+		if err := scanPropertyImage(rows, &img); err != nil {
+			return nil, fmt.Errorf("error escaneando foto: %v", err)
+		}
+		images = append(images, img)
+	}
+
+	return images, rows.Err()
+}
+
+// SaveImageBlob registra una foto guardada. Si ya existía el mismo contenido, no hace nada.
+func (db *DB) SaveImageBlob(blob *ImageBlob) error {
+	query := `
+		INSERT INTO image_blobs (hash, content_type, size, width, height, phash, has_thumb, created_at)
+		VALUES (?, ?, ?, NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, ''), ?, ?)
+		ON CONFLICT(hash) DO NOTHING`
+
+	_, err := db.Exec(query, blob.Hash, blob.ContentType, blob.Size, blob.Width, blob.Height, blob.PHash, blob.HasThumb, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error registrando foto %s: %v", blob.Hash, err)
+	}
+
+	return nil
+}
+
+// GetImageBlob devuelve una foto guardada. Devuelve sql.ErrNoRows si no existe.
+func (db *DB) GetImageBlob(hash string) (*ImageBlob, error) {
+	query := `
+		SELECT hash, content_type, size, COALESCE(width, 0), COALESCE(height, 0), COALESCE(phash, ''), has_thumb, created_at
+		FROM image_blobs
+		WHERE hash = ?`
+
+	var blob ImageBlob
+	err := db.QueryRow(query, hash).Scan(&blob.Hash, &blob.ContentType, &blob.Size, &blob.Width, &blob.Height,
+		&blob.PHash, &blob.HasThumb, &blob.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("error obteniendo foto %s: %w", hash, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo foto %s: %v", hash, err)
+	}
+
+	return &blob, nil
+}
+
+// CompleteImage registra la descarga de una foto de una propiedad y, si es casi idéntica
+// a otra de la misma propiedad, cuál es
+func (db *DB) CompleteImage(imageID int64, hash string, duplicateOf *int64) error {
+	query := `
+		UPDATE property_images
+		SET status = 'done', blob_hash = ?, duplicate_of = ?, last_error = NULL, fetched_at = ?
+		WHERE id = ?`
+
+	if _, err := db.Exec(query, hash, duplicateOf, time.Now().UTC(), imageID); err != nil {
+		return fmt.Errorf("error completando foto %d: %v", imageID, err)
+	}
+
+	return nil
+}
+
+// FailImage registra el fallo de una descarga. Si retry es true y quedan intentos, la foto
+// vuelve a quedar pendiente con espera exponencial; si no, queda fallida. Devuelve el estado resultante.
+func (db *DB) FailImage(img *PropertyImage, cause error, retry bool) (string, error) {
+	img.Attempts++
+	img.LastError = cause.Error()
+	img.Status = ImageFailed
+	if retry && img.Attempts < MaxImageAttempts {
+		img.Status = ImagePending
+		img.NextAttemptAt = time.Now().UTC().Add(ImageBackoff(img.Attempts))
+	}
+
+	query := `UPDATE property_images SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?`
+	if _, err := db.Exec(query, img.Status, img.Attempts, img.NextAttemptAt, img.LastError, img.ID); err != nil {
+		return "", fmt.Errorf("error registrando fallo de la foto %d: %v", img.ID, err)
+	}
+
+	return img.Status, nil
+}
+
+// PostponeImage deja una foto pendiente para más tarde sin contarlo como intento (por ejemplo, si el sitio pidió esperar)
+func (db *DB) PostponeImage(imageID int64, until time.Time) error {
+	if _, err := db.Exec(`UPDATE property_images SET next_attempt_at = ? WHERE id = ?`, until.UTC(), imageID); err != nil {
+		return fmt.Errorf("error postergando foto %d: %v", imageID, err)
+	}
+	return nil
+}
+
+// GetPropertyImages devuelve las fotos de una propiedad en orden, con los datos de las ya descargadas
+func (db *DB) GetPropertyImages(propertyID int64) ([]PropertyImage, error) {
+	query := `
+		SELECT ` + columnasImagen + `
+		FROM property_images
+		WHERE property_id = ?
+		ORDER BY position, id`
+
+	rows, err := db.Query(query, propertyID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando fotos de la propiedad %d: %v", propertyID, err)
+	}
+	defer rows.Close()
+
+	images := []PropertyImage{}
+	for rows.Next() {
+		var img PropertyImage
+		if err := scanPropertyImage(rows, &img); err != nil {
+			return nil, fmt.Errorf("error escaneando foto: %v", err)
+		}
+		images = append(images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range images {
+		if images[i].BlobHash == "" {
+			continue
+		}
+		blob, err := db.GetImageBlob(images[i].BlobHash)
+		if err != nil {
+			return nil, err
+		}
+		images[i].Blob = blob
+	}
+
+	return images, nil
+}
+
+// GetPropertyImageHashes devuelve las fotos descargadas de una propiedad con su hash perceptual,
+// para detectar fotos casi idénticas
+func (db *DB) GetPropertyImageHashes(propertyID int64) (map[int64]string, error) {
+	query := `
+		SELECT pi.id, COALESCE(b.phash, '')
+		FROM property_images pi
+		JOIN image_blobs b ON b.hash = pi.blob_hash
+		WHERE pi.property_id = ? AND pi.status = 'done' AND pi.duplicate_of IS NULL`
+
+	rows, err := db.Query(query, propertyID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando fotos de la propiedad %d: %v", propertyID, err)
+	}
+	defer rows.Close()
+
+	hashes := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var phash string
+		if err := rows.Scan(&id, &phash); err != nil {
+			return nil, fmt.Errorf("error escaneando foto: %v", err)
+		}
+		if phash != "" {
+			hashes[id] = phash
+		}
+	}
+
+	return hashes, rows.Err()
+}
@@ -0,0 +1,574 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Umbral a partir del cual dos inmobiliarias se consideran la misma
+const umbralCoincidenciaInmobiliaria = 0.8
+
+// Hosts compartidos por muchas inmobiliarias (redes sociales, portales, franquicias).
+// En estos casos el dominio solo no identifica a la inmobiliaria: usamos también el primer segmento del path.
+var hostsCompartidos = map[string]bool{
+	"facebook.com":        true,
+	"instagram.com":       true,
+	"linktr.ee":           true,
+	"wa.me":               true,
+	"api.whatsapp.com":    true,
+	"google.com":          true,
+	"sites.google.com":    true,
+	"linkedin.com":        true,
+	"mercadolibre.com.ar": true,
+	"zonaprop.com.ar":     true,
+	"argenprop.com":       true,
+	"properati.com.ar":    true,
+	"remax.com.ar":        true,
+	"century21.com.ar":    true,
+}
+
+// Palabras que no aportan a la identidad del nombre de una inmobiliaria
+var palabrasGenericasNombre = map[string]bool{
+	"inmobiliaria":  true,
+	"inmobiliarias": true,
+	"inmobiliario":  true,
+	"inmobiliarios": true,
+	"propiedades":   true,
+	"negocios":      true,
+	"servicios":     true,
+	"bienes":        true,
+	"raices":        true,
+	"sa":            true,
+	"srl":           true,
+	"de":            true,
+	"del":           true,
+	"la":            true,
+	"las":           true,
+	"los":           true,
+	"el":            true,
+	"y":             true,
+}
+
+// AgencyMatch describe por qué dos inmobiliarias parecen ser la misma
+type AgencyMatch struct {
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// AgencyMergeProposal es una propuesta de fusión entre dos inmobiliarias
+type AgencyMergeProposal struct {
+	Keep      Inmobiliaria `json:"keep"`
+	Duplicate Inmobiliaria `json:"duplicate"`
+	Match     AgencyMatch  `json:"match"`
+}
+
+// AgencyMergeResult resume el resultado de fusionar dos inmobiliarias
+type AgencyMergeResult struct {
+	Agency           Inmobiliaria `json:"agency"`
+	MovedProperties  int          `json:"moved_properties"`
+	MergedProperties int          `json:"merged_properties"`
+}
+
+// quitarAcentos reemplaza las vocales acentuadas y la ñ por su versión sin acento
+func quitarAcentos(texto string) string {
+	replacer := strings.NewReplacer(
+		"á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u", "ñ", "n",
+	)
+	return replacer.Replace(texto)
+}
+
+// normalizarDominio devuelve la clave de identidad del sitio web de una inmobiliaria.
+// Para hosts compartidos (redes sociales, portales) incluye el primer segmento del path.
+func normalizarDominio(sitio string) string {
+	sitio = strings.TrimSpace(strings.ToLower(sitio))
+	if sitio == "" {
+		return ""
+	}
+	if !strings.HasPrefix(sitio, "http://") && !strings.HasPrefix(sitio, "https://") {
+		sitio = "https://" + sitio
+	}
+
+	u, err := url.Parse(sitio)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+
+	host := strings.TrimPrefix(u.Hostname(), "www.")
+	if !hostsCompartidos[host] && !esSubdominioCompartido(host) {
+		return host
+	}
+
+	segmento := strings.Trim(u.Path, "/")
+	if idx := strings.Index(segmento, "/"); idx != -1 {
+		segmento = segmento[:idx]
+	}
+	if segmento == "" {
+		return ""
+	}
+
+	return host + "/" + segmento
+}
+
+// esSubdominioCompartido indica si el host es un subdominio de una plataforma compartida
+// cuyo subdominio no identifica a la inmobiliaria (ej: m.facebook.com)
+func esSubdominioCompartido(host string) bool {
+	for _, compartido := range []string{"facebook.com", "instagram.com", "google.com"} {
+		if strings.HasSuffix(host, "."+compartido) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizarTelefono deja solo los dígitos significativos del teléfono.
+// Se comparan los últimos 10 dígitos (característica + número) para ignorar prefijos +54, 0 y 9.
+func normalizarTelefono(telefono string) string {
+	var digitos strings.Builder
+	for _, r := range telefono {
+		if unicode.IsDigit(r) {
+			digitos.WriteRune(r)
+		}
+	}
+
+	numero := digitos.String()
+	if len(numero) < 8 {
+		return ""
+	}
+	if len(numero) > 10 {
+		numero = numero[len(numero)-10:]
+	}
+	return numero
+}
+
+// normalizarNombre normaliza el nombre de una inmobiliaria quitando acentos,
+// puntuación y palabras genéricas como "inmobiliaria" o "propiedades"
+func normalizarNombre(nombre string) string {
+	nombre = quitarAcentos(strings.ToLower(nombre))
+
+	palabras := strings.FieldsFunc(nombre, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '/'
+	})
+
+	var significativas []string
+	for _, palabra := range palabras {
+		// "RE/MAX" y "Re-Max" deben quedar iguales
+		palabra = strings.ReplaceAll(palabra, "/", "")
+		if palabra == "" || palabrasGenericasNombre[palabra] {
+			continue
+		}
+		significativas = append(significativas, palabra)
+	}
+
+	return strings.Join(significativas, "")
+}
+
+// normalizarDireccion normaliza una dirección para comparación
+func normalizarDireccion(direccion string) string {
+	return quitarAcentos(normalizarTexto(direccion))
+}
+
+// similitud devuelve un valor entre 0 y 1 basado en la distancia de Levenshtein
+func similitud(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			costo := 1
+			if ra[i-1] == rb[j-1] {
+				costo = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+costo)
+		}
+		prev, curr = curr, prev
+	}
+
+	maxLen := max(len(ra), len(rb))
+	return 1 - float64(prev[len(rb)])/float64(maxLen)
+}
+
+// MatchInmobiliarias compara dos inmobiliarias usando dominio, teléfono y similitud de nombre/dirección
+func MatchInmobiliarias(a, b *Inmobiliaria) AgencyMatch {
+	var match AgencyMatch
+
+	dominioA, dominioB := normalizarDominio(a.URL), normalizarDominio(b.URL)
+	if dominioA != "" && dominioA == dominioB {
+		match.Score = 1
+		match.Reasons = append(match.Reasons, "mismo sitio web: "+dominioA)
+	}
+
+	telefonoA, telefonoB := normalizarTelefono(a.Telefono), normalizarTelefono(b.Telefono)
+	if telefonoA != "" && telefonoA == telefonoB {
+		match.Score = max(match.Score, 0.95)
+		match.Reasons = append(match.Reasons, "mismo teléfono: "+telefonoA)
+	}
+
+	// Dos sitios web propios distintos indican inmobiliarias distintas (ej: franquicias)
+	if match.Score == 0 && dominioA != "" && dominioB != "" && dominioA != dominioB {
+		return match
+	}
+
+	nombreSim := similitud(normalizarNombre(a.Nombre), normalizarNombre(b.Nombre))
+	direccionA, direccionB := normalizarDireccion(a.Direccion), normalizarDireccion(b.Direccion)
+
+	switch {
+	case direccionA != "" && direccionB != "":
+		direccionSim := similitud(direccionA, direccionB)
+		if nombreSim >= 0.9 && direccionSim >= 0.8 {
+			score := 0.95 * (nombreSim + direccionSim) / 2
+			match.Reasons = append(match.Reasons, fmt.Sprintf("nombre y dirección similares (%.0f%% / %.0f%%)", nombreSim*100, direccionSim*100))
+			match.Score = max(match.Score, score)
+		}
+	case nombreSim == 1 && a.Zona != "" && strings.EqualFold(a.Zona, b.Zona):
+		// Sin dirección para comparar solo aceptamos el mismo nombre en la misma zona
+		match.Reasons = append(match.Reasons, "mismo nombre en la misma zona")
+		match.Score = max(match.Score, umbralCoincidenciaInmobiliaria)
+	}
+
+	return match
+}
+
+// ExistsInmobiliaria verifica si ya existe una inmobiliaria que coincida con la dada
+func (db *DB) ExistsInmobiliaria(candidata *Inmobiliaria) (bool, error) {
+	existente, _, err := db.FindMatchingInmobiliaria(candidata)
+	if err != nil {
+		return false, err
+	}
+
+	return existente != nil, nil
+}
+
+// FindMatchingInmobiliaria busca la inmobiliaria existente que mejor coincide con la candidata.
+// Devuelve nil si ninguna supera el umbral de coincidencia.
+func (db *DB) FindMatchingInmobiliaria(candidata *Inmobiliaria) (*Inmobiliaria, *AgencyMatch, error) {
+	inmobiliarias, err := db.GetAllAgencies()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var mejor *Inmobiliaria
+	var mejorMatch AgencyMatch
+	for i := range inmobiliarias {
+		if inmobiliarias[i].ID == candidata.ID {
+			continue
+		}
+
+		match := MatchInmobiliarias(candidata, &inmobiliarias[i])
+		if match.Score >= umbralCoincidenciaInmobiliaria && match.Score > mejorMatch.Score {
+			mejor = &inmobiliarias[i]
+			mejorMatch = match
+		}
+	}
+
+	if mejor == nil {
+		return nil, nil, nil
+	}
+
+	return mejor, &mejorMatch, nil
+}
+
+// ProposeInmobiliariaMerges compara todas las inmobiliarias entre sí y propone fusiones.
+// Se propone conservar la que tiene más propiedades (o la más antigua en caso de empate).
+func (db *DB) ProposeInmobiliariaMerges() ([]AgencyMergeProposal, error) {
+	inmobiliarias, err := db.GetAllAgencies()
+	if err != nil {
+		return nil, err
+	}
+
+	conteos, err := db.countPropiedadesPorInmobiliaria()
+	if err != nil {
+		return nil, err
+	}
+
+	var propuestas []AgencyMergeProposal
+	for i := 0; i < len(inmobiliarias); i++ {
+		for j := i + 1; j < len(inmobiliarias); j++ {
+			a, b := inmobiliarias[i], inmobiliarias[j]
+
+			match := MatchInmobiliarias(&a, &b)
+			if match.Score < umbralCoincidenciaInmobiliaria {
+				continue
+			}
+
+			keep, duplicate := a, b
+			if conteos[b.ID] > conteos[a.ID] || (conteos[b.ID] == conteos[a.ID] && b.ID < a.ID) {
+				keep, duplicate = b, a
+			}
+
+			propuestas = append(propuestas, AgencyMergeProposal{
+				Keep:      keep,
+				Duplicate: duplicate,
+				Match:     match,
+			})
+		}
+	}
+
+	sort.SliceStable(propuestas, func(i, j int) bool {
+		return propuestas[i].Match.Score > propuestas[j].Match.Score
+	})
+
+	return propuestas, nil
+}
+
+// countPropiedadesPorInmobiliaria devuelve la cantidad de propiedades de cada inmobiliaria
+func (db *DB) countPropiedadesPorInmobiliaria() (map[int64]int, error) {
+	rows, err := db.Query(`SELECT inmobiliaria_id, COUNT(*) FROM propiedades WHERE inmobiliaria_id IS NOT NULL GROUP BY inmobiliaria_id`)
+	if err != nil {
+		return nil, fmt.Errorf("error contando propiedades por inmobiliaria: %v", err)
+	}
+	defer rows.Close()
+
+	conteos := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, fmt.Errorf("error escaneando conteo de propiedades: %v", err)
+		}
+		conteos[id] = count
+	}
+
+	return conteos, rows.Err()
+}
+
+// MergeInmobiliarias fusiona la inmobiliaria duplicateID en keepID.
+// Las propiedades del duplicado pasan a la inmobiliaria conservada; si ambas tienen
+// la misma propiedad (mismo código), las calificaciones y notas se trasladan a la conservada.
+func (db *DB) MergeInmobiliarias(keepID, duplicateID int64) (*AgencyMergeResult, error) {
+	if keepID == duplicateID {
+		return nil, fmt.Errorf("no se puede fusionar una inmobiliaria consigo misma")
+	}
+
+	keep, err := db.GetInmobiliariaByID(keepID)
+	if err != nil {
+		return nil, err
+	}
+	duplicate, err := db.GetInmobiliariaByID(duplicateID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Propiedades que existen en ambas inmobiliarias
+	rows, err := tx.Query(`
+		SELECT d.id, k.id
+		FROM propiedades d
+		JOIN propiedades k ON k.codigo = d.codigo AND k.inmobiliaria_id = ?
+		WHERE d.inmobiliaria_id = ?`, keepID, duplicateID)
+	if err != nil {
+		return nil, fmt.Errorf("error buscando propiedades repetidas: %v", err)
+	}
+
+	type par struct{ duplicada, conservada int64 }
+	var repetidas []par
+	for rows.Next() {
+		var p par
+		if err := rows.Scan(&p.duplicada, &p.conservada); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error escaneando propiedades repetidas: %v", err)
+		}
+		repetidas = append(repetidas, p)
+	}
+	rows.Close()
+
+	for _, p := range repetidas {
+		if err := reassignPropertyRefs(tx, p.duplicada, p.conservada); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM propiedades WHERE id = ?`, p.duplicada); err != nil {
+			return nil, fmt.Errorf("error eliminando propiedad repetida %d: %v", p.duplicada, err)
+		}
+	}
+
+	// El resto de las propiedades pasan directamente a la inmobiliaria conservada
+	result, err := tx.Exec(`
+		UPDATE propiedades SET inmobiliaria_id = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE inmobiliaria_id = ?`, keepID, duplicateID)
+	if err != nil {
+		return nil, fmt.Errorf("error moviendo propiedades: %v", err)
+	}
+	movidas, _ := result.RowsAffected()
+
+	// Completar los datos faltantes de la inmobiliaria conservada con los del duplicado
+	_, err = tx.Exec(`
+		UPDATE inmobiliarias SET
+			url = COALESCE(NULLIF(url, ''), ?),
+			sistema = CASE WHEN sistema IS NULL OR sistema = '' OR sistema = 'No identificado' THEN ? ELSE sistema END,
+			zona = COALESCE(NULLIF(zona, ''), ?),
+			direccion = COALESCE(NULLIF(direccion, ''), ?),
+			telefono = COALESCE(NULLIF(telefono, ''), ?),
+			rating = MAX(IFNULL(rating, 0), ?),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		duplicate.URL, duplicate.Sistema, duplicate.Zona, duplicate.Direccion, duplicate.Telefono, duplicate.Rating,
+		keepID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error actualizando inmobiliaria %d: %v", keepID, err)
+	}
+
+	// El historial de scraping, los contactos y las visitas del duplicado pasan a la inmobiliaria conservada
+	moves := []string{
+		`UPDATE scrape_runs SET inmobiliaria_id = ? WHERE inmobiliaria_id = ?`,
+		`UPDATE agency_contacts SET inmobiliaria_id = ? WHERE inmobiliaria_id = ?`,
+		`UPDATE property_contacts SET inmobiliaria_id = ? WHERE inmobiliaria_id = ?`,
+		`UPDATE visits SET inmobiliaria_id = ? WHERE inmobiliaria_id = ?`,
+	}
+	for _, query := range moves {
+		if _, err := tx.Exec(query, keepID, duplicateID); err != nil {
+			return nil, fmt.Errorf("error moviendo datos de la inmobiliaria %d: %v", duplicateID, err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM inmobiliarias WHERE id = ?`, duplicateID); err != nil {
+		return nil, fmt.Errorf("error eliminando inmobiliaria %d: %v", duplicateID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error confirmando transacción: %v", err)
+	}
+
+	keep, err = db.GetInmobiliariaByID(keep.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AgencyMergeResult{
+		Agency:           *keep,
+		MovedProperties:  int(movidas),
+		MergedProperties: len(repetidas),
+	}, nil
+}
+
+// reassignPropertyRefs traslada a toID las calificaciones, notas, características, búsquedas, visitas, fotos y fichas guardadas
+// de la propiedad fromID. Si la propiedad destino ya tiene un dato equivalente, se conserva el suyo.
+func reassignPropertyRefs(tx *sql.Tx, fromID, toID int64) error {
+	queries := []string{
+		`UPDATE property_notes SET property_id = ? WHERE property_id = ?`,
+		`UPDATE OR IGNORE property_ratings SET property_id = ? WHERE property_id = ?`,
+		`UPDATE OR IGNORE busquedas_propiedades SET propiedad_id = ? WHERE propiedad_id = ?`,
+		`UPDATE OR IGNORE property_feature_relations SET property_id = ? WHERE property_id = ?`,
+		`UPDATE property_changes SET propiedad_id = ? WHERE propiedad_id = ?`,
+		`UPDATE property_rating_history SET property_id = ? WHERE property_id = ?`,
+		`UPDATE OR IGNORE property_checklist SET property_id = ? WHERE property_id = ?`,
+		`UPDATE property_contacts SET property_id = ? WHERE property_id = ?`,
+		`UPDATE visits SET property_id = ? WHERE property_id = ?`,
+		`UPDATE OR IGNORE property_images SET property_id = ? WHERE property_id = ?`,
+		`UPDATE detail_snapshots SET propiedad_id = ? WHERE propiedad_id = ?`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, toID, fromID); err != nil {
+			return fmt.Errorf("error trasladando datos de la propiedad %d a %d: %v", fromID, toID, err)
+		}
+	}
+
+	// Lo que no se pudo trasladar por estar repetido se elimina
+	cleanup := []string{
+		`DELETE FROM property_rating_reasons WHERE rating_id IN (SELECT id FROM property_ratings WHERE property_id = ?)`,
+		`DELETE FROM property_ratings WHERE property_id = ?`,
+		`DELETE FROM busquedas_propiedades WHERE propiedad_id = ?`,
+		`DELETE FROM property_feature_relations WHERE property_id = ?`,
+		`DELETE FROM property_checklist WHERE property_id = ?`,
+		`DELETE FROM detail_jobs WHERE propiedad_id = ?`,
+		`DELETE FROM property_images WHERE property_id = ?`,
+	}
+	for _, query := range cleanup {
+		if _, err := tx.Exec(query, fromID); err != nil {
+			return fmt.Errorf("error limpiando datos de la propiedad %d: %v", fromID, err)
+		}
+	}
+
+	return nil
+}
+
+// ErrInmobiliariaConPropiedades indica que la inmobiliaria no se puede eliminar porque tiene propiedades
+var ErrInmobiliariaConPropiedades = errors.New("la inmobiliaria tiene propiedades asociadas")
+
+// UpdateInmobiliaria actualiza los datos editables de una inmobiliaria
+func (db *DB) UpdateInmobiliaria(i *Inmobiliaria) error {
+	query := `
+		UPDATE inmobiliarias
+		SET nombre = ?, url = ?, sistema = ?, zona = ?, direccion = ?, telefono = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		RETURNING updated_at`
+
+	err := db.QueryRow(query, i.Nombre, i.URL, i.Sistema, i.Zona, i.Direccion, i.Telefono, i.ID).Scan(&i.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error actualizando inmobiliaria %d: %w", i.ID, err)
+	}
+
+	return nil
+}
+
+// SetInmobiliariaDisabled habilita o deshabilita una inmobiliaria
+func (db *DB) SetInmobiliariaDisabled(id int64, disabled bool) error {
+	query := `
+		UPDATE inmobiliarias
+		SET disabled = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+		RETURNING id`
+
+	if err := db.QueryRow(query, disabled, id).Scan(&id); err != nil {
+		return fmt.Errorf("error actualizando inmobiliaria %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// DeleteInmobiliaria elimina una inmobiliaria sin propiedades.
+// Las inmobiliarias con propiedades se deshabilitan o se fusionan en lugar de eliminarse.
+func (db *DB) DeleteInmobiliaria(id int64) error {
+	if _, err := db.GetInmobiliariaByID(id); err != nil {
+		return err
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM propiedades WHERE inmobiliaria_id = ?`, id).Scan(&count); err != nil {
+		return fmt.Errorf("error contando propiedades de la inmobiliaria %d: %v", id, err)
+	}
+	if count > 0 {
+		return fmt.Errorf("inmobiliaria %d (%d propiedades): %w", id, count, ErrInmobiliariaConPropiedades)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM scrape_runs WHERE inmobiliaria_id = ?`, id); err != nil {
+		return fmt.Errorf("error eliminando historial de scraping de la inmobiliaria %d: %v", id, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM agency_contacts WHERE inmobiliaria_id = ?`, id); err != nil {
+		return fmt.Errorf("error eliminando contactos de la inmobiliaria %d: %v", id, err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM inmobiliarias WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("error eliminando inmobiliaria %d: %v", id, err)
+	}
+
+	return tx.Commit()
+}
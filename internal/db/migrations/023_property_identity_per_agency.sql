@@ -0,0 +1,126 @@
+-- +goose Up
+-- +goose StatementBegin
+-- La identidad de una propiedad pasa a ser (inmobiliaria_id, codigo).
+-- SQLite no permite quitar la restricción UNIQUE de una columna, así que reconstruimos la tabla.
+
+-- Las propiedades sin código usan la URL como identidad
+UPDATE propiedades SET codigo = url WHERE (codigo IS NULL OR codigo = '') AND url IS NOT NULL AND url != '';
+
+CREATE TABLE propiedades_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    inmobiliaria_id INTEGER,
+    codigo TEXT NOT NULL,
+    titulo TEXT,
+    precio TEXT,
+    moneda TEXT DEFAULT 'USD',
+    direccion TEXT,
+    url TEXT,
+    imagen_url TEXT,
+    imagenes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    tipo_propiedad INTEGER,
+    tipo_propiedad_original TEXT,
+    ubicacion TEXT,
+    dormitorios INTEGER,
+    banios INTEGER,
+    antiguedad INTEGER,
+    antiguedad_original TEXT,
+    superficie_cubierta FLOAT,
+    superficie_total FLOAT,
+    superficie_terreno FLOAT,
+    frente FLOAT,
+    fondo FLOAT,
+    ambientes INTEGER,
+    plantas INTEGER,
+    cocheras INTEGER,
+    situacion TEXT,
+    expensas FLOAT,
+    descripcion TEXT,
+    status TEXT DEFAULT 'pending',
+    operacion TEXT,
+    condicion TEXT,
+    orientacion TEXT,
+    disposicion TEXT,
+    latitud REAL,
+    longitud REAL,
+    FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id)
+);
+
+INSERT INTO propiedades_new (
+    id, inmobiliaria_id, codigo, titulo, precio, moneda, direccion, url, imagen_url, imagenes,
+    created_at, updated_at, tipo_propiedad, tipo_propiedad_original, ubicacion, dormitorios, banios,
+    antiguedad, antiguedad_original, superficie_cubierta, superficie_total, superficie_terreno,
+    frente, fondo, ambientes, plantas, cocheras, situacion, expensas, descripcion, status,
+    operacion, condicion, orientacion, disposicion, latitud, longitud
+)
+SELECT
+    id, inmobiliaria_id, codigo, titulo, precio, moneda, direccion, url, imagen_url, imagenes,
+    created_at, updated_at, tipo_propiedad, tipo_propiedad_original, ubicacion, dormitorios, banios,
+    antiguedad, antiguedad_original, superficie_cubierta, superficie_total, superficie_terreno,
+    frente, fondo, ambientes, plantas, cocheras, situacion, expensas, descripcion, status,
+    operacion, condicion, orientacion, disposicion, latitud, longitud
+FROM propiedades;
+
+DROP TABLE propiedades;
+ALTER TABLE propiedades_new RENAME TO propiedades;
+
+CREATE INDEX IF NOT EXISTS idx_propiedades_codigo ON propiedades(codigo);
+CREATE INDEX IF NOT EXISTS idx_propiedades_inmobiliaria ON propiedades(inmobiliaria_id);
+CREATE INDEX IF NOT EXISTS idx_propiedades_tipo_propiedad ON propiedades(tipo_propiedad);
+CREATE INDEX IF NOT EXISTS idx_propiedades_antiguedad ON propiedades(antiguedad);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_propiedades_inmobiliaria_codigo ON propiedades(inmobiliaria_id, codigo);
+
+-- Detectar colisiones: con el UNIQUE(codigo) anterior, una inmobiliaria pisaba la propiedad de otra
+-- sin actualizar inmobiliaria_id. La URL de la propiedad indica a qué sitio pertenece realmente.
+CREATE TEMP TABLE inmobiliaria_hosts AS
+SELECT id, SUBSTR(h, 1, INSTR(h || '/', '/') - 1) AS host
+FROM (
+    SELECT id, LOWER(REPLACE(REPLACE(REPLACE(TRIM(url), 'https://', ''), 'http://', ''), 'www.', '')) AS h
+    FROM inmobiliarias
+    WHERE url IS NOT NULL AND url != ''
+);
+
+CREATE TEMP TABLE propiedad_hosts AS
+SELECT id, inmobiliaria_id, SUBSTR(h, 1, INSTR(h || '/', '/') - 1) AS host
+FROM (
+    SELECT id, inmobiliaria_id, LOWER(REPLACE(REPLACE(REPLACE(TRIM(url), 'https://', ''), 'http://', ''), 'www.', '')) AS h
+    FROM propiedades
+    WHERE url IS NOT NULL AND url != ''
+);
+
+-- Separar las colisiones: reasignar la propiedad a la inmobiliaria dueña de la URL
+-- y marcarla como pendiente para volver a extraer sus detalles
+UPDATE propiedades
+SET inmobiliaria_id = (
+        SELECT ih.id
+        FROM propiedad_hosts ph
+        JOIN inmobiliaria_hosts ih ON ih.host = ph.host
+        WHERE ph.id = propiedades.id
+        ORDER BY ih.id
+        LIMIT 1
+    ),
+    status = 'pending',
+    updated_at = CURRENT_TIMESTAMP
+WHERE id IN (
+    SELECT ph.id
+    FROM propiedad_hosts ph
+    WHERE NOT EXISTS (
+        SELECT 1 FROM inmobiliaria_hosts ih
+        WHERE ih.id = ph.inmobiliaria_id AND ih.host = ph.host
+    )
+    AND EXISTS (
+        SELECT 1 FROM inmobiliaria_hosts ih WHERE ih.host = ph.host
+    )
+);
+
+DROP TABLE inmobiliaria_hosts;
+DROP TABLE propiedad_hosts;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- Volver a la identidad global por código. Falla si existen códigos repetidos entre inmobiliarias.
+DROP INDEX IF EXISTS idx_propiedades_inmobiliaria_codigo;
+CREATE UNIQUE INDEX idx_propiedades_codigo_unique ON propiedades(codigo);
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Las inmobiliarias deshabilitadas se conservan pero no se analizan ni se scrapean
+ALTER TABLE inmobiliarias ADD COLUMN disabled BOOLEAN DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE inmobiliarias DROP COLUMN disabled;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Historial de ejecuciones del scraper por inmobiliaria
+CREATE TABLE IF NOT EXISTS scrape_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    inmobiliaria_id INTEGER NOT NULL,
+    run_type TEXT NOT NULL,                  -- 'search' (listado) o 'details' (detalles)
+    status TEXT NOT NULL DEFAULT 'running',  -- 'running', 'success', 'failed'
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    listings_found INTEGER DEFAULT 0,
+    new_count INTEGER DEFAULT 0,
+    updated_count INTEGER DEFAULT 0,
+    failed_count INTEGER DEFAULT 0,
+    unavailable_count INTEGER DEFAULT 0,
+    last_error TEXT,
+    FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scrape_runs_inmobiliaria ON scrape_runs(inmobiliaria_id, started_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_scrape_runs_inmobiliaria;
+DROP TABLE IF EXISTS scrape_runs;
+-- +goose StatementEnd
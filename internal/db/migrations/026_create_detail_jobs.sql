@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Cola persistente de extracción de detalles de propiedades
+CREATE TABLE IF NOT EXISTS detail_jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    propiedad_id INTEGER NOT NULL UNIQUE,
+    status TEXT NOT NULL DEFAULT 'pending',  -- 'pending', 'running', 'done', 'failed'
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    lease_until TIMESTAMP,                   -- Un job 'running' con el lease vencido vuelve a estar disponible
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (propiedad_id) REFERENCES propiedades(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_detail_jobs_status ON detail_jobs(status, next_attempt_at);
+
+-- Encolar las propiedades que todavía no tienen detalles
+INSERT OR IGNORE INTO detail_jobs (propiedad_id)
+SELECT id FROM propiedades WHERE status = 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_detail_jobs_status;
+DROP TABLE IF EXISTS detail_jobs;
+-- +goose StatementEnd
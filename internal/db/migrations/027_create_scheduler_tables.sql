@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Locks del daemon para evitar ejecuciones superpuestas de una misma tarea
+CREATE TABLE IF NOT EXISTS scheduler_locks (
+    name TEXT PRIMARY KEY,
+    owner TEXT NOT NULL,
+    acquired_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Historial de ejecuciones de las tareas programadas
+CREATE TABLE IF NOT EXISTS scheduled_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task TEXT NOT NULL,
+    owner TEXT,
+    status TEXT NOT NULL DEFAULT 'running',  -- 'running', 'success', 'failed', 'interrupted', 'skipped'
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_runs_task ON scheduled_runs(task, started_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_scheduled_runs_task;
+DROP TABLE IF EXISTS scheduled_runs;
+DROP TABLE IF EXISTS scheduler_locks;
+-- +goose StatementEnd
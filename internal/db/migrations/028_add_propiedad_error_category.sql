@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Categoría del último error al extraer los detalles de la propiedad
+ALTER TABLE propiedades ADD COLUMN error_category TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE propiedades DROP COLUMN error_category;
+-- +goose StatementEnd
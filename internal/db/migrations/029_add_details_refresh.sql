@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Fecha de la última extracción de detalles, para refrescar las propiedades desactualizadas
+ALTER TABLE propiedades ADD COLUMN details_updated_at TIMESTAMP;
+UPDATE propiedades SET details_updated_at = updated_at WHERE status = 'completed';
+
+-- Prioridad de los jobs de detalles (favoritas y con like primero)
+ALTER TABLE detail_jobs ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;
+
+-- Historial de re-extracciones de detalles con los campos que cambiaron
+CREATE TABLE IF NOT EXISTS property_refreshes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    propiedad_id INTEGER NOT NULL,
+    changed_fields TEXT NOT NULL DEFAULT '[]',  -- Array JSON con los nombres de columna modificados
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (propiedad_id) REFERENCES propiedades(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_property_refreshes_propiedad ON property_refreshes(propiedad_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_property_refreshes_propiedad;
+DROP TABLE IF EXISTS property_refreshes;
+ALTER TABLE detail_jobs DROP COLUMN priority;
+ALTER TABLE propiedades DROP COLUMN details_updated_at;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Historial de cambios por campo de las propiedades (valores normalizados como texto)
+CREATE TABLE IF NOT EXISTS property_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    propiedad_id INTEGER NOT NULL,
+    field TEXT NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (propiedad_id) REFERENCES propiedades(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_property_changes_propiedad ON property_changes(propiedad_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_property_changes_propiedad;
+DROP TABLE IF EXISTS property_changes;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Búsquedas guardadas: las que tienen filter (db.PropertyFilter en JSON).
+-- busquedas_propiedades registra las propiedades que coincidieron con cada una y desde cuándo.
+ALTER TABLE busquedas ADD COLUMN name TEXT;
+ALTER TABLE busquedas ADD COLUMN filter TEXT;
+ALTER TABLE busquedas ADD COLUMN updated_at TIMESTAMP;
+ALTER TABLE busquedas ADD COLUMN last_run_at TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE busquedas DROP COLUMN last_run_at;
+ALTER TABLE busquedas DROP COLUMN updated_at;
+ALTER TABLE busquedas DROP COLUMN filter;
+ALTER TABLE busquedas DROP COLUMN name;
+-- +goose StatementEnd
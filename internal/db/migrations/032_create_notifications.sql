@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Las coincidencias de la primera evaluación de una búsqueda no se notifican
+ALTER TABLE busquedas_propiedades ADD COLUMN initial BOOLEAN DEFAULT 0;
+UPDATE busquedas_propiedades SET initial = 1;
+
+-- Notificaciones enviadas por canal, para no repetirlas
+CREATE TABLE IF NOT EXISTS notifications_sent (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    channel TEXT NOT NULL,
+    event_key TEXT NOT NULL,  -- 'new:<búsqueda>:<propiedad>' o 'price:<búsqueda>:<cambio>'
+    busqueda_id INTEGER,
+    propiedad_id INTEGER,
+    sent_at TIMESTAMP NOT NULL,
+    UNIQUE (channel, event_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_sent_channel ON notifications_sent(channel, sent_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_notifications_sent_channel;
+DROP TABLE IF EXISTS notifications_sent;
+ALTER TABLE busquedas_propiedades DROP COLUMN initial;
+-- +goose StatementEnd
@@ -0,0 +1,71 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Usuarios; calificaciones, favoritos, notas y búsquedas guardadas pasan a ser de cada usuario
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Los datos existentes quedan a nombre del usuario por defecto
+INSERT OR IGNORE INTO users (id, name) VALUES (1, 'default');
+
+-- La calificación deja de ser única por propiedad para ser única por usuario y propiedad
+CREATE TABLE property_ratings_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL DEFAULT 1,
+    property_id INTEGER NOT NULL,
+    rating TEXT NOT NULL CHECK(rating IN ('like', 'dislike')),
+    is_favorite BOOLEAN DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    UNIQUE(user_id, property_id)
+);
+
+INSERT INTO property_ratings_new (id, user_id, property_id, rating, is_favorite, created_at)
+SELECT id, 1, property_id, rating, is_favorite, created_at FROM property_ratings;
+
+DROP TABLE property_ratings;
+ALTER TABLE property_ratings_new RENAME TO property_ratings;
+
+CREATE INDEX IF NOT EXISTS idx_property_ratings_property_id ON property_ratings(property_id);
+CREATE INDEX IF NOT EXISTS idx_property_ratings_user ON property_ratings(user_id, rating);
+
+ALTER TABLE property_notes ADD COLUMN user_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_property_notes_user ON property_notes(user_id, property_id);
+
+ALTER TABLE busquedas ADD COLUMN user_id INTEGER;
+UPDATE busquedas SET user_id = 1 WHERE filter IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_busquedas_user ON busquedas(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_busquedas_user;
+ALTER TABLE busquedas DROP COLUMN user_id;
+
+DROP INDEX IF EXISTS idx_property_notes_user;
+DELETE FROM property_notes WHERE user_id != 1;
+ALTER TABLE property_notes DROP COLUMN user_id;
+
+-- Solo se conservan las calificaciones del usuario por defecto
+CREATE TABLE property_ratings_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    property_id INTEGER NOT NULL,
+    rating TEXT NOT NULL CHECK(rating IN ('like', 'dislike')),
+    is_favorite BOOLEAN DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    UNIQUE(property_id)
+);
+
+INSERT INTO property_ratings_old (id, property_id, rating, is_favorite, created_at)
+SELECT id, property_id, rating, is_favorite, created_at FROM property_ratings WHERE user_id = 1;
+
+DROP TABLE property_ratings;
+ALTER TABLE property_ratings_old RENAME TO property_ratings;
+CREATE INDEX IF NOT EXISTS idx_property_ratings_property_id ON property_ratings(property_id);
+
+DROP TABLE IF EXISTS users;
+-- +goose StatementEnd
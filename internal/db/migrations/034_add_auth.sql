@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Credenciales y roles de los usuarios. El usuario por defecto es administrador.
+ALTER TABLE users ADD COLUMN password_hash TEXT;
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'member' CHECK(role IN ('viewer', 'member', 'admin'));
+UPDATE users SET role = 'admin' WHERE id = 1;
+
+-- Sesiones iniciadas con usuario y contraseña (se guarda el hash del token)
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- API keys para scripts (se guarda el hash de la clave)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_api_keys_user;
+DROP INDEX IF EXISTS idx_sessions_user;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS sessions;
+ALTER TABLE users DROP COLUMN role;
+ALTER TABLE users DROP COLUMN password_hash;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Hogares: grupos de usuarios que combinan sus calificaciones para decidir
+CREATE TABLE IF NOT EXISTS households (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE users ADD COLUMN household_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_users_household ON users(household_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_users_household;
+ALTER TABLE users DROP COLUMN household_id;
+DROP TABLE IF EXISTS households;
+-- +goose StatementEnd
@@ -0,0 +1,59 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Estrellas y fecha de la última calificación; created_at pasa a ser la primera
+ALTER TABLE property_ratings ADD COLUMN score INTEGER CHECK(score BETWEEN 1 AND 5);
+ALTER TABLE property_ratings ADD COLUMN updated_at TIMESTAMP;
+UPDATE property_ratings SET updated_at = created_at;
+
+-- Motivos que se pueden asociar a una calificación
+CREATE TABLE IF NOT EXISTS rating_reasons (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL UNIQUE,
+    label TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT OR IGNORE INTO rating_reasons (code, label) VALUES
+    ('too_expensive', 'Muy cara'),
+    ('bad_area', 'Mala zona'),
+    ('needs_renovation', 'Necesita refacción'),
+    ('small_rooms', 'Ambientes chicos');
+
+CREATE TABLE IF NOT EXISTS property_rating_reasons (
+    rating_id INTEGER NOT NULL,
+    reason_id INTEGER NOT NULL,
+    PRIMARY KEY (rating_id, reason_id),
+    FOREIGN KEY (rating_id) REFERENCES property_ratings(id),
+    FOREIGN KEY (reason_id) REFERENCES rating_reasons(id)
+);
+CREATE INDEX IF NOT EXISTS idx_property_rating_reasons_reason ON property_rating_reasons(reason_id);
+
+-- Historial de calificaciones, empezando por las vigentes
+CREATE TABLE IF NOT EXISTS property_rating_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    property_id INTEGER NOT NULL,
+    rating TEXT NOT NULL,
+    score INTEGER,
+    reasons TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id)
+);
+CREATE INDEX IF NOT EXISTS idx_property_rating_history_user ON property_rating_history(user_id, property_id, created_at);
+
+INSERT INTO property_rating_history (user_id, property_id, rating, created_at)
+SELECT user_id, property_id, rating, created_at FROM property_ratings;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_property_rating_history_user;
+DROP TABLE IF EXISTS property_rating_history;
+DROP INDEX IF EXISTS idx_property_rating_reasons_reason;
+DROP TABLE IF EXISTS property_rating_reasons;
+DROP TABLE IF EXISTS rating_reasons;
+ALTER TABLE property_ratings DROP COLUMN updated_at;
+ALTER TABLE property_ratings DROP COLUMN score;
+-- +goose StatementEnd
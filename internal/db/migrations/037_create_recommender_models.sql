@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Modelo de recomendación de cada usuario, entrenado con sus calificaciones
+CREATE TABLE IF NOT EXISTS recommender_models (
+    user_id INTEGER PRIMARY KEY,
+    model TEXT NOT NULL,
+    samples INTEGER NOT NULL,
+    likes INTEGER NOT NULL,
+    dislikes INTEGER NOT NULL,
+    accuracy REAL,
+    trained_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS recommender_models;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- El historial de calificaciones pasa a registrar también cuando se deshace una calificación
+ALTER TABLE property_rating_history ADD COLUMN event TEXT NOT NULL DEFAULT 'rated' CHECK(event IN ('rated', 'unrated'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM property_rating_history WHERE event = 'unrated';
+ALTER TABLE property_rating_history DROP COLUMN event;
+-- +goose StatementEnd
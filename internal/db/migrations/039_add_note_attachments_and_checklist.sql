@@ -0,0 +1,56 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Archivos adjuntos a una nota (fotos de una visita)
+CREATE TABLE IF NOT EXISTS note_attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    note_id INTEGER NOT NULL,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    path TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (note_id) REFERENCES property_notes(id)
+);
+
+-- Puntos a revisar en una visita
+CREATE TABLE IF NOT EXISTS checklist_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL UNIQUE,
+    label TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT OR IGNORE INTO checklist_items (code, label, position) VALUES
+    ('humidity', 'Humedad', 1),
+    ('light', 'Luz', 2),
+    ('noise', 'Ruido', 3),
+    ('neighbours', 'Vecinos', 4),
+    ('plumbing', 'Plomería', 5);
+
+-- Respuestas del usuario a la lista de una propiedad
+CREATE TABLE IF NOT EXISTS property_checklist (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    property_id INTEGER NOT NULL,
+    item_id INTEGER NOT NULL,
+    answer TEXT NOT NULL CHECK(answer IN ('good', 'fair', 'bad')),
+    comment TEXT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    FOREIGN KEY (item_id) REFERENCES checklist_items(id),
+    UNIQUE(user_id, property_id, item_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_attachments_note ON note_attachments(note_id);
+CREATE INDEX IF NOT EXISTS idx_property_checklist_property ON property_checklist(property_id, item_id, answer);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_property_checklist_property;
+DROP INDEX IF EXISTS idx_note_attachments_note;
+DROP TABLE IF EXISTS property_checklist;
+DROP TABLE IF EXISTS checklist_items;
+DROP TABLE IF EXISTS note_attachments;
+-- +goose StatementEnd
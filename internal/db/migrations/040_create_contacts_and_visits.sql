@@ -0,0 +1,66 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Personas de contacto de cada inmobiliaria
+CREATE TABLE IF NOT EXISTS agency_contacts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    inmobiliaria_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    phone TEXT,
+    email TEXT,
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id)
+);
+
+-- Contactos con la inmobiliaria por una propiedad (llamadas, mensajes...)
+CREATE TABLE IF NOT EXISTS property_contacts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    property_id INTEGER NOT NULL,
+    inmobiliaria_id INTEGER NOT NULL,
+    contact_id INTEGER,
+    channel TEXT NOT NULL CHECK(channel IN ('phone', 'whatsapp', 'email', 'in_person', 'other')),
+    status TEXT NOT NULL DEFAULT 'waiting' CHECK(status IN ('waiting', 'answered', 'no_answer')),
+    outcome TEXT,
+    contacted_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id),
+    FOREIGN KEY (contact_id) REFERENCES agency_contacts(id)
+);
+
+-- Visitas a propiedades
+CREATE TABLE IF NOT EXISTS visits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    property_id INTEGER NOT NULL,
+    inmobiliaria_id INTEGER NOT NULL,
+    contact_id INTEGER,
+    scheduled_at TIMESTAMP NOT NULL,
+    duration_minutes INTEGER NOT NULL DEFAULT 30,
+    status TEXT NOT NULL DEFAULT 'scheduled' CHECK(status IN ('scheduled', 'done', 'cancelled', 'no_show')),
+    outcome TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id),
+    FOREIGN KEY (contact_id) REFERENCES agency_contacts(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_agency_contacts_inmobiliaria ON agency_contacts(inmobiliaria_id);
+CREATE INDEX IF NOT EXISTS idx_property_contacts_property ON property_contacts(user_id, property_id, contacted_at);
+CREATE INDEX IF NOT EXISTS idx_visits_user ON visits(user_id, scheduled_at);
+CREATE INDEX IF NOT EXISTS idx_visits_property ON visits(property_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_visits_property;
+DROP INDEX IF EXISTS idx_visits_user;
+DROP INDEX IF EXISTS idx_property_contacts_property;
+DROP INDEX IF EXISTS idx_agency_contacts_inmobiliaria;
+DROP TABLE IF EXISTS visits;
+DROP TABLE IF EXISTS property_contacts;
+DROP TABLE IF EXISTS agency_contacts;
+-- +goose StatementEnd
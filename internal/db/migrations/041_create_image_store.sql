@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Fotos descargadas, guardadas por el SHA-256 de su contenido
+CREATE TABLE IF NOT EXISTS image_blobs (
+    hash TEXT PRIMARY KEY,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    phash TEXT,                          -- Hash perceptual (dHash de 64 bits en hexadecimal)
+    has_thumb INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Fotos de cada propiedad y su descarga
+CREATE TABLE IF NOT EXISTS property_images (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    property_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'done', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    blob_hash TEXT,
+    duplicate_of INTEGER,                -- Foto de la misma propiedad casi idéntica
+    fetched_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(property_id, url),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    FOREIGN KEY (blob_hash) REFERENCES image_blobs(hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_property_images_status ON property_images(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_property_images_property ON property_images(property_id, position);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_property_images_property;
+DROP INDEX IF EXISTS idx_property_images_status;
+DROP TABLE IF EXISTS property_images;
+DROP TABLE IF EXISTS image_blobs;
+-- +goose StatementEnd
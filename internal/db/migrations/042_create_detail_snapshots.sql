@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- HTML de las fichas tal como se extrajeron, comprimido con gzip, para volver a procesarlas sin visitar los sitios
+CREATE TABLE IF NOT EXISTS detail_snapshots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    propiedad_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    content_hash TEXT NOT NULL,          -- SHA-256 del HTML sin comprimir
+    html BLOB NOT NULL,
+    size INTEGER NOT NULL,               -- Bytes sin comprimir
+    fetched_at TIMESTAMP NOT NULL,       -- Última descarga con este contenido
+    reparsed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (propiedad_id) REFERENCES propiedades(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_detail_snapshots_propiedad ON detail_snapshots(propiedad_id, fetched_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_detail_snapshots_propiedad;
+DROP TABLE IF EXISTS detail_snapshots;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Los campos modificados en cada re-extracción ya quedan en property_changes
+DROP INDEX IF EXISTS idx_property_refreshes_propiedad;
+DROP TABLE IF EXISTS property_refreshes;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS property_refreshes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    propiedad_id INTEGER NOT NULL,
+    changed_fields TEXT NOT NULL DEFAULT '[]',  -- Array JSON con los nombres de columna modificados
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (propiedad_id) REFERENCES propiedades(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_property_refreshes_propiedad ON property_refreshes(propiedad_id, created_at);
+-- +goose StatementEnd
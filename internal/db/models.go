@@ -2,6 +2,55 @@ package db
 
 import "time"
 
+// User es un usuario de la aplicación. Las calificaciones, favoritos, notas
+// y búsquedas guardadas son de cada usuario.
+type User struct {
+	ID        int64     `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	Role      string    `db:"role" json:"role"` // viewer, member o admin
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+
+	HouseholdID *int64 `db:"household_id" json:"household_id,omitempty"` // Hogar con el que decide en conjunto
+}
+
+// Household es un grupo de usuarios que deciden juntos: cada miembro vota con su
+// propia calificación y las propiedades muestran el resultado combinado
+type Household struct {
+	ID        int64     `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	Members   []User    `db:"-" json:"members"`
+}
+
+// HouseholdVote es el voto de un miembro del hogar sobre una propiedad; Rating vacío si no votó
+type HouseholdVote struct {
+	UserID   int64  `json:"user_id"`
+	UserName string `json:"user_name"`
+	Rating   string `json:"rating,omitempty"`
+}
+
+// HouseholdVotes es el resultado combinado de los votos del hogar sobre una propiedad
+type HouseholdVotes struct {
+	PropertyID int64           `json:"property_id"`
+	Outcome    string          `json:"outcome"` // match, split, vetoed o pending
+	Likes      int             `json:"likes"`
+	Dislikes   int             `json:"dislikes"`
+	Pending    int             `json:"pending"` // Miembros que todavía no votaron
+	Votes      []HouseholdVote `json:"votes"`
+}
+
+// APIKey es una clave con la que un script accede a la API en nombre de un usuario.
+// Solo se guarda el hash de la clave.
+type APIKey struct {
+	ID         int64      `db:"id" json:"id"`
+	UserID     int64      `db:"user_id" json:"user_id"`
+	Name       string     `db:"name" json:"name"`
+	Prefix     string     `db:"prefix" json:"prefix"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
+
 // Inmobiliaria representa una inmobiliaria en la base de datos
 type Inmobiliaria struct {
 	ID        int64     `db:"id" json:"id"`
@@ -12,10 +61,27 @@ type Inmobiliaria struct {
 	Rating    float64   `db:"rating" json:"rating"`
 	Direccion string    `db:"direccion" json:"address"`
 	Telefono  string    `db:"telefono" json:"phone"`
+	Disabled  bool      `db:"disabled" json:"disabled"`
 	CreatedAt time.Time `db:"created_at" json:"createdAt"`
 	UpdatedAt time.Time `db:"updated_at" json:"updatedAt"`
 }
 
+// ScrapeRun representa una ejecución del scraper sobre una inmobiliaria
+type ScrapeRun struct {
+	ID               int64      `db:"id" json:"id"`
+	InmobiliariaID   int64      `db:"inmobiliaria_id" json:"agency_id"`
+	RunType          string     `db:"run_type" json:"run_type"` // 'search' o 'details'
+	Status           string     `db:"status" json:"status"`     // 'running', 'success' o 'failed'
+	StartedAt        time.Time  `db:"started_at" json:"started_at"`
+	FinishedAt       *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+	ListingsFound    int        `db:"listings_found" json:"listings_found"`
+	NewCount         int        `db:"new_count" json:"new"`
+	UpdatedCount     int        `db:"updated_count" json:"updated"`
+	FailedCount      int        `db:"failed_count" json:"failed"`
+	UnavailableCount int        `db:"unavailable_count" json:"unavailable"`
+	LastError        string     `db:"last_error" json:"last_error,omitempty"`
+}
+
 // Busqueda representa una búsqueda realizada
 type Busqueda struct {
 	ID           int64     `db:"id"`
@@ -28,6 +94,19 @@ type Busqueda struct {
 	CreatedAt    time.Time `db:"created_at"`
 }
 
+// SavedSearch es una búsqueda guardada con un filtro de propiedades.
+// Se guarda en busquedas; las propiedades que coincidieron quedan en busquedas_propiedades.
+type SavedSearch struct {
+	ID        int64          `db:"id" json:"id"`
+	UserID    int64          `db:"user_id" json:"user_id"`
+	Name      string         `db:"name" json:"name"`
+	Filter    PropertyFilter `db:"filter" json:"filter"`
+	CreatedAt time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at" json:"updated_at"`
+	LastRunAt *time.Time     `db:"last_run_at" json:"last_run_at,omitempty"`
+	Matches   int            `db:"-" json:"matches"` // Propiedades que coincidieron hasta ahora
+}
+
 // Propiedad representa una propiedad en la base de datos
 type Propiedad struct {
 	ID             int64     `db:"id"`
@@ -68,6 +147,9 @@ type Propiedad struct {
 	Latitud            *float64 `db:"latitud"`
 	Longitud           *float64 `db:"longitud"`
 
+	// Categoría del último error al extraer los detalles (ver scrapeerr), NULL si no falló
+	ErrorCategory *string `db:"error_category"`
+
 	// Campo virtual para indicar si es favorita
 	IsFavorite bool `db:"-"`
 
@@ -84,20 +166,191 @@ type BusquedaPropiedad struct {
 
 // PropertyRating representa una calificación de propiedad en la base de datos
 type PropertyRating struct {
-	ID         int64     `db:"id"`
-	PropertyID int64     `db:"property_id"`
-	Rating     string    `db:"rating"` // 'like' o 'dislike'
-	IsFavorite bool      `db:"is_favorite"`
-	CreatedAt  time.Time `db:"created_at"`
+	ID         int64     `db:"id" json:"id"`
+	UserID     int64     `db:"user_id" json:"user_id"`
+	PropertyID int64     `db:"property_id" json:"property_id"`
+	Rating     string    `db:"rating" json:"rating"`         // 'like' o 'dislike'
+	Score      *int      `db:"score" json:"score,omitempty"` // De 1 a 5 estrellas, solo para los like
+	Reasons    []string  `json:"reasons"`                    // Códigos de rating_reasons
+	IsFavorite bool      `db:"is_favorite" json:"is_favorite"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"` // Primera calificación
+	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"` // Última calificación
+}
+
+// RatingReason es un motivo de la lista administrada que se puede asociar a una calificación
+type RatingReason struct {
+	ID        int64     `db:"id" json:"id"`
+	Code      string    `db:"code" json:"code"`
+	Label     string    `db:"label" json:"label"`
+	Active    bool      `db:"active" json:"active"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// RatingHistoryEntry es un evento del registro de calificaciones: una calificación o una
+// calificación deshecha, con los valores que tenía
+type RatingHistoryEntry struct {
+	ID         int64     `db:"id" json:"id"`
+	UserID     int64     `db:"user_id" json:"user_id"`
+	PropertyID int64     `db:"property_id" json:"property_id"`
+	Event      string    `db:"event" json:"event"` // 'rated' o 'unrated'
+	Rating     string    `db:"rating" json:"rating"`
+	Score      *int      `db:"score" json:"score,omitempty"`
+	Reasons    []string  `json:"reasons"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	// Indica si es la calificación vigente: el último evento de la propiedad y no fue deshecha
+	Current bool `json:"current"`
+}
+
+// RecommenderModel es el modelo de recomendación entrenado con las calificaciones de un usuario.
+// Model guarda los pesos serializados por el paquete recommender.
+type RecommenderModel struct {
+	UserID    int64     `db:"user_id" json:"user_id"`
+	Model     string    `db:"model" json:"-"`
+	Samples   int       `db:"samples" json:"samples"`
+	Likes     int       `db:"likes" json:"likes"`
+	Dislikes  int       `db:"dislikes" json:"dislikes"`
+	Accuracy  float64   `db:"accuracy" json:"accuracy"` // Aciertos sobre las propias calificaciones de entrenamiento
+	TrainedAt time.Time `db:"trained_at" json:"trained_at"`
 }
 
 // PropertyNote representa una nota de propiedad en la base de datos
 type PropertyNote struct {
 	ID         int64     `db:"id" json:"id"`
+	UserID     int64     `db:"user_id" json:"user_id"`
 	PropertyID int64     `db:"property_id" json:"property_id"`
 	Text       string    `db:"note" json:"text"`
 	CreatedAt  time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"`
+
+	Attachments []NoteAttachment `json:"attachments"`
+}
+
+// NoteAttachment es un archivo adjunto a una nota, por ejemplo una foto de la visita
+type NoteAttachment struct {
+	ID          int64     `db:"id" json:"id"`
+	NoteID      int64     `db:"note_id" json:"note_id"`
+	FileName    string    `db:"file_name" json:"file_name"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Size        int64     `db:"size" json:"size"`
+	Path        string    `db:"path" json:"-"` // Relativo al directorio de adjuntos
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// ChecklistItem es un punto a revisar en una visita (humedad, luz, ruido...)
+type ChecklistItem struct {
+	ID       int64  `db:"id" json:"id"`
+	Code     string `db:"code" json:"code"`
+	Label    string `db:"label" json:"label"`
+	Position int    `db:"position" json:"position"`
+}
+
+// ChecklistAnswer es la respuesta del usuario a un punto de la lista para una propiedad.
+// Answer vacío indica que todavía no lo revisó.
+type ChecklistAnswer struct {
+	Item      string     `json:"item"`
+	Label     string     `json:"label"`
+	Answer    string     `json:"answer"` // 'good', 'fair' o 'bad'
+	Comment   string     `json:"comment,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// AgencyContact es una persona de contacto de una inmobiliaria
+type AgencyContact struct {
+	ID             int64     `db:"id" json:"id"`
+	InmobiliariaID int64     `db:"inmobiliaria_id" json:"agency_id"`
+	Name           string    `db:"name" json:"name"`
+	Phone          string    `db:"phone" json:"phone,omitempty"`
+	Email          string    `db:"email" json:"email,omitempty"`
+	Notes          string    `db:"notes" json:"notes,omitempty"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// PropertyContact es un contacto del usuario con la inmobiliaria por una propiedad
+type PropertyContact struct {
+	ID             int64     `db:"id" json:"id"`
+	UserID         int64     `db:"user_id" json:"user_id"`
+	PropertyID     int64     `db:"property_id" json:"property_id"`
+	InmobiliariaID int64     `db:"inmobiliaria_id" json:"agency_id"`
+	ContactID      *int64    `db:"contact_id" json:"contact_id,omitempty"`
+	ContactName    string    `json:"contact_name,omitempty"`
+	Channel        string    `db:"channel" json:"channel"` // 'phone', 'whatsapp', 'email', 'in_person' u 'other'
+	Status         string    `db:"status" json:"status"`   // 'waiting', 'answered' o 'no_answer'
+	Outcome        string    `db:"outcome" json:"outcome,omitempty"`
+	ContactedAt    time.Time `db:"contacted_at" json:"contacted_at"`
+}
+
+// Visit es una visita a una propiedad, con los datos de la propiedad, la inmobiliaria y el contacto
+type Visit struct {
+	ID              int64     `db:"id" json:"id"`
+	UserID          int64     `db:"user_id" json:"user_id"`
+	PropertyID      int64     `db:"property_id" json:"property_id"`
+	InmobiliariaID  int64     `db:"inmobiliaria_id" json:"agency_id"`
+	ContactID       *int64    `db:"contact_id" json:"contact_id,omitempty"`
+	ScheduledAt     time.Time `db:"scheduled_at" json:"scheduled_at"`
+	DurationMinutes int       `db:"duration_minutes" json:"duration_minutes"`
+	Status          string    `db:"status" json:"status"` // 'scheduled', 'done', 'cancelled' o 'no_show'
+	Outcome         string    `db:"outcome" json:"outcome,omitempty"`
+	CreatedAt       time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time `db:"updated_at" json:"updated_at"`
+
+	PropertyTitle   string `json:"property_title"`
+	PropertyAddress string `json:"property_address,omitempty"`
+	PropertyURL     string `json:"property_url,omitempty"`
+	AgencyName      string `json:"agency_name"`
+	ContactName     string `json:"contact_name,omitempty"`
+	ContactPhone    string `json:"contact_phone,omitempty"`
+}
+
+// PropertyChange es el cambio de un campo de una propiedad entre dos actualizaciones
+type PropertyChange struct {
+	ID         int64     `db:"id" json:"id"`
+	PropertyID int64     `db:"propiedad_id" json:"property_id"`
+	Field      string    `db:"field" json:"field"`
+	OldValue   string    `db:"old_value" json:"old_value"`
+	NewValue   string    `db:"new_value" json:"new_value"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+// ImageBlob es una foto descargada, identificada por el SHA-256 de su contenido
+type ImageBlob struct {
+	Hash        string    `db:"hash" json:"hash"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Size        int64     `db:"size" json:"size"`
+	Width       int       `db:"width" json:"width,omitempty"`
+	Height      int       `db:"height" json:"height,omitempty"`
+	PHash       string    `db:"phash" json:"phash,omitempty"`
+	HasThumb    bool      `db:"has_thumb" json:"has_thumb"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// PropertyImage es una foto de una propiedad y el estado de su descarga
+type PropertyImage struct {
+	ID            int64      `db:"id" json:"id"`
+	PropertyID    int64      `db:"property_id" json:"property_id"`
+	URL           string     `db:"url" json:"url"`
+	Position      int        `db:"position" json:"position"`
+	Status        string     `db:"status" json:"status"` // 'pending', 'done' o 'failed'
+	Attempts      int        `db:"attempts" json:"attempts"`
+	NextAttemptAt time.Time  `db:"next_attempt_at" json:"-"`
+	LastError     string     `db:"last_error" json:"last_error,omitempty"`
+	BlobHash      string     `db:"blob_hash" json:"hash,omitempty"`
+	DuplicateOf   *int64     `db:"duplicate_of" json:"duplicate_of,omitempty"`
+	FetchedAt     *time.Time `db:"fetched_at" json:"fetched_at,omitempty"`
+
+	Blob *ImageBlob `db:"-" json:"blob,omitempty"`
+}
+
+// ListingSignals son indicadores de una publicación calculados a partir de su historial de cambios
+type ListingSignals struct {
+	FirstSeenAt  time.Time `json:"first_seen_at"`
+	DaysOnMarket int       `json:"days_on_market"` // Desde que se vio por primera vez hasta hoy o hasta su baja
+	PriceDrops   int       `json:"price_drops"`
+	// Baja total desde el primer precio publicado, en porcentaje; 0 si no bajó o cambió de moneda
+	PriceDropPct    float64    `json:"price_drop_pct,omitempty"`
+	LastPriceDropAt *time.Time `json:"last_price_drop_at,omitempty"`
+	// Se dio de baja y volvió a aparecer en el listado de la inmobiliaria
+	Relisted   bool       `json:"relisted"`
+	RelistedAt *time.Time `json:"relisted_at,omitempty"`
 }
 
 // PropertyFilter representa los filtros aplicables a las propiedades
@@ -131,6 +384,20 @@ type PropertyFilter struct {
 	AgencyIDs         []int64  `json:"agencies"`       // IDs de inmobiliarias
 	ShowOnlyWithNotes bool     `json:"show_only_with_notes"`
 	ShowOnlyFavorites bool     `json:"show_only_favorites"`
+	Reasons           []string `json:"reasons"` // Códigos de motivos de la calificación del usuario
+
+	// Respuestas de la lista de visita del usuario, por código de punto (por ejemplo humidity: good)
+	Checklist map[string]string `json:"checklist"`
+
+	// Indicadores de la publicación: bajas de precio, días publicada y republicación
+	PriceDropDays   *int  `json:"price_drop_days"` // Bajó de precio en los últimos N días
+	MinPriceDrops   *int  `json:"min_price_drops"`
+	MinDaysOnMarket *int  `json:"min_days_on_market"`
+	MaxDaysOnMarket *int  `json:"max_days_on_market"`
+	Relisted        *bool `json:"relisted"`
+
+	// Usuario cuyas notas, favoritos, motivos y lista de visita se usan en los filtros
+	UserID int64 `json:"-"`
 }
 
 // PropertyFeature representa una característica de una propiedad
@@ -0,0 +1,259 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Respuestas posibles de un punto de la lista de visita
+const (
+	ChecklistGood = "good"
+	ChecklistFair = "fair"
+	ChecklistBad  = "bad"
+)
+
+// ValidChecklistAnswer indica si answer es una respuesta conocida
+func ValidChecklistAnswer(answer string) bool {
+	switch answer {
+	case ChecklistGood, ChecklistFair, ChecklistBad:
+		return true
+	}
+	return false
+}
+
+// GetPropertyNote obtiene una nota de un usuario con sus adjuntos. Devuelve sql.ErrNoRows
+// si la nota no existe o es de otro usuario.
+func (db *DB) GetPropertyNote(userID, noteID int64) (*PropertyNote, error) {
+	query := `
+		SELECT id, user_id, property_id, note, created_at, updated_at
+		FROM property_notes
+		WHERE id = ? AND user_id = ?`
+
+	var note PropertyNote
+	err := db.QueryRow(query, noteID, userID).Scan(&note.ID, &note.UserID, &note.PropertyID, &note.Text, &note.CreatedAt, &note.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("error getting property note %d: %w", noteID, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting property note %d: %v", noteID, err)
+	}
+
+	notes := []PropertyNote{note}
+	if err := db.loadNoteAttachments(notes); err != nil {
+		return nil, err
+	}
+
+	return &notes[0], nil
+}
+
+// UpdatePropertyNote cambia el texto de una nota de un usuario. Devuelve sql.ErrNoRows
+// si la nota no existe o es de otro usuario.
+func (db *DB) UpdatePropertyNote(userID, noteID int64, text string) (*PropertyNote, error) {
+	query := `UPDATE property_notes SET note = ?, updated_at = ? WHERE id = ? AND user_id = ?`
+
+	result, err := db.Exec(query, text, time.Now().UTC(), noteID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating property note: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("error updating property note %d: %w", noteID, sql.ErrNoRows)
+	}
+
+	return db.GetPropertyNote(userID, noteID)
+}
+
+// loadNoteAttachments completa los adjuntos de las notas
+func (db *DB) loadNoteAttachments(notes []PropertyNote) error {
+	if len(notes) == 0 {
+		return nil
+	}
+
+	indices := make(map[int64]int, len(notes))
+	placeholders := make([]string, len(notes))
+	args := make([]interface{}, len(notes))
+	for i := range notes {
+		notes[i].Attachments = []NoteAttachment{}
+		indices[notes[i].ID] = i
+		placeholders[i] = "?"
+		args[i] = notes[i].ID
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, note_id, file_name, content_type, size, path, created_at
+		FROM note_attachments
+		WHERE note_id IN (%s)
+		ORDER BY id`, strings.Join(placeholders, ","))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("error getting note attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a NoteAttachment
+		if err := rows.Scan(&a.ID, &a.NoteID, &a.FileName, &a.ContentType, &a.Size, &a.Path, &a.CreatedAt); err != nil {
+			return fmt.Errorf("error scanning note attachment: %w", err)
+		}
+		i := indices[a.NoteID]
+		notes[i].Attachments = append(notes[i].Attachments, a)
+	}
+
+	return rows.Err()
+}
+
+// AddNoteAttachment registra un archivo ya guardado como adjunto de una nota
+func (db *DB) AddNoteAttachment(a *NoteAttachment) error {
+	query := `
+		INSERT INTO note_attachments (note_id, file_name, content_type, size, path, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		RETURNING id, created_at`
+
+	err := db.QueryRow(query, a.NoteID, a.FileName, a.ContentType, a.Size, a.Path, time.Now().UTC()).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error adding note attachment: %w", err)
+	}
+
+	return nil
+}
+
+// GetNoteAttachment obtiene un adjunto de una nota del usuario. Devuelve sql.ErrNoRows
+// si no existe o la nota es de otro usuario.
+func (db *DB) GetNoteAttachment(userID, attachmentID int64) (*NoteAttachment, error) {
+	query := `
+		SELECT a.id, a.note_id, a.file_name, a.content_type, a.size, a.path, a.created_at
+		FROM note_attachments a
+		JOIN property_notes n ON n.id = a.note_id
+		WHERE a.id = ? AND n.user_id = ?`
+
+	var a NoteAttachment
+	err := db.QueryRow(query, attachmentID, userID).Scan(&a.ID, &a.NoteID, &a.FileName, &a.ContentType, &a.Size, &a.Path, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("error getting note attachment %d: %w", attachmentID, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting note attachment %d: %v", attachmentID, err)
+	}
+
+	return &a, nil
+}
+
+// DeleteNoteAttachment elimina el registro de un adjunto de una nota del usuario y lo
+// devuelve, para borrar el archivo. Devuelve sql.ErrNoRows si no existe o es de otro usuario.
+func (db *DB) DeleteNoteAttachment(userID, attachmentID int64) (*NoteAttachment, error) {
+	a, err := db.GetNoteAttachment(userID, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`DELETE FROM note_attachments WHERE id = ?`, a.ID); err != nil {
+		return nil, fmt.Errorf("error deleting note attachment %d: %w", a.ID, err)
+	}
+
+	return a, nil
+}
+
+// GetChecklistItems devuelve los puntos de la lista de visita en orden
+func (db *DB) GetChecklistItems() ([]ChecklistItem, error) {
+	rows, err := db.Query(`SELECT id, code, label, position FROM checklist_items ORDER BY position, id`)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando lista de visita: %v", err)
+	}
+	defer rows.Close()
+
+	items := []ChecklistItem{}
+	for rows.Next() {
+		var item ChecklistItem
+		if err := rows.Scan(&item.ID, &item.Code, &item.Label, &item.Position); err != nil {
+			return nil, fmt.Errorf("error escaneando punto de la lista de visita: %v", err)
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// GetPropertyChecklist devuelve todos los puntos de la lista de visita con las respuestas
+// del usuario para una propiedad; los que no revisó quedan sin respuesta
+func (db *DB) GetPropertyChecklist(userID, propertyID int64) ([]ChecklistAnswer, error) {
+	query := `
+		SELECT ci.code, ci.label, COALESCE(pc.answer, ''), COALESCE(pc.comment, ''), pc.updated_at
+		FROM checklist_items ci
+		LEFT JOIN property_checklist pc ON pc.item_id = ci.id AND pc.user_id = ? AND pc.property_id = ?
+		ORDER BY ci.position, ci.id`
+
+	rows, err := db.Query(query, userID, propertyID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando lista de visita de la propiedad %d: %v", propertyID, err)
+	}
+	defer rows.Close()
+
+	answers := []ChecklistAnswer{}
+	for rows.Next() {
+		var a ChecklistAnswer
+		if err := rows.Scan(&a.Item, &a.Label, &a.Answer, &a.Comment, &a.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error escaneando respuesta de la lista de visita: %v", err)
+		}
+		answers = append(answers, a)
+	}
+
+	return answers, rows.Err()
+}
+
+// SetPropertyChecklist guarda respuestas del usuario a la lista de visita de una propiedad.
+// Solo cambia los puntos incluidos; una respuesta vacía borra la que había.
+func (db *DB) SetPropertyChecklist(userID, propertyID int64, answers []ChecklistAnswer) error {
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM propiedades WHERE id = ?)", propertyID).Scan(&exists); err != nil {
+		return fmt.Errorf("error verificando propiedad %d: %v", propertyID, err)
+	}
+	if !exists {
+		return fmt.Errorf("la propiedad %d no existe: %w", propertyID, sql.ErrNoRows)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, a := range answers {
+		var itemID int64
+		err := tx.QueryRow(`SELECT id FROM checklist_items WHERE code = ?`, a.Item).Scan(&itemID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("punto de la lista de visita desconocido: %s", a.Item)
+		}
+		if err != nil {
+			return fmt.Errorf("error verificando punto %s: %v", a.Item, err)
+		}
+
+		if a.Answer == "" {
+			_, err = tx.Exec(`DELETE FROM property_checklist WHERE user_id = ? AND property_id = ? AND item_id = ?`,
+				userID, propertyID, itemID)
+			if err != nil {
+				return fmt.Errorf("error borrando respuesta %s: %v", a.Item, err)
+			}
+			continue
+		}
+		if !ValidChecklistAnswer(a.Answer) {
+			return fmt.Errorf("respuesta inválida para %s: %s", a.Item, a.Answer)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO property_checklist (user_id, property_id, item_id, answer, comment, updated_at)
+			VALUES (?, ?, ?, ?, NULLIF(?, ''), ?)
+			ON CONFLICT(user_id, property_id, item_id) DO UPDATE SET
+				answer = excluded.answer,
+				comment = excluded.comment,
+				updated_at = excluded.updated_at`,
+			userID, propertyID, itemID, a.Answer, strings.TrimSpace(a.Comment), now)
+		if err != nil {
+			return fmt.Errorf("error guardando respuesta %s: %v", a.Item, err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Tipos de eventos notificables
+const (
+	NotificationNewMatch    = "new_match"    // Una propiedad empezó a coincidir con una búsqueda guardada
+	NotificationPriceChange = "price_change" // Cambió el precio de una propiedad que coincide con una búsqueda guardada
+)
+
+// NotificationEvent es un evento de una búsqueda guardada pendiente de notificar en un canal
+type NotificationEvent struct {
+	Key        string    `json:"key"`
+	Kind       string    `json:"kind"`
+	SearchID   int64     `json:"search_id"`
+	SearchName string    `json:"search"`
+	PropertyID int64     `json:"property_id"`
+	Title      string    `json:"title"`
+	Price      string    `json:"price"`
+	OldPrice   string    `json:"old_price,omitempty"`
+	Currency   string    `json:"currency"`
+	Location   string    `json:"location"`
+	URL        string    `json:"url"`
+	Agency     string    `json:"agency"`
+	At         time.Time `json:"at"`
+}
+
+// columnasEventoPropiedad son las columnas de la propiedad que se incluyen en una notificación
+const columnasEventoPropiedad = `
+	p.id, COALESCE(p.titulo, ''), COALESCE(p.precio, ''), COALESCE(p.moneda, ''),
+	COALESCE(NULLIF(p.ubicacion, ''), p.direccion, ''), COALESCE(p.url, ''), COALESCE(i.nombre, '')`
+
+// GetNotificationEvents devuelve los eventos desde since que todavía no se enviaron por channel,
+// del más antiguo al más reciente. Las fechas se comparan con datetime() porque las columnas
+// tienen valores escritos tanto por CURRENT_TIMESTAMP como desde Go.
+func (db *DB) GetNotificationEvents(channel string, since time.Time) ([]NotificationEvent, error) {
+	// Propiedades que empezaron a coincidir con una búsqueda
+	query := `
+		SELECT 'new:' || b.id || ':' || p.id, b.id, COALESCE(b.name, ''), bp.created_at, ` + columnasEventoPropiedad + `
+		FROM busquedas_propiedades bp
+		JOIN busquedas b ON b.id = bp.busqueda_id
+		JOIN propiedades p ON p.id = bp.propiedad_id
+		LEFT JOIN inmobiliarias i ON i.id = p.inmobiliaria_id
+		WHERE b.filter IS NOT NULL
+		AND NOT COALESCE(bp.initial, 0)
+		AND datetime(bp.created_at) >= ?
+		AND COALESCE(p.status, '') != 'unavailable'
+		AND NOT EXISTS (
+			SELECT 1 FROM notifications_sent n
+			WHERE n.channel = ? AND n.event_key = 'new:' || b.id || ':' || p.id
+		)`
+
+	rows, err := db.Query(query, sqliteTime(since), channel)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando coincidencias a notificar: %v", err)
+	}
+
+	var eventos []NotificationEvent
+	for rows.Next() {
+		e := NotificationEvent{Kind: NotificationNewMatch}
+		err := rows.Scan(&e.Key, &e.SearchID, &e.SearchName, &e.At,
+			&e.PropertyID, &e.Title, &e.Price, &e.Currency, &e.Location, &e.URL, &e.Agency)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error escaneando coincidencia a notificar: %v", err)
+		}
+		eventos = append(eventos, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterando coincidencias a notificar: %v", err)
+	}
+
+	// Cambios de precio de propiedades que coinciden con cada búsqueda
+	busquedas, err := db.GetSavedSearches()
+	if err != nil {
+		return nil, err
+	}
+	for _, busqueda := range busquedas {
+		cambios, err := db.priceChangeEvents(channel, &busqueda, since)
+		if err != nil {
+			return nil, err
+		}
+		eventos = append(eventos, cambios...)
+	}
+
+	sort.SliceStable(eventos, func(i, j int) bool { return eventos[i].At.Before(eventos[j].At) })
+	return eventos, nil
+}
+
+// priceChangeEvents devuelve los cambios de precio desde since de las propiedades que coinciden con una búsqueda
+func (db *DB) priceChangeEvents(channel string, busqueda *SavedSearch, since time.Time) ([]NotificationEvent, error) {
+	condicion, args := condicionBusquedaGuardada(&busqueda.Filter)
+	clave := fmt.Sprintf("'price:%d:' || pc.id", busqueda.ID)
+
+	query := `
+		SELECT ` + clave + `, COALESCE(pc.old_value, ''), pc.created_at, ` + columnasEventoPropiedad + `
+		FROM property_changes pc
+		JOIN propiedades p ON p.id = pc.propiedad_id
+		LEFT JOIN inmobiliarias i ON i.id = p.inmobiliaria_id
+		WHERE pc.field = 'precio'
+		AND datetime(pc.created_at) >= ?
+		AND ` + condicion + `
+		AND NOT EXISTS (
+			SELECT 1 FROM notifications_sent n
+			WHERE n.channel = ? AND n.event_key = ` + clave + `
+		)`
+
+	args = append([]interface{}{sqliteTime(since)}, args...)
+	args = append(args, channel)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando cambios de precio a notificar: %v", err)
+	}
+	defer rows.Close()
+
+	var eventos []NotificationEvent
+	for rows.Next() {
+		e := NotificationEvent{Kind: NotificationPriceChange, SearchID: busqueda.ID, SearchName: busqueda.Name}
+		err := rows.Scan(&e.Key, &e.OldPrice, &e.At,
+			&e.PropertyID, &e.Title, &e.Price, &e.Currency, &e.Location, &e.URL, &e.Agency)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando cambio de precio a notificar: %v", err)
+		}
+		eventos = append(eventos, e)
+	}
+
+	return eventos, rows.Err()
+}
+
+// LastNotificationSent devuelve cuándo se envió la última notificación por channel.
+// Devuelve false si nunca se envió ninguna.
+func (db *DB) LastNotificationSent(channel string) (time.Time, bool, error) {
+	query := `SELECT sent_at FROM notifications_sent WHERE channel = ? ORDER BY sent_at DESC LIMIT 1`
+
+	var last time.Time
+	err := db.QueryRow(query, channel).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("error consultando notificaciones de %s: %v", channel, err)
+	}
+	return last, true, nil
+}
+
+// RecordNotificationsSent registra los eventos enviados por channel
+func (db *DB) RecordNotificationsSent(channel string, eventos []NotificationEvent) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	query := `
+		INSERT OR IGNORE INTO notifications_sent (channel, event_key, busqueda_id, propiedad_id, sent_at)
+		VALUES (?, ?, ?, ?, ?)`
+
+	for _, e := range eventos {
+		if _, err := tx.Exec(query, channel, e.Key, e.SearchID, e.PropertyID, now); err != nil {
+			return fmt.Errorf("error registrando notificación %s: %v", e.Key, err)
+		}
+	}
+
+	return tx.Commit()
+}
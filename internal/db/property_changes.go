@@ -0,0 +1,244 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// campoDetalle es el valor de una columna de detalles de una propiedad, normalizado como texto
+type campoDetalle struct {
+	columna string
+	valor   string
+}
+
+// camposDetalles devuelve las columnas que mantiene la extracción de detalles
+func camposDetalles(p *Propiedad) []campoDetalle {
+	var imagenes string
+	if p.Imagenes != nil && len(*p.Imagenes) > 0 {
+		data, _ := json.Marshal(*p.Imagenes)
+		imagenes = string(data)
+	}
+
+	return []campoDetalle{
+		{"tipo_propiedad", textoInt64(p.TipoPropiedad)},
+		{"imagenes", imagenes},
+		{"ubicacion", textoString(p.Ubicacion)},
+		{"dormitorios", textoInt(p.Dormitorios)},
+		{"banios", textoInt(p.Banios)},
+		{"antiguedad", textoInt(p.Antiguedad)},
+		{"superficie_cubierta", textoFloat(p.SuperficieCubierta)},
+		{"superficie_total", textoFloat(p.SuperficieTotal)},
+		{"superficie_terreno", textoFloat(p.SuperficieTerreno)},
+		{"frente", textoFloat(p.Frente)},
+		{"fondo", textoFloat(p.Fondo)},
+		{"ambientes", textoInt(p.Ambientes)},
+		{"plantas", textoInt(p.Plantas)},
+		{"cocheras", textoInt(p.Cocheras)},
+		{"situacion", textoString(p.Situacion)},
+		{"expensas", textoFloat(p.Expensas)},
+		{"descripcion", textoString(p.Descripcion)},
+		{"operacion", textoString(p.Operacion)},
+		{"condicion", textoString(p.Condicion)},
+		{"orientacion", textoString(p.Orientacion)},
+		{"disposicion", textoString(p.Disposicion)},
+		{"latitud", textoFloat(p.Latitud)},
+		{"longitud", textoFloat(p.Longitud)},
+	}
+}
+
+// camposListado devuelve las columnas que mantiene el listado de la inmobiliaria
+func camposListado(p *Propiedad) []campoDetalle {
+	return []campoDetalle{
+		{"titulo", p.Titulo},
+		{"precio", p.Precio},
+		{"moneda", p.Moneda},
+		{"direccion", p.Direccion},
+		{"url", p.URL},
+		{"imagen_url", p.ImagenURL},
+		{"status", p.Status},
+	}
+}
+
+// compararCampos devuelve los cambios entre dos versiones de los mismos campos
+func compararCampos(propiedadID int64, anteriores, nuevos []campoDetalle) []PropertyChange {
+	cambios := []PropertyChange{}
+	for i := range nuevos {
+		if anteriores[i].valor != nuevos[i].valor {
+			cambios = append(cambios, PropertyChange{
+				PropertyID: propiedadID,
+				Field:      nuevos[i].columna,
+				OldValue:   anteriores[i].valor,
+				NewValue:   nuevos[i].valor,
+			})
+		}
+	}
+	return cambios
+}
+
+// insertPropertyChanges guarda los cambios por campo de una propiedad
+func insertPropertyChanges(tx *sql.Tx, cambios []PropertyChange) error {
+	for _, cambio := range cambios {
+		query := `INSERT INTO property_changes (propiedad_id, field, old_value, new_value) VALUES (?, ?, ?, ?)`
+		if _, err := tx.Exec(query, cambio.PropertyID, cambio.Field, cambio.OldValue, cambio.NewValue); err != nil {
+			return fmt.Errorf("error registrando cambio de %s en la propiedad %d: %v", cambio.Field, cambio.PropertyID, err)
+		}
+	}
+	return nil
+}
+
+// GetPropertyChanges devuelve el historial de cambios de una propiedad, del más reciente al más antiguo.
+// Si field no está vacío, solo devuelve los cambios de ese campo.
+func (db *DB) GetPropertyChanges(propiedadID int64, field string) ([]PropertyChange, error) {
+	query := `
+		SELECT id, propiedad_id, field, COALESCE(old_value, ''), COALESCE(new_value, ''), created_at
+		FROM property_changes
+		WHERE propiedad_id = ? AND (? = '' OR field = ?)
+		ORDER BY created_at DESC, id DESC`
+
+	rows, err := db.Query(query, propiedadID, field, field)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando historial de la propiedad %d: %v", propiedadID, err)
+	}
+	defer rows.Close()
+
+	cambios := []PropertyChange{}
+	for rows.Next() {
+		var cambio PropertyChange
+		err := rows.Scan(&cambio.ID, &cambio.PropertyID, &cambio.Field, &cambio.OldValue, &cambio.NewValue, &cambio.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando cambio: %v", err)
+		}
+		cambios = append(cambios, cambio)
+	}
+
+	return cambios, rows.Err()
+}
+
+// precioSQL es la expresión SQL que extrae el valor numérico de una columna de precio
+func precioSQL(columna string) string {
+	return fmt.Sprintf(`CAST(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(%s, 'USD', ''), 'U$S', ''), '$', ''), '.', ''), ',', '') AS NUMERIC)`, columna)
+}
+
+// esUSDSQL es la condición SQL de un precio publicado en dólares
+func esUSDSQL(columna string) string {
+	return fmt.Sprintf("(%[1]s LIKE '%%USD%%' OR %[1]s LIKE '%%U$S%%')", columna)
+}
+
+// bajaDePrecioSQL es la condición de un cambio de precio c que bajó sin cambiar de moneda.
+// Como ParsePrecio, descarta los precios sin número (ej: "Consultar"), que se convierten en 0.
+var bajaDePrecioSQL = fmt.Sprintf("%[1]s = %[2]s AND %[3]s > 0 AND %[4]s > 0 AND %[3]s < %[4]s",
+	esUSDSQL("c.old_value"), esUSDSQL("c.new_value"), precioSQL("c.new_value"), precioSQL("c.old_value"))
+
+// diasPublicadaSQL son los días que la propiedad p estuvo publicada: hasta hoy, o hasta su
+// última baja si sigue dada de baja
+const diasPublicadaSQL = `(julianday(CASE WHEN p.status = 'unavailable' THEN COALESCE((
+	SELECT MAX(c.created_at) FROM property_changes c
+	WHERE c.propiedad_id = p.id AND c.field = 'status' AND c.new_value = 'unavailable'
+), 'now') ELSE 'now' END) - julianday(p.created_at))`
+
+// GetListingSignals calcula los días publicada, las bajas de precio y si la propiedad se republicó.
+// Devuelve sql.ErrNoRows si la propiedad no existe.
+func (db *DB) GetListingSignals(propiedadID int64) (*ListingSignals, error) {
+	var signals ListingSignals
+	var precio, status string
+	err := db.QueryRow(`SELECT created_at, COALESCE(precio, ''), COALESCE(status, '') FROM propiedades WHERE id = ?`, propiedadID).
+		Scan(&signals.FirstSeenAt, &precio, &status)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("la propiedad %d no existe: %w", propiedadID, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo propiedad %d: %v", propiedadID, err)
+	}
+
+	query := `
+		SELECT field, COALESCE(old_value, ''), COALESCE(new_value, ''), created_at
+		FROM property_changes
+		WHERE propiedad_id = ? AND field IN ('precio', 'status')
+		ORDER BY created_at, id`
+
+	rows, err := db.Query(query, propiedadID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando historial de la propiedad %d: %v", propiedadID, err)
+	}
+	defer rows.Close()
+
+	var primerPrecio string
+	var baja *time.Time
+	for rows.Next() {
+		var cambio PropertyChange
+		if err := rows.Scan(&cambio.Field, &cambio.OldValue, &cambio.NewValue, &cambio.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error escaneando cambio: %v", err)
+		}
+		at := cambio.CreatedAt
+
+		switch {
+		case cambio.Field == "precio":
+			if primerPrecio == "" {
+				primerPrecio = cambio.OldValue
+			}
+			anterior, monedaAnterior, ok1 := ParsePrecio(cambio.OldValue)
+			nuevo, monedaNueva, ok2 := ParsePrecio(cambio.NewValue)
+			if ok1 && ok2 && monedaAnterior == monedaNueva && nuevo < anterior {
+				signals.PriceDrops++
+				signals.LastPriceDropAt = &at
+			}
+		case cambio.NewValue == "unavailable":
+			baja = &at
+		case cambio.OldValue == "unavailable":
+			signals.Relisted = true
+			signals.RelistedAt = &at
+			baja = nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if primerPrecio != "" {
+		inicial, monedaInicial, ok1 := ParsePrecio(primerPrecio)
+		actual, monedaActual, ok2 := ParsePrecio(precio)
+		if ok1 && ok2 && monedaInicial == monedaActual && actual < inicial {
+			signals.PriceDropPct = math.Round((inicial-actual)/inicial*1000) / 10
+		}
+	}
+
+	fin := time.Now().UTC()
+	if status == "unavailable" && baja != nil {
+		fin = *baja
+	}
+	signals.DaysOnMarket = int(max(fin.Sub(signals.FirstSeenAt).Hours()/24, 0))
+
+	return &signals, nil
+}
+
+func textoString(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+func textoInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func textoInt64(v *int64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v, 10)
+}
+
+func textoFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
@@ -0,0 +1,280 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Eventos del registro de calificaciones
+const (
+	RatingEventRated   = "rated"   // El usuario calificó la propiedad
+	RatingEventUnrated = "unrated" // El usuario deshizo su calificación y la propiedad volvió a la cola
+)
+
+// ErrInvalidRating indica que la calificación pedida no es válida: rating desconocido,
+// estrellas fuera de rango o en un dislike, o motivos desconocidos o desactivados
+var ErrInvalidRating = errors.New("calificación inválida")
+
+// Los códigos de motivos se usan en filtros y en la API: minúsculas, números y guiones bajos
+var codigoMotivo = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// resolveRatingReasons valida los códigos de motivos y devuelve sus IDs junto con los códigos
+// sin repetir. Solo se aceptan motivos activos.
+func resolveRatingReasons(tx *sql.Tx, codes []string) ([]int64, []string, error) {
+	ids := []int64{}
+	validos := []string{}
+	vistos := map[string]bool{}
+
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		if code == "" || vistos[code] {
+			continue
+		}
+		vistos[code] = true
+
+		var id int64
+		err := tx.QueryRow(`SELECT id FROM rating_reasons WHERE code = ? AND active = 1`, code).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("%w: motivo desconocido: %s", ErrInvalidRating, code)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error verificando motivo %s: %v", code, err)
+		}
+		ids = append(ids, id)
+		validos = append(validos, code)
+	}
+
+	return ids, validos, nil
+}
+
+// GetRatingReasons devuelve la lista de motivos; con includeInactive incluye los desactivados
+func (db *DB) GetRatingReasons(includeInactive bool) ([]RatingReason, error) {
+	query := `SELECT id, code, label, active, created_at FROM rating_reasons`
+	if !includeInactive {
+		query += ` WHERE active = 1`
+	}
+	query += ` ORDER BY label`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando motivos: %v", err)
+	}
+	defer rows.Close()
+
+	reasons := []RatingReason{}
+	for rows.Next() {
+		var r RatingReason
+		if err := rows.Scan(&r.ID, &r.Code, &r.Label, &r.Active, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error escaneando motivo: %v", err)
+		}
+		reasons = append(reasons, r)
+	}
+
+	return reasons, rows.Err()
+}
+
+// CreateRatingReason agrega un motivo activo a la lista
+func (db *DB) CreateRatingReason(r *RatingReason) error {
+	r.Code = strings.TrimSpace(r.Code)
+	r.Label = strings.TrimSpace(r.Label)
+	if !codigoMotivo.MatchString(r.Code) {
+		return fmt.Errorf("código de motivo inválido: %q", r.Code)
+	}
+	if r.Label == "" {
+		r.Label = r.Code
+	}
+
+	query := `INSERT INTO rating_reasons (code, label) VALUES (?, ?) RETURNING id, active, created_at`
+	if err := db.QueryRow(query, r.Code, r.Label).Scan(&r.ID, &r.Active, &r.CreatedAt); err != nil {
+		return fmt.Errorf("error creando motivo %q: %v", r.Code, err)
+	}
+
+	return nil
+}
+
+// UpdateRatingReason cambia la etiqueta de un motivo o lo activa o desactiva. El código no
+// cambia porque lo usan el historial y los filtros guardados; un motivo desactivado no se
+// puede usar en calificaciones nuevas pero se conserva en las existentes.
+func (db *DB) UpdateRatingReason(r *RatingReason) error {
+	r.Label = strings.TrimSpace(r.Label)
+	if r.Label == "" {
+		return fmt.Errorf("el motivo no tiene etiqueta")
+	}
+
+	query := `UPDATE rating_reasons SET label = ?, active = ? WHERE id = ? RETURNING code, created_at`
+	err := db.QueryRow(query, r.Label, r.Active, r.ID).Scan(&r.Code, &r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("error actualizando motivo %d: %w", r.ID, err)
+	}
+	if err != nil {
+		return fmt.Errorf("error actualizando motivo %d: %v", r.ID, err)
+	}
+
+	return nil
+}
+
+// GetPropertyRating devuelve la calificación vigente del usuario sobre una propiedad.
+// Devuelve sql.ErrNoRows si no la calificó.
+func (db *DB) GetPropertyRating(userID, propertyID int64) (*PropertyRating, error) {
+	query := `
+		SELECT id, user_id, property_id, rating, score, is_favorite, created_at, updated_at
+		FROM property_ratings
+		WHERE user_id = ? AND property_id = ?`
+
+	var r PropertyRating
+	err := db.QueryRow(query, userID, propertyID).Scan(
+		&r.ID, &r.UserID, &r.PropertyID, &r.Rating, &r.Score, &r.IsFavorite, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("la propiedad %d no tiene calificación: %w", propertyID, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo calificación de la propiedad %d: %v", propertyID, err)
+	}
+
+	rows, err := db.Query(`
+		SELECT rs.code
+		FROM property_rating_reasons rr
+		JOIN rating_reasons rs ON rs.id = rr.reason_id
+		WHERE rr.rating_id = ?
+		ORDER BY rs.code`, r.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando motivos de la calificación %d: %v", r.ID, err)
+	}
+	defer rows.Close()
+
+	r.Reasons = []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("error escaneando motivo: %v", err)
+		}
+		r.Reasons = append(r.Reasons, code)
+	}
+
+	return &r, rows.Err()
+}
+
+// recordRatingEvent agrega un evento al registro de calificaciones con los valores de rating
+func recordRatingEvent(tx *sql.Tx, event string, rating *PropertyRating, at time.Time) error {
+	reasons := rating.Reasons
+	if reasons == nil {
+		reasons = []string{}
+	}
+	reasonsJSON, err := json.Marshal(reasons)
+	if err != nil {
+		return fmt.Errorf("error serializando motivos: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO property_rating_history (user_id, property_id, event, rating, score, reasons, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		rating.UserID, rating.PropertyID, event, rating.Rating, rating.Score, string(reasonsJSON), at)
+	if err != nil {
+		return fmt.Errorf("error guardando historial de calificación: %v", err)
+	}
+
+	return nil
+}
+
+// UnrateProperty deshace la calificación del usuario sobre una propiedad, que vuelve a la
+// cola de sin calificar. El favorito se pierde con la calificación; el registro conserva
+// los valores que tenía. Devuelve la calificación eliminada, o sql.ErrNoRows si no había.
+func (db *DB) UnrateProperty(userID, propertyID int64) (*PropertyRating, error) {
+	rating, err := db.GetPropertyRating(userID, propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM property_rating_reasons WHERE rating_id = ?`, rating.ID); err != nil {
+		return nil, fmt.Errorf("error eliminando motivos de la calificación %d: %v", rating.ID, err)
+	}
+	result, err := tx.Exec(`DELETE FROM property_ratings WHERE id = ?`, rating.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error eliminando calificación de la propiedad %d: %v", propertyID, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Otra solicitud la deshizo mientras tanto
+		return nil, fmt.Errorf("la propiedad %d no tiene calificación: %w", propertyID, sql.ErrNoRows)
+	}
+
+	if err := recordRatingEvent(tx, RatingEventUnrated, rating, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error confirmando transacción: %v", err)
+	}
+
+	return rating, nil
+}
+
+// columnasHistorial son las columnas que lee queryRatingHistory; current indica si el evento
+// es la calificación vigente de la propiedad
+const columnasHistorial = `
+	h.id, h.user_id, h.property_id, h.event, h.rating, h.score, h.reasons, h.created_at,
+	h.event = 'rated' AND NOT EXISTS (
+		SELECT 1 FROM property_rating_history h2
+		WHERE h2.user_id = h.user_id AND h2.property_id = h.property_id AND h2.id > h.id
+	) AS current`
+
+func (db *DB) queryRatingHistory(query string, args ...interface{}) ([]RatingHistoryEntry, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando historial de calificaciones: %v", err)
+	}
+	defer rows.Close()
+
+	history := []RatingHistoryEntry{}
+	for rows.Next() {
+		var e RatingHistoryEntry
+		var reasons sql.NullString
+		err := rows.Scan(&e.ID, &e.UserID, &e.PropertyID, &e.Event, &e.Rating, &e.Score, &reasons, &e.CreatedAt, &e.Current)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando historial de calificaciones: %v", err)
+		}
+		e.Reasons = []string{}
+		if reasons.Valid && reasons.String != "" {
+			if err := json.Unmarshal([]byte(reasons.String), &e.Reasons); err != nil {
+				return nil, fmt.Errorf("error leyendo motivos del historial %d: %v", e.ID, err)
+			}
+		}
+		history = append(history, e)
+	}
+
+	return history, rows.Err()
+}
+
+// GetRatingHistory devuelve los eventos de calificación del usuario sobre una propiedad, del más reciente al más antiguo
+func (db *DB) GetRatingHistory(userID, propertyID int64) ([]RatingHistoryEntry, error) {
+	query := `
+		SELECT ` + columnasHistorial + `
+		FROM property_rating_history h
+		WHERE h.user_id = ? AND h.property_id = ?
+		ORDER BY h.id DESC`
+
+	return db.queryRatingHistory(query, userID, propertyID)
+}
+
+// GetRecentRatings devuelve los últimos limit eventos de calificación del usuario, del más reciente al más antiguo
+func (db *DB) GetRecentRatings(userID int64, limit int) ([]RatingHistoryEntry, error) {
+	query := `
+		SELECT ` + columnasHistorial + `
+		FROM property_rating_history h
+		WHERE h.user_id = ?
+		ORDER BY h.id DESC
+		LIMIT ?`
+
+	return db.queryRatingHistory(query, userID, limit)
+}
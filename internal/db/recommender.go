@@ -0,0 +1,66 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SaveRecommenderModel guarda el modelo de un usuario, reemplazando el anterior
+func (db *DB) SaveRecommenderModel(m *RecommenderModel) error {
+	query := `
+		INSERT INTO recommender_models (user_id, model, samples, likes, dislikes, accuracy, trained_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			model = excluded.model,
+			samples = excluded.samples,
+			likes = excluded.likes,
+			dislikes = excluded.dislikes,
+			accuracy = excluded.accuracy,
+			trained_at = excluded.trained_at`
+
+	_, err := db.Exec(query, m.UserID, m.Model, m.Samples, m.Likes, m.Dislikes, m.Accuracy, m.TrainedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("error guardando modelo de recomendación del usuario %d: %v", m.UserID, err)
+	}
+
+	return nil
+}
+
+// GetRecommenderModel devuelve el modelo de un usuario. Devuelve sql.ErrNoRows si nunca se entrenó.
+func (db *DB) GetRecommenderModel(userID int64) (*RecommenderModel, error) {
+	query := `
+		SELECT user_id, model, samples, likes, dislikes, accuracy, trained_at
+		FROM recommender_models
+		WHERE user_id = ?`
+
+	var m RecommenderModel
+	err := db.QueryRow(query, userID).Scan(&m.UserID, &m.Model, &m.Samples, &m.Likes, &m.Dislikes, &m.Accuracy, &m.TrainedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("el usuario %d no tiene modelo de recomendación: %w", userID, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo modelo de recomendación del usuario %d: %v", userID, err)
+	}
+
+	return &m, nil
+}
+
+// GetPropertyFeatureIDs devuelve los IDs de las características de cada propiedad
+func (db *DB) GetPropertyFeatureIDs() (map[int64][]int64, error) {
+	rows, err := db.Query(`SELECT property_id, feature_id FROM property_feature_relations ORDER BY property_id, feature_id`)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando características de propiedades: %v", err)
+	}
+	defer rows.Close()
+
+	features := map[int64][]int64{}
+	for rows.Next() {
+		var propertyID, featureID int64
+		if err := rows.Scan(&propertyID, &featureID); err != nil {
+			return nil, fmt.Errorf("error escaneando característica de propiedad: %v", err)
+		}
+		features[propertyID] = append(features[propertyID], featureID)
+	}
+
+	return features, rows.Err()
+}
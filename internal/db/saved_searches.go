@@ -0,0 +1,243 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// condicionBusquedaGuardada arma la condición de las propiedades que coinciden con un filtro.
+// Las propiedades dadas de baja no coinciden con ninguna búsqueda.
+func condicionBusquedaGuardada(filter *PropertyFilter) (string, []interface{}) {
+	conditions, args := buildFilterConditions(filter)
+	conditions = append([]string{"COALESCE(p.status, '') != 'unavailable'"}, conditions...)
+	return strings.Join(conditions, " AND "), args
+}
+
+// CreateSavedSearch guarda una búsqueda con su filtro
+func (db *DB) CreateSavedSearch(s *SavedSearch) error {
+	filter, err := json.Marshal(s.Filter)
+	if err != nil {
+		return fmt.Errorf("error serializando filtro: %v", err)
+	}
+
+	if s.UserID == 0 {
+		s.UserID = DefaultUserID
+	}
+	s.Filter.UserID = s.UserID
+
+	now := time.Now().UTC()
+	query := `
+		INSERT INTO busquedas (operation, property_type, zone, location, name, filter, user_id, created_at, updated_at)
+		VALUES ('', '', '', '', ?, ?, ?, ?, ?)
+		RETURNING id, created_at, updated_at`
+
+	if err := db.QueryRow(query, s.Name, string(filter), s.UserID, now, now).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return fmt.Errorf("error guardando búsqueda: %v", err)
+	}
+
+	return nil
+}
+
+// GetSavedSearches devuelve las búsquedas guardadas de todos los usuarios
+func (db *DB) GetSavedSearches() ([]SavedSearch, error) {
+	return db.querySavedSearches(`WHERE b.filter IS NOT NULL ORDER BY b.name, b.id`)
+}
+
+// GetUserSavedSearches devuelve las búsquedas guardadas de un usuario
+func (db *DB) GetUserSavedSearches(userID int64) ([]SavedSearch, error) {
+	return db.querySavedSearches(`WHERE b.filter IS NOT NULL AND COALESCE(b.user_id, 1) = ? ORDER BY b.name, b.id`, userID)
+}
+
+// GetSavedSearch devuelve una búsqueda guardada
+func (db *DB) GetSavedSearch(id int64) (*SavedSearch, error) {
+	searches, err := db.querySavedSearches(`WHERE b.filter IS NOT NULL AND b.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(searches) == 0 {
+		return nil, fmt.Errorf("error obteniendo búsqueda %d: %w", id, sql.ErrNoRows)
+	}
+
+	return &searches[0], nil
+}
+
+func (db *DB) querySavedSearches(where string, args ...interface{}) ([]SavedSearch, error) {
+	query := `
+		SELECT b.id, COALESCE(b.user_id, 1), COALESCE(b.name, ''), b.filter, b.created_at, b.updated_at, b.last_run_at,
+			(SELECT COUNT(*) FROM busquedas_propiedades bp WHERE bp.busqueda_id = b.id)
+		FROM busquedas b ` + where
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando búsquedas guardadas: %v", err)
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		var s SavedSearch
+		var filter string
+		var updatedAt *time.Time
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Name, &filter, &s.CreatedAt, &updatedAt, &s.LastRunAt, &s.Matches); err != nil {
+			return nil, fmt.Errorf("error escaneando búsqueda guardada: %v", err)
+		}
+		s.UpdatedAt = s.CreatedAt
+		if updatedAt != nil {
+			s.UpdatedAt = *updatedAt
+		}
+		if err := json.Unmarshal([]byte(filter), &s.Filter); err != nil {
+			return nil, fmt.Errorf("error leyendo filtro de la búsqueda %d: %v", s.ID, err)
+		}
+		s.Filter.UserID = s.UserID
+		searches = append(searches, s)
+	}
+
+	return searches, rows.Err()
+}
+
+// UpdateSavedSearch actualiza el nombre y el filtro de una búsqueda guardada.
+// Las coincidencias registradas con el filtro anterior se conservan.
+func (db *DB) UpdateSavedSearch(s *SavedSearch) error {
+	s.Filter.UserID = s.UserID
+
+	filter, err := json.Marshal(s.Filter)
+	if err != nil {
+		return fmt.Errorf("error serializando filtro: %v", err)
+	}
+
+	query := `
+		UPDATE busquedas SET name = ?, filter = ?, updated_at = ?
+		WHERE id = ? AND filter IS NOT NULL
+		RETURNING updated_at`
+
+	err = db.QueryRow(query, s.Name, string(filter), time.Now().UTC(), s.ID).Scan(&s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("error actualizando búsqueda %d: %w", s.ID, err)
+	}
+	if err != nil {
+		return fmt.Errorf("error actualizando búsqueda %d: %v", s.ID, err)
+	}
+
+	return nil
+}
+
+// DeleteSavedSearch elimina una búsqueda guardada y sus coincidencias
+func (db *DB) DeleteSavedSearch(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM busquedas WHERE id = ? AND filter IS NOT NULL`, id)
+	if err != nil {
+		return fmt.Errorf("error eliminando búsqueda %d: %v", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("error eliminando búsqueda %d: %w", id, sql.ErrNoRows)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM busquedas_propiedades WHERE busqueda_id = ?`, id); err != nil {
+		return fmt.Errorf("error eliminando coincidencias de la búsqueda %d: %v", id, err)
+	}
+
+	return tx.Commit()
+}
+
+// RunSavedSearch devuelve las propiedades que coinciden ahora con una búsqueda guardada
+func (db *DB) RunSavedSearch(s *SavedSearch) ([]Propiedad, error) {
+	condicion, args := condicionBusquedaGuardada(&s.Filter)
+
+	query := `
+		SELECT 
+			p.id, p.inmobiliaria_id, p.codigo, p.titulo, p.precio, p.direccion, 
+			p.url, p.imagen_url, p.imagenes, p.created_at, p.updated_at,
+			p.tipo_propiedad, p.ubicacion, p.dormitorios, p.banios, p.antiguedad,
+			p.superficie_cubierta, p.superficie_total, p.superficie_terreno,
+			p.frente, p.fondo, p.ambientes, p.plantas, p.cocheras,
+			p.situacion, p.expensas, p.descripcion, p.status, p.operacion,
+			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud, p.error_category
+		FROM propiedades p
+		WHERE ` + condicion + `
+		ORDER BY p.created_at DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error ejecutando búsqueda %d: %v", s.ID, err)
+	}
+	defer rows.Close()
+
+	return scanPropiedades(rows)
+}
+
+// GetSavedSearchMatches devuelve las propiedades que empezaron a coincidir con una búsqueda
+// desde since (todas si since es cero), de la más reciente a la más antigua
+func (db *DB) GetSavedSearchMatches(searchID int64, since time.Time) ([]Propiedad, error) {
+	query := `
+		SELECT 
+			p.id, p.inmobiliaria_id, p.codigo, p.titulo, p.precio, p.direccion, 
+			p.url, p.imagen_url, p.imagenes, p.created_at, p.updated_at,
+			p.tipo_propiedad, p.ubicacion, p.dormitorios, p.banios, p.antiguedad,
+			p.superficie_cubierta, p.superficie_total, p.superficie_terreno,
+			p.frente, p.fondo, p.ambientes, p.plantas, p.cocheras,
+			p.situacion, p.expensas, p.descripcion, p.status, p.operacion,
+			p.condicion, p.orientacion, p.disposicion, p.latitud, p.longitud, p.error_category
+		FROM busquedas_propiedades bp
+		JOIN propiedades p ON p.id = bp.propiedad_id
+		WHERE bp.busqueda_id = ? AND datetime(bp.created_at) >= ?
+		ORDER BY bp.created_at DESC, p.id DESC`
+
+	rows, err := db.Query(query, searchID, sqliteTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("error consultando coincidencias de la búsqueda %d: %v", searchID, err)
+	}
+	defer rows.Close()
+
+	return scanPropiedades(rows)
+}
+
+// RecordSavedSearchMatches registra las propiedades que coinciden con una búsqueda guardada
+// y devuelve los IDs de las que coinciden por primera vez. Las coincidencias de la primera
+// evaluación quedan marcadas como iniciales y no se notifican.
+func (db *DB) RecordSavedSearchMatches(s *SavedSearch) ([]int64, error) {
+	condicion, args := condicionBusquedaGuardada(&s.Filter)
+	now := time.Now().UTC()
+
+	query := `
+		INSERT INTO busquedas_propiedades (busqueda_id, propiedad_id, created_at, initial)
+		SELECT ?, p.id, ?, ?
+		FROM propiedades p
+		WHERE ` + condicion + `
+		ON CONFLICT DO NOTHING
+		RETURNING propiedad_id`
+
+	rows, err := db.Query(query, append([]interface{}{s.ID, sqliteTime(now), s.LastRunAt == nil}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error registrando coincidencias de la búsqueda %d: %v", s.ID, err)
+	}
+
+	nuevas := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error escaneando coincidencia: %v", err)
+		}
+		nuevas = append(nuevas, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterando coincidencias: %v", err)
+	}
+
+	if _, err := db.Exec(`UPDATE busquedas SET last_run_at = ? WHERE id = ?`, now, s.ID); err != nil {
+		return nil, fmt.Errorf("error actualizando búsqueda %d: %v", s.ID, err)
+	}
+	s.LastRunAt = &now
+	s.Matches += len(nuevas)
+
+	return nuevas, nil
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// AcquireLock toma el lock name para owner si está libre, vencido o ya es suyo
+func (db *DB) AcquireLock(name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+
+	query := `
+		INSERT INTO scheduler_locks (name, owner, acquired_at, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			owner = excluded.owner,
+			acquired_at = excluded.acquired_at,
+			expires_at = excluded.expires_at
+		WHERE scheduler_locks.expires_at < ? OR scheduler_locks.owner = excluded.owner`
+
+	result, err := db.Exec(query, name, owner, now, now.Add(ttl), now)
+	if err != nil {
+		return false, fmt.Errorf("error tomando lock %s: %v", name, err)
+	}
+
+	affected, _ := result.RowsAffected()
+	return affected == 1, nil
+}
+
+// RenewLock extiende el vencimiento de un lock tomado por owner
+func (db *DB) RenewLock(name, owner string, ttl time.Duration) error {
+	query := `UPDATE scheduler_locks SET expires_at = ? WHERE name = ? AND owner = ?`
+
+	result, err := db.Exec(query, time.Now().UTC().Add(ttl), name, owner)
+	if err != nil {
+		return fmt.Errorf("error renovando lock %s: %v", name, err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("el lock %s ya no pertenece a %s", name, owner)
+	}
+
+	return nil
+}
+
+// ReleaseLock libera un lock tomado por owner
+func (db *DB) ReleaseLock(name, owner string) error {
+	if _, err := db.Exec(`DELETE FROM scheduler_locks WHERE name = ? AND owner = ?`, name, owner); err != nil {
+		return fmt.Errorf("error liberando lock %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// StartScheduledRun registra el inicio de una tarea programada
+func (db *DB) StartScheduledRun(task, owner string) (int64, error) {
+	var id int64
+	query := `INSERT INTO scheduled_runs (task, owner, status, started_at) VALUES (?, ?, 'running', ?) RETURNING id`
+
+	if err := db.QueryRow(query, task, owner, time.Now().UTC()).Scan(&id); err != nil {
+		return 0, fmt.Errorf("error registrando ejecución de %s: %v", task, err)
+	}
+
+	return id, nil
+}
+
+// FinishScheduledRun registra el resultado de una tarea programada
+func (db *DB) FinishScheduledRun(id int64, status string, runErr error) error {
+	var errMsg *string
+	if runErr != nil {
+		msg := runErr.Error()
+		errMsg = &msg
+	}
+
+	query := `UPDATE scheduled_runs SET status = ?, finished_at = ?, error = ? WHERE id = ?`
+	if _, err := db.Exec(query, status, time.Now().UTC(), errMsg, id); err != nil {
+		return fmt.Errorf("error finalizando ejecución %d: %v", id, err)
+	}
+
+	return nil
+}
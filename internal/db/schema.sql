@@ -8,6 +8,7 @@ CREATE TABLE IF NOT EXISTS inmobiliarias (
     rating REAL,
     direccion TEXT,
     telefono TEXT,
+    disabled BOOLEAN DEFAULT 0,  -- Deshabilitada: no se analiza ni se scrapea
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -35,16 +36,23 @@ CREATE TABLE IF NOT EXISTS busquedas (
     location TEXT NOT NULL,
     min_price_usd REAL,
     max_price_usd REAL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- Búsquedas guardadas: las que tienen filter (db.PropertyFilter en JSON)
+    name TEXT,
+    filter TEXT,
+    updated_at TIMESTAMP,
+    last_run_at TIMESTAMP,
+    user_id INTEGER  -- Dueño de la búsqueda guardada
 );
 
 -- Tabla de propiedades
 CREATE TABLE IF NOT EXISTS propiedades (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     inmobiliaria_id INTEGER,
-    codigo TEXT NOT NULL UNIQUE,
+    codigo TEXT NOT NULL,  -- Identidad junto con inmobiliaria_id (o la URL si no hay código)
     titulo TEXT,
     precio TEXT,
+    moneda TEXT DEFAULT 'USD',
     direccion TEXT,
     url TEXT,
     imagen_url TEXT,
@@ -72,6 +80,10 @@ CREATE TABLE IF NOT EXISTS propiedades (
     condicion TEXT,
     orientacion TEXT,
     disposicion TEXT,
+    latitud REAL,
+    longitud REAL,
+    error_category TEXT,  -- Categoría del último error de extracción: 'not_found', 'blocked', 'timeout', 'layout_changed', ...
+    details_updated_at TIMESTAMP,  -- Última extracción de detalles (updated_at cambia con cada listado)
     FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id)
 );
 
@@ -80,32 +92,257 @@ CREATE TABLE IF NOT EXISTS busquedas_propiedades (
     busqueda_id INTEGER,
     propiedad_id INTEGER,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    initial BOOLEAN DEFAULT 0,  -- Coincidencia de la primera evaluación de la búsqueda (no se notifica)
     PRIMARY KEY (busqueda_id, propiedad_id),
     FOREIGN KEY (busqueda_id) REFERENCES busquedas(id),
     FOREIGN KEY (propiedad_id) REFERENCES propiedades(id)
 );
 
+-- Usuarios; calificaciones, favoritos, notas y búsquedas guardadas son de cada usuario
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    password_hash TEXT,  -- bcrypt; sin contraseña el usuario no puede iniciar sesión
+    role TEXT NOT NULL DEFAULT 'member' CHECK(role IN ('viewer', 'member', 'admin')),
+    household_id INTEGER  -- Hogar con el que decide en conjunto
+);
+
+-- Hogares: grupos de usuarios que combinan sus calificaciones para decidir
+CREATE TABLE IF NOT EXISTS households (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Usuario por defecto, dueño de los datos anteriores a los usuarios
+INSERT OR IGNORE INTO users (id, name, role) VALUES (1, 'default', 'admin');
+
+-- Sesiones iniciadas con usuario y contraseña (se guarda el hash del token)
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- API keys para scripts (se guarda el hash de la clave)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,  -- Comienzo de la clave, para reconocerla en los listados
+    key_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
 -- Tabla de calificaciones de propiedades
 CREATE TABLE IF NOT EXISTS property_ratings (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL DEFAULT 1,
     property_id INTEGER NOT NULL,
     rating TEXT NOT NULL CHECK(rating IN ('like', 'dislike')),
+    score INTEGER CHECK(score BETWEEN 1 AND 5), -- Estrellas, solo para los like
     is_favorite BOOLEAN DEFAULT 0,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Primera calificación
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Última calificación
+    FOREIGN KEY (user_id) REFERENCES users(id),
     FOREIGN KEY (property_id) REFERENCES propiedades(id),
-    UNIQUE(property_id)
+    UNIQUE(user_id, property_id)
+);
+
+-- Motivos que se pueden asociar a una calificación (muy cara, mala zona...)
+CREATE TABLE IF NOT EXISTS rating_reasons (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL UNIQUE,
+    label TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT OR IGNORE INTO rating_reasons (code, label) VALUES
+    ('too_expensive', 'Muy cara'),
+    ('bad_area', 'Mala zona'),
+    ('needs_renovation', 'Necesita refacción'),
+    ('small_rooms', 'Ambientes chicos');
+
+-- Motivos de la calificación vigente
+CREATE TABLE IF NOT EXISTS property_rating_reasons (
+    rating_id INTEGER NOT NULL,
+    reason_id INTEGER NOT NULL,
+    PRIMARY KEY (rating_id, reason_id),
+    FOREIGN KEY (rating_id) REFERENCES property_ratings(id),
+    FOREIGN KEY (reason_id) REFERENCES rating_reasons(id)
+);
+
+-- Registro de calificaciones, solo se agregan filas: cada vez que el usuario calificó la
+-- propiedad ('rated') o deshizo su calificación ('unrated', con la calificación que tenía)
+CREATE TABLE IF NOT EXISTS property_rating_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    property_id INTEGER NOT NULL,
+    event TEXT NOT NULL DEFAULT 'rated' CHECK(event IN ('rated', 'unrated')),
+    rating TEXT NOT NULL,
+    score INTEGER,
+    reasons TEXT, -- JSON con los códigos de los motivos
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id)
 );
 
 -- Tabla de notas de propiedades
 CREATE TABLE IF NOT EXISTS property_notes (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL DEFAULT 1,
     property_id INTEGER NOT NULL,
     note TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id),
     FOREIGN KEY (property_id) REFERENCES propiedades(id)
 );
 
+-- Archivos adjuntos a una nota (fotos de una visita). path es relativo al directorio de adjuntos.
+CREATE TABLE IF NOT EXISTS note_attachments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    note_id INTEGER NOT NULL,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    path TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (note_id) REFERENCES property_notes(id)
+);
+
+-- Puntos a revisar en una visita
+CREATE TABLE IF NOT EXISTS checklist_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL UNIQUE,
+    label TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT OR IGNORE INTO checklist_items (code, label, position) VALUES
+    ('humidity', 'Humedad', 1),
+    ('light', 'Luz', 2),
+    ('noise', 'Ruido', 3),
+    ('neighbours', 'Vecinos', 4),
+    ('plumbing', 'Plomería', 5);
+
+-- Respuestas del usuario a la lista de una propiedad
+CREATE TABLE IF NOT EXISTS property_checklist (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    property_id INTEGER NOT NULL,
+    item_id INTEGER NOT NULL,
+    answer TEXT NOT NULL CHECK(answer IN ('good', 'fair', 'bad')),
+    comment TEXT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    FOREIGN KEY (item_id) REFERENCES checklist_items(id),
+    UNIQUE(user_id, property_id, item_id)
+);
+
+-- Personas de contacto de cada inmobiliaria
+CREATE TABLE IF NOT EXISTS agency_contacts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    inmobiliaria_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    phone TEXT,
+    email TEXT,
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id)
+);
+
+-- Contactos con la inmobiliaria por una propiedad (llamadas, mensajes...)
+CREATE TABLE IF NOT EXISTS property_contacts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    property_id INTEGER NOT NULL,
+    inmobiliaria_id INTEGER NOT NULL,
+    contact_id INTEGER,
+    channel TEXT NOT NULL CHECK(channel IN ('phone', 'whatsapp', 'email', 'in_person', 'other')),
+    status TEXT NOT NULL DEFAULT 'waiting' CHECK(status IN ('waiting', 'answered', 'no_answer')),
+    outcome TEXT,
+    contacted_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id),
+    FOREIGN KEY (contact_id) REFERENCES agency_contacts(id)
+);
+
+-- Visitas a propiedades
+CREATE TABLE IF NOT EXISTS visits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    property_id INTEGER NOT NULL,
+    inmobiliaria_id INTEGER NOT NULL,
+    contact_id INTEGER,
+    scheduled_at TIMESTAMP NOT NULL,
+    duration_minutes INTEGER NOT NULL DEFAULT 30,
+    status TEXT NOT NULL DEFAULT 'scheduled' CHECK(status IN ('scheduled', 'done', 'cancelled', 'no_show')),
+    outcome TEXT,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id),
+    FOREIGN KEY (contact_id) REFERENCES agency_contacts(id)
+);
+
+-- Fotos descargadas, guardadas por el SHA-256 de su contenido
+CREATE TABLE IF NOT EXISTS image_blobs (
+    hash TEXT PRIMARY KEY,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    phash TEXT,                          -- Hash perceptual (dHash de 64 bits en hexadecimal)
+    has_thumb INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Fotos de cada propiedad y su descarga
+CREATE TABLE IF NOT EXISTS property_images (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    property_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'done', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    blob_hash TEXT,
+    duplicate_of INTEGER,                -- Foto de la misma propiedad casi idéntica
+    fetched_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(property_id, url),
+    FOREIGN KEY (property_id) REFERENCES propiedades(id),
+    FOREIGN KEY (blob_hash) REFERENCES image_blobs(hash)
+);
+
+-- HTML de las fichas tal como se extrajeron, comprimido con gzip, para volver a procesarlas sin visitar los sitios
+CREATE TABLE IF NOT EXISTS detail_snapshots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    propiedad_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    content_hash TEXT NOT NULL,          -- SHA-256 del HTML sin comprimir
+    html BLOB NOT NULL,
+    size INTEGER NOT NULL,               -- Bytes sin comprimir
+    fetched_at TIMESTAMP NOT NULL,       -- Última descarga con este contenido
+    reparsed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (propiedad_id) REFERENCES propiedades(id)
+);
+
 -- Tabla para almacenar características normalizadas
 CREATE TABLE IF NOT EXISTS property_features (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -124,12 +361,120 @@ CREATE TABLE IF NOT EXISTS property_feature_relations (
     FOREIGN KEY (feature_id) REFERENCES property_features(id) ON DELETE CASCADE
 );
 
+-- Historial de ejecuciones del scraper por inmobiliaria
+CREATE TABLE IF NOT EXISTS scrape_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    inmobiliaria_id INTEGER NOT NULL,
+    run_type TEXT NOT NULL,                  -- 'search' (listado) o 'details' (detalles)
+    status TEXT NOT NULL DEFAULT 'running',  -- 'running', 'success', 'failed'
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    listings_found INTEGER DEFAULT 0,
+    new_count INTEGER DEFAULT 0,
+    updated_count INTEGER DEFAULT 0,
+    failed_count INTEGER DEFAULT 0,
+    unavailable_count INTEGER DEFAULT 0,
+    last_error TEXT,
+    FOREIGN KEY (inmobiliaria_id) REFERENCES inmobiliarias(id)
+);
+
+-- Cola persistente de extracción de detalles de propiedades
+CREATE TABLE IF NOT EXISTS detail_jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    propiedad_id INTEGER NOT NULL UNIQUE,
+    status TEXT NOT NULL DEFAULT 'pending',  -- 'pending', 'running', 'done', 'failed'
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    lease_until TIMESTAMP,                   -- Un job 'running' con el lease vencido vuelve a estar disponible
+    last_error TEXT,
+    priority INTEGER NOT NULL DEFAULT 0,     -- Se toman primero los de mayor prioridad (favoritas, con like)
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (propiedad_id) REFERENCES propiedades(id)
+);
+
+-- Historial de cambios por campo de las propiedades (valores normalizados como texto)
+CREATE TABLE IF NOT EXISTS property_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    propiedad_id INTEGER NOT NULL,
+    field TEXT NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (propiedad_id) REFERENCES propiedades(id)
+);
+
+-- Notificaciones enviadas por canal, para no repetirlas
+CREATE TABLE IF NOT EXISTS notifications_sent (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    channel TEXT NOT NULL,
+    event_key TEXT NOT NULL,  -- 'new:<búsqueda>:<propiedad>' o 'price:<búsqueda>:<cambio>'
+    busqueda_id INTEGER,
+    propiedad_id INTEGER,
+    sent_at TIMESTAMP NOT NULL,
+    UNIQUE (channel, event_key)
+);
+
+-- Modelo de recomendación de cada usuario, entrenado con sus calificaciones
+CREATE TABLE IF NOT EXISTS recommender_models (
+    user_id INTEGER PRIMARY KEY,
+    model TEXT NOT NULL, -- JSON con los pesos (ver internal/recommender)
+    samples INTEGER NOT NULL,
+    likes INTEGER NOT NULL,
+    dislikes INTEGER NOT NULL,
+    accuracy REAL,
+    trained_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Locks del daemon para evitar ejecuciones superpuestas de una misma tarea
+CREATE TABLE IF NOT EXISTS scheduler_locks (
+    name TEXT PRIMARY KEY,
+    owner TEXT NOT NULL,
+    acquired_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Historial de ejecuciones de las tareas programadas
+CREATE TABLE IF NOT EXISTS scheduled_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task TEXT NOT NULL,
+    owner TEXT,
+    status TEXT NOT NULL DEFAULT 'running',  -- 'running', 'success', 'failed', 'interrupted', 'skipped'
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP,
+    error TEXT
+);
+
 -- Índices para mejorar performance
 CREATE INDEX IF NOT EXISTS idx_propiedades_codigo ON propiedades(codigo);
 CREATE INDEX IF NOT EXISTS idx_propiedades_inmobiliaria ON propiedades(inmobiliaria_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_propiedades_inmobiliaria_codigo ON propiedades(inmobiliaria_id, codigo);
 CREATE INDEX IF NOT EXISTS idx_busquedas_fecha ON busquedas(created_at);
 CREATE INDEX IF NOT EXISTS idx_property_ratings_property_id ON property_ratings(property_id);
 CREATE INDEX IF NOT EXISTS idx_property_notes_property_id ON property_notes(property_id);
+CREATE INDEX IF NOT EXISTS idx_property_ratings_user ON property_ratings(user_id, rating);
+CREATE INDEX IF NOT EXISTS idx_property_rating_reasons_reason ON property_rating_reasons(reason_id);
+CREATE INDEX IF NOT EXISTS idx_property_rating_history_user ON property_rating_history(user_id, property_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_property_notes_user ON property_notes(user_id, property_id);
+CREATE INDEX IF NOT EXISTS idx_note_attachments_note ON note_attachments(note_id);
+CREATE INDEX IF NOT EXISTS idx_property_checklist_property ON property_checklist(property_id, item_id, answer);
+CREATE INDEX IF NOT EXISTS idx_agency_contacts_inmobiliaria ON agency_contacts(inmobiliaria_id);
+CREATE INDEX IF NOT EXISTS idx_property_contacts_property ON property_contacts(user_id, property_id, contacted_at);
+CREATE INDEX IF NOT EXISTS idx_visits_user ON visits(user_id, scheduled_at);
+CREATE INDEX IF NOT EXISTS idx_visits_property ON visits(property_id);
+CREATE INDEX IF NOT EXISTS idx_busquedas_user ON busquedas(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+CREATE INDEX IF NOT EXISTS idx_users_household ON users(household_id);
 CREATE INDEX IF NOT EXISTS idx_property_feature_relations_property_id ON property_feature_relations(property_id);
 CREATE INDEX IF NOT EXISTS idx_property_feature_relations_feature_id ON property_feature_relations(feature_id);
-CREATE INDEX IF NOT EXISTS idx_property_features_category ON property_features(category); 
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_property_features_category ON property_features(category);
+CREATE INDEX IF NOT EXISTS idx_scrape_runs_inmobiliaria ON scrape_runs(inmobiliaria_id, started_at);
+CREATE INDEX IF NOT EXISTS idx_detail_jobs_status ON detail_jobs(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_runs_task ON scheduled_runs(task, started_at);
+CREATE INDEX IF NOT EXISTS idx_property_changes_propiedad ON property_changes(propiedad_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_notifications_sent_channel ON notifications_sent(channel, sent_at);
+CREATE INDEX IF NOT EXISTS idx_property_images_status ON property_images(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_property_images_property ON property_images(property_id, position);
+CREATE INDEX IF NOT EXISTS idx_detail_snapshots_propiedad ON detail_snapshots(propiedad_id, fetched_at);
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Tipos y estados de las ejecuciones del scraper
+const (
+	ScrapeRunSearch  = "search"
+	ScrapeRunDetails = "details"
+
+	ScrapeRunRunning = "running"
+	ScrapeRunSuccess = "success"
+	ScrapeRunFailed  = "failed"
+)
+
+// Cantidad de ejecuciones anteriores que se promedian para detectar caídas
+const ejecucionesReferenciaCaida = 5
+
+// ScrapeDrop indica que la última ejecución encontró muchas menos propiedades que las anteriores
+type ScrapeDrop struct {
+	LastFound       int     `json:"last_found"`
+	PreviousAverage float64 `json:"previous_average"`
+	DroppedToZero   bool    `json:"dropped_to_zero"`
+}
+
+// AgencyScrapeStats resume el historial de scraping de una inmobiliaria
+type AgencyScrapeStats struct {
+	Runs        []ScrapeRun `json:"runs"`
+	LastSuccess *ScrapeRun  `json:"last_success,omitempty"`
+	LastFailure *ScrapeRun  `json:"last_failure,omitempty"`
+	Drop        *ScrapeDrop `json:"drop,omitempty"`
+}
+
+// StartScrapeRun registra el inicio de una ejecución del scraper
+func (db *DB) StartScrapeRun(inmobiliariaID int64, runType string) (*ScrapeRun, error) {
+	run := &ScrapeRun{
+		InmobiliariaID: inmobiliariaID,
+		RunType:        runType,
+		Status:         ScrapeRunRunning,
+	}
+
+	query := `
+		INSERT INTO scrape_runs (inmobiliaria_id, run_type, status)
+		VALUES (?, ?, ?)
+		RETURNING id, started_at`
+
+	err := db.QueryRow(query, inmobiliariaID, runType, run.Status).Scan(&run.ID, &run.StartedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error registrando ejecución para inmobiliaria %d: %v", inmobiliariaID, err)
+	}
+
+	return run, nil
+}
+
+// FinishScrapeRun guarda los contadores finales de una ejecución.
+// Si la ejecución no tiene estado final se marca como exitosa.
+func (db *DB) FinishScrapeRun(run *ScrapeRun) error {
+	if run.Status == ScrapeRunRunning {
+		run.Status = ScrapeRunSuccess
+	}
+	// En el formato de started_at (CURRENT_TIMESTAMP), para que ambas columnas se puedan comparar
+	finishedAt := time.Now().UTC().Truncate(time.Second)
+	run.FinishedAt = &finishedAt
+
+	query := `
+		UPDATE scrape_runs
+		SET status = ?, finished_at = ?, listings_found = ?, new_count = ?, updated_count = ?,
+			failed_count = ?, unavailable_count = ?, last_error = NULLIF(?, '')
+		WHERE id = ?`
+
+	_, err := db.Exec(query,
+		run.Status, sqliteTime(finishedAt), run.ListingsFound, run.NewCount, run.UpdatedCount,
+		run.FailedCount, run.UnavailableCount, run.LastError, run.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error finalizando ejecución %d: %v", run.ID, err)
+	}
+
+	return nil
+}
+
+// GetScrapeRuns devuelve las últimas ejecuciones de una inmobiliaria, de la más reciente a la más antigua
+func (db *DB) GetScrapeRuns(inmobiliariaID int64, limit int) ([]ScrapeRun, error) {
+	query := `
+		SELECT id, inmobiliaria_id, run_type, status, started_at, finished_at, listings_found,
+			new_count, updated_count, failed_count, unavailable_count, COALESCE(last_error, '')
+		FROM scrape_runs
+		WHERE inmobiliaria_id = ?
+		ORDER BY started_at DESC, id DESC
+		LIMIT ?`
+
+	rows, err := db.Query(query, inmobiliariaID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando ejecuciones: %v", err)
+	}
+	defer rows.Close()
+
+	runs := []ScrapeRun{}
+	for rows.Next() {
+		var run ScrapeRun
+		err := rows.Scan(
+			&run.ID, &run.InmobiliariaID, &run.RunType, &run.Status, &run.StartedAt, &run.FinishedAt,
+			&run.ListingsFound, &run.NewCount, &run.UpdatedCount, &run.FailedCount,
+			&run.UnavailableCount, &run.LastError,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando ejecución: %v", err)
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// GetAgencyScrapeStats devuelve el historial de ejecuciones de una inmobiliaria y detecta caídas
+func (db *DB) GetAgencyScrapeStats(inmobiliariaID int64, limit int) (*AgencyScrapeStats, error) {
+	runs, err := db.GetScrapeRuns(inmobiliariaID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &AgencyScrapeStats{Runs: runs}
+	for i := range runs {
+		if stats.LastSuccess == nil && runs[i].Status == ScrapeRunSuccess {
+			stats.LastSuccess = &runs[i]
+		}
+		if stats.LastFailure == nil && runs[i].Status == ScrapeRunFailed {
+			stats.LastFailure = &runs[i]
+		}
+	}
+	stats.Drop = DetectScrapeDrop(runs)
+
+	return stats, nil
+}
+
+// DetectScrapeDrop compara la última búsqueda de listados con el promedio de las anteriores.
+// Las ejecuciones deben estar ordenadas de la más reciente a la más antigua.
+// Devuelve nil si no hay historial suficiente o si la cantidad no cayó a menos de la mitad.
+func DetectScrapeDrop(runs []ScrapeRun) *ScrapeDrop {
+	var busquedas []ScrapeRun
+	for _, run := range runs {
+		if run.RunType == ScrapeRunSearch && run.Status != ScrapeRunRunning {
+			busquedas = append(busquedas, run)
+		}
+	}
+
+	if len(busquedas) < 2 {
+		return nil
+	}
+
+	anteriores := busquedas[1:]
+	if len(anteriores) > ejecucionesReferenciaCaida {
+		anteriores = anteriores[:ejecucionesReferenciaCaida]
+	}
+
+	var total int
+	for _, run := range anteriores {
+		total += run.ListingsFound
+	}
+	promedio := float64(total) / float64(len(anteriores))
+
+	ultima := busquedas[0].ListingsFound
+	if promedio == 0 || float64(ultima) >= promedio/2 {
+		return nil
+	}
+
+	return &ScrapeDrop{
+		LastFound:       ultima,
+		PreviousAverage: promedio,
+		DroppedToZero:   ultima == 0,
+	}
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Fichas guardadas por propiedad: al guardar una nueva se eliminan las más antiguas
+const MaxSnapshotsPerProperty = 3
+
+// DetailSnapshot es el HTML de la ficha de una propiedad tal como se extrajo
+type DetailSnapshot struct {
+	ID          int64
+	PropiedadID int64
+	URL         string
+	ContentHash string
+	HTML        string // Sin comprimir
+	Size        int64
+	FetchedAt   time.Time
+	ReparsedAt  *time.Time
+}
+
+// SaveDetailSnapshot guarda el HTML de la ficha de una propiedad comprimido. Si es idéntico
+// al último guardado solo actualiza la fecha de descarga.
+func (db *DB) SaveDetailSnapshot(propiedadID int64, url, html string, fetchedAt time.Time) error {
+	sum := sha256.Sum256([]byte(html))
+	hash := hex.EncodeToString(sum[:])
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error iniciando transacción: %v", err)
+	}
+	defer tx.Rollback()
+
+	var ultimoID int64
+	var ultimoHash string
+	err = tx.QueryRow(`
+		SELECT id, content_hash FROM detail_snapshots
+		WHERE propiedad_id = ?
+		ORDER BY fetched_at DESC, id DESC
+		LIMIT 1`, propiedadID).Scan(&ultimoID, &ultimoHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error consultando ficha guardada de la propiedad %d: %v", propiedadID, err)
+	}
+
+	if err == nil && ultimoHash == hash {
+		if _, err := tx.Exec(`UPDATE detail_snapshots SET url = ?, fetched_at = ? WHERE id = ?`, url, fetchedAt.UTC(), ultimoID); err != nil {
+			return fmt.Errorf("error actualizando ficha guardada de la propiedad %d: %v", propiedadID, err)
+		}
+		return tx.Commit()
+	}
+
+	var comprimido bytes.Buffer
+	zw := gzip.NewWriter(&comprimido)
+	if _, err := io.WriteString(zw, html); err != nil {
+		return fmt.Errorf("error comprimiendo ficha: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("error comprimiendo ficha: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO detail_snapshots (propiedad_id, url, content_hash, html, size, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		propiedadID, url, hash, comprimido.Bytes(), len(html), fetchedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("error guardando ficha de la propiedad %d: %v", propiedadID, err)
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM detail_snapshots
+		WHERE propiedad_id = ? AND id NOT IN (
+			SELECT id FROM detail_snapshots
+			WHERE propiedad_id = ?
+			ORDER BY fetched_at DESC, id DESC
+			LIMIT ?
+		)`, propiedadID, propiedadID, MaxSnapshotsPerProperty)
+	if err != nil {
+		return fmt.Errorf("error eliminando fichas antiguas de la propiedad %d: %v", propiedadID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error confirmando transacción: %v", err)
+	}
+	return nil
+}
+
+// LatestDetailSnapshotIDs devuelve la última ficha guardada de cada propiedad que no se volvió
+// a procesar desde before, de las inmobiliarias indicadas (todas si no se indica ninguna).
+// Con limit mayor a 0 devuelve como mucho limit fichas.
+func (db *DB) LatestDetailSnapshotIDs(before time.Time, limit int, inmobiliariaIDs []int64) ([]int64, error) {
+	filtro, args := filtroInmobiliariasJob(inmobiliariaIDs)
+	args = append([]interface{}{before.UTC()}, args...)
+
+	query := fmt.Sprintf(`
+		SELECT s.id
+		FROM detail_snapshots s
+		JOIN propiedades p ON p.id = s.propiedad_id
+		WHERE s.id = (
+			SELECT id FROM detail_snapshots
+			WHERE propiedad_id = s.propiedad_id
+			ORDER BY fetched_at DESC, id DESC
+			LIMIT 1
+		)
+		AND (s.reparsed_at IS NULL OR s.reparsed_at < ?)%s
+		ORDER BY s.propiedad_id`, filtro)
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando fichas guardadas: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error escaneando ficha guardada: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// GetDetailSnapshot devuelve una ficha guardada con el HTML descomprimido
+func (db *DB) GetDetailSnapshot(id int64) (*DetailSnapshot, error) {
+	query := `
+		SELECT id, propiedad_id, url, content_hash, html, size, fetched_at, reparsed_at
+		FROM detail_snapshots
+		WHERE id = ?`
+
+	var s DetailSnapshot
+	var comprimido []byte
+	err := db.QueryRow(query, id).Scan(&s.ID, &s.PropiedadID, &s.URL, &s.ContentHash, &comprimido, &s.Size, &s.FetchedAt, &s.ReparsedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("error obteniendo ficha guardada %d: %w", id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo ficha guardada %d: %v", id, err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(comprimido))
+	if err != nil {
+		return nil, fmt.Errorf("error descomprimiendo ficha guardada %d: %v", id, err)
+	}
+	html, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("error descomprimiendo ficha guardada %d: %v", id, err)
+	}
+	s.HTML = string(html)
+
+	return &s, nil
+}
+
+// MarkDetailSnapshotReparsed registra que la ficha se volvió a procesar
+func (db *DB) MarkDetailSnapshotReparsed(id int64) error {
+	if _, err := db.Exec(`UPDATE detail_snapshots SET reparsed_at = ? WHERE id = ?`, time.Now().UTC(), id); err != nil {
+		return fmt.Errorf("error registrando ficha %d procesada: %v", id, err)
+	}
+	return nil
+}
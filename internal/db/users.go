@@ -0,0 +1,146 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DefaultUserID es el usuario al que se asignaron los datos anteriores a los usuarios
+const DefaultUserID int64 = 1
+
+// Roles de usuario, de menor a mayor permiso
+const (
+	RoleViewer = "viewer" // Solo consulta
+	RoleMember = "member" // Califica, marca favoritas y escribe notas y búsquedas propias
+	RoleAdmin  = "admin"  // Además administra inmobiliarias, usuarios y lanza scrapeos
+)
+
+// rolesPorNivel ordena los roles según sus permisos
+var rolesPorNivel = map[string]int{RoleViewer: 0, RoleMember: 1, RoleAdmin: 2}
+
+// ValidRole indica si role es un rol conocido
+func ValidRole(role string) bool {
+	_, ok := rolesPorNivel[role]
+	return ok
+}
+
+// HasRole indica si el usuario tiene al menos los permisos de role
+func (u *User) HasRole(role string) bool {
+	nivel, ok := rolesPorNivel[u.Role]
+	return ok && nivel >= rolesPorNivel[role]
+}
+
+// columnasUsuario son las columnas que lee scanUser
+const columnasUsuario = `id, name, role, household_id, created_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner, u *User, extra ...interface{}) error {
+	return row.Scan(append([]interface{}{&u.ID, &u.Name, &u.Role, &u.HouseholdID, &u.CreatedAt}, extra...)...)
+}
+
+// CreateUser crea un usuario. El nombre debe ser único; sin rol se crea como member.
+func (db *DB) CreateUser(u *User) error {
+	u.Name = strings.TrimSpace(u.Name)
+	if u.Name == "" {
+		return fmt.Errorf("el usuario no tiene nombre")
+	}
+	if u.Role == "" {
+		u.Role = RoleMember
+	}
+	if !ValidRole(u.Role) {
+		return fmt.Errorf("rol inválido: %s", u.Role)
+	}
+
+	query := `INSERT INTO users (name, role) VALUES (?, ?) RETURNING id, created_at`
+	if err := db.QueryRow(query, u.Name, u.Role).Scan(&u.ID, &u.CreatedAt); err != nil {
+		return fmt.Errorf("error creando usuario %q: %v", u.Name, err)
+	}
+
+	return nil
+}
+
+// GetUsers devuelve todos los usuarios
+func (db *DB) GetUsers() ([]User, error) {
+	rows, err := db.Query(`SELECT ` + columnasUsuario + ` FROM users ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando usuarios: %v", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := scanUser(rows, &u); err != nil {
+			return nil, fmt.Errorf("error escaneando usuario: %v", err)
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// GetUserByID devuelve un usuario
+func (db *DB) GetUserByID(id int64) (*User, error) {
+	var u User
+	err := scanUser(db.QueryRow(`SELECT `+columnasUsuario+` FROM users WHERE id = ?`, id), &u)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("error obteniendo usuario %d: %w", id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo usuario %d: %v", id, err)
+	}
+
+	return &u, nil
+}
+
+// GetUserCredentials devuelve un usuario por nombre junto con el hash de su contraseña,
+// vacío si no tiene
+func (db *DB) GetUserCredentials(name string) (*User, string, error) {
+	var u User
+	var hash sql.NullString
+	query := `SELECT ` + columnasUsuario + `, password_hash FROM users WHERE name = ?`
+
+	err := scanUser(db.QueryRow(query, strings.TrimSpace(name)), &u, &hash)
+	if err == sql.ErrNoRows {
+		return nil, "", fmt.Errorf("error obteniendo usuario %q: %w", name, err)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error obteniendo usuario %q: %v", name, err)
+	}
+
+	return &u, hash.String, nil
+}
+
+// SetUserPassword guarda el hash de la contraseña de un usuario
+func (db *DB) SetUserPassword(id int64, hash string) error {
+	result, err := db.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, hash, id)
+	if err != nil {
+		return fmt.Errorf("error guardando contraseña del usuario %d: %v", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("error guardando contraseña del usuario %d: %w", id, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// SetUserRole cambia el rol de un usuario
+func (db *DB) SetUserRole(id int64, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("rol inválido: %s", role)
+	}
+
+	result, err := db.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	if err != nil {
+		return fmt.Errorf("error cambiando rol del usuario %d: %v", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("error cambiando rol del usuario %d: %w", id, sql.ErrNoRows)
+	}
+
+	return nil
+}
@@ -0,0 +1,378 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Estados de una visita
+const (
+	VisitScheduled = "scheduled"
+	VisitDone      = "done"
+	VisitCancelled = "cancelled"
+	VisitNoShow    = "no_show"
+)
+
+// Duración por defecto de una visita
+const defaultVisitMinutes = 30
+
+// ValidVisitStatus indica si status es un estado de visita conocido
+func ValidVisitStatus(status string) bool {
+	switch status {
+	case VisitScheduled, VisitDone, VisitCancelled, VisitNoShow:
+		return true
+	}
+	return false
+}
+
+// ValidContactChannel indica si channel es un medio de contacto conocido
+func ValidContactChannel(channel string) bool {
+	switch channel {
+	case "phone", "whatsapp", "email", "in_person", "other":
+		return true
+	}
+	return false
+}
+
+// ValidContactStatus indica si status es un estado de contacto conocido
+func ValidContactStatus(status string) bool {
+	switch status {
+	case "waiting", "answered", "no_answer":
+		return true
+	}
+	return false
+}
+
+// CreateAgencyContact agrega una persona de contacto a una inmobiliaria
+func (db *DB) CreateAgencyContact(c *AgencyContact) error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return fmt.Errorf("el contacto no tiene nombre")
+	}
+	if _, err := db.GetInmobiliariaByID(c.InmobiliariaID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO agency_contacts (inmobiliaria_id, name, phone, email, notes, created_at)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?)
+		RETURNING id, created_at`
+
+	err := db.QueryRow(query, c.InmobiliariaID, c.Name, c.Phone, c.Email, c.Notes, time.Now().UTC()).Scan(&c.ID, &c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error creando contacto de la inmobiliaria %d: %v", c.InmobiliariaID, err)
+	}
+
+	return nil
+}
+
+// UpdateAgencyContact actualiza los datos de una persona de contacto
+func (db *DB) UpdateAgencyContact(c *AgencyContact) error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return fmt.Errorf("el contacto no tiene nombre")
+	}
+
+	query := `
+		UPDATE agency_contacts
+		SET name = ?, phone = NULLIF(?, ''), email = NULLIF(?, ''), notes = NULLIF(?, '')
+		WHERE id = ?
+		RETURNING inmobiliaria_id, created_at`
+
+	err := db.QueryRow(query, c.Name, c.Phone, c.Email, c.Notes, c.ID).Scan(&c.InmobiliariaID, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("error actualizando contacto %d: %w", c.ID, err)
+	}
+	if err != nil {
+		return fmt.Errorf("error actualizando contacto %d: %v", c.ID, err)
+	}
+
+	return nil
+}
+
+// GetAgencyContacts devuelve las personas de contacto de una inmobiliaria
+func (db *DB) GetAgencyContacts(inmobiliariaID int64) ([]AgencyContact, error) {
+	query := `
+		SELECT id, inmobiliaria_id, name, COALESCE(phone, ''), COALESCE(email, ''), COALESCE(notes, ''), created_at
+		FROM agency_contacts
+		WHERE inmobiliaria_id = ?
+		ORDER BY name`
+
+	rows, err := db.Query(query, inmobiliariaID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando contactos de la inmobiliaria %d: %v", inmobiliariaID, err)
+	}
+	defer rows.Close()
+
+	contacts := []AgencyContact{}
+	for rows.Next() {
+		var c AgencyContact
+		if err := rows.Scan(&c.ID, &c.InmobiliariaID, &c.Name, &c.Phone, &c.Email, &c.Notes, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error escaneando contacto: %v", err)
+		}
+		contacts = append(contacts, c)
+	}
+
+	return contacts, rows.Err()
+}
+
+// propertyAgency devuelve la inmobiliaria de una propiedad y verifica que contactID, si se
+// indica, sea una persona de esa inmobiliaria. Devuelve sql.ErrNoRows si la propiedad no existe.
+func (db *DB) propertyAgency(propertyID int64, contactID *int64) (int64, error) {
+	var inmobiliariaID int64
+	err := db.QueryRow(`SELECT inmobiliaria_id FROM propiedades WHERE id = ?`, propertyID).Scan(&inmobiliariaID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("la propiedad %d no existe: %w", propertyID, err)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error obteniendo propiedad %d: %v", propertyID, err)
+	}
+
+	if contactID != nil {
+		var contactAgency int64
+		err := db.QueryRow(`SELECT inmobiliaria_id FROM agency_contacts WHERE id = ?`, *contactID).Scan(&contactAgency)
+		if err == sql.ErrNoRows || (err == nil && contactAgency != inmobiliariaID) {
+			return 0, fmt.Errorf("el contacto %d no es de la inmobiliaria de la propiedad %d", *contactID, propertyID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error obteniendo contacto %d: %v", *contactID, err)
+		}
+	}
+
+	return inmobiliariaID, nil
+}
+
+// AddPropertyContact registra un contacto con la inmobiliaria de la propiedad
+func (db *DB) AddPropertyContact(c *PropertyContact) error {
+	if !ValidContactChannel(c.Channel) {
+		return fmt.Errorf("medio de contacto inválido: %s", c.Channel)
+	}
+	if c.Status == "" {
+		c.Status = "waiting"
+	}
+	if !ValidContactStatus(c.Status) {
+		return fmt.Errorf("estado de contacto inválido: %s", c.Status)
+	}
+	if c.ContactedAt.IsZero() {
+		c.ContactedAt = time.Now()
+	}
+	c.ContactedAt = c.ContactedAt.UTC()
+
+	inmobiliariaID, err := db.propertyAgency(c.PropertyID, c.ContactID)
+	if err != nil {
+		return err
+	}
+	c.InmobiliariaID = inmobiliariaID
+
+	query := `
+		INSERT INTO property_contacts (user_id, property_id, inmobiliaria_id, contact_id, channel, status, outcome, contacted_at)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?)
+		RETURNING id`
+
+	err = db.QueryRow(query, c.UserID, c.PropertyID, c.InmobiliariaID, c.ContactID, c.Channel, c.Status, c.Outcome, c.ContactedAt).Scan(&c.ID)
+	if err != nil {
+		return fmt.Errorf("error registrando contacto por la propiedad %d: %v", c.PropertyID, err)
+	}
+
+	return nil
+}
+
+// GetPropertyContacts devuelve los contactos del usuario con la inmobiliaria por una propiedad, del más reciente al más antiguo
+func (db *DB) GetPropertyContacts(userID, propertyID int64) ([]PropertyContact, error) {
+	query := `
+		SELECT pc.id, pc.user_id, pc.property_id, pc.inmobiliaria_id, pc.contact_id, COALESCE(ac.name, ''),
+			pc.channel, pc.status, COALESCE(pc.outcome, ''), pc.contacted_at
+		FROM property_contacts pc
+		LEFT JOIN agency_contacts ac ON ac.id = pc.contact_id
+		WHERE pc.user_id = ? AND pc.property_id = ?
+		ORDER BY pc.contacted_at DESC, pc.id DESC`
+
+	rows, err := db.Query(query, userID, propertyID)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando contactos por la propiedad %d: %v", propertyID, err)
+	}
+	defer rows.Close()
+
+	contacts := []PropertyContact{}
+	for rows.Next() {
+		var c PropertyContact
+		err := rows.Scan(&c.ID, &c.UserID, &c.PropertyID, &c.InmobiliariaID, &c.ContactID, &c.ContactName,
+			&c.Channel, &c.Status, &c.Outcome, &c.ContactedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error escaneando contacto: %v", err)
+		}
+		contacts = append(contacts, c)
+	}
+
+	return contacts, rows.Err()
+}
+
+// consultaVisitas es la consulta que lee scanVisit; se completa con WHERE y ORDER BY
+const consultaVisitas = `
+	SELECT v.id, v.user_id, v.property_id, v.inmobiliaria_id, v.contact_id, v.scheduled_at, v.duration_minutes,
+		v.status, COALESCE(v.outcome, ''), v.created_at, v.updated_at,
+		COALESCE(p.titulo, ''), COALESCE(p.direccion, ''), COALESCE(p.url, ''), COALESCE(i.nombre, ''),
+		COALESCE(ac.name, ''), COALESCE(ac.phone, '')
+	FROM visits v
+	LEFT JOIN propiedades p ON p.id = v.property_id
+	LEFT JOIN inmobiliarias i ON i.id = v.inmobiliaria_id
+	LEFT JOIN agency_contacts ac ON ac.id = v.contact_id`
+
+func scanVisit(row scanner, v *Visit) error {
+	return row.Scan(&v.ID, &v.UserID, &v.PropertyID, &v.InmobiliariaID, &v.ContactID, &v.ScheduledAt, &v.DurationMinutes,
+		&v.Status, &v.Outcome, &v.CreatedAt, &v.UpdatedAt,
+		&v.PropertyTitle, &v.PropertyAddress, &v.PropertyURL, &v.AgencyName,
+		&v.ContactName, &v.ContactPhone)
+}
+
+func (db *DB) queryVisits(where string, args ...interface{}) ([]Visit, error) {
+	rows, err := db.Query(consultaVisitas+" WHERE "+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando visitas: %v", err)
+	}
+	defer rows.Close()
+
+	visits := []Visit{}
+	for rows.Next() {
+		var v Visit
+		if err := scanVisit(rows, &v); err != nil {
+			return nil, fmt.Errorf("error escaneando visita: %v", err)
+		}
+		visits = append(visits, v)
+	}
+
+	return visits, rows.Err()
+}
+
+// GetVisit devuelve una visita del usuario. Devuelve sql.ErrNoRows si no existe o es de otro usuario.
+func (db *DB) GetVisit(userID, id int64) (*Visit, error) {
+	var v Visit
+	err := scanVisit(db.QueryRow(consultaVisitas+" WHERE v.id = ? AND v.user_id = ?", id, userID), &v)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("error obteniendo visita %d: %w", id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error obteniendo visita %d: %v", id, err)
+	}
+
+	return &v, nil
+}
+
+// validateVisit completa los valores por defecto y verifica una visita
+func validateVisit(v *Visit) error {
+	if v.ScheduledAt.IsZero() {
+		return fmt.Errorf("la visita no tiene fecha")
+	}
+	v.ScheduledAt = v.ScheduledAt.UTC()
+	if v.DurationMinutes == 0 {
+		v.DurationMinutes = defaultVisitMinutes
+	}
+	if v.DurationMinutes < 0 {
+		return fmt.Errorf("duración inválida: %d minutos", v.DurationMinutes)
+	}
+	if v.Status == "" {
+		v.Status = VisitScheduled
+	}
+	if !ValidVisitStatus(v.Status) {
+		return fmt.Errorf("estado de visita inválido: %s", v.Status)
+	}
+	return nil
+}
+
+// CreateVisit agenda una visita a una propiedad con su inmobiliaria
+func (db *DB) CreateVisit(v *Visit) error {
+	if err := validateVisit(v); err != nil {
+		return err
+	}
+
+	inmobiliariaID, err := db.propertyAgency(v.PropertyID, v.ContactID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	query := `
+		INSERT INTO visits (user_id, property_id, inmobiliaria_id, contact_id, scheduled_at, duration_minutes, status, outcome, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?)
+		RETURNING id`
+
+	var id int64
+	err = db.QueryRow(query, v.UserID, v.PropertyID, inmobiliariaID, v.ContactID, v.ScheduledAt, v.DurationMinutes,
+		v.Status, v.Outcome, now, now).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("error agendando visita a la propiedad %d: %v", v.PropertyID, err)
+	}
+
+	created, err := db.GetVisit(v.UserID, id)
+	if err != nil {
+		return err
+	}
+	*v = *created
+
+	return nil
+}
+
+// UpdateVisit reprograma una visita del usuario o registra su estado y resultado.
+// Devuelve sql.ErrNoRows si no existe o es de otro usuario.
+func (db *DB) UpdateVisit(v *Visit) error {
+	if err := validateVisit(v); err != nil {
+		return err
+	}
+
+	actual, err := db.GetVisit(v.UserID, v.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := db.propertyAgency(actual.PropertyID, v.ContactID); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE visits
+		SET contact_id = ?, scheduled_at = ?, duration_minutes = ?, status = ?, outcome = NULLIF(?, ''), updated_at = ?
+		WHERE id = ? AND user_id = ?`
+
+	_, err = db.Exec(query, v.ContactID, v.ScheduledAt, v.DurationMinutes, v.Status, v.Outcome, time.Now().UTC(), v.ID, v.UserID)
+	if err != nil {
+		return fmt.Errorf("error actualizando visita %d: %v", v.ID, err)
+	}
+
+	updated, err := db.GetVisit(v.UserID, v.ID)
+	if err != nil {
+		return err
+	}
+	*v = *updated
+
+	return nil
+}
+
+// DeleteVisit elimina una visita del usuario. Devuelve sql.ErrNoRows si no existe o es de otro usuario.
+func (db *DB) DeleteVisit(userID, id int64) error {
+	result, err := db.Exec(`DELETE FROM visits WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("error eliminando visita %d: %v", id, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("error eliminando visita %d: %w", id, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// GetPropertyVisits devuelve las visitas del usuario a una propiedad, de la más reciente a la más antigua
+func (db *DB) GetPropertyVisits(userID, propertyID int64) ([]Visit, error) {
+	return db.queryVisits("v.user_id = ? AND v.property_id = ? ORDER BY v.scheduled_at DESC", userID, propertyID)
+}
+
+// GetUpcomingVisits devuelve las visitas agendadas del usuario entre from y to, de la más próxima a la más lejana
+func (db *DB) GetUpcomingVisits(userID int64, from, to time.Time) ([]Visit, error) {
+	return db.queryVisits("v.user_id = ? AND v.status = ? AND v.scheduled_at >= ? AND v.scheduled_at < ? ORDER BY v.scheduled_at",
+		userID, VisitScheduled, from.UTC(), to.UTC())
+}
+
+// GetVisitsSince devuelve todas las visitas del usuario desde since, en cualquier estado, para el calendario
+func (db *DB) GetVisitsSince(userID int64, since time.Time) ([]Visit, error) {
+	return db.queryVisits("v.user_id = ? AND v.scheduled_at >= ? ORDER BY v.scheduled_at", userID, since.UTC())
+}
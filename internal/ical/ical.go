@@ -0,0 +1,128 @@
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// Largo máximo de una línea en octetos, sin contar el salto (RFC 5545, 3.1)
+const maxLineOctets = 75
+
+// Formato de fecha y hora en UTC
+const formatoUTC = "20060102T150405Z"
+
+// Event es un evento del calendario
+type Event struct {
+	UID         string
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Location    string
+	Description string
+	URL         string
+	Cancelled   bool
+	Updated     time.Time
+}
+
+// Calendar es un calendario iCalendar con sus eventos
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Write escribe el calendario en formato iCalendar (RFC 5545)
+func (c *Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	l := &lineWriter{w: bw}
+
+	l.line("BEGIN", "VCALENDAR")
+	l.line("VERSION", "2.0")
+	l.line("PRODID", "-//findhouse//visitas//ES")
+	l.line("CALSCALE", "GREGORIAN")
+	l.line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		l.text("X-WR-CALNAME", c.Name)
+	}
+
+	now := time.Now().UTC().Format(formatoUTC)
+	for _, e := range c.Events {
+		l.line("BEGIN", "VEVENT")
+		l.text("UID", e.UID)
+		l.line("DTSTAMP", now)
+		l.line("DTSTART", e.Start.UTC().Format(formatoUTC))
+		l.line("DTEND", e.End.UTC().Format(formatoUTC))
+		if !e.Updated.IsZero() {
+			l.line("LAST-MODIFIED", e.Updated.UTC().Format(formatoUTC))
+		}
+		l.text("SUMMARY", e.Summary)
+		if e.Location != "" {
+			l.text("LOCATION", e.Location)
+		}
+		if e.Description != "" {
+			l.text("DESCRIPTION", e.Description)
+		}
+		if e.URL != "" {
+			l.line("URL", e.URL)
+		}
+		if e.Cancelled {
+			l.line("STATUS", "CANCELLED")
+		} else {
+			l.line("STATUS", "CONFIRMED")
+		}
+		l.line("END", "VEVENT")
+	}
+
+	l.line("END", "VCALENDAR")
+	if l.err != nil {
+		return l.err
+	}
+	return bw.Flush()
+}
+
+// lineWriter escribe líneas de contenido plegadas, guardando el primer error
+type lineWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// text escribe una propiedad de tipo TEXT, escapando su valor
+func (l *lineWriter) text(name, value string) {
+	l.line(name, escapeText(value))
+}
+
+// line escribe una propiedad plegando las líneas largas: las continuaciones empiezan con un espacio
+func (l *lineWriter) line(name, value string) {
+	if l.err != nil {
+		return
+	}
+
+	contenido := name + ":" + value
+	var b strings.Builder
+	largo := 0
+	for _, r := range contenido {
+		n := len(string(r))
+		// No cortar en medio de un carácter UTF-8
+		if largo+n > maxLineOctets {
+			b.WriteString("\r\n ")
+			largo = 1
+		}
+		b.WriteRune(r)
+		largo += n
+	}
+	b.WriteString("\r\n")
+
+	_, l.err = l.w.WriteString(b.String())
+}
+
+// escapeText escapa un valor TEXT (RFC 5545, 3.3.11)
+func escapeText(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\n", `\n`,
+	).Replace(s)
+}
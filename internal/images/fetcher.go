@@ -0,0 +1,250 @@
+package images
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/findhouse/internal/db"
+	"github.com/findhouse/internal/scraper/ratelimit"
+)
+
+// Tamaño máximo de una foto descargada
+const MaxImageBytes = 15 << 20
+
+// Bits distintos hasta los que dos fotos de una misma propiedad se consideran la misma
+const DuplicateDistance = 4
+
+// Fotos pendientes que se leen de la base por vez
+const lote = 50
+
+// Result resume una ejecución de la descarga de fotos
+type Result struct {
+	Enqueued   int
+	Downloaded int
+	// Fotos cuyo contenido ya estaba guardado por otra propiedad o URL
+	Reused     int
+	Duplicates int
+	Failed     int
+	Postponed  int
+}
+
+// Fetcher descarga las fotos pendientes respetando la política de cortesía de cada sitio
+type Fetcher struct {
+	db      *db.DB
+	store   *Store
+	limiter *ratelimit.Limiter
+	client  *http.Client
+}
+
+// NewFetcher crea un Fetcher que comparte el limitador por sitio con los scrapers
+func NewFetcher(database *db.DB, store *Store, limiter *ratelimit.Limiter) *Fetcher {
+	return &Fetcher{
+		db:      database,
+		store:   store,
+		limiter: limiter,
+		client:  &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Run encola las fotos nuevas de las propiedades y descarga hasta limit pendientes (0 sin límite).
+// El estado de cada foto queda en la base, así que una ejecución interrumpida se retoma en la siguiente.
+func (f *Fetcher) Run(ctx context.Context, limit int) (*Result, error) {
+	result := &Result{}
+
+	encoladas, err := f.db.EnqueuePropertyImages()
+	if err != nil {
+		return result, err
+	}
+	result.Enqueued = encoladas
+
+	// Las fotos que fallan o se postergan quedan con el próximo intento después del inicio,
+	// así que no se vuelven a tomar en esta ejecución y cada lote trae fotos nuevas
+	inicio := time.Now()
+	procesadas := 0
+	for limit <= 0 || procesadas < limit {
+		cantidad := lote
+		if limit > 0 {
+			cantidad = min(lote, limit-procesadas)
+		}
+
+		pendientes, err := f.db.PendingImages(inicio, cantidad)
+		if err != nil {
+			return result, err
+		}
+		if len(pendientes) == 0 {
+			break
+		}
+
+		for i := range pendientes {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			if err := f.fetch(ctx, &pendientes[i], result); err != nil {
+				return result, err
+			}
+			procesadas++
+		}
+	}
+
+	return result, nil
+}
+
+// fetch descarga y guarda una foto. Solo devuelve error si no se pudo registrar el resultado
+// o se canceló el contexto; los fallos de la descarga quedan registrados en la foto.
+func (f *Fetcher) fetch(ctx context.Context, img *db.PropertyImage, result *Result) error {
+	data, err := f.download(ctx, img.URL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var statusErr *ratelimit.StatusError
+		if errors.As(err, &statusErr) {
+			// El sitio pidió bajar el ritmo: reintentar después de la espera sin contarlo como intento
+			espera := f.limiter.Backoff(img.URL, statusErr.RetryAfter)
+			result.Postponed++
+			return f.db.PostponeImage(img.ID, time.Now().Add(espera))
+		}
+
+		var permanente *errorPermanente
+		estado, errDB := f.db.FailImage(img, err, !errors.As(err, &permanente))
+		if errDB != nil {
+			return errDB
+		}
+		if estado == db.ImageFailed {
+			result.Failed++
+		}
+		fmt.Printf("Error descargando foto %s de la propiedad %d: %v\n", img.URL, img.PropertyID, err)
+		return nil
+	}
+
+	hash := Hash(data)
+	blob, err := f.db.GetImageBlob(hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil && f.store.Has(hash) {
+		result.Reused++
+	} else {
+		if blob, err = f.save(data); err != nil {
+			return err
+		}
+		result.Downloaded++
+	}
+
+	duplicateOf, err := f.duplicado(img, blob)
+	if err != nil {
+		return err
+	}
+	if duplicateOf != nil {
+		result.Duplicates++
+	}
+
+	return f.db.CompleteImage(img.ID, hash, duplicateOf)
+}
+
+// save guarda la foto y su miniatura. Las fotos en formatos que no se pueden decodificar
+// (por ejemplo WebP) o que superan MaxPixels se guardan igual, sin miniatura ni hash perceptual.
+func (f *Fetcher) save(data []byte) (*db.ImageBlob, error) {
+	hash, err := f.store.Put(data)
+	if err != nil {
+		return nil, err
+	}
+
+	blob := &db.ImageBlob{Hash: hash, ContentType: http.DetectContentType(data), Size: int64(len(data))}
+	if info, err := Analyze(data); err == nil {
+		if err := f.store.PutThumb(hash, info.Thumb); err != nil {
+			return nil, err
+		}
+		blob.Width, blob.Height, blob.PHash, blob.HasThumb = info.Width, info.Height, info.PHash, true
+	}
+
+	if err := f.db.SaveImageBlob(blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// duplicado busca entre las fotos ya descargadas de la misma propiedad una casi idéntica
+func (f *Fetcher) duplicado(img *db.PropertyImage, blob *db.ImageBlob) (*int64, error) {
+	if blob.PHash == "" {
+		return nil, nil
+	}
+
+	hashes, err := f.db.GetPropertyImageHashes(img.PropertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var original *int64
+	for id, phash := range hashes {
+		if id == img.ID {
+			continue
+		}
+		if d := Distance(phash, blob.PHash); d >= 0 && d <= DuplicateDistance && (original == nil || id < *original) {
+			original = &id
+		}
+	}
+	return original, nil
+}
+
+// errorPermanente es un fallo que no tiene sentido reintentar
+type errorPermanente struct {
+	msg string
+}
+
+func (e *errorPermanente) Error() string {
+	return e.msg
+}
+
+// download descarga la foto pasando por el limitador de su sitio
+func (f *Fetcher) download(ctx context.Context, url string) ([]byte, error) {
+	release, err := f.limiter.Acquire(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &errorPermanente{fmt.Sprintf("URL inválida: %v", err)}
+	}
+	req.Header.Set("User-Agent", ratelimit.DefaultConfig.UserAgent)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if ratelimit.IsThrottle(resp.StatusCode) {
+		return nil, &ratelimit.StatusError{URL: url, StatusCode: resp.StatusCode, RetryAfter: ratelimit.ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	f.limiter.Success(url)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, &errorPermanente{fmt.Sprintf("respuesta %d", resp.StatusCode)}
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("respuesta %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo foto: %v", err)
+	}
+	if len(data) > MaxImageBytes {
+		return nil, &errorPermanente{fmt.Sprintf("la foto supera los %d MB", MaxImageBytes>>20)}
+	}
+	if tipo := http.DetectContentType(data); !strings.HasPrefix(tipo, "image/") {
+		return nil, &errorPermanente{fmt.Sprintf("el contenido no es una imagen (%s)", tipo)}
+	}
+
+	return data, nil
+}
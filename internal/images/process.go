@@ -0,0 +1,143 @@
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"strconv"
+)
+
+// Lado mayor de las miniaturas en píxeles
+const ThumbSize = 320
+
+// Calidad JPEG de las miniaturas
+const thumbQuality = 80
+
+// Máximo de píxeles que se aceptan decodificar. Un PNG o GIF chico puede declarar
+// dimensiones enormes y decodificarlo reservaría gigabytes de memoria.
+const MaxPixels = 40_000_000
+
+// Info son los datos de una foto decodificada
+type Info struct {
+	Width  int
+	Height int
+	// Hash perceptual (dHash de 64 bits en hexadecimal): fotos casi idénticas tienen hashes cercanos
+	PHash string
+	// Miniatura en JPEG
+	Thumb []byte
+}
+
+// Analyze decodifica la foto, calcula su hash perceptual y genera la miniatura
+func Analyze(data []byte) (*Info, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decodificando foto: %v", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("foto vacía")
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, fmt.Errorf("foto demasiado grande: %dx%d píxeles", cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decodificando foto: %v", err)
+	}
+
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, fmt.Errorf("foto vacía")
+	}
+
+	w, h := b.Dx(), b.Dy()
+	if w > ThumbSize || h > ThumbSize {
+		if w >= h {
+			w, h = ThumbSize, max(b.Dy()*ThumbSize/b.Dx(), 1)
+		} else {
+			w, h = max(b.Dx()*ThumbSize/b.Dy(), 1), ThumbSize
+		}
+	}
+
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, resize(img, w, h), &jpeg.Options{Quality: thumbQuality}); err != nil {
+		return nil, fmt.Errorf("error generando miniatura: %v", err)
+	}
+
+	return &Info{
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		PHash:  fmt.Sprintf("%016x", dHash(img)),
+		Thumb:  thumb.Bytes(),
+	}, nil
+}
+
+// resize reduce la imagen promediando los píxeles de origen que caen en cada píxel de destino
+func resize(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
+
+// dHash reduce la imagen a 9x8 en escala de grises y compara cada píxel con su vecino de la derecha
+func dHash(img image.Image) uint64 {
+	chica := resize(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luminancia(chica.RGBAAt(x, y)) > luminancia(chica.RGBAAt(x+1, y)) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func luminancia(c color.RGBA) int {
+	return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+}
+
+// Distance devuelve la cantidad de bits distintos entre dos hashes perceptuales, o -1 si alguno no es válido
+func Distance(a, b string) int {
+	x, errA := strconv.ParseUint(a, 16, 64)
+	y, errB := strconv.ParseUint(b, 16, 64)
+	if errA != nil || errB != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
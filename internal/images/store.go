@@ -0,0 +1,91 @@
+package images
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// hashValido es el formato de los nombres de archivo del almacén: SHA-256 en hexadecimal
+var hashValido = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidHash indica si hash tiene el formato de los hashes del almacén
+func ValidHash(hash string) bool {
+	return hashValido.MatchString(hash)
+}
+
+// Hash devuelve el SHA-256 en hexadecimal del contenido
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Store guarda las fotos en disco según el hash de su contenido: <dir>/ab/abcdef...
+// y sus miniaturas en <dir>/thumbs/ab/abcdef.... Un mismo contenido se guarda una sola vez.
+type Store struct {
+	dir string
+}
+
+// NewStore crea el almacén en dir. Los directorios se crean al guardar la primera foto.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Path devuelve la ruta de la foto con ese hash
+func (s *Store) Path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// ThumbPath devuelve la ruta de la miniatura de la foto con ese hash
+func (s *Store) ThumbPath(hash string) string {
+	return filepath.Join(s.dir, "thumbs", hash[:2], hash)
+}
+
+// Has indica si la foto ya está guardada
+func (s *Store) Has(hash string) bool {
+	_, err := os.Stat(s.Path(hash))
+	return err == nil
+}
+
+// Put guarda la foto y devuelve su hash. Si ya estaba guardada no la vuelve a escribir.
+func (s *Store) Put(data []byte) (string, error) {
+	hash := Hash(data)
+	if s.Has(hash) {
+		return hash, nil
+	}
+	return hash, writeAtomic(s.Path(hash), data)
+}
+
+// PutThumb guarda la miniatura de la foto con ese hash
+func (s *Store) PutThumb(hash string, data []byte) error {
+	return writeAtomic(s.ThumbPath(hash), data)
+}
+
+// writeAtomic escribe en un archivo temporal y lo renombra, para que una descarga
+// interrumpida nunca deje un archivo a medias con el nombre definitivo
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creando directorio %s: %v", filepath.Dir(path), err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creando archivo temporal: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error escribiendo %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error escribiendo %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error guardando %s: %v", path, err)
+	}
+	return nil
+}
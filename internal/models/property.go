@@ -1,5 +1,10 @@
 package models
 
+import (
+	"fmt"
+	"time"
+)
+
 type Property struct {
 	ID          string
 	Title       string
@@ -57,4 +62,16 @@ type PropertyDetails struct {
 	Adicionales        []string // Calefacción, Apto profesional, etc.
 	Latitud            float64  // Coordenada de latitud del mapa
 	Longitud           float64  // Coordenada de longitud del mapa
+
+	// HTML de la ficha sobre el que se hizo la extracción y cuándo se descargó, para volver a procesarla
+	HTML      string    `json:"-"`
+	FetchedAt time.Time `json:"-"`
+}
+
+// String muestra los detalles en los logs resumiendo el HTML de la ficha
+func (d PropertyDetails) String() string {
+	type sinHTML PropertyDetails
+	resumen := sinHTML(d)
+	resumen.HTML = fmt.Sprintf("(%d bytes)", len(d.HTML))
+	return fmt.Sprintf("%+v", resumen)
 }
@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Tipos de canal soportados
+const (
+	ChannelSMTP     = "smtp"
+	ChannelTelegram = "telegram"
+	ChannelWebhook  = "webhook"
+)
+
+// Config es la configuración de las notificaciones, tal como se lee del archivo JSON
+type Config struct {
+	Lookback Duration        `json:"lookback"`  // Solo se notifican eventos más recientes que esto
+	MaxItems int             `json:"max_items"` // Máximo de eventos por resumen; el resto se cuenta como omitido
+	Channels []ChannelConfig `json:"channels"`
+}
+
+// ChannelConfig configura un canal de notificación
+type ChannelConfig struct {
+	Name           string   `json:"name"`            // Identifica al canal en el registro de enviados
+	Type           string   `json:"type"`            // smtp, telegram o webhook
+	DigestInterval Duration `json:"digest_interval"` // Tiempo mínimo entre dos resúmenes del canal
+	Subject        string   `json:"subject"`         // Plantilla del asunto (smtp)
+	Template       string   `json:"template"`        // Plantilla del mensaje (text/template sobre Digest)
+	TemplateFile   string   `json:"template_file"`   // Alternativa a Template: archivo con la plantilla
+
+	SMTP     *SMTPConfig     `json:"smtp,omitempty"`
+	Telegram *TelegramConfig `json:"telegram,omitempty"`
+	Webhook  *WebhookConfig  `json:"webhook,omitempty"`
+}
+
+// SMTPConfig configura el envío de emails
+type SMTPConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// TelegramConfig configura el envío por un bot de Telegram
+type TelegramConfig struct {
+	Token  string `json:"token"`
+	ChatID string `json:"chat_id"`
+	APIURL string `json:"api_url"` // Por defecto https://api.telegram.org
+}
+
+// WebhookConfig configura el envío a un webhook genérico
+type WebhookConfig struct {
+	URL         string            `json:"url"`
+	Headers     map[string]string `json:"headers"`
+	ContentType string            `json:"content_type"` // Por defecto application/json, o text/plain si hay plantilla
+}
+
+// Duration es un time.Duration que se escribe como "1h30m" en el JSON
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duración inválida %s: se espera un texto como \"1h\"", b)
+	}
+	if s == "" {
+		d.Duration = 0
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("duración inválida %q: %v", s, err)
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Valores por defecto de la configuración
+const (
+	defaultLookback = 48 * time.Hour
+	defaultMaxItems = 25
+)
+
+// LoadConfig lee y valida la configuración de notificaciones desde un archivo JSON
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo configuración de notificaciones: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error interpretando configuración de notificaciones: %v", err)
+	}
+
+	for i := range cfg.Channels {
+		canal := &cfg.Channels[i]
+		if canal.Template == "" && canal.TemplateFile != "" {
+			plantilla, err := os.ReadFile(canal.TemplateFile)
+			if err != nil {
+				return nil, fmt.Errorf("canal %s: error leyendo plantilla: %v", canal.Name, err)
+			}
+			canal.Template = string(plantilla)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// validate completa los valores por defecto y verifica que cada canal esté bien configurado
+func (c *Config) validate() error {
+	if c.Lookback.Duration <= 0 {
+		c.Lookback.Duration = defaultLookback
+	}
+	if c.MaxItems <= 0 {
+		c.MaxItems = defaultMaxItems
+	}
+
+	nombres := make(map[string]bool)
+	for i := range c.Channels {
+		canal := &c.Channels[i]
+		if canal.Name == "" {
+			canal.Name = canal.Type
+		}
+		if nombres[canal.Name] {
+			return fmt.Errorf("canal de notificación duplicado: %s", canal.Name)
+		}
+		nombres[canal.Name] = true
+
+		var err error
+		switch canal.Type {
+		case ChannelSMTP:
+			switch {
+			case canal.SMTP == nil:
+				err = fmt.Errorf("falta la sección smtp")
+			case canal.SMTP.Host == "" || canal.SMTP.From == "" || len(canal.SMTP.To) == 0:
+				err = fmt.Errorf("smtp requiere host, from y to")
+			case canal.SMTP.Port == 0:
+				canal.SMTP.Port = 25
+			}
+		case ChannelTelegram:
+			switch {
+			case canal.Telegram == nil:
+				err = fmt.Errorf("falta la sección telegram")
+			case canal.Telegram.Token == "" || canal.Telegram.ChatID == "":
+				err = fmt.Errorf("telegram requiere token y chat_id")
+			case canal.Telegram.APIURL == "":
+				canal.Telegram.APIURL = "https://api.telegram.org"
+			}
+		case ChannelWebhook:
+			if canal.Webhook == nil || canal.Webhook.URL == "" {
+				err = fmt.Errorf("webhook requiere url")
+			}
+		default:
+			err = fmt.Errorf("tipo de canal desconocido %q", canal.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("canal %s: %v", canal.Name, err)
+		}
+	}
+
+	return nil
+}
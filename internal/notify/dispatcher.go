@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/findhouse/internal/db"
+)
+
+// Store provee los eventos a notificar y el registro de los ya enviados
+type Store interface {
+	GetNotificationEvents(channel string, since time.Time) ([]db.NotificationEvent, error)
+	LastNotificationSent(channel string) (time.Time, bool, error)
+	RecordNotificationsSent(channel string, events []db.NotificationEvent) error
+}
+
+// canalConfigurado es un canal junto con su intervalo de resumen
+type canalConfigurado struct {
+	Channel
+	interval time.Duration
+}
+
+// Dispatcher agrupa los eventos pendientes en un resumen por canal y los envía
+type Dispatcher struct {
+	store    Store
+	cfg      Config
+	channels []canalConfigurado
+}
+
+// NewDispatcher crea un dispatcher con los canales de cfg
+func NewDispatcher(store Store, cfg Config) (*Dispatcher, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	d := &Dispatcher{store: store, cfg: cfg}
+	for _, canal := range cfg.Channels {
+		c, err := NewChannel(canal)
+		if err != nil {
+			return nil, err
+		}
+		d.channels = append(d.channels, canalConfigurado{Channel: c, interval: canal.DigestInterval.Duration})
+	}
+	return d, nil
+}
+
+// Dispatch envía por cada canal los eventos que todavía no recibió.
+// Un canal que falla no impide enviar por los demás; sus eventos quedan pendientes para el próximo intento.
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	var errs []error
+	for _, canal := range d.channels {
+		if err := d.dispatchChannel(ctx, canal); err != nil {
+			errs = append(errs, fmt.Errorf("canal %s: %w", canal.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Dispatcher) dispatchChannel(ctx context.Context, canal canalConfigurado) error {
+	now := time.Now().UTC()
+
+	// Respetar el intervalo entre resúmenes; los eventos se acumulan para el siguiente
+	if canal.interval > 0 {
+		ultimo, ok, err := d.store.LastNotificationSent(canal.Name())
+		if err != nil {
+			return err
+		}
+		if ok && now.Sub(ultimo) < canal.interval {
+			return nil
+		}
+	}
+
+	eventos, err := d.store.GetNotificationEvents(canal.Name(), now.Add(-d.cfg.Lookback.Duration))
+	if err != nil {
+		return err
+	}
+	if len(eventos) == 0 {
+		return nil
+	}
+
+	digest := Digest{Channel: canal.Name(), GeneratedAt: now, Items: eventos}
+	if len(eventos) > d.cfg.MaxItems {
+		digest.Items = eventos[:d.cfg.MaxItems]
+		digest.Omitted = len(eventos) - d.cfg.MaxItems
+	}
+
+	if err := canal.Send(ctx, digest); err != nil {
+		return err
+	}
+
+	// Los omitidos también se dan por notificados: el resumen ya avisó cuántos eran
+	if err := d.store.RecordNotificationsSent(canal.Name(), eventos); err != nil {
+		return err
+	}
+
+	log.Printf("📨 Notificación enviada por %s: %d eventos (%d omitidos)", canal.Name(), len(digest.Items), digest.Omitted)
+	return nil
+}
+
+// SendTest envía un resumen de ejemplo por cada canal, sin registrarlo como enviado
+func (d *Dispatcher) SendTest(ctx context.Context) error {
+	now := time.Now().UTC()
+	ejemplo := []db.NotificationEvent{
+		{
+			Key: "test:1", Kind: db.NotificationNewMatch, SearchName: "Ejemplo",
+			Title: "Departamento 3 ambientes con balcón", Price: "120000", Currency: "USD",
+			Location: "Lanús Oeste", URL: "https://example.com/propiedad/1", Agency: "Inmobiliaria de prueba", At: now,
+		},
+		{
+			Key: "test:2", Kind: db.NotificationPriceChange, SearchName: "Ejemplo",
+			Title: "Casa con jardín", Price: "150000", OldPrice: "165000", Currency: "USD",
+			Location: "Avellaneda", URL: "https://example.com/propiedad/2", Agency: "Inmobiliaria de prueba", At: now,
+		},
+	}
+
+	var errs []error
+	for _, canal := range d.channels {
+		digest := Digest{Channel: canal.Name(), GeneratedAt: now, Items: ejemplo, Test: true}
+		if err := canal.Send(ctx, digest); err != nil {
+			errs = append(errs, fmt.Errorf("canal %s: %w", canal.Name(), err))
+			continue
+		}
+		log.Printf("📨 Notificación de prueba enviada por %s", canal.Name())
+	}
+	return errors.Join(errs...)
+}
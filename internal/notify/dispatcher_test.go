@@ -0,0 +1,396 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/findhouse/internal/db"
+)
+
+// memoryStore es un Store en memoria que recuerda qué eventos se registraron como enviados
+type memoryStore struct {
+	mu      sync.Mutex
+	eventos []db.NotificationEvent
+	sent    map[string]map[string]bool
+	ultimo  map[string]time.Time
+}
+
+func newMemoryStore(eventos ...db.NotificationEvent) *memoryStore {
+	return &memoryStore{eventos: eventos, sent: map[string]map[string]bool{}, ultimo: map[string]time.Time{}}
+}
+
+func (s *memoryStore) GetNotificationEvents(channel string, since time.Time) ([]db.NotificationEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pendientes []db.NotificationEvent
+	for _, e := range s.eventos {
+		if !e.At.Before(since) && !s.sent[channel][e.Key] {
+			pendientes = append(pendientes, e)
+		}
+	}
+	return pendientes, nil
+}
+
+func (s *memoryStore) LastNotificationSent(channel string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.ultimo[channel]
+	return t, ok, nil
+}
+
+func (s *memoryStore) RecordNotificationsSent(channel string, eventos []db.NotificationEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sent[channel] == nil {
+		s.sent[channel] = map[string]bool{}
+	}
+	for _, e := range eventos {
+		s.sent[channel][e.Key] = true
+	}
+	s.ultimo[channel] = time.Now().UTC()
+	return nil
+}
+
+func (s *memoryStore) enviados(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sent[channel])
+}
+
+func eventos(n int) []db.NotificationEvent {
+	now := time.Now().UTC()
+	var es []db.NotificationEvent
+	for i := 1; i <= n; i++ {
+		es = append(es, db.NotificationEvent{
+			Key: fmt.Sprintf("new:1:%d", i), Kind: db.NotificationNewMatch, SearchID: 1, SearchName: "Lanús",
+			PropertyID: int64(i), Title: fmt.Sprintf("Propiedad %d", i), Price: "100000", Currency: "USD",
+			URL: fmt.Sprintf("https://example.com/%d", i), At: now.Add(time.Duration(i-n) * time.Minute),
+		})
+	}
+	return es
+}
+
+// webhookServer registra los resúmenes recibidos y responde con el estado indicado
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	recibido []Digest
+}
+
+func newWebhookServer(t *testing.T) *webhookServer {
+	w := &webhookServer{status: http.StatusOK}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		if w.status != http.StatusOK {
+			http.Error(rw, "no disponible", w.status)
+			return
+		}
+		var digest Digest
+		if err := json.NewDecoder(r.Body).Decode(&digest); err != nil {
+			t.Errorf("cuerpo del webhook inválido: %v", err)
+		}
+		w.recibido = append(w.recibido, digest)
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *webhookServer) setStatus(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status = status
+}
+
+func (w *webhookServer) digests() []Digest {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Digest(nil), w.recibido...)
+}
+
+func webhookDispatcher(t *testing.T, store Store, url string, maxItems int, interval time.Duration) *Dispatcher {
+	t.Helper()
+	d, err := NewDispatcher(store, Config{
+		MaxItems: maxItems,
+		Channels: []ChannelConfig{{
+			Name: "hook", Type: ChannelWebhook, DigestInterval: Duration{interval},
+			Webhook: &WebhookConfig{URL: url},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestWebhookDigestTruncatesToMaxItems(t *testing.T) {
+	srv := newWebhookServer(t)
+	store := newMemoryStore(eventos(3)...)
+	d := webhookDispatcher(t, store, srv.URL, 2, 0)
+
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recibidos := srv.digests()
+	if len(recibidos) != 1 {
+		t.Fatalf("se esperaba un solo resumen, llegaron %d", len(recibidos))
+	}
+	if got := len(recibidos[0].Items); got != 2 {
+		t.Errorf("items = %d, se esperaban 2", got)
+	}
+	if recibidos[0].Omitted != 1 {
+		t.Errorf("omitted = %d, se esperaba 1", recibidos[0].Omitted)
+	}
+	// Los omitidos también quedan registrados: el resumen ya avisó cuántos eran
+	if got := store.enviados("hook"); got != 3 {
+		t.Errorf("eventos registrados = %d, se esperaban 3", got)
+	}
+
+	// Sin eventos nuevos no se envía nada
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.digests()); got != 1 {
+		t.Errorf("resúmenes = %d después de un segundo envío sin eventos, se esperaba 1", got)
+	}
+}
+
+func TestWebhookDigestInterval(t *testing.T) {
+	srv := newWebhookServer(t)
+	store := newMemoryStore(eventos(1)...)
+	d := webhookDispatcher(t, store, srv.URL, 10, time.Hour)
+
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un evento nuevo dentro del intervalo se acumula para el próximo resumen
+	store.mu.Lock()
+	nuevo := eventos(2)[1]
+	nuevo.Key, nuevo.At = "new:1:99", time.Now().UTC()
+	store.eventos = append(store.eventos, nuevo)
+	store.mu.Unlock()
+
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.digests()); got != 1 {
+		t.Fatalf("resúmenes = %d dentro del intervalo, se esperaba 1", got)
+	}
+
+	store.mu.Lock()
+	store.ultimo["hook"] = time.Now().UTC().Add(-2 * time.Hour)
+	store.mu.Unlock()
+
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	recibidos := srv.digests()
+	if len(recibidos) != 2 || len(recibidos[1].Items) != 1 || recibidos[1].Items[0].Key != "new:1:99" {
+		t.Fatalf("el segundo resumen debía traer solo el evento acumulado: %+v", recibidos)
+	}
+}
+
+func TestWebhookFailureIsRetried(t *testing.T) {
+	srv := newWebhookServer(t)
+	srv.setStatus(http.StatusInternalServerError)
+	store := newMemoryStore(eventos(2)...)
+	d := webhookDispatcher(t, store, srv.URL, 10, 0)
+
+	if err := d.Dispatch(context.Background()); err == nil {
+		t.Fatal("se esperaba error con el webhook respondiendo 500")
+	}
+	if got := store.enviados("hook"); got != 0 {
+		t.Fatalf("eventos registrados = %d tras un envío fallido, se esperaban 0", got)
+	}
+
+	srv.setStatus(http.StatusOK)
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	recibidos := srv.digests()
+	if len(recibidos) != 1 || len(recibidos[0].Items) != 2 {
+		t.Fatalf("el reintento debía enviar los 2 eventos: %+v", recibidos)
+	}
+	if got := store.enviados("hook"); got != 2 {
+		t.Errorf("eventos registrados = %d, se esperaban 2", got)
+	}
+}
+
+// smtpServer es un servidor SMTP mínimo que guarda los mensajes recibidos.
+// Con rechazar, responde 554 al final del DATA.
+type smtpServer struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	rechazar bool
+	mensajes []string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.atender(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) atender(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	responder := func(linea string) { io.WriteString(conn, linea+"\r\n") }
+
+	responder("220 localhost ESMTP")
+	for {
+		linea, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		comando := strings.ToUpper(strings.TrimSpace(linea))
+		switch {
+		case strings.HasPrefix(comando, "EHLO"), strings.HasPrefix(comando, "HELO"):
+			responder("250 localhost")
+		case strings.HasPrefix(comando, "MAIL"), strings.HasPrefix(comando, "RCPT"), strings.HasPrefix(comando, "RSET"):
+			responder("250 OK")
+		case comando == "DATA":
+			responder("354 fin con <CRLF>.<CRLF>")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			s.mu.Lock()
+			rechazar := s.rechazar
+			if !rechazar {
+				s.mensajes = append(s.mensajes, msg.String())
+			}
+			s.mu.Unlock()
+			if rechazar {
+				responder("554 rechazado")
+			} else {
+				responder("250 OK")
+			}
+		case comando == "QUIT":
+			responder("221 chau")
+			return
+		default:
+			responder("502 no implementado")
+		}
+	}
+}
+
+func (s *smtpServer) setRechazar(v bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rechazar = v
+}
+
+func (s *smtpServer) recibidos() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.mensajes...)
+}
+
+func smtpDispatcher(t *testing.T, store Store, srv *smtpServer, maxItems int) *Dispatcher {
+	t.Helper()
+	_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+	puerto, _ := strconv.Atoi(port)
+
+	d, err := NewDispatcher(store, Config{
+		MaxItems: maxItems,
+		Channels: []ChannelConfig{{
+			Name: "mail", Type: ChannelSMTP,
+			SMTP: &SMTPConfig{Host: "127.0.0.1", Port: puerto, From: "findhouse@example.com", To: []string{"yo@example.com"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestSMTPDigestTruncatesToMaxItems(t *testing.T) {
+	srv := newSMTPServer(t)
+	store := newMemoryStore(eventos(3)...)
+	d := smtpDispatcher(t, store, srv, 2)
+
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mensajes := srv.recibidos()
+	if len(mensajes) != 1 {
+		t.Fatalf("se esperaba un solo email, llegaron %d", len(mensajes))
+	}
+	msg := mensajes[0]
+	for _, esperado := range []string{"To: yo@example.com", "Propiedad 1", "Propiedad 2", "... y 1 más"} {
+		if !strings.Contains(msg, esperado) {
+			t.Errorf("el email no contiene %q:\n%s", esperado, msg)
+		}
+	}
+	if strings.Contains(msg, "Propiedad 3") {
+		t.Errorf("el email incluye un evento omitido:\n%s", msg)
+	}
+	if got := store.enviados("mail"); got != 3 {
+		t.Errorf("eventos registrados = %d, se esperaban 3", got)
+	}
+}
+
+func TestSMTPFailureIsRetried(t *testing.T) {
+	srv := newSMTPServer(t)
+	srv.setRechazar(true)
+	store := newMemoryStore(eventos(2)...)
+	d := smtpDispatcher(t, store, srv, 10)
+
+	if err := d.Dispatch(context.Background()); err == nil {
+		t.Fatal("se esperaba error con el servidor rechazando el mensaje")
+	}
+	if got := store.enviados("mail"); got != 0 {
+		t.Fatalf("eventos registrados = %d tras un envío fallido, se esperaban 0", got)
+	}
+
+	srv.setRechazar(false)
+	if err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(srv.recibidos()); got != 1 {
+		t.Fatalf("emails = %d tras el reintento, se esperaba 1", got)
+	}
+	if got := store.enviados("mail"); got != 2 {
+		t.Errorf("eventos registrados = %d, se esperaban 2", got)
+	}
+}
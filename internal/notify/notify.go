@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/findhouse/internal/db"
+)
+
+// Digest es un resumen de eventos que se envía de una vez por un canal
+type Digest struct {
+	Channel     string                 `json:"channel"`
+	GeneratedAt time.Time              `json:"generated_at"`
+	Items       []db.NotificationEvent `json:"items"`
+	Omitted     int                    `json:"omitted"` // Eventos que no entraron en el resumen
+	Test        bool                   `json:"test,omitempty"`
+}
+
+// Channel envía resúmenes por un medio concreto
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, digest Digest) error
+}
+
+// Tiempo máximo de una solicitud HTTP a Telegram o a un webhook
+const httpTimeout = 30 * time.Second
+
+// Plantillas por defecto
+const (
+	defaultSubject  = `findhouse: {{len .Items}} novedades en tus búsquedas`
+	defaultTemplate = `{{if .Test}}Mensaje de prueba de findhouse
+{{end}}{{len .Items}} novedades en tus búsquedas guardadas
+{{range .Items}}
+{{if eq .Kind "price_change"}}💲 Cambió el precio{{else}}🏠 Nueva propiedad{{end}} · {{.SearchName}}
+{{.Title}}
+{{.Currency}} {{.Price}}{{if .OldPrice}} (antes {{.OldPrice}}){{end}}{{if .Location}} · {{.Location}}{{end}}{{if .Agency}} · {{.Agency}}{{end}}
+{{.URL}}
+{{end}}{{if .Omitted}}
+... y {{.Omitted}} más
+{{end}}`
+)
+
+// NewChannel crea el canal descripto por cfg
+func NewChannel(cfg ChannelConfig) (Channel, error) {
+	mensaje, err := parseTemplate(cfg.Name, cfg.Template, defaultTemplate)
+	if err != nil {
+		return nil, err
+	}
+	cliente := &http.Client{Timeout: httpTimeout}
+
+	switch cfg.Type {
+	case ChannelSMTP:
+		asunto, err := parseTemplate(cfg.Name+"-subject", cfg.Subject, defaultSubject)
+		if err != nil {
+			return nil, err
+		}
+		return &smtpChannel{name: cfg.Name, cfg: *cfg.SMTP, subject: asunto, body: mensaje}, nil
+	case ChannelTelegram:
+		return &telegramChannel{name: cfg.Name, cfg: *cfg.Telegram, body: mensaje, client: cliente}, nil
+	case ChannelWebhook:
+		// Sin plantilla propia el webhook recibe el resumen en JSON
+		var cuerpo *template.Template
+		if cfg.Template != "" {
+			cuerpo = mensaje
+		}
+		return &webhookChannel{name: cfg.Name, cfg: *cfg.Webhook, body: cuerpo, client: cliente}, nil
+	default:
+		return nil, fmt.Errorf("tipo de canal desconocido %q", cfg.Type)
+	}
+}
+
+// parseTemplate compila la plantilla de un canal, o la por defecto si no tiene
+func parseTemplate(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("canal %s: plantilla inválida: %v", name, err)
+	}
+	return t, nil
+}
+
+// render aplica una plantilla al resumen
+func render(t *template.Template, digest Digest) (string, error) {
+	var sb strings.Builder
+	if err := t.Execute(&sb, digest); err != nil {
+		return "", fmt.Errorf("error aplicando plantilla %s: %v", t.Name(), err)
+	}
+	return sb.String(), nil
+}
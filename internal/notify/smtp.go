@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// smtpChannel envía el resumen por email
+type smtpChannel struct {
+	name    string
+	cfg     SMTPConfig
+	subject *template.Template
+	body    *template.Template
+}
+
+func (c *smtpChannel) Name() string { return c.name }
+
+func (c *smtpChannel) Send(ctx context.Context, digest Digest) error {
+	asunto, err := render(c.subject, digest)
+	if err != nil {
+		return err
+	}
+	cuerpo, err := render(c.body, digest)
+	if err != nil {
+		return err
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", c.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(c.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(asunto)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", digest.GeneratedAt.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(cuerpo, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if c.cfg.Username != "" {
+		auth = smtp.PlainAuth("", c.cfg.Username, c.cfg.Password, c.cfg.Host)
+	}
+
+	// net/smtp no acepta contexto; al menos no empezar si ya se canceló
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(c.cfg.Host, strconv.Itoa(c.cfg.Port))
+	if err := smtp.SendMail(addr, auth, c.cfg.From, c.cfg.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("error enviando email por %s: %v", addr, err)
+	}
+	return nil
+}
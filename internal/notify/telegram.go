@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// Largo máximo de un mensaje de Telegram
+const telegramMaxLength = 4096
+
+// telegramChannel envía el resumen por un bot de Telegram
+type telegramChannel struct {
+	name   string
+	cfg    TelegramConfig
+	body   *template.Template
+	client *http.Client
+}
+
+func (c *telegramChannel) Name() string { return c.name }
+
+func (c *telegramChannel) Send(ctx context.Context, digest Digest) error {
+	texto, err := render(c.body, digest)
+	if err != nil {
+		return err
+	}
+	if runas := []rune(texto); len(runas) > telegramMaxLength {
+		texto = string(runas[:telegramMaxLength-1]) + "…"
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  c.cfg.ChatID,
+		"text":                     texto,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimRight(c.cfg.APIURL, "/") + "/bot" + c.cfg.Token + "/sendMessage"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// El error incluye la URL con el token del bot
+		return fmt.Errorf("error enviando mensaje a Telegram: %v", strings.ReplaceAll(err.Error(), c.cfg.Token, "***"))
+	}
+	defer resp.Body.Close()
+
+	var respuesta struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&respuesta); err != nil {
+		return fmt.Errorf("respuesta inválida de Telegram (%d): %v", resp.StatusCode, err)
+	}
+	if !respuesta.OK {
+		return fmt.Errorf("Telegram rechazó el mensaje (%d): %s", resp.StatusCode, respuesta.Description)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// webhookChannel envía el resumen por POST a una URL
+type webhookChannel struct {
+	name   string
+	cfg    WebhookConfig
+	body   *template.Template // nil para enviar el resumen en JSON
+	client *http.Client
+}
+
+func (c *webhookChannel) Name() string { return c.name }
+
+func (c *webhookChannel) Send(ctx context.Context, digest Digest) error {
+	var payload []byte
+	contentType := c.cfg.ContentType
+
+	if c.body != nil {
+		texto, err := render(c.body, digest)
+		if err != nil {
+			return err
+		}
+		payload = []byte(texto)
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+	} else {
+		var err error
+		if payload, err = json.Marshal(digest); err != nil {
+			return err
+		}
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range c.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error enviando webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detalle, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook respondió %d: %s", resp.StatusCode, strings.TrimSpace(string(detalle)))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package recommender
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/findhouse/internal/db"
+)
+
+// Tamaño de la grilla con la que se agrupan las coordenadas en zonas (~1 km)
+const gridSize = 0.01
+
+// features son los valores de una propiedad que usa el modelo: numéricos, que se
+// estandarizan, y categóricos, que valen 1 si están presentes
+type features struct {
+	numeric     map[string]float64
+	categorical []string
+}
+
+// extractFeatures calcula las características de una propiedad. Los numéricos que no se
+// pueden calcular se omiten y el modelo los reemplaza por el promedio.
+func extractFeatures(p *db.Propiedad, featureIDs []int64) features {
+	f := features{numeric: map[string]float64{}}
+
+	// Precio y precio por m², separados por moneda porque no son comparables
+	precio, moneda, ok := db.ParsePrecio(p.Precio)
+	if ok {
+		moneda = strings.ToLower(moneda)
+		f.categorical = append(f.categorical, "currency:"+moneda)
+		f.numeric["log_price_"+moneda] = math.Log(precio)
+		if area := superficie(p); area > 0 {
+			f.numeric["log_price_m2_"+moneda] = math.Log(precio / area)
+		}
+	}
+
+	if area := superficie(p); area > 0 {
+		f.numeric["log_area"] = math.Log(area)
+	}
+	if p.SuperficieTerreno != nil && *p.SuperficieTerreno > 0 {
+		f.numeric["log_land_area"] = math.Log(*p.SuperficieTerreno)
+	}
+	setInt(f.numeric, "rooms", p.Ambientes)
+	setInt(f.numeric, "bedrooms", p.Dormitorios)
+	setInt(f.numeric, "bathrooms", p.Banios)
+	setInt(f.numeric, "garages", p.Cocheras)
+	setInt(f.numeric, "age", p.Antiguedad)
+	if p.Expensas != nil && *p.Expensas >= 0 {
+		f.numeric["log_expenses"] = math.Log1p(*p.Expensas)
+	}
+
+	if zona := locationCluster(p); zona != "" {
+		f.categorical = append(f.categorical, "location:"+zona)
+	}
+	if p.InmobiliariaID > 0 {
+		f.categorical = append(f.categorical, fmt.Sprintf("agency:%d", p.InmobiliariaID))
+	}
+	if p.TipoPropiedad != nil {
+		f.categorical = append(f.categorical, fmt.Sprintf("type:%d", *p.TipoPropiedad))
+	}
+	if p.Operacion != nil && *p.Operacion != "" {
+		f.categorical = append(f.categorical, "operation:"+strings.ToLower(*p.Operacion))
+	}
+	for _, id := range featureIDs {
+		f.categorical = append(f.categorical, fmt.Sprintf("feature:%d", id))
+	}
+
+	return f
+}
+
+func setInt(numeric map[string]float64, name string, v *int) {
+	if v != nil && *v >= 0 {
+		numeric[name] = float64(*v)
+	}
+}
+
+// superficie devuelve la superficie cubierta o, si no se conoce, la total
+func superficie(p *db.Propiedad) float64 {
+	if p.SuperficieCubierta != nil && *p.SuperficieCubierta > 0 {
+		return *p.SuperficieCubierta
+	}
+	if p.SuperficieTotal != nil && *p.SuperficieTotal > 0 {
+		return *p.SuperficieTotal
+	}
+	return 0
+}
+
+// locationCluster agrupa las propiedades por zona: una celda de la grilla si tiene
+// coordenadas, o la ubicación publicada si no
+func locationCluster(p *db.Propiedad) string {
+	if p.Latitud != nil && p.Longitud != nil && (*p.Latitud != 0 || *p.Longitud != 0) {
+		return fmt.Sprintf("%.2f,%.2f", math.Floor(*p.Latitud/gridSize)*gridSize, math.Floor(*p.Longitud/gridSize)*gridSize)
+	}
+	if p.Ubicacion != nil {
+		return strings.ToLower(strings.TrimSpace(*p.Ubicacion))
+	}
+	return ""
+}
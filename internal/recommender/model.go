@@ -0,0 +1,289 @@
+package recommender
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/findhouse/internal/db"
+)
+
+// Parámetros del entrenamiento
+const (
+	epochs       = 300
+	learningRate = 0.1
+	l2           = 0.01 // Regularización: evita que una característica rara domine
+
+	// Calificaciones mínimas, de cada tipo, para entrenar
+	MinLikes    = 5
+	MinDislikes = 5
+)
+
+// ErrNotEnoughRatings indica que el usuario todavía no calificó lo suficiente para entrenar
+var ErrNotEnoughRatings = errors.New("no hay suficientes calificaciones para entrenar")
+
+// Model es una regresión logística que estima la probabilidad de que al usuario le guste una propiedad
+type Model struct {
+	Bias    float64            `json:"bias"`
+	Weights map[string]float64 `json:"weights"`
+	// Promedio y desvío de cada característica numérica en el entrenamiento, para estandarizar
+	Mean map[string]float64 `json:"mean"`
+	Std  map[string]float64 `json:"std"`
+
+	Samples   int       `json:"samples"`
+	Likes     int       `json:"likes"`
+	Dislikes  int       `json:"dislikes"`
+	Accuracy  float64   `json:"accuracy"`
+	TrainedAt time.Time `json:"trained_at"`
+}
+
+// Weight es el peso de una característica, para explicar qué aprendió el modelo
+type Weight struct {
+	Feature string  `json:"feature"`
+	Weight  float64 `json:"weight"`
+}
+
+// Sample es una propiedad calificada con sus características
+type Sample struct {
+	Property   db.Propiedad
+	FeatureIDs []int64
+	Liked      bool
+}
+
+// entrada es un valor no nulo del vector de características
+type entrada struct {
+	feature string
+	value   float64
+}
+
+// Train entrena un modelo con las calificaciones del usuario
+func Train(samples []Sample) (*Model, error) {
+	m := &Model{
+		Weights:   map[string]float64{},
+		Mean:      map[string]float64{},
+		Std:       map[string]float64{},
+		Samples:   len(samples),
+		TrainedAt: time.Now().UTC(),
+	}
+	for _, s := range samples {
+		if s.Liked {
+			m.Likes++
+		} else {
+			m.Dislikes++
+		}
+	}
+	if m.Likes < MinLikes || m.Dislikes < MinDislikes {
+		return nil, fmt.Errorf("%w: se necesitan al menos %d likes y %d dislikes (hay %d y %d)",
+			ErrNotEnoughRatings, MinLikes, MinDislikes, m.Likes, m.Dislikes)
+	}
+
+	extraidas := make([]features, len(samples))
+	for i := range samples {
+		extraidas[i] = extractFeatures(&samples[i].Property, samples[i].FeatureIDs)
+	}
+	m.fitScaling(extraidas)
+
+	vectores := make([][]entrada, len(samples))
+	for i := range extraidas {
+		vectores[i] = m.vector(extraidas[i])
+	}
+
+	// Pesar las clases para que la más numerosa no sesgue el modelo
+	pesoLike := float64(m.Samples) / (2 * float64(m.Likes))
+	pesoDislike := float64(m.Samples) / (2 * float64(m.Dislikes))
+
+	// Descenso por gradiente sobre todo el conjunto
+	n := float64(m.Samples)
+	for epoch := 0; epoch < epochs; epoch++ {
+		gradBias := 0.0
+		grad := map[string]float64{}
+
+		for i, v := range vectores {
+			y, peso := 0.0, pesoDislike
+			if samples[i].Liked {
+				y, peso = 1.0, pesoLike
+			}
+			diff := peso * (m.predict(v) - y)
+			gradBias += diff
+			for _, e := range v {
+				grad[e.feature] += diff * e.value
+			}
+		}
+
+		m.Bias -= learningRate * gradBias / n
+		for feature, w := range m.Weights {
+			m.Weights[feature] = w - learningRate*(grad[feature]/n+l2*w)
+		}
+	}
+
+	aciertos := 0
+	for i, v := range vectores {
+		if (m.predict(v) >= 0.5) == samples[i].Liked {
+			aciertos++
+		}
+	}
+	m.Accuracy = float64(aciertos) / n
+
+	return m, nil
+}
+
+// fitScaling calcula promedio y desvío de las características numéricas y registra
+// todas las características vistas en el entrenamiento
+func (m *Model) fitScaling(extraidas []features) {
+	sumas := map[string]float64{}
+	cuadrados := map[string]float64{}
+	cuentas := map[string]float64{}
+
+	for _, f := range extraidas {
+		for name, v := range f.numeric {
+			sumas[name] += v
+			cuadrados[name] += v * v
+			cuentas[name]++
+		}
+		for _, name := range f.categorical {
+			m.Weights[name] = 0
+		}
+	}
+
+	for name, suma := range sumas {
+		mean := suma / cuentas[name]
+		std := math.Sqrt(math.Max(cuadrados[name]/cuentas[name]-mean*mean, 0))
+		if std == 0 {
+			// Un valor constante no aporta información
+			continue
+		}
+		m.Mean[name] = mean
+		m.Std[name] = std
+		m.Weights[name] = 0
+	}
+}
+
+// vector convierte las características al espacio del modelo. Las que el modelo no conoce se
+// ignoran y los numéricos faltantes quedan en 0, que tras estandarizar es el promedio.
+func (m *Model) vector(f features) []entrada {
+	var v []entrada
+	for name, value := range f.numeric {
+		if std, ok := m.Std[name]; ok {
+			v = append(v, entrada{name, (value - m.Mean[name]) / std})
+		}
+	}
+	for _, name := range f.categorical {
+		if _, ok := m.Weights[name]; ok {
+			v = append(v, entrada{name, 1})
+		}
+	}
+	return v
+}
+
+func (m *Model) predict(v []entrada) float64 {
+	z := m.Bias
+	for _, e := range v {
+		z += m.Weights[e.feature] * e.value
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+// Score estima de 0 a 1 cuánto le gustaría la propiedad al usuario
+func (m *Model) Score(p *db.Propiedad, featureIDs []int64) float64 {
+	return m.predict(m.vector(extractFeatures(p, featureIDs)))
+}
+
+// TopWeights devuelve las n características con más peso a favor y las n con más peso en contra
+func (m *Model) TopWeights(n int) (positive, negative []Weight) {
+	pesos := make([]Weight, 0, len(m.Weights))
+	for feature, w := range m.Weights {
+		pesos = append(pesos, Weight{Feature: feature, Weight: w})
+	}
+	sort.Slice(pesos, func(i, j int) bool {
+		if pesos[i].Weight != pesos[j].Weight {
+			return pesos[i].Weight > pesos[j].Weight
+		}
+		return pesos[i].Feature < pesos[j].Feature
+	})
+
+	for i := 0; i < len(pesos) && len(positive) < n && pesos[i].Weight > 0; i++ {
+		positive = append(positive, pesos[i])
+	}
+	for i := len(pesos) - 1; i >= 0 && len(negative) < n && pesos[i].Weight < 0; i-- {
+		negative = append(negative, pesos[i])
+	}
+	return positive, negative
+}
+
+// Record convierte el modelo en el registro que se guarda en la base de datos
+func (m *Model) Record(userID int64) (*db.RecommenderModel, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("error serializando modelo: %v", err)
+	}
+
+	return &db.RecommenderModel{
+		UserID:    userID,
+		Model:     string(data),
+		Samples:   m.Samples,
+		Likes:     m.Likes,
+		Dislikes:  m.Dislikes,
+		Accuracy:  m.Accuracy,
+		TrainedAt: m.TrainedAt,
+	}, nil
+}
+
+// Load reconstruye un modelo guardado
+func Load(record *db.RecommenderModel) (*Model, error) {
+	var m Model
+	if err := json.Unmarshal([]byte(record.Model), &m); err != nil {
+		return nil, fmt.Errorf("error leyendo modelo de recomendación del usuario %d: %v", record.UserID, err)
+	}
+	return &m, nil
+}
+
+// TrainUser entrena el modelo de un usuario con sus likes y dislikes y lo guarda
+func TrainUser(database *db.DB, userID int64) (*Model, error) {
+	liked, err := database.GetLikedProperties(userID, &db.PropertyFilter{})
+	if err != nil {
+		return nil, err
+	}
+	disliked, err := database.GetDislikedProperties(userID, &db.PropertyFilter{})
+	if err != nil {
+		return nil, err
+	}
+	featureIDs, err := database.GetPropertyFeatureIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]Sample, 0, len(liked)+len(disliked))
+	for _, p := range liked {
+		samples = append(samples, Sample{Property: p, FeatureIDs: featureIDs[p.ID], Liked: true})
+	}
+	for _, p := range disliked {
+		samples = append(samples, Sample{Property: p, FeatureIDs: featureIDs[p.ID]})
+	}
+
+	m, err := Train(samples)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := m.Record(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.SaveRecommenderModel(record); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// LoadUser devuelve el último modelo entrenado de un usuario. Devuelve sql.ErrNoRows si no tiene.
+func LoadUser(database *db.DB, userID int64) (*Model, error) {
+	record, err := database.GetRecommenderModel(userID)
+	if err != nil {
+		return nil, err
+	}
+	return Load(record)
+}
@@ -0,0 +1,172 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule es una expresión cron de cinco campos: minuto, hora, día del mes, mes y día de la semana.
+// Cada campo acepta *, valores, rangos (a-b), listas (a,b) y pasos (*/n, a-b/n).
+// También se aceptan los atajos @hourly, @daily, @weekly y @monthly.
+type Schedule struct {
+	expr    string
+	minutos [60]bool
+	horas   [24]bool
+	dias    [32]bool
+	meses   [13]bool
+	semana  [7]bool
+
+	// Según cron, si se restringen ambos días alcanza con que coincida uno
+	diaRestringido    bool
+	semanaRestringida bool
+}
+
+// Atajos de expresiones habituales
+var atajos = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// Parse interpreta una expresión cron
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	campos := strings.Fields(expr)
+	if atajo, ok := atajos[expr]; ok {
+		campos = strings.Fields(atajo)
+	}
+
+	if len(campos) != 5 {
+		return nil, fmt.Errorf("expresión cron inválida %q: se esperan 5 campos", expr)
+	}
+
+	s := &Schedule{expr: expr}
+	if err := parseCampo(campos[0], 0, 59, s.minutos[:]); err != nil {
+		return nil, fmt.Errorf("expresión cron inválida %q (minuto): %v", expr, err)
+	}
+	if err := parseCampo(campos[1], 0, 23, s.horas[:]); err != nil {
+		return nil, fmt.Errorf("expresión cron inválida %q (hora): %v", expr, err)
+	}
+	if err := parseCampo(campos[2], 1, 31, s.dias[:]); err != nil {
+		return nil, fmt.Errorf("expresión cron inválida %q (día): %v", expr, err)
+	}
+	if err := parseCampo(campos[3], 1, 12, s.meses[:]); err != nil {
+		return nil, fmt.Errorf("expresión cron inválida %q (mes): %v", expr, err)
+	}
+
+	// El domingo puede escribirse 0 o 7
+	var semana [8]bool
+	if err := parseCampo(campos[4], 0, 7, semana[:]); err != nil {
+		return nil, fmt.Errorf("expresión cron inválida %q (día de la semana): %v", expr, err)
+	}
+	copy(s.semana[:], semana[:7])
+	s.semana[0] = s.semana[0] || semana[7]
+
+	// Como en Vixie cron, un campo que empieza con * (incluso */n) no cuenta como restringido
+	s.diaRestringido = !strings.HasPrefix(campos[2], "*")
+	s.semanaRestringida = !strings.HasPrefix(campos[4], "*")
+
+	// Una expresión que nunca se cumple (ej: 30 de febrero) dejaría a la tarea sin próxima ejecución
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("expresión cron inválida %q: nunca se cumple", expr)
+	}
+
+	return s, nil
+}
+
+// parseCampo marca en valores los números que cubre el campo
+func parseCampo(campo string, minimo, maximo int, valores []bool) error {
+	for _, parte := range strings.Split(campo, ",") {
+		rango, paso := parte, 1
+		if i := strings.Index(parte, "/"); i >= 0 {
+			n, err := strconv.Atoi(parte[i+1:])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("paso inválido %q", parte)
+			}
+			rango, paso = parte[:i], n
+		}
+
+		desde, hasta := minimo, maximo
+		switch {
+		case rango == "*":
+		case strings.Contains(rango, "-"):
+			extremos := strings.SplitN(rango, "-", 2)
+			var err error
+			if desde, err = strconv.Atoi(extremos[0]); err != nil {
+				return fmt.Errorf("valor inválido %q", parte)
+			}
+			if hasta, err = strconv.Atoi(extremos[1]); err != nil {
+				return fmt.Errorf("valor inválido %q", parte)
+			}
+		default:
+			n, err := strconv.Atoi(rango)
+			if err != nil {
+				return fmt.Errorf("valor inválido %q", parte)
+			}
+			desde, hasta = n, n
+			// "5/15" equivale a "5-max/15"
+			if paso > 1 {
+				hasta = maximo
+			}
+		}
+
+		if desde < minimo || hasta > maximo || desde > hasta {
+			return fmt.Errorf("valor fuera de rango %q (%d-%d)", parte, minimo, maximo)
+		}
+
+		for v := desde; v <= hasta; v += paso {
+			valores[v] = true
+		}
+	}
+
+	return nil
+}
+
+// Next devuelve el primer minuto posterior a t que cumple la expresión,
+// o el tiempo cero si no hay ninguno en los próximos cinco años
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Con cinco años se cubre cualquier expresión válida (ej: 29 de febrero)
+	limite := t.AddDate(5, 0, 0)
+	for t.Before(limite) {
+		if !s.meses[t.Month()] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.coincideDia(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.horas[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutos[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// coincideDia aplica la regla de cron para día del mes y día de la semana
+func (s *Schedule) coincideDia(t time.Time) bool {
+	dia := s.dias[t.Day()]
+	semana := s.semana[t.Weekday()]
+
+	if s.diaRestringido && s.semanaRestringida {
+		return dia || semana
+	}
+	return dia && semana
+}
+
+// String devuelve la expresión original
+func (s *Schedule) String() string {
+	return s.expr
+}
@@ -0,0 +1,183 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Estados de una ejecución programada
+const (
+	RunRunning     = "running"
+	RunSuccess     = "success"
+	RunFailed      = "failed"
+	RunInterrupted = "interrupted"
+	RunSkipped     = "skipped"
+)
+
+// Duración del lock de una tarea; se renueva mientras la tarea sigue corriendo
+const lockTTL = 10 * time.Minute
+
+// Task es una tarea recurrente del daemon
+type Task struct {
+	Name     string
+	Schedule *Schedule
+	Run      func(ctx context.Context) error
+}
+
+// Store persiste los locks y el historial de ejecuciones.
+// El lock evita que dos procesos ejecuten la misma tarea a la vez.
+type Store interface {
+	AcquireLock(name, owner string, ttl time.Duration) (bool, error)
+	RenewLock(name, owner string, ttl time.Duration) error
+	ReleaseLock(name, owner string) error
+	StartScheduledRun(task, owner string) (int64, error)
+	FinishScheduledRun(id int64, status string, runErr error) error
+}
+
+// Scheduler ejecuta tareas según su expresión cron
+type Scheduler struct {
+	store Store
+	owner string
+	tasks []Task
+}
+
+// New crea un scheduler. owner identifica al proceso en los locks.
+func New(store Store, owner string, tasks ...Task) *Scheduler {
+	return &Scheduler{store: store, owner: owner, tasks: tasks}
+}
+
+// Run ejecuta las tareas hasta que se cancele el contexto.
+// Las tareas corren de a una; si una tarea se demora, las ejecuciones perdidas no se acumulan.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if len(s.tasks) == 0 {
+		return fmt.Errorf("no hay tareas programadas")
+	}
+
+	// Una tarea sin próxima ejecución (tiempo cero) queda deshabilitada
+	proximas := make([]time.Time, len(s.tasks))
+	for i, task := range s.tasks {
+		proximas[i] = task.Schedule.Next(time.Now())
+		if proximas[i].IsZero() {
+			log.Printf("Tarea %s (%s): la expresión nunca se cumple, se deshabilita", task.Name, task.Schedule)
+			continue
+		}
+		log.Printf("Tarea %s (%s): próxima ejecución %s", task.Name, task.Schedule, proximas[i].Format(time.DateTime))
+	}
+
+	for {
+		siguiente := -1
+		for i := range proximas {
+			if !proximas[i].IsZero() && (siguiente < 0 || proximas[i].Before(proximas[siguiente])) {
+				siguiente = i
+			}
+		}
+		if siguiente < 0 {
+			return fmt.Errorf("ninguna tarea tiene una próxima ejecución")
+		}
+
+		timer := time.NewTimer(time.Until(proximas[siguiente]))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		for i, task := range s.tasks {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if proximas[i].IsZero() || time.Now().Before(proximas[i]) {
+				continue
+			}
+
+			s.runTask(ctx, task)
+			proximas[i] = task.Schedule.Next(time.Now())
+			if proximas[i].IsZero() {
+				log.Printf("Tarea %s: la expresión ya no se cumple, se deshabilita", task.Name)
+				continue
+			}
+			log.Printf("Tarea %s: próxima ejecución %s", task.Name, proximas[i].Format(time.DateTime))
+		}
+	}
+}
+
+// runTask ejecuta una tarea con su lock y registra el resultado
+func (s *Scheduler) runTask(ctx context.Context, task Task) {
+	adquirido, err := s.store.AcquireLock(task.Name, s.owner, lockTTL)
+	if err != nil {
+		log.Printf("Tarea %s: error tomando el lock: %v", task.Name, err)
+		return
+	}
+
+	if !adquirido {
+		log.Printf("Tarea %s: otra ejecución está en curso, se omite", task.Name)
+		if id, err := s.store.StartScheduledRun(task.Name, s.owner); err == nil {
+			s.store.FinishScheduledRun(id, RunSkipped, nil)
+		}
+		return
+	}
+	defer func() {
+		if err := s.store.ReleaseLock(task.Name, s.owner); err != nil {
+			log.Printf("Tarea %s: error liberando el lock: %v", task.Name, err)
+		}
+	}()
+
+	runID, err := s.store.StartScheduledRun(task.Name, s.owner)
+	if err != nil {
+		log.Printf("Tarea %s: error registrando la ejecución: %v", task.Name, err)
+	}
+
+	// Renovar el lock mientras la tarea está corriendo
+	hecho := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hecho:
+				return
+			case <-ticker.C:
+				if err := s.store.RenewLock(task.Name, s.owner, lockTTL); err != nil {
+					log.Printf("Tarea %s: error renovando el lock: %v", task.Name, err)
+				}
+			}
+		}
+	}()
+
+	log.Printf("▶️ Iniciando tarea %s", task.Name)
+	inicio := time.Now()
+	runErr := ejecutar(ctx, task)
+	close(hecho)
+
+	estado := RunSuccess
+	switch {
+	case ctx.Err() != nil:
+		estado = RunInterrupted
+	case runErr != nil:
+		estado = RunFailed
+	}
+	log.Printf("⏹️ Tarea %s terminada en %s: %s", task.Name, time.Since(inicio).Round(time.Second), estado)
+	if runErr != nil {
+		log.Printf("Tarea %s: %v", task.Name, runErr)
+	}
+
+	if runID != 0 {
+		if err := s.store.FinishScheduledRun(runID, estado, runErr); err != nil {
+			log.Printf("Tarea %s: error registrando el resultado: %v", task.Name, err)
+		}
+	}
+}
+
+// ejecutar corre la tarea convirtiendo un panic en error para no detener el daemon
+func ejecutar(ctx context.Context, task Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return task.Run(ctx)
+}
@@ -0,0 +1,96 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/findhouse/internal/models"
+	"github.com/findhouse/internal/scraper/ratelimit"
+)
+
+// Timeouts de cada sesión de scraping. Empiezan a correr cuando el limitador del host le da
+// turno a la sesión: la espera en la fila del host no cuenta como tiempo de la solicitud.
+const (
+	TimeoutBusqueda = 5 * time.Minute
+	TimeoutDetalles = 2 * time.Minute
+)
+
+var (
+	limiterMu sync.Mutex
+	limiter   = ratelimit.New(ratelimit.DefaultConfig)
+)
+
+// ConfigureRateLimit reemplaza la política de cortesía usada por todos los scrapers
+func ConfigureRateLimit(cfg ratelimit.Config) {
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+	limiter = ratelimit.New(cfg)
+}
+
+// RateLimiter devuelve el limitador compartido por todos los scrapers, para otras descargas
+// que tengan que respetar la misma política de cortesía
+func RateLimiter() *ratelimit.Limiter {
+	return currentLimiter()
+}
+
+// currentLimiter devuelve el limitador compartido por todos los scrapers
+func currentLimiter() *ratelimit.Limiter {
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+	return limiter
+}
+
+// politeScraper pasa cada sesión de un scraper por el limitador de su host: ocupa un lugar
+// de concurrencia y un token por búsqueda o ficha. Las páginas que el scraper carga dentro
+// de una búsqueda (el scroll del listado) toman sus propios tokens con Limiter.Wait.
+type politeScraper struct {
+	inner   PropertyScraper
+	baseURL string
+	limiter *ratelimit.Limiter
+}
+
+func (s *politeScraper) SearchProperties(ctx context.Context) ([]models.Property, error) {
+	release, err := s.limiter.Acquire(ctx, s.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, TimeoutBusqueda)
+	defer cancel()
+
+	properties, err := s.inner.SearchProperties(ctx)
+	observarRespuesta(s.limiter, s.baseURL, err)
+	return properties, err
+}
+
+func (s *politeScraper) GetPropertyDetails(ctx context.Context, url string) (*models.PropertyDetails, error) {
+	release, err := s.limiter.Acquire(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, TimeoutDetalles)
+	defer cancel()
+
+	details, err := s.inner.GetPropertyDetails(ctx, url)
+	observarRespuesta(s.limiter, url, err)
+	return details, err
+}
+
+// observarRespuesta ajusta el limitador según el resultado de una solicitud
+func observarRespuesta(l *ratelimit.Limiter, url string, err error) {
+	var statusErr *ratelimit.StatusError
+	if errors.As(err, &statusErr) && ratelimit.IsThrottle(statusErr.StatusCode) {
+		espera := l.Backoff(url, statusErr.RetryAfter)
+		log.Printf("⏸️ %s respondió %d, pausando %s por %s", ratelimit.Host(url), statusErr.StatusCode, ratelimit.Host(url), espera)
+		return
+	}
+	if err == nil {
+		l.Success(url)
+	}
+}
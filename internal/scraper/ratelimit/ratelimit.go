@@ -0,0 +1,335 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config define la política de cortesía con cada sitio
+type Config struct {
+	RequestsPerMinute    int           // Solicitudes por minuto por host
+	Burst                int           // Solicitudes que se pueden hacer seguidas antes de esperar
+	MaxConcurrentPerHost int           // Solicitudes simultáneas por host
+	RespectRobots        bool          // Respetar el Crawl-delay de robots.txt
+	UserAgent            string        // User-agent con el que se lee robots.txt
+	BackoffBase          time.Duration // Primera espera ante un 429/503
+	BackoffMax           time.Duration // Espera máxima ante 429/503 repetidos
+}
+
+// DefaultConfig es la política usada si no se configura otra
+var DefaultConfig = Config{
+	RequestsPerMinute:    20,
+	Burst:                1,
+	MaxConcurrentPerHost: 2,
+	RespectRobots:        true,
+	UserAgent:            "findhouse",
+	BackoffBase:          30 * time.Second,
+	BackoffMax:           15 * time.Minute,
+}
+
+// StatusError es una respuesta HTTP que pide bajar el ritmo (429 o 503)
+type StatusError struct {
+	URL        string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("respuesta %d de %s", e.StatusCode, e.URL)
+}
+
+// IsThrottle indica si el código de estado pide bajar el ritmo
+func IsThrottle(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+// ParseRetryAfter interpreta el header Retry-After (segundos o fecha HTTP)
+func ParseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if segundos, err := strconv.Atoi(value); err == nil && segundos > 0 {
+		return time.Duration(segundos) * time.Second
+	}
+	if fecha, err := http.ParseTime(value); err == nil {
+		return max(time.Until(fecha), 0)
+	}
+	return 0
+}
+
+// Limiter limita las solicitudes por host con un token bucket y un máximo de concurrencia
+type Limiter struct {
+	cfg    Config
+	client *http.Client
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState es el estado del limitador para un host
+type hostState struct {
+	slots chan struct{}
+
+	// robots.txt se lee una vez por host; si falla por un error transitorio se reintenta más tarde
+	robotsMu        sync.Mutex
+	robotsLeido     bool
+	robotsReintento time.Time
+
+	mu             sync.Mutex
+	intervalo      time.Duration // Tiempo para generar un token
+	tokens         float64
+	ultimo         time.Time
+	bloqueado      time.Time // No se hacen solicitudes hasta este momento (backoff)
+	fallosSeguidos int
+}
+
+// New crea un limitador con la configuración indicada
+func New(cfg Config) *Limiter {
+	if cfg.RequestsPerMinute <= 0 {
+		cfg.RequestsPerMinute = DefaultConfig.RequestsPerMinute
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = 1
+	}
+	if cfg.MaxConcurrentPerHost <= 0 {
+		cfg.MaxConcurrentPerHost = 1
+	}
+	if cfg.BackoffBase <= 0 {
+		cfg.BackoffBase = DefaultConfig.BackoffBase
+	}
+	if cfg.BackoffMax < cfg.BackoffBase {
+		cfg.BackoffMax = cfg.BackoffBase
+	}
+
+	return &Limiter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		hosts:  make(map[string]*hostState),
+	}
+}
+
+// Host devuelve el host (sin www.) de una URL, que es la unidad de limitación
+func Host(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return rawURL
+	}
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+// estado devuelve el estado del host, creándolo si es la primera solicitud
+func (l *Limiter) estado(host string) *hostState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st, ok := l.hosts[host]
+	if !ok {
+		st = &hostState{
+			slots:     make(chan struct{}, l.cfg.MaxConcurrentPerHost),
+			intervalo: time.Minute / time.Duration(l.cfg.RequestsPerMinute),
+			tokens:    float64(l.cfg.Burst),
+			ultimo:    time.Now(),
+		}
+		l.hosts[host] = st
+	}
+	return st
+}
+
+// Acquire espera un lugar para hacer una solicitud a la URL.
+// Hay que llamar a release cuando la solicitud termina.
+func (l *Limiter) Acquire(ctx context.Context, rawURL string) (release func(), err error) {
+	host := Host(rawURL)
+	st := l.estado(host)
+
+	if l.cfg.RespectRobots {
+		l.revisarRobots(rawURL, st)
+	}
+
+	select {
+	case st.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release = func() { <-st.slots }
+
+	if err := l.esperarToken(ctx, st); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// Wait espera el turno de una solicitud a la URL sin ocupar un lugar de concurrencia.
+// Es para las solicitudes que se hacen dentro de una sesión que ya pasó por Acquire,
+// como cada página que carga el scroll de un listado.
+func (l *Limiter) Wait(ctx context.Context, rawURL string) error {
+	return l.esperarToken(ctx, l.estado(Host(rawURL)))
+}
+
+// esperarToken espera hasta poder consumir un token del host
+func (l *Limiter) esperarToken(ctx context.Context, st *hostState) error {
+	for {
+		espera := st.tomarToken(float64(l.cfg.Burst))
+		if espera == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(espera)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// tomarToken consume un token si hay disponible; si no, devuelve cuánto esperar
+func (st *hostState) tomarToken(burst float64) time.Duration {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(st.bloqueado) {
+		return st.bloqueado.Sub(now)
+	}
+
+	st.tokens = min(burst, st.tokens+float64(now.Sub(st.ultimo))/float64(st.intervalo))
+	st.ultimo = now
+	if st.tokens >= 1 {
+		st.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - st.tokens) * float64(st.intervalo))
+}
+
+// Backoff bloquea el host ante un 429/503. La espera crece exponencialmente con los
+// fallos seguidos y nunca es menor que el Retry-After indicado por el sitio.
+func (l *Limiter) Backoff(rawURL string, retryAfter time.Duration) time.Duration {
+	st := l.estado(Host(rawURL))
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.fallosSeguidos++
+	espera := l.cfg.BackoffBase
+	for i := 1; i < st.fallosSeguidos && espera < l.cfg.BackoffMax; i++ {
+		espera *= 2
+	}
+	espera = max(min(espera, l.cfg.BackoffMax), retryAfter)
+
+	st.bloqueado = time.Now().Add(espera)
+	st.tokens = 0
+	return espera
+}
+
+// Success indica que el host respondió bien y reinicia el backoff
+func (l *Limiter) Success(rawURL string) {
+	st := l.estado(Host(rawURL))
+
+	st.mu.Lock()
+	st.fallosSeguidos = 0
+	st.mu.Unlock()
+}
+
+// Timeout de la lectura de robots.txt y espera antes de reintentarla si falló
+const (
+	robotsTimeout = 10 * time.Second
+	robotsRetry   = 10 * time.Minute
+)
+
+// revisarRobots aplica robots.txt del host si todavía no se pudo leer
+func (l *Limiter) revisarRobots(rawURL string, st *hostState) {
+	st.robotsMu.Lock()
+	defer st.robotsMu.Unlock()
+
+	if st.robotsLeido || time.Now().Before(st.robotsReintento) {
+		return
+	}
+
+	if err := l.aplicarRobots(rawURL, st); err != nil {
+		st.robotsReintento = time.Now().Add(robotsRetry)
+		log.Printf("No se pudo leer robots.txt de %s, se reintentará en %s: %v", Host(rawURL), robotsRetry, err)
+		return
+	}
+	st.robotsLeido = true
+}
+
+// aplicarRobots lee robots.txt del host y, si pide un Crawl-delay mayor al intervalo, lo respeta.
+// Usa su propio contexto para que una solicitud cancelada no deje al host sin leer.
+// Devuelve error solo si la falla es transitoria: un robots.txt inexistente cuenta como leído.
+func (l *Limiter) aplicarRobots(rawURL string, st *hostState) error {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), robotsTimeout)
+	defer cancel()
+
+	robotsURL := fmt.Sprintf("%s://%s/robots.txt", parsed.Scheme, parsed.Host)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", l.cfg.UserAgent)
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 || IsThrottle(resp.StatusCode) {
+		return fmt.Errorf("respuesta %d de %s", resp.StatusCode, robotsURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	delay := CrawlDelay(resp.Body, l.cfg.UserAgent)
+	if delay <= 0 {
+		return nil
+	}
+
+	st.mu.Lock()
+	if delay > st.intervalo {
+		st.intervalo = delay
+		st.tokens = min(st.tokens, 1)
+	}
+	st.mu.Unlock()
+	return nil
+}
+
+// ResponseError devuelve un *StatusError si la respuesta pide bajar el ritmo, o nil en otro caso.
+// headers son los headers de la respuesta tal como los entrega el navegador.
+func ResponseError(rawURL string, statusCode int, headers map[string]interface{}) error {
+	if !IsThrottle(statusCode) {
+		return nil
+	}
+
+	statusErr := &StatusError{URL: rawURL, StatusCode: statusCode}
+	for nombre, valor := range headers {
+		if strings.EqualFold(nombre, "Retry-After") {
+			statusErr.RetryAfter = ParseRetryAfter(fmt.Sprint(valor))
+		}
+	}
+	return statusErr
+}
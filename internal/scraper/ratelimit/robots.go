@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CrawlDelay devuelve el Crawl-delay de robots.txt para el user-agent indicado.
+// Un grupo que nombra al user-agent tiene prioridad sobre el grupo "*".
+func CrawlDelay(robots io.Reader, userAgent string) time.Duration {
+	userAgent = strings.ToLower(userAgent)
+
+	var (
+		agentesGrupo []string
+		enReglas     bool
+		delayGeneral time.Duration
+		delayPropio  time.Duration
+		tienePropio  bool
+		tieneGeneral bool
+	)
+
+	scanner := bufio.NewScanner(io.LimitReader(robots, 512*1024))
+	for scanner.Scan() {
+		linea := scanner.Text()
+		if i := strings.Index(linea, "#"); i >= 0 {
+			linea = linea[:i]
+		}
+
+		clave, valor, ok := strings.Cut(linea, ":")
+		if !ok {
+			continue
+		}
+		clave = strings.ToLower(strings.TrimSpace(clave))
+		valor = strings.TrimSpace(valor)
+
+		switch clave {
+		case "user-agent":
+			// Una línea user-agent después de reglas empieza un grupo nuevo
+			if enReglas {
+				agentesGrupo = nil
+				enReglas = false
+			}
+			agentesGrupo = append(agentesGrupo, strings.ToLower(valor))
+
+		case "crawl-delay":
+			enReglas = true
+			segundos, err := strconv.ParseFloat(valor, 64)
+			if err != nil || segundos <= 0 {
+				continue
+			}
+			delay := time.Duration(segundos * float64(time.Second))
+
+			for _, agente := range agentesGrupo {
+				switch {
+				case agente == "*":
+					delayGeneral, tieneGeneral = delay, true
+				case userAgent != "" && strings.Contains(userAgent, agente):
+					delayPropio, tienePropio = delay, true
+				}
+			}
+
+		default:
+			enReglas = true
+		}
+	}
+
+	if tienePropio {
+		return delayPropio
+	}
+	if tieneGeneral {
+		return delayGeneral
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package scrapeerr
+
+import (
+	"context"
+	"errors"
+
+	"github.com/findhouse/internal/scraper/ratelimit"
+)
+
+// Errores que devuelven los scrapers de propiedades, para que el analizador
+// decida si reintentar, dar de baja el aviso o alertar sobre un scraper roto
+var (
+	// ErrNotFound indica que el aviso ya no existe en el sitio
+	ErrNotFound = errors.New("propiedad no disponible")
+	// ErrBlocked indica que el sitio rechazó o limitó la solicitud
+	ErrBlocked = errors.New("solicitud bloqueada por el sitio")
+	// ErrTimeout indica que el sitio no respondió a tiempo
+	ErrTimeout = errors.New("tiempo de espera agotado")
+	// ErrLayoutChanged indica que la página cargó pero no tiene la estructura esperada
+	ErrLayoutChanged = errors.New("la estructura del sitio cambió")
+	// ErrUnsupported indica que no hay scraper para el sistema de la inmobiliaria
+	ErrUnsupported = errors.New("sistema no soportado")
+	// ErrIncomplete acompaña a un listado que se cortó antes de llegar al final: las propiedades
+	// devueltas son válidas, pero las que faltan no se pueden dar por dadas de baja
+	ErrIncomplete = errors.New("listado incompleto")
+)
+
+// Category es la categoría de un error de scraping, tal como se guarda en la base de datos
+type Category string
+
+const (
+	CategoryNotFound      Category = "not_found"
+	CategoryBlocked       Category = "blocked"
+	CategoryTimeout       Category = "timeout"
+	CategoryLayoutChanged Category = "layout_changed"
+	CategoryUnsupported   Category = "unsupported"
+	CategoryUnknown       Category = "unknown"
+)
+
+// Classify devuelve la categoría de err, o "" si err es nil
+func Classify(err error) Category {
+	var statusErr *ratelimit.StatusError
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrNotFound):
+		return CategoryNotFound
+	case errors.Is(err, ErrLayoutChanged):
+		return CategoryLayoutChanged
+	case errors.Is(err, ErrUnsupported):
+		return CategoryUnsupported
+	case errors.Is(err, ErrBlocked), errors.As(err, &statusErr):
+		return CategoryBlocked
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return CategoryTimeout
+	default:
+		return CategoryUnknown
+	}
+}
+
+// Retryable indica si vale la pena reintentar una solicitud que falló con err.
+// Los avisos inexistentes, los cambios de estructura y los sistemas no soportados
+// no se arreglan reintentando.
+func Retryable(err error) bool {
+	switch Classify(err) {
+	case CategoryTimeout, CategoryBlocked, CategoryUnknown:
+		return true
+	default:
+		return false
+	}
+}
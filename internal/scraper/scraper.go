@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/chromedp/chromedp"
+	"github.com/findhouse/internal/scraper/ratelimit"
 )
 
 // Inmobiliaria representa una agencia inmobiliaria
@@ -109,11 +110,22 @@ var sistemasConocidos = []SistemaInmobiliario{
 	},
 }
 
+// SistemaConocido devuelve el nombre canónico de un sistema conocido, sin distinguir mayúsculas
+func SistemaConocido(nombre string) (string, bool) {
+	for _, sistema := range sistemasConocidos {
+		if strings.EqualFold(strings.TrimSpace(nombre), sistema.Nombre) {
+			return sistema.Nombre, true
+		}
+	}
+
+	return "", false
+}
+
 // SearchInmobiliarias busca inmobiliarias en Google Maps y opcionalmente las guarda en CSV
 func SearchInmobiliarias(ctx context.Context, zona string) ([]Inmobiliaria, error) {
 	fmt.Println("🚀 Iniciando scraper...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
 	defer cancel()
 
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
@@ -125,7 +137,7 @@ func SearchInmobiliarias(ctx context.Context, zona string) ([]Inmobiliaria, erro
 		chromedp.Flag("disable-dev-shm-usage", true),
 	)
 
-	allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
+	allocCtx, cancel := chromedp.NewExecAllocator(ctx, opts...)
 	defer cancel()
 
 	ctx, cancel = chromedp.NewContext(allocCtx)
@@ -134,9 +146,15 @@ func SearchInmobiliarias(ctx context.Context, zona string) ([]Inmobiliaria, erro
 	url := fmt.Sprintf("https://www.google.com/maps/search/inmobiliarias+en+%s", strings.ReplaceAll(zona, " ", "+"))
 	fmt.Printf("📍 Navegando a: %s\n", url)
 
+	release, err := currentLimiter().Acquire(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	var results []Inmobiliaria
 
-	err := chromedp.Run(ctx,
+	err = chromedp.Run(ctx,
 		chromedp.Navigate(url),
 		chromedp.Sleep(2*time.Second),
 		chromedp.WaitVisible(`div[role="feed"]`),
@@ -284,9 +302,24 @@ func AnalyzeSystem(url string) (string, error) {
 	ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
+	// Respetar la política de cortesía del sitio
+	release, err := currentLimiter().Acquire(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	resp, err := chromedp.RunResponse(ctx, chromedp.Navigate(url))
+	if err == nil && resp != nil {
+		err = ratelimit.ResponseError(url, int(resp.Status), resp.Headers)
+	}
+	observarRespuesta(currentLimiter(), url, err)
+	if err != nil {
+		return "", fmt.Errorf("error al acceder a %s: %w", url, err)
+	}
+
 	var htmlContent string
-	err := chromedp.Run(ctx,
-		chromedp.Navigate(url),
+	err = chromedp.Run(ctx,
 		chromedp.Sleep(2*time.Second),
 		chromedp.OuterHTML("html", &htmlContent),
 	)
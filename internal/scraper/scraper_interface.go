@@ -2,34 +2,60 @@ package scraper
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	"github.com/findhouse/internal/models"
+	"github.com/findhouse/internal/scraper/scrapeerr"
 	"github.com/findhouse/internal/scraper/tokko"
 )
 
-// PropertyScraper define la interfaz que deben implementar todos los scrapers de propiedades
+// PropertyScraper define la interfaz que deben implementar todos los scrapers de propiedades.
+// Los errores deben envolver los de scrapeerr para que el analizador sepa cómo tratarlos.
 type PropertyScraper interface {
-	// SearchProperties busca propiedades en el sitio web de la inmobiliaria
+	// SearchProperties busca propiedades en el sitio web de la inmobiliaria. Si el listado se
+	// cortó antes del final devuelve lo que encontró junto con scrapeerr.ErrIncomplete.
 	SearchProperties(ctx context.Context) ([]models.Property, error)
 
 	// GetPropertyDetails obtiene los detalles de una propiedad específica
 	GetPropertyDetails(ctx context.Context, url string) (*models.PropertyDetails, error)
 }
 
-// Verificación de que TokkoScraper implementa la interfaz PropertyScraper
-var _ PropertyScraper = (*tokko.TokkoScraper)(nil)
+// SnapshotParser vuelve a extraer los detalles de una ficha guardada, sin hacer solicitudes al sitio.
+// Lo implementan los scrapers que guardan el HTML de las fichas en PropertyDetails.HTML.
+type SnapshotParser interface {
+	ParseSnapshot(ctx context.Context, url, html string) (*models.PropertyDetails, error)
+}
+
+// Verificación de que TokkoScraper implementa las interfaces PropertyScraper y SnapshotParser
+var (
+	_ PropertyScraper = (*tokko.TokkoScraper)(nil)
+	_ SnapshotParser  = (*tokko.TokkoScraper)(nil)
+)
 
-// NewScraper crea un nuevo scraper basado en el sistema de la inmobiliaria
-func NewScraper(sistema string, baseURL string) PropertyScraper {
+// NewScraper crea un nuevo scraper basado en el sistema de la inmobiliaria.
+// Devuelve scrapeerr.ErrUnsupported si el sistema no tiene scraper.
+func NewScraper(sistema string, baseURL string) (PropertyScraper, error) {
 	// Por ahora solo soportamos Tokko, pero en el futuro podemos agregar más
 	sistema = strings.ToLower(sistema)
 
+	// Todas las solicitudes pasan por el limitador por host
 	if strings.Contains(sistema, "tokko") {
-		return tokko.New(baseURL)
+		limiter := currentLimiter()
+		inner := tokko.New(baseURL)
+		inner.Limiter = limiter
+		return &politeScraper{inner: inner, baseURL: baseURL, limiter: limiter}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", scrapeerr.ErrUnsupported, sistema)
+}
+
+// NewSnapshotParser crea el extractor de fichas guardadas del sistema de la inmobiliaria.
+// No pasa por el limitador porque no visita el sitio.
+func NewSnapshotParser(sistema string, baseURL string) (SnapshotParser, error) {
+	if strings.Contains(strings.ToLower(sistema), "tokko") {
+		return tokko.New(baseURL), nil
 	}
 
-	// Si no reconocemos el sistema, devolvemos nil
-	// En el futuro podríamos devolver un scraper genérico o un error
-	return nil
+	return nil, fmt.Errorf("%w: %s", scrapeerr.ErrUnsupported, sistema)
 }
@@ -2,18 +2,31 @@ package tokko
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/chromedp"
 	"github.com/findhouse/internal/models"
+	"github.com/findhouse/internal/scraper/ratelimit"
+	"github.com/findhouse/internal/scraper/scrapeerr"
 )
 
+// esperaFicha es cuánto se espera a que aparezca la ficha antes de revisar por qué no está
+const esperaFicha = 30 * time.Second
+
 // TokkoScraper implementa la interfaz PropertyScraper para el sistema Tokko
 type TokkoScraper struct {
 	BaseURL string
+	// Limiter, si está configurado, pausa cada scroll del listado como una solicitud más al sitio
+	Limiter *ratelimit.Limiter
 }
 
 // New crea una nueva instancia de TokkoScraper
@@ -52,17 +65,30 @@ func (s *TokkoScraper) SearchProperties(ctx context.Context) ([]models.Property,
 
 	// Navegar a la página y esperar a que cargue
 	fmt.Println("Navegando a la página y esperando carga inicial...")
-	err := chromedp.Run(taskCtx,
-		chromedp.Navigate(url),
-		chromedp.Sleep(5*time.Second),
-	)
+	resp, err := chromedp.RunResponse(taskCtx, chromedp.Navigate(url))
+	if err == nil && resp != nil {
+		err = errorDeRespuesta(url, int(resp.Status), resp.Headers)
+	}
+	if err == nil {
+		err = chromedp.Run(taskCtx, chromedp.Sleep(5*time.Second))
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error navegando a la página: %v", err)
+		return nil, fmt.Errorf("error navegando a la página: %w", err)
+	}
+
+	// Sin el contenedor del listado no hay forma de distinguir "sin propiedades" de "sitio cambiado"
+	var hayListado bool
+	if err := chromedp.Run(taskCtx, chromedp.Evaluate(`!!document.querySelector('#propiedades.resultados-list')`, &hayListado)); err != nil {
+		return nil, fmt.Errorf("error inspeccionando la página: %w", err)
+	}
+	if !hayListado {
+		return nil, fmt.Errorf("%w: no se encontró el listado de propiedades en %s", scrapeerr.ErrLayoutChanged, url)
 	}
 
 	// Implementar scroll con detección de fin de lista
 	var lastCount int
 	var sameCountIterations int
+	var finDelListado bool
 	maxScrollAttempts := 30           // Límite máximo de intentos de scroll
 	scrollWaitTime := 3 * time.Second // Tiempo de espera entre scrolls
 
@@ -93,6 +119,7 @@ func (s *TokkoScraper) SearchProperties(ctx context.Context) ([]models.Property,
 			sameCountIterations++
 			if sameCountIterations >= 3 {
 				fmt.Println("No se encontraron más propiedades después de varios intentos de scroll. Finalizando.")
+				finDelListado = true
 				break
 			}
 		} else {
@@ -100,6 +127,13 @@ func (s *TokkoScraper) SearchProperties(ctx context.Context) ([]models.Property,
 			lastCount = currentCount
 		}
 
+		// Cada scroll hace que el sitio cargue otra página del listado
+		if s.Limiter != nil {
+			if err := s.Limiter.Wait(taskCtx, url); err != nil {
+				return nil, fmt.Errorf("error esperando turno para %s: %w", url, err)
+			}
+		}
+
 		// Hacer scroll hacia abajo
 		err = chromedp.Run(taskCtx, chromedp.Evaluate(`
 			(() => {
@@ -191,10 +225,13 @@ func (s *TokkoScraper) SearchProperties(ctx context.Context) ([]models.Property,
 	)
 
 	if err != nil {
-		return nil, fmt.Errorf("error extrayendo propiedades: %v", err)
+		return nil, fmt.Errorf("error extrayendo propiedades: %w", err)
 	}
 
 	fmt.Printf("Total de propiedades extraídas: %d\n", len(properties))
+	if !finDelListado {
+		return properties, fmt.Errorf("%w: el scroll de %s terminó sin llegar al final", scrapeerr.ErrIncomplete, url)
+	}
 	return properties, nil
 }
 
@@ -202,7 +239,90 @@ func (s *TokkoScraper) SearchProperties(ctx context.Context) ([]models.Property,
 func (s *TokkoScraper) GetPropertyDetails(ctx context.Context, url string) (*models.PropertyDetails, error) {
 	fmt.Printf("🔍 Intentando obtener detalles de: %s\n", url)
 
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+	allocCtx, cancel := chromedp.NewExecAllocator(ctx, opcionesNavegador()...)
+	defer cancel()
+
+	taskCtx, cancel := chromedp.NewContext(allocCtx)
+	defer cancel()
+
+	// Detectar avisos dados de baja y respuestas que piden bajar el ritmo (429/503)
+	resp, err := chromedp.RunResponse(taskCtx, chromedp.Navigate(url))
+	if err == nil && resp != nil {
+		err = errorDeRespuesta(url, int(resp.Status), resp.Headers)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error navegando a %s: %w", url, err)
+	}
+
+	if err := esperarFicha(ctx, taskCtx, url); err != nil {
+		return nil, err
+	}
+
+	// El HTML de la ficha se guarda para poder volver a extraerla sin visitar el sitio
+	var html string
+	if err := chromedp.Run(taskCtx, chromedp.OuterHTML("html", &html, chromedp.ByQuery)); err != nil {
+		fmt.Printf("⚠️ No se pudo leer el HTML de %s: %v\n", url, err)
+	}
+
+	details, err := extraerFicha(taskCtx, url)
+	if err != nil {
+		return nil, err
+	}
+	details.HTML = html
+	details.FetchedAt = time.Now().UTC()
+
+	fmt.Printf("✓ Extracción completada: %v\n", details)
+	return details, nil
+}
+
+// ParseSnapshot vuelve a extraer los detalles de una ficha guardada con GetPropertyDetails.
+// El navegador recibe el HTML guardado como respuesta a url y no hace ninguna solicitud a la red;
+// los scripts de la página no se ejecutan porque el HTML ya es el que generaron.
+func (s *TokkoScraper) ParseSnapshot(ctx context.Context, url, html string) (*models.PropertyDetails, error) {
+	allocCtx, cancel := chromedp.NewExecAllocator(ctx, opcionesNavegador()...)
+	defer cancel()
+
+	taskCtx, cancel := chromedp.NewContext(allocCtx)
+	defer cancel()
+
+	cuerpo := base64.StdEncoding.EncodeToString([]byte(html))
+	chromedp.ListenTarget(taskCtx, func(ev interface{}) {
+		pausada, ok := ev.(*fetch.EventRequestPaused)
+		if !ok {
+			return
+		}
+		go func() {
+			execCtx := cdp.WithExecutor(taskCtx, chromedp.FromContext(taskCtx).Target)
+			if pausada.ResourceType == network.ResourceTypeDocument && strings.TrimRight(pausada.Request.URL, "/") == strings.TrimRight(url, "/") {
+				fetch.FulfillRequest(pausada.RequestID, 200).
+					WithResponseHeaders([]*fetch.HeaderEntry{{Name: "Content-Type", Value: "text/html; charset=utf-8"}}).
+					WithBody(cuerpo).
+					Do(execCtx)
+				return
+			}
+			fetch.FailRequest(pausada.RequestID, network.ErrorReasonBlockedByClient).Do(execCtx)
+		}()
+	})
+
+	err := chromedp.Run(taskCtx,
+		fetch.Enable(),
+		emulation.SetScriptExecutionDisabled(true),
+		chromedp.Navigate(url),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error cargando la ficha guardada de %s: %w", url, err)
+	}
+
+	if err := esperarFicha(ctx, taskCtx, url); err != nil {
+		return nil, err
+	}
+
+	return extraerFicha(taskCtx, url)
+}
+
+// opcionesNavegador son las opciones del navegador con que se extraen las fichas
+func opcionesNavegador() []chromedp.ExecAllocatorOption {
+	return append(chromedp.DefaultExecAllocatorOptions[:],
 		chromedp.Flag("headless", true),
 		chromedp.Flag("disable-gpu", true),
 		chromedp.Flag("no-sandbox", true),
@@ -211,19 +331,20 @@ func (s *TokkoScraper) GetPropertyDetails(ctx context.Context, url string) (*mod
 		chromedp.Flag("disable-popup-blocking", true),
 		chromedp.Flag("disable-notifications", true),
 	)
+}
 
-	allocCtx, cancel := chromedp.NewExecAllocator(ctx, opts...)
-	defer cancel()
-
-	taskCtx, cancel := chromedp.NewContext(allocCtx)
-	defer cancel()
-
+// extraerFicha extrae los detalles de la ficha cargada en el navegador
+func extraerFicha(taskCtx context.Context, url string) (*models.PropertyDetails, error) {
 	var details models.PropertyDetails
+	if err := chromedp.Run(taskCtx, chromedp.Evaluate(scriptFicha, &details)); err != nil {
+		return nil, fmt.Errorf("error extrayendo detalles: %w (url: %s)", err, url)
+	}
+	return &details, nil
+}
 
-	err := chromedp.Run(taskCtx,
-		chromedp.Navigate(url),
-		chromedp.WaitVisible("#ficha_detalle_cuerpo", chromedp.ByID),
-		chromedp.Evaluate(`
+// scriptFicha extrae los detalles de la ficha de una propiedad de Tokko. Solo lee el documento,
+// así que sirve tanto para la página en vivo como para una ficha guardada.
+const scriptFicha = `
 			(() => {
 				// Función auxiliar para extraer números
 				function extractNumber(text) {
@@ -908,15 +1029,60 @@ func (s *TokkoScraper) GetPropertyDetails(ctx context.Context, url string) (*mod
 					longitud
 				};
 			})()
-		`, &details),
-	)
+`
+
+// errorDeRespuesta clasifica el código de estado de una navegación según scrapeerr
+func errorDeRespuesta(url string, statusCode int, headers map[string]interface{}) error {
+	if err := ratelimit.ResponseError(url, statusCode, headers); err != nil {
+		return fmt.Errorf("%w: %w", scrapeerr.ErrBlocked, err)
+	}
 
+	switch statusCode {
+	case 404, 410:
+		return fmt.Errorf("%w: respuesta %d de %s", scrapeerr.ErrNotFound, statusCode, url)
+	case 401, 403:
+		return fmt.Errorf("%w: respuesta %d de %s", scrapeerr.ErrBlocked, statusCode, url)
+	}
+	if statusCode >= 500 {
+		return fmt.Errorf("respuesta %d de %s", statusCode, url)
+	}
+	return nil
+}
+
+// esperarFicha espera a que aparezca la ficha de la propiedad. Si no aparece, distingue
+// un aviso dado de baja (Tokko redirige o muestra un mensaje con estado 200) de una página
+// con otra estructura.
+func esperarFicha(ctx, taskCtx context.Context, url string) error {
+	fichaCtx, cancel := context.WithTimeout(taskCtx, esperaFicha)
+	defer cancel()
+
+	err := chromedp.Run(fichaCtx, chromedp.WaitVisible("#ficha_detalle_cuerpo", chromedp.ByID))
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("error esperando la ficha de %s: %w", url, err)
+	}
+
+	var noDisponible bool
+	err = chromedp.Run(taskCtx, chromedp.Evaluate(`
+		(() => {
+			const texto = (document.body?.innerText || '').toLowerCase();
+			const avisos = ['no disponible', 'no se encuentra disponible', 'no existe', 'no fue encontrad', 'no encontrad'];
+			// Tokko manda los avisos dados de baja al inicio o al buscador
+			const redirigida = !document.querySelector('#ficha_detalle_cuerpo') &&
+				(location.pathname === '/' || location.pathname.toLowerCase().startsWith('/buscar'));
+			return redirigida || avisos.some(aviso => texto.includes(aviso));
+		})()
+	`, &noDisponible))
 	if err != nil {
-		return nil, fmt.Errorf("error extrayendo detalles: %v (url: %s)", err, url)
+		return fmt.Errorf("error inspeccionando la página de %s: %w", url, err)
 	}
 
-	fmt.Printf("✓ Extracción completada: %+v\n", details)
-	return &details, nil
+	if noDisponible {
+		return fmt.Errorf("%w: %s", scrapeerr.ErrNotFound, url)
+	}
+	return fmt.Errorf("%w: no se encontró la ficha de la propiedad en %s", scrapeerr.ErrLayoutChanged, url)
 }
 
 // parseAntiguedad convierte el texto de antigüedad a un valor numérico
@@ -2,6 +2,7 @@ import { BrowserRouter as Router, Routes, Route } from 'react-router-dom';
 import Home from './pages/Home';
 import Likes from './pages/Likes';
 import Settings from './pages/Settings';
+import Login from './pages/Login';
 import Layout from './components/Layout/Layout';
 import { useState, useEffect } from 'react';
 import BottomNavBar from './components/BottomNavBar/BottomNavBar';
@@ -74,6 +75,7 @@ function App() {
               <Route path="/" element={<Home setShowNavBar={setShowNavBar} />} />
               <Route path="/likes" element={<Likes setShowNavBar={setShowNavBar} />} />
               <Route path="/settings" element={<Settings setShowNavBar={setShowNavBar} />} />
+              <Route path="/login" element={<Login setShowNavBar={setShowNavBar} />} />
             </Routes>
           </div>
           {showNavBar && <BottomNavBar show={showNavBar} />}
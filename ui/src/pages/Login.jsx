@@ -0,0 +1,64 @@
+import { useState, useEffect } from 'react';
+import { useNavigate } from 'react-router-dom';
+import { login } from '../services/api';
+
+export default function Login({ setShowNavBar }) {
+    const navigate = useNavigate();
+    const [username, setUsername] = useState('');
+    const [password, setPassword] = useState('');
+    const [error, setError] = useState(null);
+    const [loading, setLoading] = useState(false);
+
+    useEffect(() => {
+        setShowNavBar(false);
+        return () => setShowNavBar(true);
+    }, [setShowNavBar]);
+
+    const handleSubmit = async (e) => {
+        e.preventDefault();
+        setLoading(true);
+        setError(null);
+        try {
+            await login(username, password);
+            navigate('/');
+        } catch (err) {
+            setError(err.response?.status === 401
+                ? 'Usuario o contraseña incorrectos'
+                : 'No se pudo iniciar sesión');
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    return (
+        <div className="min-h-screen flex items-center justify-center bg-gray-950 px-6">
+            <form onSubmit={handleSubmit} className="w-full max-w-sm space-y-4">
+                <h1 className="text-2xl font-semibold text-white text-center">FindHouse</h1>
+                <input
+                    type="text"
+                    autoComplete="username"
+                    placeholder="Usuario"
+                    value={username}
+                    onChange={(e) => setUsername(e.target.value)}
+                    className="w-full rounded-lg bg-gray-800 px-4 py-3 text-white placeholder-gray-400"
+                />
+                <input
+                    type="password"
+                    autoComplete="current-password"
+                    placeholder="Contraseña"
+                    value={password}
+                    onChange={(e) => setPassword(e.target.value)}
+                    className="w-full rounded-lg bg-gray-800 px-4 py-3 text-white placeholder-gray-400"
+                />
+                {error && <p className="text-sm text-red-400">{error}</p>}
+                <button
+                    type="submit"
+                    disabled={loading || !username || !password}
+                    className="w-full rounded-lg bg-blue-600 py-3 font-medium text-white disabled:opacity-50"
+                >
+                    {loading ? 'Ingresando...' : 'Ingresar'}
+                </button>
+            </form>
+        </div>
+    );
+}
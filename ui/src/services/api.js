@@ -9,6 +9,48 @@ const api = axios.create({
   },
 });
 
+// Token de sesión guardado tras el login
+const TOKEN_KEY = 'authToken';
+
+export const getAuthToken = () => localStorage.getItem(TOKEN_KEY);
+
+// Enviar el token de sesión en cada solicitud
+api.interceptors.request.use(config => {
+  const token = getAuthToken();
+  if (token) {
+    config.headers.Authorization = `Bearer ${token}`;
+  }
+  return config;
+});
+
+// Si la sesión venció o no existe, ir al login
+api.interceptors.response.use(
+  response => response,
+  error => {
+    if (error.response?.status === 401 && !error.config?.url?.endsWith('/auth/login')) {
+      localStorage.removeItem(TOKEN_KEY);
+      if (window.location.pathname !== '/login') {
+        window.location.assign('/login');
+      }
+    }
+    return Promise.reject(error);
+  }
+);
+
+export const login = async (username, password) => {
+  const response = await api.post('/auth/login', { username, password });
+  localStorage.setItem(TOKEN_KEY, response.data.token);
+  return response.data.user;
+};
+
+export const logout = async () => {
+  try {
+    await api.post('/auth/logout');
+  } finally {
+    localStorage.removeItem(TOKEN_KEY);
+  }
+};
+
 export const getUnratedProperties = (filters = null, cancelToken = null) => {
   const config = {
     headers: {
@@ -58,10 +100,23 @@ export const getLikedProperties = (filters = null, cancelToken = null) => {
   return api.get('/properties/liked', config);
 };
 
-export const rateProperty = (id, rating) => api.put(`/properties/${id}/rate`, { rating });
+// details admite score (1 a 5, solo para like) y reasons (códigos de /rating-reasons)
+export const rateProperty = (id, rating, details = {}) => api.put(`/properties/${id}/rate`, { rating, ...details });
+
+// Función para marcar una propiedad como dislike, opcionalmente con motivos
+export const dislikeProperty = (id, reasons = []) => api.put(`/properties/${id}/rate`, { rating: 'dislike', reasons });
+
+// Función para obtener los motivos disponibles para una calificación
+export const getRatingReasons = () => api.get('/rating-reasons');
+
+// Función para obtener el historial de calificaciones de una propiedad
+export const getRatingHistory = (id) => api.get(`/properties/${id}/rating/history`);
+
+// Función para deshacer la calificación de una propiedad, que vuelve a la cola
+export const undoRating = (id) => api.delete(`/properties/${id}/rate`);
 
-// Función para marcar una propiedad como dislike
-export const dislikeProperty = (id) => api.put(`/properties/${id}/rate`, { rating: 'dislike' });
+// Función para obtener las últimas calificaciones del usuario
+export const getRecentRatings = (limit = 20) => api.get('/ratings/recent', { params: { limit } });
 
 // Función para marcar/desmarcar una propiedad como favorita
 export const togglePropertyFavorite = (id, isFavorite) => api.put(`/properties/${id}/favorite`, { is_favorite: isFavorite });
@@ -88,6 +143,82 @@ export const addPropertyNote = (propertyId, text) => api.post(`/properties/${pro
 
 export const deletePropertyNote = (noteId) => api.delete(`/properties/notes/${noteId}`);
 
+// Función para editar el texto de una nota
+export const updatePropertyNote = (noteId, text) => api.put(`/properties/notes/${noteId}`, { text });
+
+// Función para adjuntar una foto a una nota
+export const addNoteAttachment = (noteId, file) => {
+  const form = new FormData();
+  form.append('file', file);
+  return api.post(`/properties/notes/${noteId}/attachments`, form);
+};
+
+// Función para descargar un adjunto; devuelve un blob porque requiere autenticación
+export const getNoteAttachment = (attachmentId) => api.get(`/properties/notes/attachments/${attachmentId}`, { responseType: 'blob' });
+
+// Función para eliminar un adjunto
+export const deleteNoteAttachment = (attachmentId) => api.delete(`/properties/notes/attachments/${attachmentId}`);
+
+// Función para obtener las fotos descargadas de una propiedad; con duplicates incluye las casi idénticas
+export const getPropertyImages = (propertyId, duplicates = false) =>
+  api.get(`/properties/${propertyId}/images`, { params: duplicates ? { duplicates: true } : {} });
+
+// URL de una foto del almacén local (local_url o thumb_url de getPropertyImages), para usar en <img>
+export const imageURL = (path) => `${api.defaults.baseURL.replace(/\/api$/, '')}${path}`;
+
+// Función para obtener los puntos de la lista de visita
+export const getChecklistItems = () => api.get('/checklist-items');
+
+// Función para obtener la lista de visita de una propiedad
+export const getPropertyChecklist = (propertyId) => api.get(`/properties/${propertyId}/checklist`);
+
+// Función para guardar respuestas de la lista de visita; answers es [{ item, answer, comment }]
+export const updatePropertyChecklist = (propertyId, answers) => api.put(`/properties/${propertyId}/checklist`, { answers });
+
+// Función para comparar propiedades lado a lado; usdRate (pesos por dólar) es opcional
+export const compareProperties = (ids, usdRate) =>
+  api.get('/properties/compare', { params: { ids: ids.join(','), ...(usdRate ? { usd_rate: usdRate } : {}) } });
+
+// Función para obtener las estadísticas del mercado por segmento; params es { location, property_type_id, operation, usd_rate }
+export const getMarketSegments = (params = {}) => api.get('/analytics/segments', { params });
+
+// Función para obtener la evolución del inventario; params agrega { interval: 'week' | 'month', periods }
+export const getMarketTrend = (params = {}) => api.get('/analytics/trend', { params });
+
+// Función para obtener las personas de contacto de una inmobiliaria
+export const getAgencyContacts = (agencyId) => api.get(`/agencies/${agencyId}/contacts`);
+
+// Función para agregar una persona de contacto; contact es { name, phone, email, notes }
+export const createAgencyContact = (agencyId, contact) => api.post(`/agencies/${agencyId}/contacts`, contact);
+
+// Función para actualizar una persona de contacto
+export const updateAgencyContact = (contactId, contact) => api.put(`/agencies/contacts/${contactId}`, contact);
+
+// Función para obtener los contactos con la inmobiliaria por una propiedad
+export const getPropertyContacts = (propertyId) => api.get(`/properties/${propertyId}/contacts`);
+
+// Función para registrar un contacto; contact es { contact_id, channel, status, outcome, contacted_at }
+export const addPropertyContact = (propertyId, contact) => api.post(`/properties/${propertyId}/contacts`, contact);
+
+// Función para obtener las visitas a una propiedad
+export const getPropertyVisits = (propertyId) => api.get(`/properties/${propertyId}/visits`);
+
+// Función para agendar una visita; visit es { contact_id, scheduled_at, duration_minutes, status, outcome }
+export const createVisit = (propertyId, visit) => api.post(`/properties/${propertyId}/visits`, visit);
+
+// Función para reprogramar una visita o registrar cómo resultó
+export const updateVisit = (visitId, visit) => api.put(`/visits/${visitId}`, visit);
+
+// Función para eliminar una visita
+export const deleteVisit = (visitId) => api.delete(`/visits/${visitId}`);
+
+// Función para obtener las visitas agendadas de los próximos días
+export const getUpcomingVisits = (days = 30) => api.get('/visits/upcoming', { params: { days } });
+
+// URL del calendario de visitas para suscribirse desde una aplicación de calendario
+export const getVisitsCalendarUrl = (apiKey) =>
+  `${api.defaults.baseURL}/visits/calendar.ics?key=${encodeURIComponent(apiKey)}`;
+
 // Función para convertir los filtros del frontend al formato del backend
 const convertFilters = (filters) => {
   if (!filters) return {};
@@ -227,6 +358,23 @@ const convertFilters = (filters) => {
     backendFilters.agencies = filters.agencies.join(',');
   }
 
+  // Convertir bajas de precio, días publicada y republicación
+  if (filters.priceDropDays) {
+    backendFilters.price_drop_days = filters.priceDropDays;
+  }
+  if (filters.minPriceDrops) {
+    backendFilters.min_price_drops = filters.minPriceDrops;
+  }
+  if (filters.minDaysOnMarket) {
+    backendFilters.min_days_on_market = filters.minDaysOnMarket;
+  }
+  if (filters.maxDaysOnMarket) {
+    backendFilters.max_days_on_market = filters.maxDaysOnMarket;
+  }
+  if (filters.relisted !== undefined && filters.relisted !== null) {
+    backendFilters.relisted = filters.relisted;
+  }
+
   return backendFilters;
 };
 